@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/starius/sialite/human"
+	"gitlab.com/NebulousLabs/Sia/encoding"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+const (
+	coinSiacoin = "sc"
+	coinSiafund = "sf"
+
+	richBalanceLen = 32
+)
+
+var (
+	ErrNoBalances = fmt.Errorf("balances are not available")
+
+	richBuckets = map[string][]byte{
+		coinSiacoin: bucketRichSc,
+		coinSiafund: bucketRichSf,
+	}
+)
+
+type balanceRecord struct {
+	Siacoins types.Currency
+	Siafunds types.Currency
+}
+
+// richKey orders the rich list by balance, the richest first: the balance
+// is stored as inverted big endian number.
+func richKey(balance types.Currency, address types.UnlockHash) []byte {
+	key := make([]byte, richBalanceLen+len(address))
+	b := balance.Big().Bytes()
+	copy(key[richBalanceLen-len(b):], b)
+	for i := 0; i < richBalanceLen; i++ {
+		key[i] = ^key[i]
+	}
+	copy(key[richBalanceLen:], address[:])
+	return key
+}
+
+func parseRichKey(key []byte) (balance types.Currency, address types.UnlockHash) {
+	b := make([]byte, richBalanceLen)
+	for i := range b {
+		b[i] = ^key[i]
+	}
+	copy(address[:], key[richBalanceLen:])
+	return types.NewCurrency(new(big.Int).SetBytes(b)), address
+}
+
+// changeBalance adds the value to the balance of the address in the coin
+// or subtracts it and updates the rich list. Spending more than the
+// balance means that the index is inconsistent, so it fails.
+func (db *Database) changeBalance(address types.UnlockHash, coin string, value types.Currency, add bool) error {
+	if value.IsZero() {
+		return nil
+	}
+	b, _ := db.balance(address)
+	balance := &b.Siacoins
+	if coin == coinSiafund {
+		balance = &b.Siafunds
+	}
+	old := *balance
+	if add {
+		*balance = old.Add(value)
+	} else if old.Cmp(value) < 0 {
+		return fmt.Errorf("balance of %s is %s %s, less than spent %s", address, old, coin, value)
+	} else {
+		*balance = old.Sub(value)
+	}
+	if !old.IsZero() {
+		if err := db.del(richBuckets[coin], richKey(old, address)); err != nil {
+			return err
+		}
+	}
+	if !balance.IsZero() {
+		if err := db.put(richBuckets[coin], richKey(*balance, address), true); err != nil {
+			return err
+		}
+	}
+	if b.Siacoins.IsZero() && b.Siafunds.IsZero() {
+		return db.del(bucketBalances, address[:])
+	}
+	return db.put(bucketBalances, address[:], *b)
+}
+
+func (db *Database) addOutputs(outputs []types.SiacoinOutput) error {
+	for _, o := range outputs {
+		if err := db.changeBalance(o.UnlockHash, coinSiacoin, o.Value, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addBalances applies changes of balances made by the block added at
+// the height. Like in Sia, miner payouts, Siafund claims and storage
+// proof outputs are paid when they mature, MaturityDelay blocks after
+// the block creating them.
+func (db *Database) addBalances(height int, block *types.Block) error {
+	if matured := height - int(types.MaturityDelay); matured >= 0 {
+		if err := db.addMatured(matured, db.blockAt(matured)); err != nil {
+			return err
+		}
+	}
+	for j := range block.Transactions {
+		tx := &block.Transactions[j]
+		for _, sci := range tx.SiacoinInputs {
+			sco, has := db.sco(sci.ParentID)
+			if !has {
+				return fmt.Errorf("unknown Siacoin output %s", sci.ParentID)
+			}
+			o := sco.Value(db)
+			if err := db.changeBalance(o.UnlockHash, coinSiacoin, o.Value, false); err != nil {
+				return err
+			}
+		}
+		if err := db.addOutputs(tx.SiacoinOutputs); err != nil {
+			return err
+		}
+		for _, sfi := range tx.SiafundInputs {
+			sfo, has := db.sfo(sfi.ParentID)
+			if !has {
+				return fmt.Errorf("unknown Siafund output %s", sfi.ParentID)
+			}
+			o := sfo.Value(db)
+			if err := db.changeBalance(o.UnlockHash, coinSiafund, o.Value, false); err != nil {
+				return err
+			}
+		}
+		for _, o := range tx.SiafundOutputs {
+			if err := db.changeBalance(o.UnlockHash, coinSiafund, o.Value, true); err != nil {
+				return err
+			}
+		}
+	}
+	return db.put(bucketMeta, keyBalanceBlocks, height+1)
+}
+
+// addMatured pays the outputs created by the block at the height: miner
+// payouts, Siafund claims and outputs of contracts resolved by it, see
+// resolvedContracts.
+func (db *Database) addMatured(height int, block *types.Block) error {
+	if err := db.addOutputs(block.MinerPayouts); err != nil {
+		return err
+	}
+	for j := range block.Transactions {
+		for _, sfi := range block.Transactions[j].SiafundInputs {
+			claim, has := db.sco(sfi.ParentID.SiaClaimOutputID())
+			if !has {
+				return fmt.Errorf("unknown claim of Siafund output %s", sfi.ParentID)
+			}
+			if err := db.addOutputs([]types.SiacoinOutput{*claim.Value(db)}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, outcome := range db.resolvedContracts(height, block) {
+		if err := db.addOutputs(outcome.Outputs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillBalances applies blocks added by versions which did not
+// maintain balances.
+func (db *Database) backfillBalances() error {
+	done := 0
+	if data := db.btx.Bucket(bucketMeta).Get(keyBalanceBlocks); data != nil {
+		if err := encoding.Unmarshal(data, &done); err != nil {
+			return fmt.Errorf("decoding %s: %v", keyBalanceBlocks, err)
+		}
+	}
+	for height := done; height < db.numBlocks(); height++ {
+		block := db.blockAt(height)
+		if err := db.trackExpiring(block); err != nil {
+			return err
+		}
+		if err := db.addBalances(height, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Database) addressBalance(address types.UnlockHash) (*human.AddressBalance, error) {
+	if db.server != nil {
+		return nil, ErrNoBalances
+	}
+	b, _ := db.balance(address)
+	return &human.AddressBalance{
+		UnlockHash: address,
+		Siacoins:   b.Siacoins,
+		Siafunds:   b.Siafunds,
+	}, nil
+}
+
+type richListPosition struct {
+	Key  []byte
+	Rank int
+}
+
+func (db *Database) richListPage(coin, startWith string, limit int) (*human.RichList, error) {
+	if db.server != nil {
+		return nil, ErrNoBalances
+	}
+	if _, has := richBuckets[coin]; !has {
+		return nil, fmt.Errorf("coin must be %s or %s, got %q", coinSiacoin, coinSiafund, coin)
+	}
+	var pos richListPosition
+	if startWith != "" {
+		startWithBytes, err := hex.DecodeString(startWith)
+		if err != nil {
+			return nil, fmt.Errorf("hex.DecodeString: %v", err)
+		}
+		if err := json.Unmarshal(startWithBytes, &pos); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal: %v", err)
+		}
+	}
+	keys := db.richList(coin, pos.Key, limit+1)
+	list := &human.RichList{
+		Coin:    coin,
+		Entries: []human.RichListEntry{},
+	}
+	for i, key := range keys {
+		if i == limit {
+			nextBytes, err := json.Marshal(richListPosition{Key: key, Rank: pos.Rank + i})
+			if err != nil {
+				return nil, fmt.Errorf("json.Marshal: %v", err)
+			}
+			list.Next = hex.EncodeToString(nextBytes)
+			break
+		}
+		balance, address := parseRichKey(key)
+		list.Entries = append(list.Entries, human.RichListEntry{
+			Rank:       pos.Rank + i + 1,
+			UnlockHash: address,
+			Balance:    balance,
+		})
+	}
+	return list, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/starius/sialite/netlib/fakepeer"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// expectedBalances computes balances of the chain like Sia does, for
+// chains without file contracts and Siafund inputs.
+func expectedBalances(t *testing.T, blocks []types.Block) map[types.UnlockHash]balanceRecord {
+	scos := make(map[types.SiacoinOutputID]types.SiacoinOutput)
+	balances := make(map[types.UnlockHash]balanceRecord)
+	change := func(address types.UnlockHash, sc, sf types.Currency, add bool) {
+		b, has := balances[address]
+		if !has {
+			b = balanceRecord{Siacoins: types.NewCurrency64(0), Siafunds: types.NewCurrency64(0)}
+		}
+		if add {
+			b.Siacoins, b.Siafunds = b.Siacoins.Add(sc), b.Siafunds.Add(sf)
+		} else {
+			b.Siacoins, b.Siafunds = b.Siacoins.Sub(sc), b.Siafunds.Sub(sf)
+		}
+		balances[address] = b
+	}
+	zero := types.NewCurrency64(0)
+	for height := range blocks {
+		if matured := height - int(types.MaturityDelay); matured >= 0 {
+			block := &blocks[matured]
+			for i, o := range block.MinerPayouts {
+				scos[block.MinerPayoutID(uint64(i))] = o
+				change(o.UnlockHash, o.Value, zero, true)
+			}
+		}
+		for _, tx := range blocks[height].Transactions {
+			if len(tx.FileContracts) != 0 || len(tx.SiafundInputs) != 0 {
+				t.Fatalf("block %d has contracts or Siafund inputs", height)
+			}
+			for _, sci := range tx.SiacoinInputs {
+				o, has := scos[sci.ParentID]
+				if !has {
+					t.Fatalf("block %d spends unknown output %s", height, sci.ParentID)
+				}
+				delete(scos, sci.ParentID)
+				change(o.UnlockHash, o.Value, zero, false)
+			}
+			for i, o := range tx.SiacoinOutputs {
+				scos[tx.SiacoinOutputID(uint64(i))] = o
+				change(o.UnlockHash, o.Value, zero, true)
+			}
+			for _, o := range tx.SiafundOutputs {
+				change(o.UnlockHash, zero, o.Value, true)
+			}
+		}
+	}
+	return balances
+}
+
+// checkBalances compares balances and the rich lists of the database
+// with the expected ones. Addresses of other are expected to have no
+// balance, unless they are in want.
+func checkBalances(t *testing.T, db *Database, want, other map[types.UnlockHash]balanceRecord) {
+	t.Helper()
+	nonzero := map[string]int{}
+	err := db.view(func(db *Database) error {
+		for address := range other {
+			if _, has := want[address]; has {
+				continue
+			}
+			if b, has := db.balance(address); has {
+				t.Errorf("balance of %s is %s SC and %s SF, want none", address, b.Siacoins, b.Siafunds)
+			}
+		}
+		for address, w := range want {
+			if !w.Siacoins.IsZero() {
+				nonzero[coinSiacoin]++
+			}
+			if !w.Siafunds.IsZero() {
+				nonzero[coinSiafund]++
+			}
+			b, _ := db.balance(address)
+			if b.Siacoins.Cmp(w.Siacoins) != 0 || b.Siafunds.Cmp(w.Siafunds) != 0 {
+				t.Errorf("balance of %s is %s SC and %s SF, want %s SC and %s SF", address, b.Siacoins, b.Siafunds, w.Siacoins, w.Siafunds)
+			}
+		}
+		for _, coin := range []string{coinSiacoin, coinSiafund} {
+			if n := len(db.richList(coin, nil, len(want)+1)); n != nonzero[coin] {
+				t.Errorf("rich list of %s has %d addresses, want %d", coin, n, nonzero[coin])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("db.view: %v", err)
+	}
+}
+
+func TestBalances(t *testing.T) {
+	blocks := readTestBlocks(t)
+	db := openTestDatabase(t)
+	skipWork(db)
+
+	// Miner payouts are not paid until they mature.
+	addTestBlocks(t, db, blocks[:types.MaturityDelay])
+	young := expectedBalances(t, blocks[:types.MaturityDelay])
+	checkBalances(t, db, young, nil)
+	payout := blocks[1].MinerPayouts[0]
+	if _, has := young[payout.UnlockHash]; has {
+		t.Fatalf("the payout of block 1 was paid before maturity")
+	}
+
+	if err := process(db, blocks[types.MaturityDelay:], 100); err != nil {
+		t.Fatalf("processBlocks: %v", err)
+	}
+	main := expectedBalances(t, blocks)
+	checkBalances(t, db, main, nil)
+	if b := main[payout.UnlockHash]; b.Siacoins.Cmp(payout.Value) != 0 {
+		t.Fatalf("the payout of block 1 is %s, balance %s", payout.Value, b.Siacoins)
+	}
+
+	// Disconnected blocks are undone.
+	fork := fakepeer.Fork(blocks, 900, 200)
+	if err := process(db, fork[901:], 100); err != nil {
+		t.Fatalf("processBlocks(fork): %v", err)
+	}
+	checkLastBlock(t, db, fork[len(fork)-1].ID())
+	checkBalances(t, db, expectedBalances(t, fork), main)
+}
+
+func checkSiacoins(t *testing.T, db *Database, address types.UnlockHash, want types.Currency) {
+	t.Helper()
+	err := db.view(func(db *Database) error {
+		b, _ := db.balance(address)
+		if b.Siacoins.Cmp(want) != 0 {
+			t.Errorf("balance of %s is %s, want %s", address, b.Siacoins, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("db.view: %v", err)
+	}
+}
+
+func TestDelayedOutputs(t *testing.T) {
+	blocks := readTestBlocks(t)
+	db := openTestDatabase(t)
+	skipWork(db)
+	addTestBlocks(t, db, blocks[:901])
+
+	// Contract 1 is proven, contract 2 misses its window and a Siafund
+	// output is spent after the contracts paid the tax.
+	valid1, missed1, valid2, missed2 := types.UnlockHash{1}, types.UnlockHash{2}, types.UnlockHash{3}, types.UnlockHash{4}
+	claimer, receiver := types.UnlockHash{5}, types.UnlockHash{6}
+	const tax = 1000000
+	contract := func(windowEnd types.BlockHeight, valid, missed types.UnlockHash) types.FileContract {
+		return types.FileContract{
+			WindowStart:        903,
+			WindowEnd:          windowEnd,
+			Payout:             types.NewCurrency64(tax/2 + 10),
+			ValidProofOutputs:  []types.SiacoinOutput{{Value: types.NewCurrency64(10), UnlockHash: valid}},
+			MissedProofOutputs: []types.SiacoinOutput{{Value: types.NewCurrency64(10), UnlockHash: missed}},
+		}
+	}
+	genesis := types.GenesisBlock.Transactions[0]
+	sfo := genesis.SiafundOutputs[0]
+	chain := fakepeer.Fork(blocks, 900, 10+int(types.MaturityDelay))
+	chain[901].Transactions = []types.Transaction{{
+		FileContracts: []types.FileContract{contract(906, valid1, missed1), contract(905, valid2, missed2)},
+	}}
+	fcid1 := chain[901].Transactions[0].FileContractID(0)
+	chain[902].Transactions = []types.Transaction{{
+		SiafundInputs:  []types.SiafundInput{{ParentID: genesis.SiafundOutputID(0), ClaimUnlockHash: claimer}},
+		SiafundOutputs: []types.SiafundOutput{{Value: sfo.Value, UnlockHash: receiver}},
+	}}
+	chain[904].Transactions = []types.Transaction{{
+		StorageProofs: []types.StorageProof{{ParentID: fcid1}},
+	}}
+	relink(chain, 900)
+	claim := types.NewCurrency64(tax).Mul(sfo.Value).Div(types.SiafundCount)
+
+	zero, ten := types.NewCurrency64(0), types.NewCurrency64(10)
+	// paid returns the value if the output created at the height matured.
+	paid := func(height, created int, value types.Currency) types.Currency {
+		if height < created+int(types.MaturityDelay) {
+			return zero
+		}
+		return value
+	}
+	for height := 901; height < len(chain); height++ {
+		if err := process(db, chain[height:height+1], 1); err != nil {
+			t.Fatalf("processBlocks(%d): %v", height, err)
+		}
+		checkSiacoins(t, db, claimer, paid(height, 902, claim))
+		checkSiacoins(t, db, valid1, paid(height, 904, ten))
+		checkSiacoins(t, db, missed2, paid(height, 905, ten))
+	}
+	checkSiacoins(t, db, missed1, zero)
+	checkSiacoins(t, db, valid2, zero)
+
+	// A longer fork without the storage proof takes back its output and
+	// pays the missed output of contract 1 instead. The claim stays.
+	fork := fakepeer.Fork(chain, 903, len(chain)-903)
+	if err := process(db, fork[904:], 100); err != nil {
+		t.Fatalf("processBlocks(fork): %v", err)
+	}
+	checkLastBlock(t, db, fork[len(fork)-1].ID())
+	checkSiacoins(t, db, claimer, claim)
+	checkSiacoins(t, db, valid1, zero)
+	checkSiacoins(t, db, missed1, ten)
+	checkSiacoins(t, db, missed2, ten)
+}
@@ -27,6 +27,12 @@ type parameters struct {
 	AddressOffsetLen  int
 	ContractPrefixLen int
 	ContractOffsetLen int
+
+	// Completeness is true if addressesPageHashes file was built.
+	Completeness bool
+
+	// Explorer is true if indexes for the explorer were built.
+	Explorer bool
 }
 
 type blockHeader struct {
@@ -68,6 +74,12 @@ type Builder struct {
 	contracts    emsort.SortedWriter
 	contractstmp *os.File
 
+	// Indexes for the explorer, nil if not built.
+	explorer *explorerWriter
+
+	// Checks blocks before they are added.
+	validator *Validator
+
 	tmpBuf         []byte
 	tmpBufSuffix   []byte
 	itemOffset     []byte
@@ -84,9 +96,22 @@ type Builder struct {
 	offsetLen, offsetIndexLen int
 	addressRecordSize         int
 	contractRecordSize        int
+
+	dir              string
+	completeness     bool
+	addressUninliner fastmap.Uninliner
 }
 
-func NewBuilder(dir string, memLimit, offsetLen, offsetIndexLen, addressPageLen, addressPrefixLen, addressFastmapPrefixLen, addressOffsetLen, contractPageLen, contractPrefixLen, contractFastmapPrefixLen, contractOffsetLen int) (*Builder, error) {
+// BuilderOptions selects optional indexes built by Builder.
+type BuilderOptions struct {
+	// Completeness builds proofs of completeness of address histories.
+	Completeness bool
+
+	// Explorer builds indexes for the explorer.
+	Explorer bool
+}
+
+func NewBuilder(dir string, memLimit, offsetLen, offsetIndexLen, addressPageLen, addressPrefixLen, addressFastmapPrefixLen, addressOffsetLen, contractPageLen, contractPrefixLen, contractFastmapPrefixLen, contractOffsetLen int, opts BuilderOptions) (*Builder, error) {
 
 	addressRecordSize := addressPrefixLen + offsetIndexLen
 	contractRecordSize := contractPrefixLen + offsetIndexLen
@@ -121,6 +146,8 @@ func NewBuilder(dir string, memLimit, offsetLen, offsetIndexLen, addressPageLen,
 		AddressOffsetLen:  addressOffsetLen,
 		ContractPrefixLen: contractPrefixLen,
 		ContractOffsetLen: contractOffsetLen,
+		Completeness:      opts.Completeness,
+		Explorer:          opts.Explorer,
 	}
 
 	parametersJson, err := os.Create(path.Join(dir, "parameters.json"))
@@ -181,9 +208,12 @@ func NewBuilder(dir string, memLimit, offsetLen, offsetIndexLen, addressPageLen,
 	}
 
 	var addressInliner fastmap.Inliner = fastmap.NoInliner{}
+	var addressUninliner fastmap.Uninliner = fastmap.NoUninliner{}
 	addressContainerLen := offsetIndexLen
 	if addressOffsetLen == offsetIndexLen {
-		addressInliner = fastmap.NewFFOOInliner(offsetIndexLen)
+		ffoo := fastmap.NewFFOOInliner(offsetIndexLen)
+		addressInliner = ffoo
+		addressUninliner = ffoo
 		addressContainerLen = 2 * offsetIndexLen
 	}
 	addressesMultiMapWriter, err := fastmap.NewMultiMapWriter(addressPageLen, addressPrefixLen, offsetIndexLen, addressFastmapPrefixLen, addressOffsetLen, addressContainerLen, addressesFastmapData, addressesIndices, addressInliner)
@@ -222,9 +252,18 @@ func NewBuilder(dir string, memLimit, offsetLen, offsetIndexLen, addressPageLen,
 		return nil, fmt.Errorf("too large offsetLen")
 	}
 
+	var explorerIndexes *explorerWriter
+	if opts.Explorer {
+		explorerIndexes, err = newExplorerWriter(dir, memLimit, contractPageLen, contractPrefixLen, contractFastmapPrefixLen, contractOffsetLen, offsetIndexLen)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	tmpBuf := make([]byte, 8)
 
 	return &Builder{
+		validator:       NewValidator(nil, nil, 0),
 		blockchain:      blockchain,
 		blockchainBuf:   bufio.NewWriter(blockchain),
 		leavesHashes:    leavesHashes,
@@ -242,6 +281,8 @@ func NewBuilder(dir string, memLimit, offsetLen, offsetIndexLen, addressPageLen,
 		addressestmp: addressestmp,
 		contractstmp: contractstmp,
 
+		explorer: explorerIndexes,
+
 		tmpBuf:         tmpBuf,
 		tmpBufSuffix:   tmpBuf[len(tmpBuf)-offsetIndexLen:],
 		itemOffset:     itemOffset,
@@ -259,6 +300,10 @@ func NewBuilder(dir string, memLimit, offsetLen, offsetIndexLen, addressPageLen,
 		offsetIndexLen:     offsetIndexLen,
 		addressRecordSize:  addressRecordSize,
 		contractRecordSize: contractRecordSize,
+
+		dir:              dir,
+		completeness:     opts.Completeness,
+		addressUninliner: addressUninliner,
 	}, nil
 }
 
@@ -283,7 +328,63 @@ func (s *Builder) writeContract(id types.FileContractID) error {
 	return nil
 }
 
+// forEachAddress calls f for each address touched by the transaction.
+// An address may be passed multiple times.
+func forEachAddress(tx *types.Transaction, f func(types.UnlockHash) error) error {
+	for _, si := range tx.SiacoinInputs {
+		if err := f(si.UnlockConditions.UnlockHash()); err != nil {
+			return err
+		}
+	}
+	for _, si := range tx.SiafundInputs {
+		if err := f(si.UnlockConditions.UnlockHash()); err != nil {
+			return err
+		}
+		if err := f(si.ClaimUnlockHash); err != nil {
+			return err
+		}
+	}
+	for _, so := range tx.SiacoinOutputs {
+		if err := f(so.UnlockHash); err != nil {
+			return err
+		}
+	}
+	for _, so := range tx.SiafundOutputs {
+		if err := f(so.UnlockHash); err != nil {
+			return err
+		}
+	}
+	for _, contract := range tx.FileContracts {
+		for _, so := range contract.ValidProofOutputs {
+			if err := f(so.UnlockHash); err != nil {
+				return err
+			}
+		}
+		for _, so := range contract.MissedProofOutputs {
+			if err := f(so.UnlockHash); err != nil {
+				return err
+			}
+		}
+	}
+	for _, rev := range tx.FileContractRevisions {
+		for _, so := range rev.NewValidProofOutputs {
+			if err := f(so.UnlockHash); err != nil {
+				return err
+			}
+		}
+		for _, so := range rev.NewMissedProofOutputs {
+			if err := f(so.UnlockHash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (s *Builder) Add(block *types.Block) error {
+	if err := s.validator.Add(block); err != nil {
+		return fmt.Errorf("invalid block: %v", err)
+	}
 	header := blockHeader{
 		Nonce:      block.Nonce,
 		Timestamp:  block.Timestamp,
@@ -334,58 +435,18 @@ func (s *Builder) Add(block *types.Block) error {
 		wireOffsetIndex := s.offsetIndex + 1 // To avoid special 0 value on wire.
 		binary.BigEndian.PutUint64(s.tmpBuf, wireOffsetIndex)
 		copy(s.itemOffset, s.tmpBufSuffix)
-		for _, si := range tx.SiacoinInputs {
-			if err := s.writeAddress(si.UnlockConditions.UnlockHash()); err != nil {
-				return err
-			}
-		}
-		for _, si := range tx.SiafundInputs {
-			if err := s.writeAddress(si.UnlockConditions.UnlockHash()); err != nil {
-				return err
-			}
-			if err := s.writeAddress(si.ClaimUnlockHash); err != nil {
-				return err
-			}
-		}
-		for _, so := range tx.SiacoinOutputs {
-			if err := s.writeAddress(so.UnlockHash); err != nil {
-				return err
-			}
-		}
-		for _, so := range tx.SiafundOutputs {
-			if err := s.writeAddress(so.UnlockHash); err != nil {
-				return err
-			}
+		if err := forEachAddress(&block.Transactions[i], s.writeAddress); err != nil {
+			return err
 		}
-		for j, contract := range tx.FileContracts {
+		for j := range tx.FileContracts {
 			if err := s.writeContract(tx.FileContractID(uint64(j))); err != nil {
 				return err
 			}
-			for _, so := range contract.ValidProofOutputs {
-				if err := s.writeAddress(so.UnlockHash); err != nil {
-					return err
-				}
-			}
-			for _, so := range contract.MissedProofOutputs {
-				if err := s.writeAddress(so.UnlockHash); err != nil {
-					return err
-				}
-			}
 		}
 		for _, rev := range tx.FileContractRevisions {
 			if err := s.writeContract(rev.ParentID); err != nil {
 				return err
 			}
-			for _, so := range rev.NewValidProofOutputs {
-				if err := s.writeAddress(so.UnlockHash); err != nil {
-					return err
-				}
-			}
-			for _, so := range rev.NewMissedProofOutputs {
-				if err := s.writeAddress(so.UnlockHash); err != nil {
-					return err
-				}
-			}
 		}
 		for _, proof := range tx.StorageProofs {
 			if err := s.writeContract(proof.ParentID); err != nil {
@@ -422,6 +483,11 @@ func (s *Builder) Add(block *types.Block) error {
 	if s.blockchainLen > s.offsetEnd {
 		return fmt.Errorf("too large offset (%d > %d); increase offsetLen", s.blockchainLen, s.offsetEnd)
 	}
+	if s.explorer != nil {
+		if err := s.explorer.addBlock(block, firstMinerPayout); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -456,6 +522,11 @@ func (s *Builder) Close() error {
 	if err := os.Remove(s.addressestmp.Name()); err != nil {
 		return err
 	}
+	if s.completeness {
+		if err := writePageHashes(s.dir, "addresses", s.offsetIndexLen, s.addressUninliner); err != nil {
+			return fmt.Errorf("writePageHashes: %v", err)
+		}
+	}
 	if err := s.contracts.Close(); err != nil {
 		return err
 	}
@@ -465,5 +536,10 @@ func (s *Builder) Close() error {
 	if err := os.Remove(s.contractstmp.Name()); err != nil {
 		return err
 	}
+	if s.explorer != nil {
+		if err := s.explorer.close(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"testing"
 
@@ -141,7 +142,7 @@ next:
 		if err != nil {
 			t.Fatalf("ioutil.TempDir: %v", err)
 		}
-		b, err := NewBuilder(tmpDir, tc.memLimit, tc.offsetLen, tc.offsetIndexLen, tc.addressPageLen, tc.addressPrefixLen, tc.addressFastmapPrefixLen, tc.addressOffsetLen, tc.contractPageLen, tc.contractPrefixLen, tc.contractFastmapPrefixLen, tc.contractOffsetLen)
+		b, err := NewBuilder(tmpDir, tc.memLimit, tc.offsetLen, tc.offsetIndexLen, tc.addressPageLen, tc.addressPrefixLen, tc.addressFastmapPrefixLen, tc.addressOffsetLen, tc.contractPageLen, tc.contractPrefixLen, tc.contractFastmapPrefixLen, tc.contractOffsetLen, BuilderOptions{})
 		if err != nil {
 			t.Errorf("NewBuilder: %v", err)
 			continue next
@@ -156,11 +157,14 @@ next:
 			t.Errorf("b.Close: %v", err)
 			continue next
 		}
-		s, err := NewServer(tmpDir)
+		s, err := NewServer(tmpDir, ServerOptions{})
 		if err != nil {
 			t.Errorf("NewServer: %v", err)
 			continue next
 		}
+		if err := s.Verify(); err != nil {
+			t.Errorf("s.Verify: %v", err)
+		}
 	next2:
 		for _, address := range addresses {
 			addressBytes, err := hex.DecodeString(address)
@@ -200,3 +204,73 @@ next:
 		}
 	}
 }
+
+func TestServerReadIndices(t *testing.T) {
+	blocks, err := read1000Blocks()
+	if err != nil {
+		t.Fatalf("read1000Blocks: %v", err)
+	}
+	addresses, err := readAddresses()
+	if err != nil {
+		t.Fatalf("readAddresses: %v", err)
+	}
+	mapped, tmpDir, err := buildTestServer(blocks, true, true)
+	if err != nil {
+		t.Fatalf("buildTestServer: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer mapped.Close()
+	s, err := NewServer(tmpDir, ServerOptions{ReadIndices: true, CachePages: 2})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	defer s.Close()
+	if len(s.AddressesFastmapData) != 0 || len(s.ObjectsIndices) != 0 {
+		t.Errorf("the indices are mapped to memory")
+	}
+	if err := s.Verify(); err != nil {
+		t.Errorf("s.Verify: %v", err)
+	}
+	c1, err := mapped.Commitment()
+	if err != nil {
+		t.Fatalf("Commitment: %v", err)
+	}
+	c2, err := s.Commitment()
+	if err != nil {
+		t.Fatalf("Commitment: %v", err)
+	}
+	if !reflect.DeepEqual(c1, c2) {
+		t.Errorf("commitments differ: %v and %v", c1, c2)
+	}
+	for _, address := range addresses {
+		addressBytes, err := hex.DecodeString(address)
+		if err != nil {
+			t.Fatalf("hex.DecodeString(%s): %v", address, err)
+		}
+		want, _, err := mapped.AddressHistory(addressBytes[:32], "")
+		if err != nil {
+			t.Fatalf("AddressHistory(%s): %v", address, err)
+		}
+		got, _, err := s.AddressHistory(addressBytes[:32], "")
+		if err != nil {
+			t.Fatalf("AddressHistory(%s): %v", address, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("AddressHistory(%s) differs from the mapped indices", address)
+		}
+	}
+	for height, block := range blocks {
+		id := block.ID()
+		want, err := mapped.ObjectItems(id[:])
+		if err != nil {
+			t.Fatalf("ObjectItems(block %d): %v", height, err)
+		}
+		got, err := s.ObjectItems(id[:])
+		if err != nil {
+			t.Fatalf("ObjectItems(block %d): %v", height, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ObjectItems(block %d) = %v, want %v", height, got, want)
+		}
+	}
+}
@@ -76,6 +76,19 @@ func ParseHeaders(headersBytes []byte) (*BlockHeadersSetImpl, error) {
 	}, nil
 }
 
+// NewHeadersSet returns the set of headers of the chain starting with
+// the genesis block.
+func NewHeadersSet(headers []types.BlockHeader) *BlockHeadersSetImpl {
+	headersBytes := make([]byte, 0, len(headers)*48)
+	for _, header := range headers {
+		headersBytes = append(headersBytes, header.Nonce[:]...)
+		headersBytes = append(headersBytes, encoding.EncUint64(uint64(header.Timestamp))...)
+		headersBytes = append(headersBytes, header.MerkleRoot[:]...)
+	}
+	set, _ := ParseHeaders(headersBytes)
+	return set
+}
+
 func verifyBlockHeader(
 	info BlockInfo,
 	minTimestamp types.Timestamp,
@@ -89,20 +102,22 @@ func verifyBlockHeader(
 		return fmt.Errorf("Block header validation failed: EarlyTimestamp")
 	}
 
-	// Check if the block is in the extreme future is omitted because it does
-	// included in the further check (FutureThreshold check) and does not make
-	// sense as optimization in our case.
-	//if info.Timestamp > types.CurrentTimestamp()+types.ExtremeFutureThreshold {
-	//	return fmt.Errorf("Block header validation failed: ExtremeFutureTimestamp")
-	//}
+	// Check if the block is in the extreme future. Such a block is invalid.
+	now := types.CurrentTimestamp()
+	if info.Timestamp > now+types.ExtremeFutureThreshold {
+		return fmt.Errorf("Block header validation failed: ExtremeFutureTimestamp")
+	}
 
 	// Check if the block is in the near future, but too far to be acceptable.
-	if info.Timestamp > types.CurrentTimestamp()+types.FutureThreshold {
-		return fmt.Errorf("Block header validation failed: FutureTimestamp")
+	// Such a block may become acceptable later.
+	if info.Timestamp > now+types.FutureThreshold {
+		return errFutureTimestamp
 	}
 	return nil
 }
 
+var errFutureTimestamp = fmt.Errorf("Block header validation failed: FutureTimestamp")
+
 // checkTarget returns true if the block's ID meets the given target.
 func checkTarget(id types.BlockID, target types.Target) bool {
 	return bytes.Compare(target[:], id[:]) >= 0
@@ -328,9 +343,20 @@ func calculateChildTarget(
 	}
 }
 
-func getTargets(headers BlockHeadersSet) []types.Target {
-	targets := make([]types.Target, headers.Length())
-	// Set the base values.
+// TargetState is the state of difficulty adjustment after a block.
+// It allows computing targets block by block, see NextTargetState.
+type TargetState struct {
+	Height      int
+	Target      types.Target // Target of the block.
+	ChildTarget types.Target // Target of the next block.
+	TotalTime   int64
+	TotalTarget types.Target
+	Timestamp   types.Timestamp // Timestamp of the block.
+}
+
+// NextTargetState returns the state after the block following parent.
+// Pass nil parent for the genesis block. headers must include the block.
+func NextTargetState(headers BlockHeadersSet, parent *TargetState) *TargetState {
 	// Parent timestamp for genesis block is GenesisTimestamp as well.
 	parentTimestamp := types.GenesisTimestamp
 	// Parent height for genesis block is 0.
@@ -339,17 +365,45 @@ func getTargets(headers BlockHeadersSet) []types.Target {
 	totalTime := int64(0)
 	totalTarget := types.RootDepth
 	// The first target is root target.
+	height := 0
+	target := types.RootTarget
+	if parent != nil {
+		parentTimestamp = parent.Timestamp
+		parentHeight = parent.Height
+		totalTime = parent.TotalTime
+		totalTarget = parent.TotalTarget
+		height = parent.Height + 1
+		target = parent.ChildTarget
+	}
+	blockHeader := headers.Index(height)
+	s := &TargetState{
+		Height:    height,
+		Target:    target,
+		Timestamp: blockHeader.Timestamp,
+	}
+	// The algorithm computes the target of a child.
+	s.ChildTarget = calculateChildTarget(headers, target, totalTime, totalTarget, parentHeight, parentTimestamp)
+	// Calculate the new block totals.
+	s.TotalTime, s.TotalTarget = calculateBlockTotals(height, blockHeader.CurrentID, totalTime, parentTimestamp, blockHeader.Timestamp, totalTarget, target)
+	return s
+}
+
+// Hashrate estimates hashes per second from the totals used by Oak.
+func (s *TargetState) Hashrate() types.Currency {
+	totalTime := s.TotalTime
+	if totalTime < 1 {
+		totalTime = 1
+	}
+	return s.TotalTarget.Difficulty().Div64(uint64(totalTime))
+}
+
+func getTargets(headers BlockHeadersSet) []types.Target {
+	targets := make([]types.Target, headers.Length())
 	targets[0] = types.RootTarget
+	var state *TargetState
 	for i := 0; i < headers.Length()-1; i++ {
-		blockHeader := headers.Index(i)
-		// The algorithm computes the target of a child.
-		// That's why we set i+1s target here.
-		targets[i+1] = calculateChildTarget(headers, targets[i], totalTime, totalTarget, parentHeight, parentTimestamp)
-		// Calculate the new block totals.
-		totalTime, totalTarget = calculateBlockTotals(i, blockHeader.CurrentID, totalTime, parentTimestamp, blockHeader.Timestamp, totalTarget, targets[i])
-		// Update the parents values.
-		parentTimestamp = blockHeader.Timestamp
-		parentHeight = i
+		state = NextTargetState(headers, state)
+		targets[i+1] = state.ChildTarget
 	}
 	return targets
 }
@@ -31,3 +31,88 @@ func TestVerify1000Blocks(t *testing.T) {
 		t.Errorf("VerifyBlockHeaders(first 1000 blocks): %v.", err)
 	}
 }
+
+func TestValidator(t *testing.T) {
+	blocks, err := read1000Blocks()
+	if err != nil {
+		t.Fatalf("read1000Blocks: %v", err)
+	}
+	v := NewValidator(nil, nil, 10)
+	for i, block := range blocks[:990] {
+		if err := v.Add(block); err != nil {
+			t.Fatalf("Add(block %d): %v", i, err)
+		}
+	}
+	// Resume from the headers of the validator.
+	v = NewValidator(v, func(height int) *TargetState {
+		return v.states[height-v.base]
+	}, 10)
+	if err := v.Add(blocks[995]); err == nil {
+		t.Errorf("Add accepted a block with unknown parent.")
+	}
+	bad := *blocks[990]
+	bad.Nonce[0]++
+	if err := v.Add(&bad); err == nil {
+		t.Errorf("Add accepted an unsolved block.")
+	}
+	for i, block := range blocks[990:] {
+		if err := v.Add(block); err != nil {
+			t.Fatalf("Add(block %d): %v", 990+i, err)
+		}
+	}
+	// Blocks forking from a recent block are accepted again.
+	if err := v.Add(blocks[995]); err != nil {
+		t.Errorf("Add(block forking from block 994): %v", err)
+	}
+	if v.Length() != 996 {
+		t.Errorf("Length() = %d after the fork, want 996", v.Length())
+	}
+	if err := v.Add(blocks[500]); err == nil {
+		t.Errorf("Add accepted a fork deeper than depth.")
+	}
+}
+
+func TestFutureBlock(t *testing.T) {
+	blocks, err := read1000Blocks()
+	if err != nil {
+		t.Fatalf("read1000Blocks: %v", err)
+	}
+	headers := &headersOfBlocks{blocks[:10]}
+	// Any block meets the easiest target.
+	parent := &TargetState{ChildTarget: types.RootDepth}
+	block := func(timestamp types.Timestamp) *types.Block {
+		return &types.Block{ParentID: blocks[9].ID(), Timestamp: timestamp}
+	}
+	now := types.CurrentTimestamp()
+	if err := VerifyBlock(headers, parent, block(now)); err != nil {
+		t.Errorf("VerifyBlock(current block): %v", err)
+	}
+	err = VerifyBlock(headers, parent, block(now+types.FutureThreshold+60))
+	if _, ok := err.(*FutureBlockError); !ok {
+		t.Errorf("VerifyBlock(block in the near future) = %v, want *FutureBlockError", err)
+	}
+	err = VerifyBlock(headers, parent, block(now+types.ExtremeFutureThreshold+60))
+	if _, ok := err.(*FutureBlockError); ok || err == nil {
+		t.Errorf("VerifyBlock(block in the extreme future) = %v, want an invalid block", err)
+	}
+}
+
+func TestNewHeadersSet(t *testing.T) {
+	blocks, err := read1000Blocks()
+	if err != nil {
+		t.Fatalf("read1000Blocks: %v", err)
+	}
+	var headers []types.BlockHeader
+	for _, block := range blocks {
+		headers = append(headers, block.Header())
+	}
+	set := NewHeadersSet(headers)
+	if err := VerifyBlockHeaders(set); err != nil {
+		t.Errorf("VerifyBlockHeaders: %v.", err)
+	}
+	for i, block := range blocks {
+		if id := set.Index(i).CurrentID; id != block.ID() {
+			t.Fatalf("set.Index(%d).CurrentID = %s, want %s", i, id, block.ID())
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/starius/sialite/fastmap"
+	"gitlab.com/NebulousLabs/Sia/crypto"
+	"gitlab.com/NebulousLabs/Sia/types"
+	"gitlab.com/NebulousLabs/merkletree"
+)
+
+// Proofs of completeness of address history.
+//
+// Merkle proofs of items only prove that the items are in the blockchain,
+// but a server can omit some of them. To detect omissions, the builder
+// commits to the index of addresses: it computes the hash of every page
+// of addressesFastmapData together with the values of its keys (see
+// fastmap.MultiMap.PageLeaf) and writes the hashes to addressesPageHashes.
+// The Merkle root of page hashes and the layout of pages form Commitment,
+// which the server publishes. For an address, the server returns the page
+// leaf responsible for the address with its Merkle proof, from which the
+// client learns how many items touch the address prefix.
+
+// Commitment commits to the set of items touching every address prefix
+// in the first NumBlocks blocks.
+type Commitment struct {
+	NumBlocks int
+	NumPages  int
+	fastmap.LeafParams
+	Inlined   bool
+	PagesRoot crypto.Hash
+}
+
+// CompletenessProof proves the set of items touching an address prefix.
+type CompletenessProof struct {
+	PageIndex   int
+	Leaf        []byte
+	MerkleProof []byte
+}
+
+var (
+	ErrNoCompleteness = fmt.Errorf("the index of addresses was built without proofs of completeness")
+)
+
+// IncompleteHistoryError is returned when the history of an address has
+// fewer items than committed.
+type IncompleteHistoryError struct {
+	Address   types.UnlockHash
+	Got, Want int
+}
+
+func (e *IncompleteHistoryError) Error() string {
+	return fmt.Sprintf("history of address %s is incomplete: got %d items, committed %d items", e.Address, e.Got, e.Want)
+}
+
+func mmapFile(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() == 0 {
+		return nil, nil
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(buf []byte) error {
+	if buf == nil {
+		return nil
+	}
+	return syscall.Munmap(buf)
+}
+
+// writePageHashes writes hashes of page leaves of multimap stored in
+// files <name>FastmapData and <name>Indices to file <name>PageHashes.
+func writePageHashes(dir, name string, valueLen int, uninliner fastmap.Uninliner) error {
+	data, err := mmapFile(path.Join(dir, name+"FastmapData"))
+	if err != nil {
+		return err
+	}
+	defer munmap(data)
+	values, err := mmapFile(path.Join(dir, name+"Indices"))
+	if err != nil {
+		return err
+	}
+	defer munmap(values)
+	m, err := fastmap.OpenMultiMap(valueLen, data, values, uninliner)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path.Join(dir, name+"PageHashes"))
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(f)
+	h := crypto.NewHash()
+	var sum []byte
+	for i := 0; i < m.NumPages(); i++ {
+		leaf, err := m.PageLeaf(i)
+		if err != nil {
+			return fmt.Errorf("PageLeaf(%d): %v", i, err)
+		}
+		h.Reset()
+		_, _ = h.Write([]byte{0x00})
+		_, _ = h.Write(leaf)
+		sum = h.Sum(sum[:0])
+		if _, err := buf.Write(sum); err != nil {
+			return err
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func merkleRoot(leavesHashes []byte) (root crypto.Hash) {
+	tree := merkletree.NewCachedTree(crypto.NewHash(), 0)
+	for start := 0; start < len(leavesHashes); start += crypto.HashSize {
+		tree.Push(leavesHashes[start : start+crypto.HashSize])
+	}
+	copy(root[:], tree.Root())
+	return
+}
+
+func (s *Server) buildCommitment(inlined bool) error {
+	npages := s.addressMap.NumPages()
+	if len(s.AddressesPageHashes) != npages*crypto.HashSize {
+		return fmt.Errorf("Bad length of addressesPageHashes")
+	}
+	s.commitment = &Commitment{
+		NumBlocks:  s.nblocks,
+		NumPages:   npages,
+		LeafParams: s.addressMap.LeafParams(),
+		Inlined:    inlined,
+		PagesRoot:  merkleRoot(s.AddressesPageHashes),
+	}
+	return nil
+}
+
+// Commitment returns the commitment to the index of addresses.
+func (s *Server) Commitment() (*Commitment, error) {
+	if s.commitment == nil {
+		return nil, ErrNoCompleteness
+	}
+	return s.commitment, nil
+}
+
+// AddressCompleteness returns the proof of the set of items touching
+// the address.
+func (s *Server) AddressCompleteness(address []byte) (*CompletenessProof, error) {
+	if s.commitment == nil {
+		return nil, ErrNoCompleteness
+	}
+	if len(address) != crypto.HashSize {
+		return nil, fmt.Errorf("size of address: want %d, got %d", crypto.HashSize, len(address))
+	}
+	addressPrefix := address[:s.addressPrefixLen]
+	ipage := s.addressMap.LeafPage(addressPrefix)
+	if ipage == -1 {
+		// The map is empty.
+		return &CompletenessProof{PageIndex: -1}, nil
+	}
+	leaf, err := s.addressMap.PageLeaf(ipage)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := merkleProof(s.AddressesPageHashes, ipage)
+	if err != nil {
+		return nil, err
+	}
+	return &CompletenessProof{
+		PageIndex:   ipage,
+		Leaf:        leaf,
+		MerkleProof: proof,
+	}, nil
+}
+
+// VerifyCompleteness checks the proof against the commitment and returns
+// the number of items touching the prefix of the address.
+func VerifyCompleteness(c *Commitment, address types.UnlockHash, proof *CompletenessProof) (int, error) {
+	if c.NumPages == 0 {
+		return 0, nil
+	}
+	if c.KeyLen <= 0 || c.KeyLen > len(address) || c.ContainerLen <= 0 || c.ValueLen <= 0 || c.PageLen < c.KeyLen+c.ContainerLen {
+		return 0, fmt.Errorf("bad parameters of the commitment")
+	}
+	if c.Inlined && (c.ValueLen > 4 || c.ContainerLen != 2*c.ValueLen) {
+		return 0, fmt.Errorf("bad parameters of the inliner")
+	}
+	if proof.PageIndex < 0 || proof.PageIndex >= c.NumPages {
+		return 0, fmt.Errorf("bad page index: %d", proof.PageIndex)
+	}
+	if !VerifyProof(c.PagesRoot[:], proof.Leaf, proof.MerkleProof, proof.PageIndex, c.NumPages) {
+		return 0, fmt.Errorf("bad Merkle proof of page %d", proof.PageIndex)
+	}
+	var uninliner fastmap.Uninliner = fastmap.NoUninliner{}
+	if c.Inlined {
+		uninliner = fastmap.NewFFOOInliner(c.ValueLen)
+	}
+	values, err := fastmap.LookupLeaf(proof.Leaf, address[:c.KeyLen], proof.PageIndex == 0, c.LeafParams, uninliner)
+	if err != nil {
+		return 0, err
+	}
+	return len(values) / c.ValueLen, nil
+}
+
+// touchesPrefix returns if the history item touches an address which
+// starts with the prefix.
+func touchesPrefix(item *HistoryItem, prefix []byte) bool {
+	if item.Payout != nil {
+		return string(item.Payout.UnlockHash[:len(prefix)]) == string(prefix)
+	}
+	errFound := fmt.Errorf("found")
+	err := forEachAddress(item.Tx, func(uh types.UnlockHash) error {
+		if string(uh[:len(prefix)]) == string(prefix) {
+			return errFound
+		}
+		return nil
+	})
+	return err == errFound
+}
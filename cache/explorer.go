@@ -0,0 +1,427 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path"
+
+	"github.com/golang/snappy"
+	"github.com/starius/sialite/emsort"
+	"github.com/starius/sialite/fastmap"
+	"gitlab.com/NebulousLabs/Sia/crypto"
+	"gitlab.com/NebulousLabs/Sia/encoding"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// Indexes needed by the explorer.
+//
+// objectsFastmapData and objectsIndices map prefixes of IDs of blocks,
+// transactions, outputs and contracts to the items creating them (the
+// first item of a block for the ID of the block). spendsFastmapData and
+// spendsIndices map prefixes of IDs of outputs to the transactions
+// spending them. Both multimaps use the parameters of the index of
+// contracts. Since only prefixes are stored, the caller must check the
+// full ID against the decoded items.
+//
+// blockIDs stores IDs of blocks (32 bytes per block) and sfpools stores
+// the value of siafund pool after every block (16 bytes big endian).
+
+const sfpoolLen = 16
+
+var (
+	ErrNoExplorer = fmt.Errorf("the cache was built without explorer indexes")
+)
+
+func newMultiMapSorter(dir, name string, memLimit, pageLen, prefixLen, fastmapPrefixLen, offsetLen, offsetIndexLen int) (emsort.SortedWriter, *os.File, error) {
+	data, err := os.Create(path.Join(dir, name+"FastmapData"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening %sFastmapData: %v", name, err)
+	}
+	indices, err := os.Create(path.Join(dir, name+"Indices"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening %sIndices: %v", name, err)
+	}
+	var inliner fastmap.Inliner = fastmap.NoInliner{}
+	containerLen := offsetIndexLen
+	if offsetLen == offsetIndexLen {
+		inliner = fastmap.NewFFOOInliner(offsetIndexLen)
+		containerLen = 2 * offsetIndexLen
+	}
+	w, err := fastmap.NewMultiMapWriter(pageLen, prefixLen, offsetIndexLen, fastmapPrefixLen, offsetLen, containerLen, data, indices, inliner)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fastmap.NewMultiMapWriter: %v", err)
+	}
+	tmp, err := os.Create(path.Join(dir, name+".tmp"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening %s.tmp: %v", name, err)
+	}
+	sorter, err := emsort.New(w, prefixLen+offsetIndexLen, emsort.BytesLess, false, memLimit, tmp)
+	if err != nil {
+		return nil, nil, fmt.Errorf("emsort.New: %v", err)
+	}
+	return sorter, tmp, nil
+}
+
+func closeMultiMapSorter(sorter emsort.SortedWriter, tmp *os.File) error {
+	if err := sorter.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Remove(tmp.Name())
+}
+
+type explorerWriter struct {
+	objects    emsort.SortedWriter
+	objectstmp *os.File
+	spends     emsort.SortedWriter
+	spendstmp  *os.File
+
+	blockIDs      *os.File
+	blockIDsBuf   *bufio.Writer
+	sfpools       *os.File
+	sfpoolsBuf    *bufio.Writer
+	sfpool        types.Currency
+	sfpoolEncoded [sfpoolLen]byte
+
+	prefixLen      int
+	offsetIndexLen int
+	record         []byte
+	tmpBuf         [8]byte
+}
+
+func newExplorerWriter(dir string, memLimit, pageLen, prefixLen, fastmapPrefixLen, offsetLen, offsetIndexLen int) (*explorerWriter, error) {
+	objects, objectstmp, err := newMultiMapSorter(dir, "objects", memLimit, pageLen, prefixLen, fastmapPrefixLen, offsetLen, offsetIndexLen)
+	if err != nil {
+		return nil, err
+	}
+	spends, spendstmp, err := newMultiMapSorter(dir, "spends", memLimit, pageLen, prefixLen, fastmapPrefixLen, offsetLen, offsetIndexLen)
+	if err != nil {
+		return nil, err
+	}
+	blockIDs, err := os.Create(path.Join(dir, "blockIDs"))
+	if err != nil {
+		return nil, fmt.Errorf("opening blockIDs: %v", err)
+	}
+	sfpools, err := os.Create(path.Join(dir, "sfpools"))
+	if err != nil {
+		return nil, fmt.Errorf("opening sfpools: %v", err)
+	}
+	return &explorerWriter{
+		objects:        objects,
+		objectstmp:     objectstmp,
+		spends:         spends,
+		spendstmp:      spendstmp,
+		blockIDs:       blockIDs,
+		blockIDsBuf:    bufio.NewWriter(blockIDs),
+		sfpools:        sfpools,
+		sfpoolsBuf:     bufio.NewWriter(sfpools),
+		sfpool:         types.NewCurrency64(0),
+		prefixLen:      prefixLen,
+		offsetIndexLen: offsetIndexLen,
+		record:         make([]byte, prefixLen+offsetIndexLen),
+	}, nil
+}
+
+func (w *explorerWriter) write(sorter emsort.SortedWriter, id crypto.Hash, itemIndex uint64) error {
+	copy(w.record, id[:w.prefixLen])
+	wireOffsetIndex := itemIndex + 1 // To avoid special 0 value on wire.
+	binary.BigEndian.PutUint64(w.tmpBuf[:], wireOffsetIndex)
+	copy(w.record[w.prefixLen:], w.tmpBuf[8-w.offsetIndexLen:])
+	if n, err := sorter.Write(w.record); err != nil {
+		return err
+	} else if n != len(w.record) {
+		return io.ErrShortWrite
+	}
+	return nil
+}
+
+func (w *explorerWriter) addObject(id crypto.Hash, itemIndex uint64) error {
+	return w.write(w.objects, id, itemIndex)
+}
+
+func (w *explorerWriter) addSpend(id crypto.Hash, itemIndex uint64) error {
+	return w.write(w.spends, id, itemIndex)
+}
+
+func (w *explorerWriter) addTx(tx *types.Transaction, itemIndex uint64) error {
+	if err := w.addObject(crypto.Hash(tx.ID()), itemIndex); err != nil {
+		return err
+	}
+	for _, sci := range tx.SiacoinInputs {
+		if err := w.addSpend(crypto.Hash(sci.ParentID), itemIndex); err != nil {
+			return err
+		}
+	}
+	for _, sfi := range tx.SiafundInputs {
+		if err := w.addSpend(crypto.Hash(sfi.ParentID), itemIndex); err != nil {
+			return err
+		}
+		if err := w.addObject(crypto.Hash(sfi.ParentID.SiaClaimOutputID()), itemIndex); err != nil {
+			return err
+		}
+	}
+	for i := range tx.SiacoinOutputs {
+		if err := w.addObject(crypto.Hash(tx.SiacoinOutputID(uint64(i))), itemIndex); err != nil {
+			return err
+		}
+	}
+	for i := range tx.SiafundOutputs {
+		if err := w.addObject(crypto.Hash(tx.SiafundOutputID(uint64(i))), itemIndex); err != nil {
+			return err
+		}
+	}
+	for i, contract := range tx.FileContracts {
+		fcid := tx.FileContractID(uint64(i))
+		if err := w.addObject(crypto.Hash(fcid), itemIndex); err != nil {
+			return err
+		}
+		sum := types.NewCurrency64(0)
+		for j, so := range contract.ValidProofOutputs {
+			if err := w.addObject(crypto.Hash(fcid.StorageProofOutputID(types.ProofValid, uint64(j))), itemIndex); err != nil {
+				return err
+			}
+			sum = sum.Add(so.Value)
+		}
+		for j := range contract.MissedProofOutputs {
+			if err := w.addObject(crypto.Hash(fcid.StorageProofOutputID(types.ProofMissed, uint64(j))), itemIndex); err != nil {
+				return err
+			}
+		}
+		w.sfpool = w.sfpool.Add(contract.Payout.Sub(sum))
+	}
+	for _, rev := range tx.FileContractRevisions {
+		for j := range rev.NewValidProofOutputs {
+			if err := w.addObject(crypto.Hash(rev.ParentID.StorageProofOutputID(types.ProofValid, uint64(j))), itemIndex); err != nil {
+				return err
+			}
+		}
+		for j := range rev.NewMissedProofOutputs {
+			if err := w.addObject(crypto.Hash(rev.ParentID.StorageProofOutputID(types.ProofMissed, uint64(j))), itemIndex); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addBlock indexes the block, which items start with firstItem.
+func (w *explorerWriter) addBlock(block *types.Block, firstItem uint64) error {
+	id := block.ID()
+	if _, err := w.blockIDsBuf.Write(id[:]); err != nil {
+		return err
+	}
+	if len(block.MinerPayouts) != 0 || len(block.Transactions) != 0 {
+		if err := w.addObject(crypto.Hash(id), firstItem); err != nil {
+			return err
+		}
+	}
+	itemIndex := firstItem
+	for i := range block.MinerPayouts {
+		if err := w.addObject(crypto.Hash(block.MinerPayoutID(uint64(i))), itemIndex); err != nil {
+			return err
+		}
+		itemIndex++
+	}
+	for i := range block.Transactions {
+		if err := w.addTx(&block.Transactions[i], itemIndex); err != nil {
+			return err
+		}
+		itemIndex++
+	}
+	sfpool := w.sfpool.Big().Bytes()
+	if len(sfpool) > sfpoolLen {
+		return fmt.Errorf("too large siafund pool: %s", w.sfpool)
+	}
+	w.sfpoolEncoded = [sfpoolLen]byte{}
+	copy(w.sfpoolEncoded[sfpoolLen-len(sfpool):], sfpool)
+	_, err := w.sfpoolsBuf.Write(w.sfpoolEncoded[:])
+	return err
+}
+
+func (w *explorerWriter) close() error {
+	if err := closeMultiMapSorter(w.objects, w.objectstmp); err != nil {
+		return err
+	}
+	if err := closeMultiMapSorter(w.spends, w.spendstmp); err != nil {
+		return err
+	}
+	if err := w.blockIDsBuf.Flush(); err != nil {
+		return err
+	}
+	if err := w.blockIDs.Close(); err != nil {
+		return err
+	}
+	if err := w.sfpoolsBuf.Flush(); err != nil {
+		return err
+	}
+	return w.sfpools.Close()
+}
+
+func (s *Server) openExplorer(par *parameters) error {
+	if len(s.BlockIDs) != s.nblocks*crypto.HashSize {
+		return fmt.Errorf("Bad length of blockIDs")
+	}
+	if len(s.Sfpools) != s.nblocks*sfpoolLen {
+		return fmt.Errorf("Bad length of sfpools")
+	}
+	var uninliner fastmap.Uninliner = fastmap.NoUninliner{}
+	if par.ContractOffsetLen == par.OffsetIndexLen {
+		uninliner = fastmap.NewFFOOInliner(par.OffsetIndexLen)
+	}
+	objectMap, err := s.openMultiMap("objects", par.OffsetIndexLen, s.ObjectsFastmapData, s.ObjectsIndices, uninliner)
+	if err != nil {
+		return err
+	}
+	spendMap, err := s.openMultiMap("spends", par.OffsetIndexLen, s.SpendsFastmapData, s.SpendsIndices, uninliner)
+	if err != nil {
+		return err
+	}
+	s.objectMap = objectMap
+	s.spendMap = spendMap
+	return nil
+}
+
+// NumBlocks returns the number of blocks in the cache.
+func (s *Server) NumBlocks() int {
+	return s.nblocks
+}
+
+// BlockID returns the ID of the block with the height.
+func (s *Server) BlockID(height int) (id types.BlockID, err error) {
+	if s.objectMap == nil {
+		return id, ErrNoExplorer
+	}
+	if height < 0 || height >= s.nblocks {
+		return id, ErrTooLargeIndex
+	}
+	copy(id[:], s.BlockIDs[height*crypto.HashSize:])
+	return id, nil
+}
+
+// BlockHeader returns the header of the block with the height.
+func (s *Server) BlockHeader(height int) (header types.BlockHeader, err error) {
+	if height < 0 || height >= s.nblocks {
+		return header, ErrTooLargeIndex
+	}
+	header = headerAt(s.Headers, height)
+	if height != 0 {
+		header.ParentID, err = s.BlockID(height - 1)
+	}
+	return header, err
+}
+
+// Block decodes the block with the height from its items.
+func (s *Server) Block(height int) (*types.Block, error) {
+	header, err := s.BlockHeader(height)
+	if err != nil {
+		return nil, err
+	}
+	block := &types.Block{
+		ParentID:  header.ParentID,
+		Nonce:     header.Nonce,
+		Timestamp: header.Timestamp,
+	}
+	payoutsStart, txsStart, nleaves := s.getBlockLocation(height)
+	for i := payoutsStart; i < txsStart; i++ {
+		var mp types.SiacoinOutput
+		if err := encoding.Unmarshal(s.itemData(i), &mp); err != nil {
+			return nil, fmt.Errorf("decoding item %d: %v", i, err)
+		}
+		block.MinerPayouts = append(block.MinerPayouts, mp)
+	}
+	for i := txsStart; i < payoutsStart+nleaves; i++ {
+		data, err := snappy.Decode(nil, s.itemData(i))
+		if err != nil {
+			return nil, fmt.Errorf("snappy.Decode of item %d: %v", i, err)
+		}
+		var tx types.Transaction
+		if err := encoding.Unmarshal(data, &tx); err != nil {
+			return nil, fmt.Errorf("decoding item %d: %v", i, err)
+		}
+		block.Transactions = append(block.Transactions, tx)
+	}
+	return block, nil
+}
+
+// ItemLocation returns the height of the block containing the item and
+// the index of the item among miner payouts of the block (if payout is
+// true) or among its transactions.
+func (s *Server) ItemLocation(itemIndex int) (block, index int, payout bool, err error) {
+	if itemIndex < 0 || itemIndex >= s.nitems {
+		return 0, 0, false, ErrTooLargeIndex
+	}
+	block = s.itemBlock(itemIndex)
+	payoutsStart, txsStart, _ := s.getBlockLocation(block)
+	if itemIndex < txsStart {
+		return block, itemIndex - payoutsStart, true, nil
+	}
+	return block, itemIndex - txsStart, false, nil
+}
+
+// Sfpool returns the value of siafund pool after the block.
+func (s *Server) Sfpool(height int) (types.Currency, error) {
+	if s.objectMap == nil {
+		return types.Currency{}, ErrNoExplorer
+	}
+	if height < 0 || height >= s.nblocks {
+		return types.Currency{}, ErrTooLargeIndex
+	}
+	start := height * sfpoolLen
+	value := new(big.Int).SetBytes(s.Sfpools[start : start+sfpoolLen])
+	return types.NewCurrency(value), nil
+}
+
+// itemIndices returns indices of items stored in the multimap under
+// the prefix of the ID.
+func (s *Server) itemIndices(m *fastmap.MultiMap, id []byte, prefixLen int) ([]int, error) {
+	if len(id) != crypto.HashSize {
+		return nil, fmt.Errorf("size of ID: want %d, got %d", crypto.HashSize, len(id))
+	}
+	values, err := m.Lookup(id[:prefixLen])
+	if err != nil {
+		return nil, err
+	}
+	var tmp [8]byte
+	tmpBytesSuffix := tmp[8-s.offsetIndexLen:]
+	indices := make([]int, 0, len(values)/s.offsetIndexLen)
+	for start := 0; start < len(values); start += s.offsetIndexLen {
+		copy(tmpBytesSuffix, values[start:start+s.offsetIndexLen])
+		// Value 0 is special on wire, so all indices are shifted.
+		indices = append(indices, int(binary.BigEndian.Uint64(tmp[:]))-1)
+	}
+	return indices, nil
+}
+
+// AddressItems returns indices of items touching the prefix of the address.
+func (s *Server) AddressItems(address []byte) ([]int, error) {
+	return s.itemIndices(s.addressMap, address, s.addressPrefixLen)
+}
+
+// ContractItems returns indices of items touching the prefix of the contract.
+func (s *Server) ContractItems(contract []byte) ([]int, error) {
+	return s.itemIndices(s.contractMap, contract, s.contractPrefixLen)
+}
+
+// ObjectItems returns indices of items which create objects with the
+// prefix of the ID: blocks, transactions, outputs and contracts.
+func (s *Server) ObjectItems(id []byte) ([]int, error) {
+	if s.objectMap == nil {
+		return nil, ErrNoExplorer
+	}
+	return s.itemIndices(s.objectMap, id, s.contractPrefixLen)
+}
+
+// SpendItems returns indices of items which spend outputs with the prefix
+// of the ID.
+func (s *Server) SpendItems(id []byte) ([]int, error) {
+	if s.spendMap == nil {
+		return nil, ErrNoExplorer
+	}
+	return s.itemIndices(s.spendMap, id, s.contractPrefixLen)
+}
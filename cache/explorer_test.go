@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"os"
+	"testing"
+
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+func containsItem(items []int, item int) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExplorer(t *testing.T) {
+	blocks, err := read1000Blocks()
+	if err != nil {
+		t.Fatalf("read1000Blocks: %v", err)
+	}
+	s, tmpDir, err := buildTestServer(blocks, false, true)
+	if err != nil {
+		t.Fatalf("buildTestServer: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer s.Close()
+	if s.NumBlocks() != len(blocks) {
+		t.Fatalf("s.NumBlocks() = %d, want %d", s.NumBlocks(), len(blocks))
+	}
+	item := 0
+	for height, want := range blocks {
+		id, err := s.BlockID(height)
+		if err != nil {
+			t.Fatalf("s.BlockID(%d): %v", height, err)
+		}
+		if id != want.ID() {
+			t.Fatalf("s.BlockID(%d) = %s, want %s", height, id, want.ID())
+		}
+		block, err := s.Block(height)
+		if err != nil {
+			t.Fatalf("s.Block(%d): %v", height, err)
+		}
+		if block.ID() != want.ID() || block.MerkleRoot() != want.MerkleRoot() {
+			t.Fatalf("s.Block(%d) returned another block", height)
+		}
+		if items, err := s.ObjectItems(id[:]); err != nil {
+			t.Fatalf("s.ObjectItems(block %d): %v", height, err)
+		} else if !containsItem(items, item) {
+			t.Errorf("s.ObjectItems(block %d) = %v, want item %d", height, items, item)
+		}
+		for i := range want.MinerPayouts {
+			outid := want.MinerPayoutID(uint64(i))
+			if items, err := s.ObjectItems(outid[:]); err != nil {
+				t.Fatalf("s.ObjectItems(payout): %v", err)
+			} else if !containsItem(items, item) {
+				t.Errorf("s.ObjectItems(payout %d of block %d) = %v, want item %d", i, height, items, item)
+			}
+			b, index, payout, err := s.ItemLocation(item)
+			if err != nil || b != height || index != i || !payout {
+				t.Errorf("s.ItemLocation(%d) = %d, %d, %v, %v; want %d, %d, true, nil", item, b, index, payout, err, height, i)
+			}
+			item++
+		}
+		for i, tx := range want.Transactions {
+			txid := tx.ID()
+			if items, err := s.ObjectItems(txid[:]); err != nil {
+				t.Fatalf("s.ObjectItems(tx): %v", err)
+			} else if !containsItem(items, item) {
+				t.Errorf("s.ObjectItems(tx %s) = %v, want item %d", txid, items, item)
+			}
+			for _, sci := range tx.SiacoinInputs {
+				if items, err := s.SpendItems(sci.ParentID[:]); err != nil {
+					t.Fatalf("s.SpendItems: %v", err)
+				} else if !containsItem(items, item) {
+					t.Errorf("s.SpendItems(%s) = %v, want item %d", sci.ParentID, items, item)
+				}
+			}
+			b, index, payout, err := s.ItemLocation(item)
+			if err != nil || b != height || index != i || payout {
+				t.Errorf("s.ItemLocation(%d) = %d, %d, %v, %v; want %d, %d, false, nil", item, b, index, payout, err, height, i)
+			}
+			item++
+		}
+		if sfpool, err := s.Sfpool(height); err != nil {
+			t.Fatalf("s.Sfpool(%d): %v", height, err)
+		} else if !sfpool.Equals(types.ZeroCurrency) {
+			t.Errorf("s.Sfpool(%d) = %s, want 0 (no contracts)", height, sfpool)
+		}
+	}
+
+	plain, plainDir, err := buildTestServer(blocks[:10], false, false)
+	if err != nil {
+		t.Fatalf("buildTestServer: %v", err)
+	}
+	defer os.RemoveAll(plainDir)
+	defer plain.Close()
+	if _, err := plain.ObjectItems(make([]byte, 32)); err != ErrNoExplorer {
+		t.Errorf("plain.ObjectItems returned %v, want ErrNoExplorer", err)
+	}
+}
@@ -0,0 +1,444 @@
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"gitlab.com/NebulousLabs/Sia/crypto"
+	"gitlab.com/NebulousLabs/Sia/encoding"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// HistoryItem is an item of history which Merkle proof has been verified.
+// Exactly one of Payout and Tx is set.
+type HistoryItem struct {
+	Item
+
+	Payout *types.SiacoinOutput
+	Tx     *types.Transaction
+}
+
+// Omission is an item which was proven by one server and not returned
+// by another server.
+type Omission struct {
+	Server string
+	Block  int
+	Index  int
+}
+
+// ServerFailure is a server which failed to return a valid history.
+// Its items are not used and it is not reported in omissions.
+type ServerFailure struct {
+	Server string
+	Err    error
+}
+
+// TipMismatchError is returned when servers disagree on the last block.
+type TipMismatchError struct {
+	Server1, Server2 string
+	Tip1, Tip2       types.BlockID
+	Height1, Height2 int
+}
+
+func (e *TipMismatchError) Error() string {
+	return fmt.Sprintf("servers %s and %s disagree on the tip: %s (height %d) vs %s (height %d)", e.Server1, e.Server2, e.Tip1, e.Height1, e.Tip2, e.Height2)
+}
+
+// CommitmentMismatchError is returned when a server returns a commitment
+// different from the commitment of another server or from the trusted one.
+type CommitmentMismatchError struct {
+	Server1, Server2 string
+	Root1, Root2     crypto.Hash
+}
+
+func (e *CommitmentMismatchError) Error() string {
+	return fmt.Sprintf("servers %s and %s returned different commitments: pages root %s vs %s", e.Server1, e.Server2, e.Root1, e.Root2)
+}
+
+// Client downloads data from several sialiteservers and cross-checks it
+// to reduce trust in any single server.
+type Client struct {
+	servers    []string
+	httpClient *http.Client
+	commitment *Commitment
+	trusted    *Commitment
+}
+
+// NewClient creates a client for the servers. Each server is specified as
+// host:port. If httpClient is nil, http.DefaultClient is used.
+func NewClient(servers []string, httpClient *http.Client) (*Client, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no servers")
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		servers:    servers,
+		httpClient: httpClient,
+	}, nil
+}
+
+func (c *Client) get(server, path string, query url.Values) (*http.Response, error) {
+	u := fmt.Sprintf("http://%s%s?%s", server, path, query.Encode())
+	resp, err := c.httpClient.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("http.Get(%q): %v", u, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("http.Get(%q): %s", u, resp.Status)
+	}
+	return resp, nil
+}
+
+func (c *Client) headers(server string) (*BlockHeadersSetImpl, error) {
+	resp, err := c.get(server, "/v1/headers", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	headersBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := ParseHeaders(headersBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyBlockHeaders(headers); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// Headers downloads and verifies block headers from all the servers.
+// All the servers must agree on the last block.
+func (c *Client) Headers() (*BlockHeadersSetImpl, error) {
+	var first *BlockHeadersSetImpl
+	for i, server := range c.servers {
+		headers, err := c.headers(server)
+		if err != nil {
+			return nil, fmt.Errorf("headers from %s: %v", server, err)
+		}
+		if i == 0 {
+			first = headers
+			continue
+		}
+		tip1 := first.Index(first.Length() - 1).CurrentID
+		tip2 := headers.Index(headers.Length() - 1).CurrentID
+		if tip1 != tip2 {
+			return nil, &TipMismatchError{
+				Server1: c.servers[0],
+				Server2: server,
+				Tip1:    tip1,
+				Tip2:    tip2,
+				Height1: first.Length() - 1,
+				Height2: headers.Length() - 1,
+			}
+		}
+	}
+	return first, nil
+}
+
+// Commitment downloads the commitment to the index of addresses from all
+// the servers. All the servers must return the same commitment covering
+// all the headers. After a successful call AddressHistory checks that
+// the servers return all the items touching the address.
+//
+// The commitment is attested by the servers only: nothing in the block
+// headers ties PagesRoot to the chain. Servers built with the same
+// parameters publish the same commitment, so agreement protects against
+// dishonest servers as long as one of them is honest. Colluding servers
+// can agree on a commitment omitting items. To remove this trust, get
+// the commitment from a trusted source and pass it to TrustCommitment;
+// Commitment then requires all the servers to match it.
+func (c *Client) Commitment(headers BlockHeadersSet) (*Commitment, error) {
+	first, firstServer := c.trusted, "trusted"
+	for _, server := range c.servers {
+		resp, err := c.get(server, "/v1/commitment", nil)
+		if err != nil {
+			return nil, fmt.Errorf("commitment from %s: %v", server, err)
+		}
+		var commitment Commitment
+		err = encoding.NewDecoder(resp.Body).Decode(&commitment)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("commitment from %s: Decode: %v", server, err)
+		}
+		if commitment.NumBlocks != headers.Length() {
+			return nil, fmt.Errorf("commitment from %s covers %d blocks, want %d", server, commitment.NumBlocks, headers.Length())
+		}
+		if first == nil {
+			first, firstServer = &commitment, server
+		} else if commitment != *first {
+			return nil, &CommitmentMismatchError{
+				Server1: firstServer,
+				Server2: server,
+				Root1:   first.PagesRoot,
+				Root2:   commitment.PagesRoot,
+			}
+		}
+	}
+	c.commitment = first
+	return first, nil
+}
+
+// TrustCommitment makes the client use the commitment obtained from
+// a trusted source instead of the one attested by the servers. It must
+// cover all the headers. After a successful call AddressHistory checks
+// completeness against it and Commitment requires the servers to match it.
+func (c *Client) TrustCommitment(commitment Commitment, headers BlockHeadersSet) error {
+	if commitment.NumBlocks != headers.Length() {
+		return fmt.Errorf("the trusted commitment covers %d blocks, want %d", commitment.NumBlocks, headers.Length())
+	}
+	c.trusted = &commitment
+	c.commitment = &commitment
+	return nil
+}
+
+// committedItems returns the number of items touching the prefix of
+// the address, proven by every server against the commitment.
+func (c *Client) committedItems(address types.UnlockHash) (int, error) {
+	want := -1
+	for _, server := range c.servers {
+		query := url.Values{}
+		query.Set("address", address.String())
+		resp, err := c.get(server, "/v1/address-completeness", query)
+		if err != nil {
+			return 0, fmt.Errorf("completeness proof from %s: %v", server, err)
+		}
+		var proof CompletenessProof
+		err = encoding.NewDecoder(resp.Body).Decode(&proof)
+		resp.Body.Close()
+		if err != nil {
+			return 0, fmt.Errorf("completeness proof from %s: Decode: %v", server, err)
+		}
+		n, err := VerifyCompleteness(c.commitment, address, &proof)
+		if err != nil {
+			return 0, fmt.Errorf("completeness proof from %s: %v", server, err)
+		}
+		if want != -1 && n != want {
+			// Impossible unless the hash function is broken.
+			return 0, fmt.Errorf("completeness proofs from %s and %s disagree", c.servers[0], server)
+		}
+		want = n
+	}
+	return want, nil
+}
+
+func (c *Client) history(server, kind, id string, headers BlockHeadersSet) ([]HistoryItem, error) {
+	next := ""
+	cursors := make(map[string]bool)
+	var rawItems []Item
+	for {
+		query := url.Values{}
+		query.Set(kind, id)
+		query.Set("start", next)
+		resp, err := c.get(server, "/v1/"+kind+"-history", query)
+		if err != nil {
+			return nil, err
+		}
+		var history []Item
+		err = encoding.NewDecoder(resp.Body).DecodeAll(&next, &history)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("DecodeAll: %v", err)
+		}
+		rawItems = append(rawItems, history...)
+		if next == "" {
+			break
+		}
+		if cursors[next] {
+			return nil, fmt.Errorf("the server repeated cursor %q", next)
+		}
+		cursors[next] = true
+	}
+	var items []HistoryItem
+	for i := range rawItems {
+		item, err := verifyItem(rawItems[i], headers)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func verifyItem(item Item, headers BlockHeadersSet) (HistoryItem, error) {
+	data, err := item.SourceData(nil)
+	if err != nil {
+		return HistoryItem{}, fmt.Errorf("item.SourceData: %v", err)
+	}
+	if item.Block < 0 || item.Block >= headers.Length() {
+		return HistoryItem{}, fmt.Errorf("bad block index: %d", item.Block)
+	}
+	header := headers.Index(item.Block)
+	if !VerifyProof(header.MerkleRoot[:], data, item.MerkleProof, item.Index, item.NumLeaves) {
+		return HistoryItem{}, fmt.Errorf("bad Merkle proof of item %d in block %d", item.Index, item.Block)
+	}
+	full := HistoryItem{Item: item}
+	if item.Index < item.NumMinerPayouts {
+		var payout types.SiacoinOutput
+		if err := encoding.Unmarshal(data, &payout); err != nil {
+			return HistoryItem{}, fmt.Errorf("encoding.Unmarshal payout: %v", err)
+		}
+		full.Payout = &payout
+	} else {
+		var tx types.Transaction
+		if err := encoding.Unmarshal(data, &tx); err != nil {
+			return HistoryItem{}, fmt.Errorf("encoding.Unmarshal tx: %v", err)
+		}
+		full.Tx = &tx
+	}
+	return full, nil
+}
+
+type itemKey struct {
+	block, index int
+}
+
+// crossCheck downloads the history from all the servers and returns
+// the union of verified items for which relevant returns true ordered by
+// location in the blockchain. Other items are dropped, so a server can
+// not make other servers look like they omit items by returning real
+// but unrelated items. Servers which failed to return an item proven by
+// another server are reported in the list of omissions. Servers which
+// failed to return a valid history are reported in the list of failures.
+// It fails only if all the servers fail.
+func (c *Client) crossCheck(kind, id string, headers BlockHeadersSet, relevant func(item *HistoryItem) bool) ([]HistoryItem, []Omission, []ServerFailure, error) {
+	union := make(map[itemKey]HistoryItem)
+	perServer := make([]map[itemKey]struct{}, len(c.servers))
+	var failures []ServerFailure
+	for i, server := range c.servers {
+		items, err := c.history(server, kind, id, headers)
+		if err != nil {
+			failures = append(failures, ServerFailure{
+				Server: server,
+				Err:    fmt.Errorf("%s history: %v", kind, err),
+			})
+			continue
+		}
+		seen := make(map[itemKey]struct{}, len(items))
+		for j := range items {
+			if !relevant(&items[j]) {
+				continue
+			}
+			key := itemKey{items[j].Block, items[j].Index}
+			seen[key] = struct{}{}
+			union[key] = items[j]
+		}
+		perServer[i] = seen
+	}
+	if len(failures) == len(c.servers) {
+		return nil, nil, failures, fmt.Errorf("%s history from %s: %v", kind, failures[0].Server, failures[0].Err)
+	}
+	items := make([]HistoryItem, 0, len(union))
+	for _, item := range union {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Block != items[j].Block {
+			return items[i].Block < items[j].Block
+		}
+		return items[i].Index < items[j].Index
+	})
+	var omissions []Omission
+	for _, item := range items {
+		key := itemKey{item.Block, item.Index}
+		for i, server := range c.servers {
+			if perServer[i] == nil {
+				// Failed.
+				continue
+			}
+			if _, has := perServer[i][key]; !has {
+				omissions = append(omissions, Omission{
+					Server: server,
+					Block:  item.Block,
+					Index:  item.Index,
+				})
+			}
+		}
+	}
+	return items, omissions, failures, nil
+}
+
+// AddressHistory returns the union of address histories returned by
+// the servers, the list of items omitted by some of the servers and
+// the list of servers which failed. Items not touching the address are
+// dropped. If Commitment or TrustCommitment was called, AddressHistory
+// also checks that the union is complete and returns
+// *IncompleteHistoryError otherwise. Completeness is only as trustworthy
+// as the commitment, see Commitment.
+func (c *Client) AddressHistory(address types.UnlockHash, headers BlockHeadersSet) ([]HistoryItem, []Omission, []ServerFailure, error) {
+	// The index stores prefixes of addresses, so the commitment counts
+	// items touching any address with the same prefix. They are kept
+	// until the number is checked.
+	prefix := address[:]
+	if c.commitment != nil {
+		prefix = address[:c.commitment.KeyLen]
+	}
+	items, omissions, failures, err := c.crossCheck("address", address.String(), headers, func(item *HistoryItem) bool {
+		return touchesPrefix(item, prefix)
+	})
+	if err != nil || c.commitment == nil {
+		return items, omissions, failures, err
+	}
+	want, err := c.committedItems(address)
+	if err != nil {
+		return nil, nil, failures, err
+	}
+	if got := len(items); got < want {
+		return nil, nil, failures, &IncompleteHistoryError{
+			Address: address,
+			Got:     got,
+			Want:    want,
+		}
+	} else if got > want {
+		return nil, nil, failures, fmt.Errorf("history of address %s has %d items, but only %d items are committed", address, got, want)
+	}
+	var own []HistoryItem
+	for i := range items {
+		if touchesPrefix(&items[i], address[:]) {
+			own = append(own, items[i])
+		}
+	}
+	return own, omissions, failures, nil
+}
+
+// ContractHistory returns the union of contract histories returned by
+// the servers, the list of items omitted by some of the servers and the
+// list of servers which failed. Items not touching the contract are
+// dropped.
+func (c *Client) ContractHistory(fcid types.FileContractID, headers BlockHeadersSet) ([]HistoryItem, []Omission, []ServerFailure, error) {
+	return c.crossCheck("contract", fcid.String(), headers, func(item *HistoryItem) bool {
+		return touchesContract(item, fcid)
+	})
+}
+
+// touchesContract returns if the history item creates, revises or proves
+// the contract.
+func touchesContract(item *HistoryItem, fcid types.FileContractID) bool {
+	if item.Tx == nil {
+		return false
+	}
+	for i := range item.Tx.FileContracts {
+		if item.Tx.FileContractID(uint64(i)) == fcid {
+			return true
+		}
+	}
+	for _, rev := range item.Tx.FileContractRevisions {
+		if rev.ParentID == fcid {
+			return true
+		}
+	}
+	for _, proof := range item.Tx.StorageProofs {
+		if proof.ParentID == fcid {
+			return true
+		}
+	}
+	return false
+}
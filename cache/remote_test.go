@@ -0,0 +1,433 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/Sia/crypto"
+	"gitlab.com/NebulousLabs/Sia/encoding"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+func buildTestServer(blocks []*types.Block, completeness, explorer bool) (*Server, string, error) {
+	tmpDir, err := ioutil.TempDir("", "buildTestServer")
+	if err != nil {
+		return nil, "", fmt.Errorf("ioutil.TempDir: %v", err)
+	}
+	b, err := NewBuilder(tmpDir, 1024*1024, 8, 4, 4096, 16, 5, 4, 4096, 16, 5, 4, BuilderOptions{Completeness: completeness, Explorer: explorer})
+	if err != nil {
+		return nil, "", fmt.Errorf("NewBuilder: %v", err)
+	}
+	for _, block := range blocks {
+		if err := b.Add(block); err != nil {
+			return nil, "", fmt.Errorf("b.Add: %v", err)
+		}
+	}
+	if err := b.Close(); err != nil {
+		return nil, "", fmt.Errorf("b.Close: %v", err)
+	}
+	s, err := NewServer(tmpDir, ServerOptions{})
+	if err != nil {
+		return nil, "", fmt.Errorf("NewServer: %v", err)
+	}
+	if err := s.Verify(); err != nil {
+		return nil, "", fmt.Errorf("s.Verify: %v", err)
+	}
+	return s, tmpDir, nil
+}
+
+// testHandler serves the subset of sialiteserver API used by Client.
+// If omit is true, the first item of every history is dropped. Items of
+// extra are added to every history. If fail is true, histories are not
+// served. If loop is true, every page of history points to itself.
+// If forge is true, the commitment has a wrong pages root.
+type testHandler struct {
+	s     *Server
+	omit  bool
+	extra []Item
+	fail  bool
+	loop  bool
+	forge bool
+}
+
+func (h *testHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/headers" {
+		http.ServeContent(w, r, "headers", time.Now(), bytes.NewReader(h.s.Headers))
+		return
+	}
+	if r.URL.Path == "/v1/commitment" {
+		c, err := h.s.Commitment()
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if h.forge {
+			forged := *c
+			forged.PagesRoot[0] ^= 1
+			c = &forged
+		}
+		encoding.NewEncoder(w).Encode(*c)
+		return
+	}
+	if r.URL.Path == "/v1/address-completeness" {
+		var address types.UnlockHash
+		if err := address.LoadString(r.URL.Query().Get("address")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		proof, err := h.s.AddressCompleteness(address[:])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		encoding.NewEncoder(w).Encode(*proof)
+		return
+	}
+	var history []Item
+	var next string
+	var err error
+	start := r.URL.Query().Get("start")
+	if r.URL.Path == "/v1/address-history" {
+		var address types.UnlockHash
+		if err := address.LoadString(r.URL.Query().Get("address")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		history, next, err = h.s.AddressHistory(address[:], start)
+	} else if r.URL.Path == "/v1/contract-history" {
+		var id crypto.Hash
+		if err := id.LoadString(r.URL.Query().Get("contract")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		history, next, err = h.s.ContractHistory(id[:], start)
+	} else {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.fail {
+		http.Error(w, "failure", http.StatusInternalServerError)
+		return
+	}
+	if h.omit && start == "" && len(history) != 0 {
+		history = history[1:]
+	}
+	if start == "" {
+		history = append(history, h.extra...)
+	}
+	if h.loop {
+		next = "loop"
+	}
+	encoding.NewEncoder(w).EncodeAll(next, history)
+}
+
+func serverAddress(t *testing.T, ts *httptest.Server) string {
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%q): %v", ts.URL, err)
+	}
+	return u.Host
+}
+
+func TestClientCrossCheck(t *testing.T) {
+	blocks, err := read1000Blocks()
+	if err != nil {
+		t.Fatalf("read1000Blocks: %v", err)
+	}
+	addresses, err := readAddresses()
+	if err != nil {
+		t.Fatalf("readAddresses: %v", err)
+	}
+	s, tmpDir, err := buildTestServer(blocks, false, false)
+	if err != nil {
+		t.Fatalf("buildTestServer: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer s.Close()
+	honest := httptest.NewServer(&testHandler{s: s})
+	defer honest.Close()
+	liar := httptest.NewServer(&testHandler{s: s, omit: true})
+	defer liar.Close()
+	honestAddr := serverAddress(t, honest)
+	liarAddr := serverAddress(t, liar)
+
+	c, err := NewClient([]string{honestAddr, liarAddr}, nil)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	headers, err := c.Headers()
+	if err != nil {
+		t.Fatalf("c.Headers: %v", err)
+	}
+	if headers.Length() != len(blocks) {
+		t.Fatalf("c.Headers returned %d headers, want %d", headers.Length(), len(blocks))
+	}
+	var address types.UnlockHash
+	if err := address.LoadString(addresses[0]); err != nil {
+		t.Fatalf("address.LoadString(%q): %v", addresses[0], err)
+	}
+	items, omissions, _, err := c.AddressHistory(address, headers)
+	if err != nil {
+		t.Fatalf("c.AddressHistory: %v", err)
+	}
+	if len(items) == 0 {
+		t.Fatalf("c.AddressHistory returned no items")
+	}
+	if len(omissions) != 1 {
+		t.Fatalf("c.AddressHistory returned %d omissions, want 1", len(omissions))
+	}
+	o := omissions[0]
+	if o.Server != liarAddr || o.Block != items[0].Block || o.Index != items[0].Index {
+		t.Errorf("c.AddressHistory returned omission %#v, want the first item omitted by %s", o, liarAddr)
+	}
+	for _, item := range items {
+		if (item.Payout == nil) == (item.Tx == nil) {
+			t.Errorf("item %d of block %d: want exactly one of Payout and Tx", item.Index, item.Block)
+		}
+	}
+
+	// Servers with different tips.
+	short, shortDir, err := buildTestServer(blocks[:len(blocks)-1], false, false)
+	if err != nil {
+		t.Fatalf("buildTestServer: %v", err)
+	}
+	defer os.RemoveAll(shortDir)
+	defer short.Close()
+	lagging := httptest.NewServer(&testHandler{s: short})
+	defer lagging.Close()
+	c2, err := NewClient([]string{honestAddr, serverAddress(t, lagging)}, nil)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := c2.Headers(); err == nil {
+		t.Errorf("c.Headers: want an error for servers with different tips")
+	} else if _, ok := err.(*TipMismatchError); !ok {
+		t.Errorf("c.Headers returned %v, want *TipMismatchError", err)
+	}
+}
+
+func TestClientMaliciousServers(t *testing.T) {
+	blocks, err := read1000Blocks()
+	if err != nil {
+		t.Fatalf("read1000Blocks: %v", err)
+	}
+	addresses, err := readAddresses()
+	if err != nil {
+		t.Fatalf("readAddresses: %v", err)
+	}
+	s, tmpDir, err := buildTestServer(blocks, false, false)
+	if err != nil {
+		t.Fatalf("buildTestServer: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer s.Close()
+	var address, other types.UnlockHash
+	if err := address.LoadString(addresses[0]); err != nil {
+		t.Fatalf("address.LoadString(%q): %v", addresses[0], err)
+	}
+	if err := other.LoadString(addresses[1]); err != nil {
+		t.Fatalf("address.LoadString(%q): %v", addresses[1], err)
+	}
+	own, _, err := s.AddressHistory(address[:], "")
+	if err != nil {
+		t.Fatalf("s.AddressHistory: %v", err)
+	}
+	unrelated, _, err := s.AddressHistory(other[:], "")
+	if err != nil {
+		t.Fatalf("s.AddressHistory: %v", err)
+	}
+	// Keep only the items of the other address.
+	var extra []Item
+	for _, item := range unrelated {
+		shared := false
+		for _, o := range own {
+			if o.Block == item.Block && o.Index == item.Index {
+				shared = true
+			}
+		}
+		if !shared {
+			extra = append(extra, item)
+		}
+	}
+	if len(extra) == 0 {
+		t.Fatalf("addresses %s and %s have the same history", address, other)
+	}
+	var addrs []string
+	for _, h := range []*testHandler{
+		{s: s},
+		{s: s, extra: extra},
+		{s: s, fail: true},
+		{s: s, loop: true},
+	} {
+		ts := httptest.NewServer(h)
+		defer ts.Close()
+		addrs = append(addrs, serverAddress(t, ts))
+	}
+	c, err := NewClient(addrs, nil)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	headers, err := c.Headers()
+	if err != nil {
+		t.Fatalf("c.Headers: %v", err)
+	}
+	items, omissions, failures, err := c.AddressHistory(address, headers)
+	if err != nil {
+		t.Fatalf("c.AddressHistory: %v", err)
+	}
+	if len(items) != len(own) {
+		t.Errorf("c.AddressHistory returned %d items, want %d", len(items), len(own))
+	}
+	if len(omissions) != 0 {
+		t.Errorf("c.AddressHistory returned omissions %v caused by unrelated items", omissions)
+	}
+	if len(failures) != 2 || failures[0].Server != addrs[2] || failures[1].Server != addrs[3] {
+		t.Errorf("c.AddressHistory returned failures %v, want servers %s and %s", failures, addrs[2], addrs[3])
+	}
+	// All the servers fail.
+	c2, err := NewClient(addrs[2:], nil)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, _, _, err := c2.AddressHistory(address, headers); err == nil {
+		t.Errorf("c2.AddressHistory succeeded with failing servers")
+	}
+}
+
+func TestClientCompleteness(t *testing.T) {
+	blocks, err := read1000Blocks()
+	if err != nil {
+		t.Fatalf("read1000Blocks: %v", err)
+	}
+	addresses, err := readAddresses()
+	if err != nil {
+		t.Fatalf("readAddresses: %v", err)
+	}
+	s, tmpDir, err := buildTestServer(blocks, true, false)
+	if err != nil {
+		t.Fatalf("buildTestServer: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer s.Close()
+	honest := httptest.NewServer(&testHandler{s: s})
+	defer honest.Close()
+	liar := httptest.NewServer(&testHandler{s: s, omit: true})
+	defer liar.Close()
+
+	c, err := NewClient([]string{serverAddress(t, honest)}, nil)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	headers, err := c.Headers()
+	if err != nil {
+		t.Fatalf("c.Headers: %v", err)
+	}
+	if _, err := c.Commitment(headers); err != nil {
+		t.Fatalf("c.Commitment: %v", err)
+	}
+	for _, addressHex := range addresses[:10] {
+		var address types.UnlockHash
+		if err := address.LoadString(addressHex); err != nil {
+			t.Fatalf("address.LoadString(%q): %v", addressHex, err)
+		}
+		items, _, _, err := c.AddressHistory(address, headers)
+		if err != nil {
+			t.Errorf("c.AddressHistory(%s): %v", addressHex, err)
+		} else if len(items) == 0 {
+			t.Errorf("c.AddressHistory(%s) returned no items", addressHex)
+		}
+	}
+	// Address which does not appear in the blockchain.
+	var unknown types.UnlockHash
+	unknown[0] = 0x42
+	if items, _, _, err := c.AddressHistory(unknown, headers); err != nil {
+		t.Errorf("c.AddressHistory(unknown): %v", err)
+	} else if len(items) != 0 {
+		t.Errorf("c.AddressHistory(unknown) returned %d items, want 0", len(items))
+	}
+
+	// The only server omits items.
+	c2, err := NewClient([]string{serverAddress(t, liar)}, nil)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := c2.Commitment(headers); err != nil {
+		t.Fatalf("c2.Commitment: %v", err)
+	}
+	var address types.UnlockHash
+	if err := address.LoadString(addresses[0]); err != nil {
+		t.Fatalf("address.LoadString(%q): %v", addresses[0], err)
+	}
+	if _, _, _, err := c2.AddressHistory(address, headers); err == nil {
+		t.Errorf("c2.AddressHistory: want an error for incomplete history")
+	} else if e, ok := err.(*IncompleteHistoryError); !ok {
+		t.Errorf("c2.AddressHistory returned %v, want *IncompleteHistoryError", err)
+	} else if e.Got != e.Want-1 {
+		t.Errorf("c2.AddressHistory: got %d items, committed %d, want exactly one missing", e.Got, e.Want)
+	}
+
+	// A server forging the commitment is named in the error.
+	forger := httptest.NewServer(&testHandler{s: s, forge: true})
+	defer forger.Close()
+	c3, err := NewClient([]string{serverAddress(t, honest), serverAddress(t, forger)}, nil)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := c3.Commitment(headers); err == nil {
+		t.Errorf("c3.Commitment accepted a forged commitment")
+	} else if e, ok := err.(*CommitmentMismatchError); !ok {
+		t.Errorf("c3.Commitment returned %v, want *CommitmentMismatchError", err)
+	} else if e.Server2 != serverAddress(t, forger) {
+		t.Errorf("c3.Commitment blamed %s, want %s", e.Server2, serverAddress(t, forger))
+	}
+
+	// A trusted commitment is used as is and the servers must match it.
+	trusted, err := s.Commitment()
+	if err != nil {
+		t.Fatalf("s.Commitment: %v", err)
+	}
+	bad := *trusted
+	bad.NumBlocks--
+	c4, err := NewClient([]string{serverAddress(t, forger)}, nil)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if err := c4.TrustCommitment(bad, headers); err == nil {
+		t.Errorf("c4.TrustCommitment accepted a commitment of %d blocks", bad.NumBlocks)
+	}
+	if err := c4.TrustCommitment(*trusted, headers); err != nil {
+		t.Fatalf("c4.TrustCommitment: %v", err)
+	}
+	if _, err := c4.Commitment(headers); err == nil {
+		t.Errorf("c4.Commitment accepted a commitment different from the trusted one")
+	} else if e, ok := err.(*CommitmentMismatchError); !ok || e.Server1 != "trusted" {
+		t.Errorf("c4.Commitment returned %v, want a mismatch with the trusted commitment", err)
+	}
+	if items, _, _, err := c4.AddressHistory(address, headers); err != nil {
+		t.Errorf("c4.AddressHistory: %v", err)
+	} else if len(items) == 0 {
+		t.Errorf("c4.AddressHistory returned no items")
+	}
+
+	// Servers built without completeness.
+	plain, plainDir, err := buildTestServer(blocks, false, false)
+	if err != nil {
+		t.Fatalf("buildTestServer: %v", err)
+	}
+	defer os.RemoveAll(plainDir)
+	defer plain.Close()
+	if _, err := plain.Commitment(); err != ErrNoCompleteness {
+		t.Errorf("plain.Commitment returned %v, want ErrNoCompleteness", err)
+	}
+}
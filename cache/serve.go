@@ -30,23 +30,61 @@ type Server struct {
 	LeavesHashes   []byte
 	Headers        []byte
 
-	AddressesFastmapData []byte
-	AddressesIndices     []byte
+	AddressesFastmapData []byte `cache:"index"`
+	AddressesIndices     []byte `cache:"index"`
+	AddressesPageHashes  []byte `cache:"optional"`
 	addressMap           *fastmap.MultiMap
+	commitment           *Commitment
 
-	ContractsFastmapData []byte
-	ContractsIndices     []byte
+	ContractsFastmapData []byte `cache:"index"`
+	ContractsIndices     []byte `cache:"index"`
 	contractMap          *fastmap.MultiMap
 
+	ObjectsFastmapData []byte `cache:"optional,index"`
+	ObjectsIndices     []byte `cache:"optional,index"`
+	objectMap          *fastmap.MultiMap
+	SpendsFastmapData  []byte `cache:"optional,index"`
+	SpendsIndices      []byte `cache:"optional,index"`
+	spendMap           *fastmap.MultiMap
+	BlockIDs           []byte `cache:"optional"`
+	Sfpools            []byte `cache:"optional"`
+
 	offsetLen         int
 	offsetIndexLen    int
 	addressPrefixLen  int
 	contractPrefixLen int
 
 	nblocks, nitems int
+
+	// Files of indices read with ReadAt, see ServerOptions.ReadIndices.
+	indexFiles map[string]*os.File
+	cachePages int
+}
+
+// ServerOptions configure NewServer. The zero value maps all the files
+// to memory.
+type ServerOptions struct {
+	// ReadIndices makes the server read the indices of addresses,
+	// contracts and explorer objects (files *FastmapData and *Indices)
+	// when needed instead of mapping them to memory, e.g. if they are
+	// on network storage.
+	ReadIndices bool
+	// CachePages is the number of pages of each index kept in memory
+	// if ReadIndices is set.
+	CachePages int
+}
+
+// hasTag returns if the field has the option in its "cache" tag.
+func hasTag(ft reflect.StructField, option string) bool {
+	for _, o := range strings.Split(ft.Tag.Get("cache"), ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
 }
 
-func NewServer(dir string) (*Server, error) {
+func NewServer(dir string, opts ServerOptions) (*Server, error) {
 	// Read parameters.json.
 	jf, err := os.Open(path.Join(dir, "parameters.json"))
 	if err != nil {
@@ -62,7 +100,11 @@ func NewServer(dir string) (*Server, error) {
 		offsetIndexLen:    par.OffsetIndexLen,
 		addressPrefixLen:  par.AddressPrefixLen,
 		contractPrefixLen: par.ContractPrefixLen,
+		indexFiles:        make(map[string]*os.File),
+		cachePages:        opts.CachePages,
 	}
+	// Release the files if opening fails.
+	runtime.SetFinalizer(s, (*Server).Close)
 	v := reflect.ValueOf(s).Elem()
 	st := v.Type()
 	// Mmap all []byte fileds from files.
@@ -71,9 +113,15 @@ func NewServer(dir string) (*Server, error) {
 		if ft.Type == reflect.TypeOf([]byte{}) {
 			name := strings.ToLower(ft.Name[:1]) + ft.Name[1:]
 			f, err := os.Open(path.Join(dir, name))
-			if err != nil {
+			if os.IsNotExist(err) && hasTag(ft, "optional") {
+				continue
+			} else if err != nil {
 				return nil, err
 			}
+			if opts.ReadIndices && hasTag(ft, "index") {
+				s.indexFiles[name] = f
+				continue
+			}
 			defer f.Close()
 			stat, err := f.Stat()
 			if err != nil {
@@ -93,7 +141,7 @@ func NewServer(dir string) (*Server, error) {
 	if par.AddressOffsetLen == par.OffsetIndexLen {
 		addressUninliner = fastmap.NewFFOOInliner(par.OffsetIndexLen)
 	}
-	addressMap, err := fastmap.OpenMultiMap(par.OffsetIndexLen, s.AddressesFastmapData, s.AddressesIndices, addressUninliner)
+	addressMap, err := s.openMultiMap("addresses", par.OffsetIndexLen, s.AddressesFastmapData, s.AddressesIndices, addressUninliner)
 	if err != nil {
 		return nil, err
 	}
@@ -102,7 +150,7 @@ func NewServer(dir string) (*Server, error) {
 	if par.ContractOffsetLen == par.OffsetIndexLen {
 		contractUninliner = fastmap.NewFFOOInliner(par.OffsetIndexLen)
 	}
-	contractMap, err := fastmap.OpenMultiMap(par.OffsetIndexLen, s.ContractsFastmapData, s.ContractsIndices, contractUninliner)
+	contractMap, err := s.openMultiMap("contracts", par.OffsetIndexLen, s.ContractsFastmapData, s.ContractsIndices, contractUninliner)
 	if err != nil {
 		return nil, err
 	}
@@ -115,11 +163,42 @@ func NewServer(dir string) (*Server, error) {
 	if s.nitems*par.OffsetLen != len(s.Offsets) {
 		return nil, fmt.Errorf("Bad length of offsets")
 	}
-	runtime.SetFinalizer(s, (*Server).Close)
+	if par.Completeness {
+		if err := s.buildCommitment(par.AddressOffsetLen == par.OffsetIndexLen); err != nil {
+			return nil, err
+		}
+	}
+	if par.Explorer {
+		if err := s.openExplorer(&par); err != nil {
+			return nil, err
+		}
+	}
 	return s, nil
 }
 
+// openMultiMap opens the multimap stored in files <name>FastmapData and
+// <name>Indices: mapped to data and values or read with ReadAt.
+func (s *Server) openMultiMap(name string, valueLen int, data, values []byte, uninliner fastmap.Uninliner) (*fastmap.MultiMap, error) {
+	dataFile, has := s.indexFiles[name+"FastmapData"]
+	if !has {
+		return fastmap.OpenMultiMap(valueLen, data, values, uninliner)
+	}
+	valuesFile := s.indexFiles[name+"Indices"]
+	dataStat, err := dataFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	valuesStat, err := valuesFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return fastmap.OpenMultiMapReaderAt(valueLen, dataFile, dataStat.Size(), valuesFile, valuesStat.Size(), s.cachePages, uninliner)
+}
+
 func (s *Server) Close() error {
+	// The memory can be mapped again after munmap, so make sure
+	// it is not unmapped second time by the finalizer.
+	runtime.SetFinalizer(s, nil)
 	v := reflect.ValueOf(s).Elem()
 	st := v.Type()
 	for i := 0; i < st.NumField(); i++ {
@@ -132,6 +211,36 @@ func (s *Server) Close() error {
 			if err := syscall.Munmap(buf); err != nil {
 				return err
 			}
+			v.Field(i).Set(reflect.Zero(ft.Type))
+		}
+	}
+	for name, f := range s.indexFiles {
+		if err := f.Close(); err != nil {
+			return err
+		}
+		delete(s.indexFiles, name)
+	}
+	return nil
+}
+
+// Verify checks checksums and consistency of the indices of addresses,
+// contracts and explorer objects. It reads all of them, so it is slow.
+func (s *Server) Verify() error {
+	maps := []struct {
+		name string
+		m    *fastmap.MultiMap
+	}{
+		{"addresses", s.addressMap},
+		{"contracts", s.contractMap},
+		{"objects", s.objectMap},
+		{"spends", s.spendMap},
+	}
+	for _, m := range maps {
+		if m.m == nil {
+			continue
+		}
+		if err := m.m.Verify(); err != nil {
+			return fmt.Errorf("index of %s: %v", m.name, err)
 		}
 	}
 	return nil
@@ -237,25 +346,11 @@ var (
 )
 
 func (s *Server) GetItem(itemIndex int) (Item, error) {
-	var tmp [8]byte
-	tmpBytes := tmp[:]
 	if itemIndex >= s.nitems {
 		return Item{}, ErrTooLargeIndex
 	}
-	start := itemIndex * s.offsetLen
-	copy(tmpBytes, s.Offsets[start:start+s.offsetLen])
-	dataStart := int(binary.LittleEndian.Uint64(tmpBytes))
-	dataEnd := len(s.Blockchain)
-	if itemIndex != s.nitems-1 {
-		copy(tmpBytes, s.Offsets[start+s.offsetLen:start+2*s.offsetLen])
-		dataEnd = int(binary.LittleEndian.Uint64(tmpBytes))
-	}
-	data := s.Blockchain[dataStart:dataEnd]
-	// Find the block.
-	blockIndex := sort.Search(s.nblocks, func(i int) bool {
-		payoutsStart := s.getPayoutsStart(i)
-		return payoutsStart > itemIndex
-	}) - 1
+	data := s.itemData(itemIndex)
+	blockIndex := s.itemBlock(itemIndex)
 	payoutsStart, txsStart, nleaves := s.getBlockLocation(blockIndex)
 	numMinerPayouts := txsStart - payoutsStart
 	item := Item{
@@ -273,13 +368,45 @@ func (s *Server) GetItem(itemIndex int) (Item, error) {
 	// Build MerkleProof.
 	hstart := payoutsStart * crypto.HashSize
 	hstop := hstart + nleaves*crypto.HashSize
-	leavesHashes := s.LeavesHashes[hstart:hstop]
+	proof, err := merkleProof(s.LeavesHashes[hstart:hstop], item.Index)
+	if err != nil {
+		return Item{}, err
+	}
+	item.MerkleProof = proof
+	return item, nil
+}
+
+// itemData returns the encoded item.
+func (s *Server) itemData(itemIndex int) []byte {
+	var tmp [8]byte
+	tmpBytes := tmp[:]
+	start := itemIndex * s.offsetLen
+	copy(tmpBytes, s.Offsets[start:start+s.offsetLen])
+	dataStart := int(binary.LittleEndian.Uint64(tmpBytes))
+	dataEnd := len(s.Blockchain)
+	if itemIndex != s.nitems-1 {
+		copy(tmpBytes, s.Offsets[start+s.offsetLen:start+2*s.offsetLen])
+		dataEnd = int(binary.LittleEndian.Uint64(tmpBytes))
+	}
+	return s.Blockchain[dataStart:dataEnd]
+}
+
+// itemBlock returns the index of the block containing the item.
+func (s *Server) itemBlock(itemIndex int) int {
+	return sort.Search(s.nblocks, func(i int) bool {
+		payoutsStart := s.getPayoutsStart(i)
+		return payoutsStart > itemIndex
+	}) - 1
+}
+
+// merkleProof returns concatenated hashes of Merkle proof of i-th leaf
+// given the concatenated hashes of all leaves.
+func merkleProof(leavesHashes []byte, index int) ([]byte, error) {
 	tree := merkletree.NewCachedTree(crypto.NewHash(), 0)
-	if err := tree.SetIndex(uint64(item.Index)); err != nil {
-		return Item{}, fmt.Errorf("tree.SetIndex(%d): %v", item.Index, err)
+	if err := tree.SetIndex(uint64(index)); err != nil {
+		return nil, fmt.Errorf("tree.SetIndex(%d): %v", index, err)
 	}
-	for i := 0; i < nleaves; i++ {
-		start := i * crypto.HashSize
+	for start := 0; start < len(leavesHashes); start += crypto.HashSize {
 		stop := start + crypto.HashSize
 		tree.Push(leavesHashes[start:stop])
 	}
@@ -287,12 +414,11 @@ func (s *Server) GetItem(itemIndex int) (Item, error) {
 	proof := make([]byte, 0, len(proofSet)*crypto.HashSize)
 	for _, h := range proofSet {
 		if len(h) != crypto.HashSize {
-			panic("len(h)=" + string(len(h)))
+			panic("len(h)=" + strconv.Itoa(len(h)))
 		}
 		proof = append(proof, h...)
 	}
-	item.MerkleProof = proof
-	return item, nil
+	return proof, nil
 }
 
 func (s *Server) getBlockLocation(index int) (int, int, int) {
@@ -32,6 +32,8 @@ var (
 	contractPrefixLen        = flag.Int("contract_prefix_len", 16, "sizeof(prefix of contract to store)")
 	contractFastmapPrefixLen = flag.Int("contract_fastmap_prefix_len", 5, "sizeof(prefix of contract to store in contractsFastmapPrefixes)")
 	contractOffsetLen        = flag.Int("contract_offset_len", 4, "sizeof(offset in contractsIndices file)")
+	completeness             = flag.Bool("completeness", false, "Write hashes of pages of addresses index for proofs of completeness")
+	explorer                 = flag.Bool("explorer", false, "Write indexes needed to serve the explorer from the cache")
 )
 
 func main() {
@@ -45,7 +47,10 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 	ctx := context.Background()
-	b, err := cache.NewBuilder(*files, *memLimit, *offsetLen, *offsetIndexLen, *addressPageLen, *addressPrefixLen, *addressFastmapPrefixLen, *addressOffsetLen, *contractPageLen, *contractPrefixLen, *contractFastmapPrefixLen, *contractOffsetLen)
+	b, err := cache.NewBuilder(*files, *memLimit, *offsetLen, *offsetIndexLen, *addressPageLen, *addressPrefixLen, *addressFastmapPrefixLen, *addressOffsetLen, *contractPageLen, *contractPrefixLen, *contractFastmapPrefixLen, *contractOffsetLen, cache.BuilderOptions{
+		Completeness: *completeness,
+		Explorer:     *explorer,
+	})
 	if err != nil {
 		log.Fatalf("cache.NewBuilder: %v", err)
 	}
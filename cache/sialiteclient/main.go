@@ -1,13 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"fmt"
 	"io/ioutil"
 	"log"
-	"net/http"
+	"strings"
 
 	"github.com/starius/sialite/cache"
+	"github.com/starius/sialite/netlib"
 	"gitlab.com/NebulousLabs/Sia/crypto"
 	"gitlab.com/NebulousLabs/Sia/encoding"
 	"gitlab.com/NebulousLabs/Sia/modules"
@@ -15,75 +16,31 @@ import (
 )
 
 var (
-	server   = flag.String("server", "127.0.0.1:35813", "Target address")
+	servers  = flag.String("server", "127.0.0.1:35813", "Target address (several addresses are separated by commas)")
 	seedFile = flag.String("seed-file", "", "File with seed")
 	maxGap   = flag.Int("max-gap", 100, "Maximum consecutive number of unused addresses")
-)
+	nodes    = flag.String("nodes", "", "Get headers from Sia nodes instead of the servers (comma-separated addresses or 'bootstrap'). Sia has no RPC sending only headers, so the full blocks are downloaded and validated")
 
-type fullItem struct {
-	source *cache.Item
-	payout *types.SiacoinOutput
-	tx     *types.Transaction
-}
+	completeness   = flag.Bool("completeness", false, "Require proofs of completeness of address histories")
+	commitmentFile = flag.String("commitment-file", "", "File with trusted commitment (as served by /v1/commitment) to check completeness against instead of trusting the servers")
+)
 
-func getHistory(kind, id string, headers cache.BlockHeadersSet) ([]fullItem, error) {
-	next := ""
-	var rawItems []cache.Item
-	for {
-		url := fmt.Sprintf("http://%s/v1/%s-history?%s=%s&start=%s", *server, kind, kind, id, next)
-		respHistory, err := http.Get(url)
-		if err != nil {
-			return nil, fmt.Errorf("http.Get(%q): %v", url, err)
-		}
-		if respHistory.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("http.Get(%q): %s", url, respHistory.Status)
-		}
-		var history []cache.Item
-		if err := encoding.NewDecoder(respHistory.Body).DecodeAll(&next, &history); err != nil {
-			return nil, fmt.Errorf("DecodeAll: %v", err)
-		}
-		respHistory.Body.Close()
-		rawItems = append(rawItems, history...)
-		if next == "" {
-			break
-		}
+func logOmissions(omissions []cache.Omission, failures []cache.ServerFailure) {
+	for _, o := range omissions {
+		log.Printf("Server %s omitted item %d of block %d.", o.Server, o.Index, o.Block)
 	}
-	var fullItems []fullItem
-	for i := 0; i < len(rawItems); i++ {
-		item := &rawItems[i]
-		data, err := item.SourceData(nil)
-		if err != nil {
-			return nil, fmt.Errorf("item.SourceData: %v", err)
-		}
-		if item.Block < 0 || item.Block >= headers.Length() {
-			return nil, fmt.Errorf("bad block index: %d", item.Block)
-		}
-		header := headers.Index(item.Block)
-		merkleRoot := header.MerkleRoot[:]
-		if !cache.VerifyProof(merkleRoot, data, item.MerkleProof, item.Index, item.NumLeaves) {
-			return nil, fmt.Errorf("cache.VerifyProof: bad proof")
-		}
-		full := fullItem{source: item}
-		if item.Index < item.NumMinerPayouts {
-			var payout types.SiacoinOutput
-			if err := encoding.Unmarshal(data, &payout); err != nil {
-				return nil, fmt.Errorf("encoding.Unmarshal payout: %v", err)
-			}
-			full.payout = &payout
-		} else {
-			var tx types.Transaction
-			if err := encoding.Unmarshal(data, &tx); err != nil {
-				return nil, fmt.Errorf("encoding.Unmarshal tx: %v", err)
-			}
-			full.tx = &tx
-		}
-		fullItems = append(fullItems, full)
+	for _, f := range failures {
+		log.Printf("Server %s failed: %v.", f.Server, f.Err)
 	}
-	return fullItems, nil
 }
 
-func addressHistory(address string, headers cache.BlockHeadersSet) ([]fullItem, error) {
-	return getHistory("address", address, headers)
+func addressHistory(client *cache.Client, address types.UnlockHash, headers cache.BlockHeadersSet) ([]cache.HistoryItem, error) {
+	items, omissions, failures, err := client.AddressHistory(address, headers)
+	if err != nil {
+		return nil, err
+	}
+	logOmissions(omissions, failures)
+	return items, nil
 }
 
 // generateAddress generates a key and an address from seed.
@@ -120,37 +77,37 @@ type contractOutput struct {
 	valid  bool
 }
 
-func findMoney(address types.UnlockHash, item fullItem, blockID types.BlockID) (incomes []income, outcomes []types.SiacoinOutputID, sfincomes []sfincome, sfoutcomes []types.SiafundOutputID, contracts []contractOutput) {
-	if item.payout != nil {
-		if address == item.payout.UnlockHash {
-			id := payoutID(blockID, uint64(item.source.Index))
-			incomes = append(incomes, income{id: id, value: item.payout.Value})
+func findMoney(address types.UnlockHash, item cache.HistoryItem, blockID types.BlockID) (incomes []income, outcomes []types.SiacoinOutputID, sfincomes []sfincome, sfoutcomes []types.SiafundOutputID, contracts []contractOutput) {
+	if item.Payout != nil {
+		if address == item.Payout.UnlockHash {
+			id := payoutID(blockID, uint64(item.Index))
+			incomes = append(incomes, income{id: id, value: item.Payout.Value})
 		}
-	} else if item.tx != nil {
-		for _, si := range item.tx.SiacoinInputs {
+	} else if item.Tx != nil {
+		for _, si := range item.Tx.SiacoinInputs {
 			if si.UnlockConditions.UnlockHash() == address {
 				outcomes = append(outcomes, si.ParentID)
 			}
 		}
-		for _, si := range item.tx.SiafundInputs {
+		for _, si := range item.Tx.SiafundInputs {
 			if si.UnlockConditions.UnlockHash() == address {
 				sfoutcomes = append(sfoutcomes, si.ParentID)
 			}
 		}
-		for i, so := range item.tx.SiacoinOutputs {
+		for i, so := range item.Tx.SiacoinOutputs {
 			if so.UnlockHash == address {
-				id := item.tx.SiacoinOutputID(uint64(i))
+				id := item.Tx.SiacoinOutputID(uint64(i))
 				incomes = append(incomes, income{id: id, value: so.Value})
 			}
 		}
-		for i, so := range item.tx.SiafundOutputs {
+		for i, so := range item.Tx.SiafundOutputs {
 			if so.UnlockHash == address {
-				id := item.tx.SiafundOutputID(uint64(i))
+				id := item.Tx.SiafundOutputID(uint64(i))
 				sfincomes = append(sfincomes, sfincome{id: id, value: so.Value})
 			}
 		}
-		for i0, contract := range item.tx.FileContracts {
-			fcid := item.tx.FileContractID(uint64(i0))
+		for i0, contract := range item.Tx.FileContracts {
+			fcid := item.Tx.FileContractID(uint64(i0))
 			for i, o := range contract.ValidProofOutputs {
 				if o.UnlockHash == address {
 					id := fcid.StorageProofOutputID(types.ProofValid, uint64(i))
@@ -174,7 +131,7 @@ func findMoney(address types.UnlockHash, item fullItem, blockID types.BlockID) (
 				}
 			}
 		}
-		for _, contractRev := range item.tx.FileContractRevisions {
+		for _, contractRev := range item.Tx.FileContractRevisions {
 			fcid := contractRev.ParentID
 			for i, o := range contractRev.NewValidProofOutputs {
 				if o.UnlockHash == address {
@@ -212,21 +169,22 @@ type contractResult struct {
 	closed  bool
 }
 
-func getContractResult(fcid types.FileContractID, headers cache.BlockHeadersSet) (contractResult, error) {
-	items, err := getHistory("contract", fcid.String(), headers)
+func getContractResult(client *cache.Client, fcid types.FileContractID, headers cache.BlockHeadersSet) (contractResult, error) {
+	items, omissions, failures, err := client.ContractHistory(fcid, headers)
 	if err != nil {
 		return contractResult{}, err
 	}
+	logOmissions(omissions, failures)
 	lastRev := uint64(0)
 	lastWindowEnd := types.BlockHeight(0)
 	valid := false
 	closed := false
 	for _, full := range items {
-		if full.tx == nil {
+		if full.Tx == nil {
 			continue
 		}
-		for i0, contract := range full.tx.FileContracts {
-			if full.tx.FileContractID(uint64(i0)) != fcid {
+		for i0, contract := range full.Tx.FileContracts {
+			if full.Tx.FileContractID(uint64(i0)) != fcid {
 				continue
 			}
 			if contract.RevisionNumber > lastRev {
@@ -234,7 +192,7 @@ func getContractResult(fcid types.FileContractID, headers cache.BlockHeadersSet)
 				lastWindowEnd = contract.WindowEnd
 			}
 		}
-		for _, contractRev := range full.tx.FileContractRevisions {
+		for _, contractRev := range full.Tx.FileContractRevisions {
 			if contractRev.ParentID != fcid {
 				continue
 			}
@@ -243,7 +201,7 @@ func getContractResult(fcid types.FileContractID, headers cache.BlockHeadersSet)
 				lastWindowEnd = contractRev.NewWindowEnd
 			}
 		}
-		for _, proof := range full.tx.StorageProofs {
+		for _, proof := range full.Tx.StorageProofs {
 			if proof.ParentID != fcid {
 				continue
 			}
@@ -262,23 +220,79 @@ func getContractResult(fcid types.FileContractID, headers cache.BlockHeadersSet)
 	}, nil
 }
 
+// headersFromNodes downloads the chain from Sia nodes, verifies it
+// and returns its headers.
+func headersFromNodes(ctx context.Context) (*cache.BlockHeadersSetImpl, error) {
+	seeds := modules.BootstrapPeers
+	if *nodes != "bootstrap" {
+		seeds = nil
+		for _, node := range strings.Split(*nodes, ",") {
+			seeds = append(seeds, modules.NetAddress(node))
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pm := netlib.NewPeerManager(seeds, 4, netlib.DefaultIdentity)
+	pm.Discover = *nodes == "bootstrap"
+	v := cache.NewValidator(nil, nil, 0)
+	if err := v.Add(&types.GenesisBlock); err != nil {
+		return nil, err
+	}
+	pm.Check = v.Add
+	go pm.Run(ctx)
+	var history [32]types.BlockID
+	history[0] = types.GenesisID
+	history[31] = types.GenesisID
+	hchan := make(chan types.BlockHeader, 100)
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := pm.DownloadHeadersFromHistory(ctx, hchan, history)
+		close(hchan)
+		errChan <- err
+	}()
+	headers := []types.BlockHeader{types.GenesisBlock.Header()}
+	for header := range hchan {
+		headers = append(headers, header)
+	}
+	if err := <-errChan; err != nil {
+		return nil, err
+	}
+	log.Printf("Downloaded %d headers from Sia nodes.", len(headers))
+	return cache.NewHeadersSet(headers), nil
+}
+
 func main() {
 	flag.Parse()
-	respHeaders, err := http.Get("http://" + *server + "/v1/headers")
+	client, err := cache.NewClient(strings.Split(*servers, ","), nil)
 	if err != nil {
 		panic(err)
 	}
-	headersBytes, err := ioutil.ReadAll(respHeaders.Body)
-	if err != nil {
-		panic(err)
+	var headers *cache.BlockHeadersSetImpl
+	if *nodes != "" {
+		headers, err = headersFromNodes(context.Background())
+	} else {
+		headers, err = client.Headers()
 	}
-	respHeaders.Body.Close()
-	headers, err := cache.ParseHeaders(headersBytes)
 	if err != nil {
 		panic(err)
 	}
-	if err := cache.VerifyBlockHeaders(headers); err != nil {
-		panic(err)
+	if *commitmentFile != "" {
+		data, err := ioutil.ReadFile(*commitmentFile)
+		if err != nil {
+			panic(err)
+		}
+		var trusted cache.Commitment
+		if err := encoding.Unmarshal(data, &trusted); err != nil {
+			panic(err)
+		}
+		if err := client.TrustCommitment(trusted, headers); err != nil {
+			panic(err)
+		}
+	}
+	if *completeness || *commitmentFile != "" {
+		if _, err := client.Commitment(headers); err != nil {
+			panic(err)
+		}
 	}
 	seedBytes, err := ioutil.ReadFile(*seedFile)
 	if err != nil {
@@ -297,7 +311,7 @@ func main() {
 	for index := uint64(0); gap < *maxGap; index++ {
 		uc, _ := generateAddress(seed, index)
 		address := uc.UnlockHash()
-		history, err := addressHistory(address.String(), headers)
+		history, err := addressHistory(client, address, headers)
 		if err != nil {
 			panic(err)
 		}
@@ -307,7 +321,7 @@ func main() {
 			gap = 0
 		}
 		for _, full := range history {
-			blockID := headers.Index(full.source.Block).CurrentID
+			blockID := headers.Index(full.Block).CurrentID
 			incomes, outcomes, sfincomes, sfoutcomes, contracts := findMoney(address, full, blockID)
 			for _, income := range incomes {
 				incomesMap[income.id] = income.value
@@ -330,7 +344,7 @@ func main() {
 	}
 	contractsResults := make(map[types.FileContractID]contractResult)
 	for fcid := range contractsSet {
-		result, err := getContractResult(fcid, headers)
+		result, err := getContractResult(client, fcid, headers)
 		if err != nil {
 			panic(err)
 		}
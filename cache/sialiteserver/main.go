@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -9,16 +10,23 @@ import (
 	"time"
 
 	"github.com/starius/sialite/cache"
+	"github.com/starius/sialite/explorerclient"
+	"github.com/starius/sialite/human"
 	"gitlab.com/NebulousLabs/Sia/crypto"
 	"gitlab.com/NebulousLabs/Sia/encoding"
 	"gitlab.com/NebulousLabs/Sia/types"
 )
 
 var (
-	files = flag.String("files", ".", "Dir with output of builder")
-	addr  = flag.String("addr", ":35813", "Address to run HTTP server")
+	files       = flag.String("files", ".", "Dir with output of builder")
+	addr        = flag.String("addr", ":35813", "Address to run HTTP server")
+	explorer    = flag.String("explorer", "", "Address of sialite explorer to relay events from (e.g. http://127.0.0.1:8080)")
+	verify      = flag.Bool("verify", false, "Verify checksums of the indices and exit")
+	readIndices = flag.Bool("read-indices", false, "Read the indices from files when needed instead of mapping them to memory")
+	cachePages  = flag.Int("index-cache-pages", 1024, "Number of pages of each index to keep in memory with -read-indices")
 
 	s *cache.Server
+	e *explorerclient.Client
 )
 
 func encodingLen(history []cache.Item, next string) int {
@@ -90,15 +98,127 @@ func handleHeaders(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, "headers", modTime, reader)
 }
 
+func handleCommitment(w http.ResponseWriter, r *http.Request) {
+	c, err := s.Commitment()
+	if err == cache.ErrNoCompleteness {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Commitment: %v.\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := encoding.NewEncoder(w).Encode(*c); err != nil {
+		return
+	}
+}
+
+func handleAddressCompleteness(w http.ResponseWriter, r *http.Request) {
+	addressHex := r.URL.Query().Get("address")
+	var address types.UnlockHash
+	if err := address.LoadString(addressHex); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "address.LoadString(%q): %v.\n", addressHex, err)
+		log.Printf("address.LoadString(%q): %v.\n", addressHex, err)
+		return
+	}
+	proof, err := s.AddressCompleteness(address[:])
+	if err == cache.ErrNoCompleteness {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "AddressCompleteness: %v.\n", err)
+		log.Printf("AddressCompleteness: %v.\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := encoding.NewEncoder(w).Encode(*proof); err != nil {
+		return
+	}
+}
+
+// handleEvents relays events of the explorer as Server-Sent Events,
+// since the cache itself does not change. Query parameters are the same
+// as in /events of the explorer.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	if e == nil {
+		http.NotFound(w, r)
+		return
+	}
+	var addresses []types.UnlockHash
+	for _, addressHex := range r.URL.Query()["address"] {
+		var address types.UnlockHash
+		if err := address.LoadString(addressHex); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "address.LoadString(%q): %v.\n", addressHex, err)
+			log.Printf("address.LoadString(%q): %v.\n", addressHex, err)
+			return
+		}
+		addresses = append(addresses, address)
+	}
+	var contracts []types.FileContractID
+	for _, contractHex := range r.URL.Query()["contract"] {
+		var id crypto.Hash
+		if err := id.LoadString(contractHex); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "LoadString(%q): %v.\n", contractHex, err)
+			log.Printf("LoadString(%q): %v.\n", contractHex, err)
+			return
+		}
+		contracts = append(contracts, types.FileContractID(id))
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	err := e.Events(r.Context(), addresses, contracts, func(event *human.Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil && r.Context().Err() == nil {
+		log.Printf("Events: %v.\n", err)
+	}
+}
+
 func main() {
 	flag.Parse()
-	s1, err := cache.NewServer(*files)
+	s1, err := cache.NewServer(*files, cache.ServerOptions{
+		ReadIndices: *readIndices,
+		CachePages:  *cachePages,
+	})
 	if err != nil {
 		log.Fatalf("cache.NewServer: %v", err)
 	}
 	s = s1
+	if *verify {
+		if err := s.Verify(); err != nil {
+			log.Fatalf("Verify: %v", err)
+		}
+		log.Printf("The indices are intact.")
+		return
+	}
+	if *explorer != "" {
+		e = explorerclient.NewClient(*explorer, nil)
+	}
 	http.HandleFunc("/v1/address-history", handleAddressHistory)
 	http.HandleFunc("/v1/contract-history", handleContractHistory)
 	http.HandleFunc("/v1/headers", handleHeaders)
+	http.HandleFunc("/v1/commitment", handleCommitment)
+	http.HandleFunc("/v1/address-completeness", handleAddressCompleteness)
+	http.HandleFunc("/v1/events", handleEvents)
 	log.Fatal(http.ListenAndServe(*addr, nil))
 }
@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"fmt"
+
+	"gitlab.com/NebulousLabs/Sia/encoding"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// VerifyBlock checks that the block can follow the last of headers:
+// its ParentID, size, proof of work and timestamp. A block too far in
+// the future is invalid, a block slightly in the future fails with
+// *FutureBlockError. parent is TargetState of the last header, nil if
+// headers are empty. The ID of the block commits to the Merkle root of
+// its transactions and miner payouts, so proof of work covers them as
+// well.
+func VerifyBlock(headers BlockHeadersSet, parent *TargetState, block *types.Block) error {
+	id := block.ID()
+	n := headers.Length()
+	if n == 0 {
+		if id != types.GenesisID {
+			return fmt.Errorf("bad genesis block %s", id)
+		}
+		return nil
+	}
+	if last := headers.Index(n - 1).CurrentID; block.ParentID != last {
+		return fmt.Errorf("block %s does not follow block %s", id, last)
+	}
+	if size := len(encoding.Marshal(block)); uint64(size) > types.BlockSizeLimit {
+		return fmt.Errorf("block %s is too large: %d bytes", id, size)
+	}
+	minTimestamp, err := minimumValidChildTimestamp(headers, n-1)
+	if err != nil {
+		return err
+	}
+	info := BlockInfo{
+		BlockHeader: block.Header(),
+		CurrentID:   id,
+	}
+	if err := verifyBlockHeader(info, minTimestamp, parent.ChildTarget); err == errFutureTimestamp {
+		return &FutureBlockError{ID: id, Timestamp: block.Timestamp}
+	} else if err != nil {
+		return fmt.Errorf("block %d: %v", n, err)
+	}
+	return nil
+}
+
+// FutureBlockError is returned by VerifyBlock if the timestamp of the
+// block is after types.FutureThreshold, but not after
+// types.ExtremeFutureThreshold. The block may become valid later, or the
+// local clock may be behind. Blocks further in the future are invalid.
+type FutureBlockError struct {
+	ID        types.BlockID
+	Timestamp types.Timestamp
+}
+
+func (e *FutureBlockError) Error() string {
+	return fmt.Sprintf("block %s has timestamp %d in the future", e.ID, e.Timestamp)
+}
+
+// Stale reports that the block may become valid later, see
+// netlib.PeerManager.Check.
+func (e *FutureBlockError) Stale() bool {
+	return true
+}
+
+// Validator checks blocks one by one with VerifyBlock. It keeps recent
+// headers and their target states, so a block can also fork from one of
+// the last depth blocks.
+type Validator struct {
+	base    int // Height of headers[0].
+	headers []BlockInfo
+	states  []*TargetState
+	depth   int
+}
+
+// NewValidator returns a validator of blocks following the headers.
+// state returns TargetState of the header at the height. Pass nil headers
+// to start with the genesis block.
+func NewValidator(headers BlockHeadersSet, state func(height int) *TargetState, depth int) *Validator {
+	v := &Validator{depth: depth}
+	if headers == nil {
+		return v
+	}
+	n := headers.Length()
+	v.base = n - v.keep()
+	if v.base < 0 {
+		v.base = 0
+	}
+	for i := v.base; i < n; i++ {
+		v.headers = append(v.headers, headers.Index(i))
+		v.states = append(v.states, state(i))
+	}
+	return v
+}
+
+// keep returns the number of headers needed to compute the target of a
+// block forking from one of the last depth blocks.
+func (v *Validator) keep() int {
+	return int(types.TargetWindow) + v.depth + 1
+}
+
+func (v *Validator) Length() int {
+	return v.base + len(v.headers)
+}
+
+func (v *Validator) Index(i int) BlockInfo {
+	return v.headers[i-v.base]
+}
+
+// UnknownParentError is returned by Validator.Add if the parent of the
+// block is not among the blocks it keeps. The validator may be behind
+// or too shallow, so the block is not necessarily invalid.
+type UnknownParentError struct {
+	ID, ParentID types.BlockID
+}
+
+func (e *UnknownParentError) Error() string {
+	return fmt.Sprintf("block %s has unknown parent %s", e.ID, e.ParentID)
+}
+
+// Stale reports that the error is caused by the state of the validator,
+// see netlib.PeerManager.Check.
+func (e *UnknownParentError) Stale() bool {
+	return true
+}
+
+// Add verifies the block and appends it. If the block forks from an
+// earlier block, the blocks after it are forgotten.
+func (v *Validator) Add(block *types.Block) error {
+	n := v.Length()
+	parentHeight := n - 1
+	if n != 0 && block.ParentID != v.Index(n-1).CurrentID {
+		parentHeight = -1
+		for h := n - 2; h >= v.base && h >= n-1-v.depth; h-- {
+			if v.Index(h).CurrentID == block.ParentID {
+				parentHeight = h
+				break
+			}
+		}
+		if parentHeight == -1 {
+			return &UnknownParentError{ID: block.ID(), ParentID: block.ParentID}
+		}
+		v.headers = v.headers[:parentHeight+1-v.base]
+		v.states = v.states[:parentHeight+1-v.base]
+	}
+	var parent *TargetState
+	if parentHeight >= 0 {
+		parent = v.states[parentHeight-v.base]
+	}
+	if err := VerifyBlock(v, parent, block); err != nil {
+		return err
+	}
+	v.headers = append(v.headers, BlockInfo{
+		BlockHeader: block.Header(),
+		CurrentID:   block.ID(),
+	})
+	v.states = append(v.states, NextTargetState(v, parent))
+	// Forget old headers in batches not to copy the rest too often.
+	if len(v.headers) >= 2*v.keep() {
+		extra := len(v.headers) - v.keep()
+		v.headers = append([]BlockInfo(nil), v.headers[extra:]...)
+		v.states = append([]*TargetState(nil), v.states[extra:]...)
+		v.base += extra
+	}
+	return nil
+}
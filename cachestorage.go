@@ -0,0 +1,442 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/starius/sialite/cache"
+	"github.com/starius/sialite/human"
+	"gitlab.com/NebulousLabs/Sia/crypto"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// OpenCache opens the index stored in cache directory built by
+// sialitebuilder with -explorer. The index is read-only.
+func OpenCache(dir string, blockCacheSize int, opts cache.ServerOptions) (*Database, error) {
+	server, err := cache.NewServer(dir, opts)
+	if err != nil {
+		return nil, fmt.Errorf("cache.NewServer(%q): %v", dir, err)
+	}
+	if _, err := server.Sfpool(0); err == cache.ErrNoExplorer {
+		server.Close()
+		return nil, err
+	}
+	return &Database{
+		server: server,
+		blocks: newBlockCache(blockCacheSize),
+		// The cache is not connected to a node, so the mempool is empty.
+		mempool: NewMempool(0),
+		hub:     NewHub(),
+		mu:      new(sync.RWMutex),
+	}, nil
+}
+
+// cacheIndex reads the index from cache directory. The cache maps
+// prefixes of IDs to items (miner payouts and transactions), so every
+// candidate item is decoded and checked against the full ID. Where bolt
+// index overwrites records, the last matching record wins here too.
+type cacheIndex struct {
+	s      *cache.Server
+	blocks *blockCache
+
+	// Memoized IDs of outputs of addresses in the order of addBlock.
+	addressScos map[types.UnlockHash][]types.SiacoinOutputID
+	addressSfos map[types.UnlockHash][]types.SiafundOutputID
+}
+
+func newCacheIndex(s *cache.Server, blocks *blockCache) *cacheIndex {
+	return &cacheIndex{
+		s:           s,
+		blocks:      blocks,
+		addressScos: make(map[types.UnlockHash][]types.SiacoinOutputID),
+		addressSfos: make(map[types.UnlockHash][]types.SiafundOutputID),
+	}
+}
+
+// mustCache fails the request if the cache could not be read,
+// see failIndex.
+func mustCache(err error) {
+	if err != nil {
+		failIndex(fmt.Errorf("corrupted cache: %v", err))
+	}
+}
+
+// scoRecord is a Siacoin output created by a transaction or a block.
+type scoRecord struct {
+	id         types.SiacoinOutputID
+	unlockHash types.UnlockHash
+	sco        SiacoinOutput
+}
+
+// createdScos returns Siacoin outputs created by the transaction or by
+// the miner payout with index payout if loc.Tx is -1, in the order in
+// which addBlock adds them.
+func createdScos(block *types.Block, loc TxLocation, payout int) []scoRecord {
+	var records []scoRecord
+	add := func(id types.SiacoinOutputID, uh types.UnlockHash, nature, index, index0 int) {
+		records = append(records, scoRecord{
+			id:         id,
+			unlockHash: uh,
+			sco: SiacoinOutput{
+				TxLocation: loc,
+				Nature:     nature,
+				Index:      index,
+				Index0:     index0,
+			},
+		})
+	}
+	if loc.Tx == -1 {
+		mp := block.MinerPayouts[payout]
+		add(block.MinerPayoutID(uint64(payout)), mp.UnlockHash, minerPayout, payout, 0)
+		return records
+	}
+	tx := &block.Transactions[loc.Tx]
+	for i, sfi := range tx.SiafundInputs {
+		add(sfi.ParentID.SiaClaimOutputID(), sfi.ClaimUnlockHash, siaClaimOutput, i, 0)
+	}
+	for i, o := range tx.SiacoinOutputs {
+		add(tx.SiacoinOutputID(uint64(i)), o.UnlockHash, siacoinOutput, i, 0)
+	}
+	for i0, contract := range tx.FileContracts {
+		fcid := tx.FileContractID(uint64(i0))
+		for i, o := range contract.ValidProofOutputs {
+			add(fcid.StorageProofOutputID(types.ProofValid, uint64(i)), o.UnlockHash, validProofOutput, i, i0)
+		}
+		for i, o := range contract.MissedProofOutputs {
+			add(fcid.StorageProofOutputID(types.ProofMissed, uint64(i)), o.UnlockHash, missedProofOutput, i, i0)
+		}
+	}
+	for i0, rev := range tx.FileContractRevisions {
+		for i, o := range rev.NewValidProofOutputs {
+			add(rev.ParentID.StorageProofOutputID(types.ProofValid, uint64(i)), o.UnlockHash, validProofOutputInRevision, i, i0)
+		}
+		for i, o := range rev.NewMissedProofOutputs {
+			add(rev.ParentID.StorageProofOutputID(types.ProofMissed, uint64(i)), o.UnlockHash, missedProofOutputInRevision, i, i0)
+		}
+	}
+	return records
+}
+
+// locate returns the location of the item. For miner payouts loc.Tx is -1
+// and payout is the index of the payout.
+func (ix *cacheIndex) locate(item int) (loc TxLocation, payout int) {
+	block, index, isPayout, err := ix.s.ItemLocation(item)
+	mustCache(err)
+	if block < 0 || block >= ix.s.NumBlocks() {
+		failIndex(fmt.Errorf("corrupted cache: item %d is in missing block %d", item, block))
+	}
+	if isPayout {
+		return TxLocation{Block: block, Tx: -1}, index
+	}
+	return TxLocation{Block: block, Tx: index}, 0
+}
+
+// txs calls f for every transaction created by the items. It stops
+// if f returns false.
+func (ix *cacheIndex) txs(items []int, f func(loc TxLocation, tx *types.Transaction) bool) {
+	for _, item := range items {
+		loc, _ := ix.locate(item)
+		if loc.Tx == -1 {
+			continue
+		}
+		if !f(loc, &ix.blockAt(loc.Block).Transactions[loc.Tx]) {
+			return
+		}
+	}
+}
+
+func (ix *cacheIndex) numBlocks() int {
+	return ix.s.NumBlocks()
+}
+
+func (ix *cacheIndex) blockAt(height int) *types.Block {
+	if height == 0 {
+		// See boltIndex.blockAt.
+		return &types.GenesisBlock
+	}
+	if height < 0 || height >= ix.s.NumBlocks() {
+		return nil
+	}
+	id, err := ix.s.BlockID(height)
+	mustCache(err)
+	if block := ix.blocks.get(id); block != nil {
+		return block
+	}
+	block, err := ix.s.Block(height)
+	mustCache(err)
+	ix.blocks.add(id, block)
+	return block
+}
+
+func (ix *cacheIndex) header(height int) human.BlockHeader {
+	header, err := ix.s.BlockHeader(height)
+	mustCache(err)
+	id, err := ix.s.BlockID(height)
+	mustCache(err)
+	return human.BlockHeader{
+		ID:        id,
+		Nonce:     header.Nonce,
+		Timestamp: header.Timestamp,
+	}
+}
+
+func (ix *cacheIndex) blockHeight(id types.BlockID) (int, bool) {
+	items, err := ix.s.ObjectItems(id[:])
+	mustCache(err)
+	for _, item := range items {
+		loc, _ := ix.locate(item)
+		blockID, err := ix.s.BlockID(loc.Block)
+		mustCache(err)
+		if blockID == id {
+			return loc.Block, true
+		}
+	}
+	return 0, false
+}
+
+func (ix *cacheIndex) sfpool(height int) types.Currency {
+	sfpool, err := ix.s.Sfpool(height)
+	mustCache(err)
+	return sfpool
+}
+
+func (ix *cacheIndex) txLocation(id types.TransactionID) (loc TxLocation, has bool) {
+	items, err := ix.s.ObjectItems(id[:])
+	mustCache(err)
+	ix.txs(items, func(l TxLocation, tx *types.Transaction) bool {
+		if tx.ID() == id {
+			loc, has = l, true
+		}
+		return !has
+	})
+	return
+}
+
+func (ix *cacheIndex) sco(id types.SiacoinOutputID) (*SiacoinOutput, bool) {
+	items, err := ix.s.ObjectItems(id[:])
+	mustCache(err)
+	var o *SiacoinOutput
+	for _, item := range items {
+		loc, payout := ix.locate(item)
+		for _, r := range createdScos(ix.blockAt(loc.Block), loc, payout) {
+			if r.id == id {
+				sco := r.sco
+				o = &sco
+			}
+		}
+	}
+	if o == nil {
+		return new(SiacoinOutput), false
+	}
+	return o, true
+}
+
+func (ix *cacheIndex) sfo(id types.SiafundOutputID) (*SiafundOutput, bool) {
+	items, err := ix.s.ObjectItems(id[:])
+	mustCache(err)
+	o := new(SiafundOutput)
+	has := false
+	ix.txs(items, func(loc TxLocation, tx *types.Transaction) bool {
+		for i := range tx.SiafundOutputs {
+			if tx.SiafundOutputID(uint64(i)) == id {
+				*o, has = SiafundOutput{TxLocation: loc, Index: i}, true
+			}
+		}
+		return !has
+	})
+	return o, has
+}
+
+func (ix *cacheIndex) sci(id types.SiacoinOutputID) (*SiacoinInput, bool) {
+	items, err := ix.s.SpendItems(id[:])
+	mustCache(err)
+	in := new(SiacoinInput)
+	has := false
+	ix.txs(items, func(loc TxLocation, tx *types.Transaction) bool {
+		for i, sci := range tx.SiacoinInputs {
+			if sci.ParentID == id {
+				*in, has = SiacoinInput{TxLocation: loc, Index: i}, true
+			}
+		}
+		return true
+	})
+	return in, has
+}
+
+func (ix *cacheIndex) sfi(id types.SiafundOutputID) (*SiafundInput, bool) {
+	items, err := ix.s.SpendItems(id[:])
+	mustCache(err)
+	in := new(SiafundInput)
+	has := false
+	ix.txs(items, func(loc TxLocation, tx *types.Transaction) bool {
+		for i, sfi := range tx.SiafundInputs {
+			if sfi.ParentID == id {
+				*in, has = SiafundInput{TxLocation: loc, Index: i}, true
+			}
+		}
+		return true
+	})
+	return in, has
+}
+
+func (ix *cacheIndex) contract(id types.FileContractID) (*ContractHistory, bool) {
+	items, err := ix.s.ContractItems(id[:])
+	mustCache(err)
+	h := new(ContractHistory)
+	has := false
+	ix.txs(items, func(loc TxLocation, tx *types.Transaction) bool {
+		for i := range tx.FileContracts {
+			if tx.FileContractID(uint64(i)) == id {
+				h.Contract, has = Contract{TxLocation: loc, Index: i}, true
+			}
+		}
+		for i, rev := range tx.FileContractRevisions {
+			if rev.ParentID == id {
+				h.Revs = append(h.Revs, ContractRev{TxLocation: loc, Index: i})
+			}
+		}
+		for i, proof := range tx.StorageProofs {
+			if proof.ParentID == id {
+				h.Proof = &StorageProof{TxLocation: loc, Index: i}
+			}
+		}
+		return true
+	})
+	return h, has
+}
+
+// addressScoIDs returns IDs of Siacoin outputs of the address. Unlike
+// the database, the cache puts created storage proof outputs at the
+// contract or revision defining them, not at the block resolving it.
+func (ix *cacheIndex) addressScoIDs(address types.UnlockHash) []types.SiacoinOutputID {
+	if ids, has := ix.addressScos[address]; has {
+		return ids
+	}
+	items, err := ix.s.AddressItems(address[:])
+	mustCache(err)
+	var ids []types.SiacoinOutputID
+	for _, item := range items {
+		loc, payout := ix.locate(item)
+		for _, r := range createdScos(ix.blockAt(loc.Block), loc, payout) {
+			if r.unlockHash == address && proofOutputCreated(ix, &r.sco) {
+				ids = append(ids, r.id)
+			}
+		}
+	}
+	ix.addressScos[address] = ids
+	return ids
+}
+
+func (ix *cacheIndex) addressSfoIDs(address types.UnlockHash) []types.SiafundOutputID {
+	if ids, has := ix.addressSfos[address]; has {
+		return ids
+	}
+	items, err := ix.s.AddressItems(address[:])
+	mustCache(err)
+	var ids []types.SiafundOutputID
+	ix.txs(items, func(loc TxLocation, tx *types.Transaction) bool {
+		for i, o := range tx.SiafundOutputs {
+			if o.UnlockHash == address {
+				ids = append(ids, tx.SiafundOutputID(uint64(i)))
+			}
+		}
+		return true
+	})
+	ix.addressSfos[address] = ids
+	return ids
+}
+
+func (ix *cacheIndex) addressScosLen(address types.UnlockHash) int {
+	return len(ix.addressScoIDs(address))
+}
+
+func (ix *cacheIndex) addressSfosLen(address types.UnlockHash) int {
+	return len(ix.addressSfoIDs(address))
+}
+
+func (ix *cacheIndex) addressSco(address types.UnlockHash, index int) *SiacoinOutput {
+	o, _ := ix.sco(ix.addressScoIDs(address)[index])
+	return o
+}
+
+func (ix *cacheIndex) addressSfo(address types.UnlockHash, index int) *SiafundOutput {
+	o, _ := ix.sfo(ix.addressSfoIDs(address)[index])
+	return o
+}
+
+// idsWithPrefix finds only full IDs: the cache maps prefixes of IDs to
+// items, but is not sorted by IDs, so it can't be scanned by prefix.
+func (ix *cacheIndex) idsWithPrefix(kind, prefix string, limit int) []crypto.Hash {
+	var id crypto.Hash
+	if limit == 0 || len(prefix) != 2*crypto.HashSize || id.LoadString(prefix) != nil {
+		return nil
+	}
+	has := false
+	switch kind {
+	case human.SearchBlock:
+		_, has = ix.blockHeight(types.BlockID(id))
+	case human.SearchTransaction:
+		_, has = ix.txLocation(types.TransactionID(id))
+	case human.SearchContract:
+		_, has = ix.contract(types.FileContractID(id))
+	case human.SearchSiacoinOutput:
+		_, has = ix.sco(types.SiacoinOutputID(id))
+	case human.SearchSiafundOutput:
+		_, has = ix.sfo(types.SiafundOutputID(id))
+	case human.SearchAddress:
+		has = ix.addressScosLen(types.UnlockHash(id)) != 0 || ix.addressSfosLen(types.UnlockHash(id)) != 0
+	}
+	if !has {
+		return nil
+	}
+	return []crypto.Hash{id}
+}
+
+// The cache has no statistics, balances and hosts. Reading them fails
+// the request with ErrNoStats, ErrNoBalances or ErrNoHosts instead of
+// returning empty results.
+
+func (ix *cacheIndex) balance(address types.UnlockHash) (*balanceRecord, bool) {
+	failIndex(ErrNoBalances)
+	return nil, false
+}
+
+func (ix *cacheIndex) richList(coin string, start []byte, limit int) [][]byte {
+	failIndex(ErrNoBalances)
+	return nil
+}
+
+func (ix *cacheIndex) host(key []byte) (*hostRecord, bool) {
+	failIndex(ErrNoHosts)
+	return nil, false
+}
+
+func (ix *cacheIndex) hostKeys(start []byte, limit int) [][]byte {
+	failIndex(ErrNoHosts)
+	return nil
+}
+
+func (ix *cacheIndex) hostContracts(key []byte) []types.FileContractID {
+	failIndex(ErrNoHosts)
+	return nil
+}
+
+func (ix *cacheIndex) stats(height int) (*statsRecord, bool) {
+	failIndex(ErrNoStats)
+	return nil, false
+}
+
+func (ix *cacheIndex) dayStats(day int) (*human.DayStats, bool) {
+	failIndex(ErrNoStats)
+	return nil, false
+}
+
+// The cache is built from the main chain only and has no orphans.
+
+func (ix *cacheIndex) orphan(id types.BlockID) (*Orphan, bool) {
+	return new(Orphan), false
+}
+
+func (ix *cacheIndex) orphans() []*Orphan {
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/starius/sialite/cache"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+func openTestCache(t *testing.T) *Database {
+	blocks := readTestBlocks(t)
+	dir := t.TempDir()
+	b, err := cache.NewBuilder(dir, 1024*1024, 8, 4, 4096, 16, 5, 4, 4096, 16, 5, 4, cache.BuilderOptions{Explorer: true})
+	if err != nil {
+		t.Fatalf("cache.NewBuilder: %v", err)
+	}
+	for i := range blocks {
+		if err := b.Add(&blocks[i]); err != nil {
+			t.Fatalf("b.Add: %v", err)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("b.Close: %v", err)
+	}
+	db, err := OpenCache(dir, 100, cache.ServerOptions{})
+	if err != nil {
+		t.Fatalf("OpenCache: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+func TestCacheHandlers(t *testing.T) {
+	blocks := readTestBlocks(t)
+	db := openTestCache(t)
+	router := httprouter.New()
+	db.addHandlers(router)
+	address := blocks[10].MinerPayouts[0].UnlockHash
+	for _, tc := range []struct {
+		path   string
+		status int
+	}{
+		{"/blocki/10", http.StatusOK},
+		{fmt.Sprintf("/block/%s", blocks[10].ID()), http.StatusOK},
+		{fmt.Sprintf("/address/%s", address), http.StatusOK},
+		// The cache has no statistics, balances and hosts.
+		{"/stats", http.StatusNotFound},
+		{"/charts", http.StatusNotFound},
+		{"/richlist", http.StatusNotFound},
+		{fmt.Sprintf("/address/%s/balance", address), http.StatusNotFound},
+		{"/hosts", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.status {
+			t.Errorf("GET %s: status %d, want %d: %s", tc.path, w.Code, tc.status, w.Body)
+		}
+	}
+}
+
+func TestCacheUnavailable(t *testing.T) {
+	db := openTestCache(t)
+	for _, tc := range []struct {
+		name string
+		read func(db *Database)
+		want error
+	}{
+		{"stats", func(db *Database) { db.stats(10) }, ErrNoStats},
+		{"dayStats", func(db *Database) { db.dayStats(10) }, ErrNoStats},
+		{"balance", func(db *Database) { db.balance(types.UnlockHash{}) }, ErrNoBalances},
+		{"hostKeys", func(db *Database) { db.hostKeys(nil, 10) }, ErrNoHosts},
+	} {
+		err := db.view(func(db *Database) error {
+			tc.read(db)
+			return nil
+		})
+		if err != tc.want {
+			t.Errorf("%s returned %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/starius/sialite/human"
+	"gitlab.com/NebulousLabs/Sia/encoding"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// contractOutcome is the set of storage proof outputs created when
+// a contract is resolved: valid ones by a storage proof, missed ones by
+// the block at WindowEnd if there is no storage proof.
+type contractOutcome struct {
+	ID      types.FileContractID
+	Valid   bool
+	Outputs []types.SiacoinOutput
+}
+
+func (o *contractOutcome) outputID(i int) types.SiacoinOutputID {
+	proofStatus := types.ProofMissed
+	if o.Valid {
+		proofStatus = types.ProofValid
+	}
+	return o.ID.StorageProofOutputID(proofStatus, uint64(i))
+}
+
+func txAt(ix index, loc TxLocation) *types.Transaction {
+	return &ix.blockAt(loc.Block).Transactions[loc.Tx]
+}
+
+// contractTerms returns the proof window and the proof outputs of the
+// last revision of the contract.
+func contractTerms(ix index, h *ContractHistory) (windowStart, windowEnd int, valid, missed []types.SiacoinOutput) {
+	if len(h.Revs) != 0 {
+		last := h.Revs[len(h.Revs)-1]
+		rev := &txAt(ix, last.TxLocation).FileContractRevisions[last.Index]
+		return int(rev.NewWindowStart), int(rev.NewWindowEnd), rev.NewValidProofOutputs, rev.NewMissedProofOutputs
+	}
+	contract := &txAt(ix, h.Contract.TxLocation).FileContracts[h.Contract.Index]
+	return int(contract.WindowStart), int(contract.WindowEnd), contract.ValidProofOutputs, contract.MissedProofOutputs
+}
+
+// contractStatus returns human.Contract* status of the contract in the
+// current chain.
+func contractStatus(ix index, h *ContractHistory) string {
+	if h.Proof != nil {
+		return human.ContractSucceeded
+	}
+	height := ix.numBlocks() - 1
+	windowStart, windowEnd, _, _ := contractTerms(ix, h)
+	switch {
+	case height >= windowEnd:
+		return human.ContractFailed
+	case height >= windowStart:
+		return human.ContractProofWindow
+	default:
+		return human.ContractActive
+	}
+}
+
+// proofOutputCreated returns if the storage proof output was created:
+// it belongs to the last revision and the set chosen by the outcome.
+func proofOutputCreated(ix index, sco *SiacoinOutput) bool {
+	if !isProofOutput(sco.Nature) {
+		return true
+	}
+	tx := txAt(ix, sco.TxLocation)
+	var fcid types.FileContractID
+	switch sco.Nature {
+	case validProofOutput, missedProofOutput:
+		fcid = tx.FileContractID(uint64(sco.Index0))
+	default:
+		fcid = tx.FileContractRevisions[sco.Index0].ParentID
+	}
+	h, has := ix.contract(fcid)
+	if !has {
+		return false
+	}
+	switch sco.Nature {
+	case validProofOutput, missedProofOutput:
+		if len(h.Revs) != 0 {
+			return false
+		}
+	default:
+		if last := h.Revs[len(h.Revs)-1]; last.TxLocation != sco.TxLocation || last.Index != sco.Index0 {
+			return false
+		}
+	}
+	switch contractStatus(ix, h) {
+	case human.ContractSucceeded:
+		return sco.Nature == validProofOutput || sco.Nature == validProofOutputInRevision
+	case human.ContractFailed:
+		return sco.Nature == missedProofOutput || sco.Nature == missedProofOutputInRevision
+	default:
+		return false
+	}
+}
+
+// expiring returns IDs of contracts which had WindowEnd at the height.
+// Contracts revised to another WindowEnd are not removed.
+func (db *Database) expiring(height int) []types.FileContractID {
+	var ids []types.FileContractID
+	if data := db.btx.Bucket(bucketExpiring).Get(heightKey(height)); data != nil {
+		if err := encoding.Unmarshal(data, &ids); err != nil {
+			failIndex(fmt.Errorf("corrupted record in bucket %s: %v", bucketExpiring, err))
+		}
+	}
+	return ids
+}
+
+func (db *Database) addExpiring(height int, fcid types.FileContractID) error {
+	ids := db.expiring(height)
+	for _, id := range ids {
+		if id == fcid {
+			return nil
+		}
+	}
+	return db.put(bucketExpiring, heightKey(height), append(ids, fcid))
+}
+
+// trackExpiring adds contracts and revisions of the block to the lists
+// of contracts expiring at WindowEnd.
+func (db *Database) trackExpiring(block *types.Block) error {
+	for j := range block.Transactions {
+		tx := &block.Transactions[j]
+		for i, contract := range tx.FileContracts {
+			if err := db.addExpiring(int(contract.WindowEnd), tx.FileContractID(uint64(i))); err != nil {
+				return err
+			}
+		}
+		for _, rev := range tx.FileContractRevisions {
+			if err := db.addExpiring(int(rev.NewWindowEnd), rev.ParentID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolvedContracts returns outcomes of contracts resolved by the block
+// at the height: the contracts proven by its storage proofs and the
+// contracts with WindowEnd at the height and without a storage proof.
+// It must be called after the block was added and trackExpiring.
+func (db *Database) resolvedContracts(height int, block *types.Block) []contractOutcome {
+	var outcomes []contractOutcome
+	for j := range block.Transactions {
+		for _, proof := range block.Transactions[j].StorageProofs {
+			h, _ := db.contract(proof.ParentID)
+			_, _, valid, _ := contractTerms(db, h)
+			outcomes = append(outcomes, contractOutcome{ID: proof.ParentID, Valid: true, Outputs: valid})
+		}
+	}
+	for _, fcid := range db.expiring(height) {
+		h, _ := db.contract(fcid)
+		_, windowEnd, _, missed := contractTerms(db, h)
+		if h.Proof != nil || windowEnd != height {
+			continue
+		}
+		outcomes = append(outcomes, contractOutcome{ID: fcid, Valid: false, Outputs: missed})
+	}
+	return outcomes
+}
+
+// indexProofOutputs adds the created storage proof outputs to the
+// histories of addresses. Proof outputs are not added when the contract
+// or revision is confirmed, since most of them are never created.
+func (db *Database) indexProofOutputs(resolved []contractOutcome) error {
+	for _, outcome := range resolved {
+		for i, o := range outcome.Outputs {
+			if err := db.addAddressSco(o.UnlockHash, outcome.outputID(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isProofOutput(nature int) bool {
+	return nature == validProofOutput || nature == missedProofOutput || nature == validProofOutputInRevision || nature == missedProofOutputInRevision
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/starius/sialite/human"
+	"github.com/starius/sialite/netlib/fakepeer"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+func proofOutputs(address types.UnlockHash, value uint64) []types.SiacoinOutput {
+	return []types.SiacoinOutput{{Value: types.NewCurrency64(value), UnlockHash: address}}
+}
+
+func checkContract(t *testing.T, db *Database, fcid types.FileContractID, want string) {
+	t.Helper()
+	err := db.view(func(db *Database) error {
+		h, has := db.contract(fcid)
+		if !has {
+			t.Errorf("contract %s is unknown", fcid)
+		} else if status := contractStatus(db, h); status != want {
+			t.Errorf("contract %s is %s, want %s", fcid, status, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("db.view: %v", err)
+	}
+}
+
+func TestContractStatus(t *testing.T) {
+	blocks := readTestBlocks(t)
+	db := openTestDatabase(t)
+	skipWork(db)
+	addTestBlocks(t, db, blocks[:901])
+
+	// Contract 1 is revised to a later window and misses it, contract 2
+	// is proven.
+	valid1, missed1, missed1rev := types.UnlockHash{1}, types.UnlockHash{2}, types.UnlockHash{3}
+	valid2, missed2 := types.UnlockHash{4}, types.UnlockHash{5}
+	chain := fakepeer.Fork(blocks, 900, 20+int(types.MaturityDelay))
+	chain[901].Transactions = []types.Transaction{{
+		FileContracts: []types.FileContract{
+			{
+				WindowStart:        905,
+				WindowEnd:          910,
+				ValidProofOutputs:  proofOutputs(valid1, 10),
+				MissedProofOutputs: proofOutputs(missed1, 10),
+			},
+			{
+				WindowStart:        905,
+				WindowEnd:          915,
+				ValidProofOutputs:  proofOutputs(valid2, 20),
+				MissedProofOutputs: proofOutputs(missed2, 20),
+			},
+		},
+	}}
+	relink(chain, 900)
+	fcid1 := chain[901].Transactions[0].FileContractID(0)
+	fcid2 := chain[901].Transactions[0].FileContractID(1)
+	chain[903].Transactions = []types.Transaction{{
+		FileContractRevisions: []types.FileContractRevision{{
+			ParentID:              fcid1,
+			NewRevisionNumber:     1,
+			NewWindowStart:        907,
+			NewWindowEnd:          912,
+			NewValidProofOutputs:  proofOutputs(valid1, 10),
+			NewMissedProofOutputs: proofOutputs(missed1rev, 10),
+		}},
+	}}
+	chain[908].Transactions = []types.Transaction{{
+		StorageProofs: []types.StorageProof{{ParentID: fcid2}},
+	}}
+	relink(chain, 902)
+
+	next := 901
+	for _, tc := range []struct {
+		last             int
+		status1, status2 string
+	}{
+		{904, human.ContractActive, human.ContractActive},
+		// The window of contract 1 is taken from the revision.
+		{906, human.ContractActive, human.ContractProofWindow},
+		{907, human.ContractProofWindow, human.ContractProofWindow},
+	} {
+		if err := process(db, chain[next:tc.last+1], 100); err != nil {
+			t.Fatalf("processBlocks: %v", err)
+		}
+		next = tc.last + 1
+		checkContract(t, db, fcid1, tc.status1)
+		checkContract(t, db, fcid2, tc.status2)
+	}
+
+	if err := process(db, chain[908:], 100); err != nil {
+		t.Fatalf("processBlocks: %v", err)
+	}
+	checkContract(t, db, fcid1, human.ContractFailed)
+	checkContract(t, db, fcid2, human.ContractSucceeded)
+	checkSiacoins(t, db, missed1, types.NewCurrency64(0))
+	checkSiacoins(t, db, missed1rev, types.NewCurrency64(10))
+	checkSiacoins(t, db, valid2, types.NewCurrency64(20))
+	checkSiacoins(t, db, missed2, types.NewCurrency64(0))
+
+	// A longer fork without the revision and the storage proof undoes
+	// both outcomes.
+	fork := fakepeer.Fork(chain, 902, 30+int(types.MaturityDelay))
+	if err := process(db, fork[903:], 100); err != nil {
+		t.Fatalf("processBlocks(fork): %v", err)
+	}
+	checkLastBlock(t, db, fork[len(fork)-1].ID())
+	checkContract(t, db, fcid1, human.ContractFailed)
+	checkContract(t, db, fcid2, human.ContractFailed)
+	checkSiacoins(t, db, missed1, types.NewCurrency64(10))
+	checkSiacoins(t, db, missed1rev, types.NewCurrency64(0))
+	checkSiacoins(t, db, valid2, types.NewCurrency64(0))
+	checkSiacoins(t, db, missed2, types.NewCurrency64(20))
+	err := db.view(func(db *Database) error {
+		if h, _ := db.contract(fcid1); len(h.Revs) != 0 {
+			t.Errorf("contract %s has %d revisions after the reorganization, want 0", fcid1, len(h.Revs))
+		}
+		if h, _ := db.contract(fcid2); h.Proof != nil {
+			t.Errorf("contract %s has a storage proof after the reorganization", fcid2)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("db.view: %v", err)
+	}
+}
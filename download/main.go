@@ -3,77 +3,108 @@ package main
 import (
 	"context"
 	"flag"
-	"io"
+	"fmt"
 	"log"
-	"os"
+	"net"
+	"strings"
 
+	"github.com/starius/sialite/cache"
 	"github.com/starius/sialite/netlib"
-	"github.com/xtaci/smux"
 	"gitlab.com/NebulousLabs/Sia/modules"
 	"gitlab.com/NebulousLabs/Sia/types"
-	"gitlab.com/NebulousLabs/fastrand"
 )
 
 var (
-	source = flag.String("source", "", "Source of data (siad node)")
+	source     = flag.String("source", "", "Source of data (siad nodes, comma-separated)")
+	output     = flag.String("output", "blockchain.dat", "Recorded chain to write, resumed if it exists")
+	numPeers   = flag.Int("peers", 4, "Number of nodes to keep connections with")
+	listen     = flag.String("listen", "", "After downloading, serve the chain with Sia gateway protocol on the address (e.g. :9981)")
+	reorgDepth = flag.Int("reorg-depth", 1000, "Max number of blocks which can be replaced by a fork")
 )
 
-type tee struct {
-	impl io.ReadWriter
-	sink io.Writer
-}
-
-func (t *tee) Read(b []byte) (int, error) {
-	n, err := t.impl.Read(b)
-	if err != nil {
-		return n, err
-	}
-	n1, err := t.sink.Write(b[:n])
-	if err != nil {
-		return n, err
-	} else if n1 != n {
-		log.Println(n1, n)
-		return n, io.ErrShortWrite
+// validator returns a validator of blocks following the chain. It reads
+// and verifies all the blocks of the chain.
+func validator(c *netlib.ChainFile) (*cache.Validator, error) {
+	v := cache.NewValidator(nil, nil, *reorgDepth)
+	for height := 0; height < c.Len(); height++ {
+		block, err := c.Block(height)
+		if err != nil {
+			return nil, err
+		}
+		if err := v.Add(block); err != nil {
+			return nil, fmt.Errorf("block %d: %v", height, err)
+		}
 	}
-	return n, nil
-}
-
-func (t *tee) Write(b []byte) (int, error) {
-	return t.impl.Write(b)
+	return v, nil
 }
 
 func main() {
 	flag.Parse()
 	ctx := context.Background()
-	node := *source
-	if node == "" {
-		i := fastrand.Intn(len(modules.BootstrapPeers))
-		node = string(modules.BootstrapPeers[i])
-	}
-	conn, err := netlib.Connect(ctx, node)
+	c, err := netlib.OpenChainFile(*output)
 	if err != nil {
-		panic(err)
+		log.Fatalf("netlib.OpenChainFile: %v", err)
 	}
-	sess, err := smux.Client(conn, nil)
+	defer c.Close()
+	if c.Len() == 0 {
+		if err := c.Append(&types.GenesisBlock); err != nil {
+			log.Fatalf("c.Append: %v", err)
+		}
+	}
+	identity := netlib.DefaultIdentity
+	var ln net.Listener
+	if *listen != "" {
+		ln, err = net.Listen("tcp", *listen)
+		if err != nil {
+			log.Fatalf("net.Listen: %v", err)
+		}
+		identity = netlib.ListenerIdentity(ln)
+	}
+	log.Printf("Resuming after block %d.", c.Len()-1)
+	seeds := modules.BootstrapPeers
+	if *source != "" {
+		seeds = nil
+		for _, node := range strings.Split(*source, ",") {
+			seeds = append(seeds, modules.NetAddress(node))
+		}
+	}
+	pm := netlib.NewPeerManager(seeds, *numPeers, identity)
+	pm.Discover = *source == ""
+	v, err := validator(c)
 	if err != nil {
-		panic(err)
+		log.Fatalf("verifying %s: %v", *output, err)
 	}
-	bchan := make(chan *types.Block)
+	pm.Check = v.Add
+	go pm.Run(ctx)
+	bchan := make(chan *types.Block, 100)
+	errChan := make(chan error, 1)
+	history := c.History()
 	go func() {
-		for range bchan {
-		}
+		_, err := pm.DownloadBlocksFromHistory(ctx, bchan, history)
+		close(bchan)
+		errChan <- err
 	}()
-	f := func() (io.ReadWriter, error) {
-		rw, err := sess.OpenStream()
-		if err != nil {
-			return nil, err
+	for block := range bchan {
+		// Blocks of a fork replace the blocks after their parent.
+		if height, has := c.Height(block.ParentID); has {
+			if err := c.Truncate(height + 1); err != nil {
+				log.Fatalf("c.Truncate: %v", err)
+			}
 		}
-		return &tee{
-			impl: rw,
-			sink: os.Stdout,
-		}, nil
+		if err := c.Append(block); err != nil {
+			log.Fatalf("c.Append: %v", err)
+		}
+	}
+	if err := <-errChan; err != nil {
+		log.Fatalf("DownloadBlocksFromHistory: %v", err)
 	}
-	if err := netlib.DownloadAllBlocks(ctx, bchan, f); err != nil {
-		panic(err)
+	log.Printf("Downloaded blocks up to %d.", c.Len()-1)
+	if ln != nil {
+		log.Printf("Serving the chain on %s.", ln.Addr())
+		server := &netlib.Server{
+			Source:   c,
+			Identity: identity,
+		}
+		log.Fatalf("Server.Serve: %v", server.Serve(ctx, ln))
 	}
 }
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/starius/sialite/human"
+	"gitlab.com/NebulousLabs/Sia/crypto"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+const (
+	// Number of events buffered for a subscriber. A subscriber which
+	// falls behind is disconnected and has to resubscribe.
+	subscriptionBuffer = 1000
+
+	eventsPingInterval = 30 * time.Second
+)
+
+// Hub delivers events about blocks added by addBlocks to subscribers
+// of /events.
+type Hub struct {
+	subs map[*subscription]struct{}
+	mu   sync.Mutex
+}
+
+type subscription struct {
+	addresses map[types.UnlockHash]bool
+	contracts map[types.FileContractID]bool
+	events    chan *human.Event
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subs: make(map[*subscription]struct{}),
+	}
+}
+
+// active returns if anybody is subscribed. Events are not generated
+// otherwise.
+func (h *Hub) active() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs) != 0
+}
+
+func (h *Hub) subscribe(addresses []types.UnlockHash, contracts []types.FileContractID) *subscription {
+	s := &subscription{
+		addresses: make(map[types.UnlockHash]bool),
+		contracts: make(map[types.FileContractID]bool),
+		events:    make(chan *human.Event, subscriptionBuffer),
+	}
+	for _, address := range addresses {
+		s.addresses[address] = true
+	}
+	for _, contract := range contracts {
+		s.contracts[contract] = true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[s] = struct{}{}
+	return s
+}
+
+func (h *Hub) unsubscribe(s *subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, has := h.subs[s]; has {
+		delete(h.subs, s)
+		close(s.events)
+	}
+}
+
+// publish sends the events to the subscribers which want them without
+// blocking. Subscribers with full buffers are dropped.
+func (h *Hub) publish(events []*human.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		for _, e := range events {
+			if !s.wants(e) {
+				continue
+			}
+			select {
+			case s.events <- e:
+			default:
+				delete(h.subs, s)
+				close(s.events)
+			}
+			if _, has := h.subs[s]; !has {
+				break
+			}
+		}
+	}
+}
+
+func (s *subscription) wants(e *human.Event) bool {
+	switch {
+	case e.Type == human.EventBlock || e.Type == human.EventDisconnected:
+		return true
+	case e.Address != nil:
+		return s.addresses[*e.Address]
+	case e.Contract != nil:
+		return s.contracts[*e.Contract]
+	default:
+		return false
+	}
+}
+
+// blockEvents returns events about the block added at the height and
+// the contracts it resolved. It must be called after the block was added.
+// Storage proof outputs are received when the contract is resolved, not
+// when the contract or revision defining them is confirmed.
+func (db *Database) blockEvents(height int, block *types.Block, resolved []contractOutcome) []*human.Event {
+	header := human.BlockHeader{
+		ID:        block.ID(),
+		Nonce:     block.Nonce,
+		Timestamp: block.Timestamp,
+	}
+	var events []*human.Event
+	add := func(typ string, tx *types.TransactionID) *human.Event {
+		e := &human.Event{
+			Type:   typ,
+			Height: height,
+			Block:  header,
+			Tx:     tx,
+		}
+		events = append(events, e)
+		return e
+	}
+	add(human.EventBlock, nil)
+	received := func(records []scoRecord, tx *types.TransactionID) {
+		for _, r := range records {
+			if isProofOutput(r.sco.Nature) {
+				continue
+			}
+			e := add(human.EventSiacoinReceived, tx)
+			address, output := r.unlockHash, crypto.Hash(r.id)
+			e.Address = &address
+			e.Output = &output
+			e.Value = &r.sco.Value(db).Value
+		}
+	}
+	for i := range block.MinerPayouts {
+		received(createdScos(block, TxLocation{Block: height, Tx: -1}, i), nil)
+	}
+	for j := range block.Transactions {
+		tx := &block.Transactions[j]
+		txid := tx.ID()
+		for _, sci := range tx.SiacoinInputs {
+			e := add(human.EventSiacoinSpent, &txid)
+			address, output := sci.UnlockConditions.UnlockHash(), crypto.Hash(sci.ParentID)
+			e.Address = &address
+			e.Output = &output
+		}
+		for _, sfi := range tx.SiafundInputs {
+			e := add(human.EventSiafundSpent, &txid)
+			address, output := sfi.UnlockConditions.UnlockHash(), crypto.Hash(sfi.ParentID)
+			e.Address = &address
+			e.Output = &output
+		}
+		received(createdScos(block, TxLocation{Block: height, Tx: j}, 0), &txid)
+		for i := range tx.SiafundOutputs {
+			sfo := &tx.SiafundOutputs[i]
+			e := add(human.EventSiafundReceived, &txid)
+			output := crypto.Hash(tx.SiafundOutputID(uint64(i)))
+			e.Address = &sfo.UnlockHash
+			e.Output = &output
+			e.Value = &sfo.Value
+		}
+		for i := range tx.FileContracts {
+			e := add(human.EventContract, &txid)
+			fcid := tx.FileContractID(uint64(i))
+			e.Contract = &fcid
+		}
+		for i := range tx.FileContractRevisions {
+			e := add(human.EventRevision, &txid)
+			e.Contract = &tx.FileContractRevisions[i].ParentID
+		}
+		for i := range tx.StorageProofs {
+			e := add(human.EventStorageProof, &txid)
+			e.Contract = &tx.StorageProofs[i].ParentID
+		}
+	}
+	for _, outcome := range resolved {
+		for i := range outcome.Outputs {
+			e := add(human.EventSiacoinReceived, nil)
+			fcid, output := outcome.ID, crypto.Hash(outcome.outputID(i))
+			e.Address = &outcome.Outputs[i].UnlockHash
+			e.Output = &output
+			e.Value = &outcome.Outputs[i].Value
+			e.Contract = &fcid
+		}
+	}
+	return events
+}
+
+func disconnectedEvent(height int, block *types.Block) *human.Event {
+	return &human.Event{
+		Type:   human.EventDisconnected,
+		Height: height,
+		Block: human.BlockHeader{
+			ID:        block.ID(),
+			Nonce:     block.Nonce,
+			Timestamp: block.Timestamp,
+		},
+	}
+}
+
+// handleEvents streams events as Server-Sent Events. Query parameters
+// address and contract (may be repeated) select the events to receive
+// in addition to block events. It does not hold db.mu, since the stream
+// lasts long.
+func (db *Database) handleEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var addresses []types.UnlockHash
+	for _, addressHex := range r.URL.Query()["address"] {
+		var address types.UnlockHash
+		if err := address.LoadString(addressHex); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "address.LoadString(%q): %v.\n", addressHex, err)
+			return
+		}
+		addresses = append(addresses, address)
+	}
+	var contracts []types.FileContractID
+	for _, contractHex := range r.URL.Query()["contract"] {
+		var id crypto.Hash
+		if err := id.LoadString(contractHex); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "id.LoadString(%q): %v.\n", contractHex, err)
+			return
+		}
+		contracts = append(contracts, types.FileContractID(id))
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "streaming is not supported.\n")
+		return
+	}
+	s := db.hub.subscribe(addresses, contracts)
+	defer db.hub.unsubscribe(s)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	ping := time.NewTicker(eventsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-s.events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+		case <-ping.C:
+			if _, err := fmt.Fprintf(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/starius/sialite/human"
+	"github.com/starius/sialite/netlib/fakepeer"
+	"gitlab.com/NebulousLabs/Sia/crypto"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// receive returns the events buffered for the subscriber and if it is
+// still subscribed.
+func receive(s *subscription) (events []*human.Event, open bool) {
+	for {
+		select {
+		case e, ok := <-s.events:
+			if !ok {
+				return events, false
+			}
+			events = append(events, e)
+		default:
+			return events, true
+		}
+	}
+}
+
+func TestHub(t *testing.T) {
+	h := NewHub()
+	if h.active() {
+		t.Fatalf("new hub is active")
+	}
+	address, other := types.UnlockHash{1}, types.UnlockHash{2}
+	contract := types.FileContractID{3}
+	byAddress := h.subscribe([]types.UnlockHash{address}, nil)
+	byContract := h.subscribe(nil, []types.FileContractID{contract})
+	if !h.active() {
+		t.Fatalf("hub with subscribers is not active")
+	}
+	block := &human.Event{Type: human.EventBlock}
+	received := &human.Event{Type: human.EventSiacoinReceived, Address: &address}
+	otherReceived := &human.Event{Type: human.EventSiacoinReceived, Address: &other}
+	revision := &human.Event{Type: human.EventRevision, Contract: &contract}
+	h.publish([]*human.Event{block, received, otherReceived, revision})
+	for _, tc := range []struct {
+		name string
+		s    *subscription
+		want []*human.Event
+	}{
+		{"address subscriber", byAddress, []*human.Event{block, received}},
+		{"contract subscriber", byContract, []*human.Event{block, revision}},
+	} {
+		events, open := receive(tc.s)
+		if !open {
+			t.Errorf("%s was dropped", tc.name)
+		}
+		if len(events) != len(tc.want) {
+			t.Errorf("%s received %d events, want %d", tc.name, len(events), len(tc.want))
+			continue
+		}
+		for i, e := range events {
+			if e != tc.want[i] {
+				t.Errorf("%s received event %d of type %s, want %s", tc.name, i, e.Type, tc.want[i].Type)
+			}
+		}
+	}
+
+	h.unsubscribe(byAddress)
+	if _, open := receive(byAddress); open {
+		t.Errorf("events of unsubscribed subscriber are not closed")
+	}
+	// Unsubscribing twice is harmless.
+	h.unsubscribe(byAddress)
+	h.publish([]*human.Event{block})
+	if events, _ := receive(byContract); len(events) != 1 {
+		t.Errorf("remaining subscriber received %d events, want 1", len(events))
+	}
+	h.unsubscribe(byContract)
+	if h.active() {
+		t.Errorf("hub without subscribers is active")
+	}
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	h := NewHub()
+	fast := h.subscribe(nil, nil)
+	slow := h.subscribe(nil, nil)
+	block := &human.Event{Type: human.EventBlock}
+	for i := 0; i <= subscriptionBuffer; i++ {
+		h.publish([]*human.Event{block})
+		if events, open := receive(fast); !open || len(events) != 1 {
+			t.Fatalf("fast subscriber received %d events, open is %v", len(events), open)
+		}
+	}
+	// The slow subscriber gets the buffered events and is dropped.
+	events, open := receive(slow)
+	if open {
+		t.Errorf("slow subscriber was not dropped")
+	}
+	if len(events) != subscriptionBuffer {
+		t.Errorf("slow subscriber received %d events, want %d", len(events), subscriptionBuffer)
+	}
+	h.unsubscribe(slow)
+	h.unsubscribe(fast)
+	if h.active() {
+		t.Errorf("hub without subscribers is active")
+	}
+}
+
+// waitHub waits until the hub becomes active or inactive.
+func waitHub(t *testing.T, h *Hub, active bool) {
+	t.Helper()
+	for i := 0; i < 100 && h.active() != active; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if h.active() != active {
+		t.Fatalf("hub.active() is %v, want %v", !active, active)
+	}
+}
+
+// readEvent reads the next event of the stream skipping pings.
+func readEvent(t *testing.T, r *bufio.Reader) *human.Event {
+	t.Helper()
+	var typ string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if strings.HasPrefix(line, "event: ") {
+			typ = strings.TrimPrefix(line, "event: ")
+		} else if strings.HasPrefix(line, "data: ") {
+			var e human.Event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatalf("json.Unmarshal: %v", err)
+			}
+			if e.Type != typ {
+				t.Fatalf("event %q has data of type %q", typ, e.Type)
+			}
+			return &e
+		}
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	blocks := readTestBlocks(t)[:301]
+	db := openTestDatabase(t)
+	skipWork(db)
+	addTestBlocks(t, db, blocks[:300])
+	router := httprouter.New()
+	db.addHandlers(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?address=zz")
+	if err != nil {
+		t.Fatalf("http.Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad address: status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	address := blocks[300].MinerPayouts[0].UnlockHash
+	resp, err = http.Get(fmt.Sprintf("%s/events?address=%s", server.URL, address))
+	if err != nil {
+		t.Fatalf("http.Get: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type is %q", ct)
+	}
+	waitHub(t, db.hub, true)
+	r := bufio.NewReader(resp.Body)
+
+	if err := db.addBlocks([]*types.Block{&blocks[300]}); err != nil {
+		t.Fatalf("addBlocks: %v", err)
+	}
+	if e := readEvent(t, r); e.Type != human.EventBlock || e.Height != 300 || e.Block.ID != blocks[300].ID() {
+		t.Errorf("got event %s at %d, want block 300", e.Type, e.Height)
+	}
+	e := readEvent(t, r)
+	if e.Type != human.EventSiacoinReceived || e.Address == nil || *e.Address != address {
+		t.Errorf("got event %s, want %s of %s", e.Type, human.EventSiacoinReceived, address)
+	}
+
+	// Replacing block 300 by a fork disconnects it.
+	fork := fakepeer.Fork(blocks, 299, 2)
+	if err := db.addBlocks([]*types.Block{&fork[300], &fork[301]}); err != nil {
+		t.Fatalf("addBlocks(fork): %v", err)
+	}
+	if e := readEvent(t, r); e.Type != human.EventDisconnected || e.Height != 300 || e.Block.ID != blocks[300].ID() {
+		t.Errorf("got event %s of %s, want %s of block 300", e.Type, e.Block.ID, human.EventDisconnected)
+	}
+	for _, height := range []int{300, 301} {
+		if e := readEvent(t, r); e.Type != human.EventBlock || e.Block.ID != fork[height].ID() {
+			t.Errorf("got event %s of %s, want block %d of the fork", e.Type, e.Block.ID, height)
+		}
+	}
+
+	// The subscriber is removed when the client disconnects.
+	resp.Body.Close()
+	waitHub(t, db.hub, false)
+}
+
+func TestProofOutputEvents(t *testing.T) {
+	blocks := readTestBlocks(t)
+	db := openTestDatabase(t)
+	skipWork(db)
+	addTestBlocks(t, db, blocks[:901])
+
+	valid, missed := types.UnlockHash{1}, types.UnlockHash{2}
+	chain := fakepeer.Fork(blocks, 900, 12)
+	chain[901].Transactions = []types.Transaction{{
+		FileContracts: []types.FileContract{{
+			WindowStart:        905,
+			WindowEnd:          910,
+			ValidProofOutputs:  []types.SiacoinOutput{{Value: types.NewCurrency64(10), UnlockHash: valid}},
+			MissedProofOutputs: []types.SiacoinOutput{{Value: types.NewCurrency64(10), UnlockHash: missed}},
+		}},
+	}}
+	relink(chain, 900)
+	fcid := chain[901].Transactions[0].FileContractID(0)
+
+	s := db.hub.subscribe([]types.UnlockHash{valid, missed}, nil)
+	defer db.hub.unsubscribe(s)
+	if err := process(db, chain[901:], 100); err != nil {
+		t.Fatalf("processBlocks: %v", err)
+	}
+	var received []*human.Event
+	for len(s.events) != 0 {
+		if e := <-s.events; e.Type == human.EventSiacoinReceived {
+			received = append(received, e)
+		}
+	}
+	// Only the missed proof output is received, at WindowEnd.
+	if len(received) != 1 {
+		t.Fatalf("got %d siacoin_received events, want 1", len(received))
+	}
+	e := received[0]
+	if e.Height != 910 || *e.Address != missed || e.Contract == nil || *e.Contract != fcid || e.Tx != nil {
+		t.Errorf("got event %+v, want the missed proof output of %s at height 910", e, fcid)
+	}
+	if want := fcid.StorageProofOutputID(types.ProofMissed, 0); *e.Output != crypto.Hash(want) {
+		t.Errorf("event output is %s, want %s", e.Output, want)
+	}
+}
@@ -0,0 +1,394 @@
+// Package explorerclient implements a client of HTTP API of sialite
+// explorer. The responses are decoded to the types of package human.
+package explorerclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/starius/sialite/human"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// NotFoundError is returned when the explorer responds with 404,
+// e.g. if there is no object with requested ID.
+type NotFoundError struct {
+	URL     string
+	Message string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s: not found: %s", e.URL, e.Message)
+}
+
+// BadRequestError is returned when the explorer responds with 400,
+// e.g. if an ID or a pagination cursor is malformed.
+type BadRequestError struct {
+	URL     string
+	Message string
+}
+
+func (e *BadRequestError) Error() string {
+	return fmt.Sprintf("%s: bad request: %s", e.URL, e.Message)
+}
+
+// StatusError is returned when the explorer responds with an unexpected
+// status other than 400 and 404.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: HTTP status %d: %s", e.URL, e.StatusCode, e.Message)
+}
+
+// Client sends requests to the explorer.
+type Client struct {
+	addr       string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the explorer running on addr, e.g.
+// "http://127.0.0.1:8080". If httpClient is nil, http.DefaultClient
+// is used.
+func NewClient(addr string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		addr:       strings.TrimSuffix(addr, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// do sends GET request and returns the response if its status is 200.
+func (c *Client) do(ctx context.Context, path string, query url.Values) (*http.Response, string, error) {
+	u := c.addr + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, u, fmt.Errorf("http.NewRequest(%q): %v", u, err)
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, u, fmt.Errorf("GET %s: %v", u, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		message := strings.TrimSpace(string(body))
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			return nil, u, &NotFoundError{URL: u, Message: message}
+		case http.StatusBadRequest:
+			return nil, u, &BadRequestError{URL: u, Message: message}
+		default:
+			return nil, u, &StatusError{URL: u, StatusCode: resp.StatusCode, Message: message}
+		}
+	}
+	return resp, u, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	resp, u, err := c.do(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("GET %s: json.Decode: %v", u, err)
+	}
+	return nil
+}
+
+func startWithQuery(startWith string) url.Values {
+	if startWith == "" {
+		return nil
+	}
+	return url.Values{"startwith": []string{startWith}}
+}
+
+// BlockHeadersPage returns one page of block headers. Pass empty startWith
+// to get the first page and the value of Next of previous page to get
+// the following pages. Next of the last page is empty.
+func (c *Client) BlockHeadersPage(ctx context.Context, startWith string) (*human.BlockHeaders, error) {
+	var headers human.BlockHeaders
+	if err := c.get(ctx, "/blocks", startWithQuery(startWith), &headers); err != nil {
+		return nil, err
+	}
+	return &headers, nil
+}
+
+// BlockHeaders returns headers of all the blocks.
+func (c *Client) BlockHeaders(ctx context.Context) ([]human.BlockHeader, error) {
+	var all []human.BlockHeader
+	startWith := ""
+	for {
+		headers, err := c.BlockHeadersPage(ctx, startWith)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, headers.Headers...)
+		if headers.Next == "" {
+			return all, nil
+		}
+		startWith = headers.Next
+	}
+}
+
+// Block returns the block with the ID.
+func (c *Client) Block(ctx context.Context, id types.BlockID) (*human.Block, error) {
+	var block human.Block
+	if err := c.get(ctx, "/block/"+id.String(), nil, &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// BlockAt returns the block with the height.
+func (c *Client) BlockAt(ctx context.Context, height int) (*human.Block, error) {
+	var block human.Block
+	if err := c.get(ctx, "/blocki/"+strconv.Itoa(height), nil, &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// Orphans returns headers of blocks disconnected from the main chain
+// by reorganizations, the highest first.
+func (c *Client) Orphans(ctx context.Context) ([]human.OrphanHeader, error) {
+	var orphans human.OrphanHeaders
+	if err := c.get(ctx, "/orphans", nil, &orphans); err != nil {
+		return nil, err
+	}
+	return orphans.Orphans, nil
+}
+
+// Orphan returns the orphan block with the ID.
+func (c *Client) Orphan(ctx context.Context, id types.BlockID) (*human.OrphanBlock, error) {
+	var block human.OrphanBlock
+	if err := c.get(ctx, "/orphan/"+id.String(), nil, &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// Transaction returns the transaction with the ID. It may be unconfirmed.
+func (c *Client) Transaction(ctx context.Context, id types.TransactionID) (*human.Transaction, error) {
+	var tx human.Transaction
+	if err := c.get(ctx, "/tx/"+id.String(), nil, &tx); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// Search finds objects by a block height, a prefix of hex ID or an
+// address. Pass 0 as limit to use the default limit of the explorer.
+func (c *Client) Search(ctx context.Context, q string, limit int) (*human.SearchResults, error) {
+	query := url.Values{"q": []string{q}}
+	if limit != 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var results human.SearchResults
+	if err := c.get(ctx, "/search", query, &results); err != nil {
+		return nil, err
+	}
+	return &results, nil
+}
+
+// Stats returns statistics of the last block.
+func (c *Client) Stats(ctx context.Context) (*human.BlockStats, error) {
+	var stats human.BlockStats
+	if err := c.get(ctx, "/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Charts returns statistics of blocks ("block" bucket) with heights or
+// of days ("day" bucket) with timestamps in range [from, to]. Empty from
+// or to are chosen by the explorer.
+func (c *Client) Charts(ctx context.Context, bucket, from, to string) (*human.Charts, error) {
+	query := url.Values{"bucket": []string{bucket}}
+	if from != "" {
+		query.Set("from", from)
+	}
+	if to != "" {
+		query.Set("to", to)
+	}
+	var charts human.Charts
+	if err := c.get(ctx, "/charts", query, &charts); err != nil {
+		return nil, err
+	}
+	return &charts, nil
+}
+
+// Mempool returns unconfirmed transactions in the order of arrival.
+func (c *Client) Mempool(ctx context.Context) ([]*human.Transaction, error) {
+	var mempool human.Mempool
+	if err := c.get(ctx, "/mempool", nil, &mempool); err != nil {
+		return nil, err
+	}
+	return mempool.Transactions, nil
+}
+
+// Contract returns the history of the file contract.
+func (c *Client) Contract(ctx context.Context, id types.FileContractID) (*human.ContractHistory, error) {
+	var history human.ContractHistory
+	if err := c.get(ctx, "/contract/"+id.String(), nil, &history); err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// SiacoinOutput returns the record of the Siacoin output.
+func (c *Client) SiacoinOutput(ctx context.Context, id types.SiacoinOutputID) (*human.SiacoinRecord, error) {
+	var record human.SiacoinRecord
+	if err := c.get(ctx, "/siacoin-output/"+id.String(), nil, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// SiafundOutput returns the record of the Siafund output.
+func (c *Client) SiafundOutput(ctx context.Context, id types.SiafundOutputID) (*human.SiafundRecord, error) {
+	var record human.SiafundRecord
+	if err := c.get(ctx, "/siafund-output/"+id.String(), nil, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// AddressHistoryPage returns one page of the history of the address.
+// See BlockHeadersPage for the meaning of startWith.
+func (c *Client) AddressHistoryPage(ctx context.Context, address types.UnlockHash, startWith string) (*human.AddressHistory, error) {
+	var history human.AddressHistory
+	if err := c.get(ctx, "/address/"+address.String(), startWithQuery(startWith), &history); err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// AddressHistory returns the full history of the address. Records of all
+// the pages are concatenated and Next of the result is empty.
+func (c *Client) AddressHistory(ctx context.Context, address types.UnlockHash) (*human.AddressHistory, error) {
+	var all *human.AddressHistory
+	startWith := ""
+	for {
+		history, err := c.AddressHistoryPage(ctx, address, startWith)
+		if err != nil {
+			return nil, err
+		}
+		if all == nil {
+			all = history
+		} else {
+			all.SiacoinHistory = append(all.SiacoinHistory, history.SiacoinHistory...)
+			all.SiafundHistory = append(all.SiafundHistory, history.SiafundHistory...)
+		}
+		if history.Next == "" {
+			all.Next = ""
+			return all, nil
+		}
+		startWith = history.Next
+	}
+}
+
+// Balance returns the current balance of the address.
+func (c *Client) Balance(ctx context.Context, address types.UnlockHash) (*human.AddressBalance, error) {
+	var balance human.AddressBalance
+	if err := c.get(ctx, "/address/"+address.String()+"/balance", nil, &balance); err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// RichListPage returns one page of addresses with the largest balances
+// of the coin ("sc" or "sf"). See BlockHeadersPage for the meaning of
+// startWith. Zero limit means the default of the explorer.
+func (c *Client) RichListPage(ctx context.Context, coin string, limit int, startWith string) (*human.RichList, error) {
+	query := startWithQuery(startWith)
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("coin", coin)
+	if limit != 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var list human.RichList
+	if err := c.get(ctx, "/richlist", query, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// HostsPage returns one page of hosts which announced themselves.
+// See BlockHeadersPage for the meaning of startWith.
+func (c *Client) HostsPage(ctx context.Context, startWith string) (*human.Hosts, error) {
+	var hosts human.Hosts
+	if err := c.get(ctx, "/hosts", startWithQuery(startWith), &hosts); err != nil {
+		return nil, err
+	}
+	return &hosts, nil
+}
+
+// Host returns the host with the public key.
+func (c *Client) Host(ctx context.Context, pubkey types.SiaPublicKey) (*human.Host, error) {
+	var host human.Host
+	if err := c.get(ctx, "/host/"+pubkey.String(), nil, &host); err != nil {
+		return nil, err
+	}
+	return &host, nil
+}
+
+// Events subscribes to events of new blocks and of the addresses and
+// contracts and calls f for every event until ctx is done, the stream
+// fails or f returns an error. The explorer drops subscribers which
+// fall behind, then Events returns io.ErrUnexpectedEOF.
+func (c *Client) Events(ctx context.Context, addresses []types.UnlockHash, contracts []types.FileContractID, f func(*human.Event) error) error {
+	query := url.Values{}
+	for _, address := range addresses {
+		query.Add("address", address.String())
+	}
+	for _, contract := range contracts {
+		query.Add("contract", contract.String())
+	}
+	resp, u, err := c.do(ctx, "/events", query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			// Event type is repeated in data, comments are pings.
+			continue
+		}
+		var e human.Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+			return fmt.Errorf("GET %s: json.Unmarshal: %v", u, err)
+		}
+		if err := f(&e); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("GET %s: %v", u, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
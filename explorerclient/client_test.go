@@ -0,0 +1,127 @@
+package explorerclient
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/starius/sialite/human"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+func newTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blocks", func(w http.ResponseWriter, r *http.Request) {
+		var headers human.BlockHeaders
+		switch r.URL.Query().Get("startwith") {
+		case "":
+			headers.Headers = []human.BlockHeader{{Nonce: types.BlockNonce{1}}, {Nonce: types.BlockNonce{2}}}
+			headers.Next = "ab"
+		case "ab":
+			headers.Headers = []human.BlockHeader{{Nonce: types.BlockNonce{3}}}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("bad startwith.\n"))
+			return
+		}
+		json.NewEncoder(w).Encode(headers)
+	})
+	mux.HandleFunc("/address/", func(w http.ResponseWriter, r *http.Request) {
+		history := human.AddressHistory{SiacoinHistoryLen: 2}
+		if r.URL.Query().Get("startwith") == "" {
+			history.SiacoinHistory = []*human.SiacoinRecord{{}}
+			history.Next = "cd"
+		} else {
+			history.SiacoinHistory = []*human.SiacoinRecord{{}}
+		}
+		json.NewEncoder(w).Encode(history)
+	})
+	mux.HandleFunc("/block/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("no block.\n"))
+	})
+	mux.HandleFunc("/tx/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Query()["address"]) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(": ping\n\n"))
+		w.Write([]byte("event: block\ndata: {\"type\":\"block\",\"height\":7}\n\n"))
+		w.Write([]byte("event: siacoin_spent\ndata: {\"type\":\"siacoin_spent\",\"height\":7}\n\n"))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestClient(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+	c := NewClient(ts.URL, ts.Client())
+	ctx := context.Background()
+
+	headers, err := c.BlockHeaders(ctx)
+	if err != nil {
+		t.Fatalf("c.BlockHeaders: %v", err)
+	}
+	if len(headers) != 3 {
+		t.Fatalf("c.BlockHeaders returned %d headers, want 3", len(headers))
+	}
+	for i, h := range headers {
+		if h.Nonce[0] != byte(i+1) {
+			t.Errorf("header %d: got nonce %v", i, h.Nonce)
+		}
+	}
+
+	history, err := c.AddressHistory(ctx, types.UnlockHash{})
+	if err != nil {
+		t.Fatalf("c.AddressHistory: %v", err)
+	}
+	if len(history.SiacoinHistory) != 2 || history.Next != "" {
+		t.Errorf("c.AddressHistory returned %d records and next %q, want 2 records and no next", len(history.SiacoinHistory), history.Next)
+	}
+
+	if _, err := c.BlockHeadersPage(ctx, "ff"); err == nil {
+		t.Errorf("c.BlockHeadersPage: want an error for bad cursor")
+	} else if _, ok := err.(*BadRequestError); !ok {
+		t.Errorf("c.BlockHeadersPage returned %v, want *BadRequestError", err)
+	}
+	if _, err := c.Block(ctx, types.BlockID{}); err == nil {
+		t.Errorf("c.Block: want an error for unknown block")
+	} else if e, ok := err.(*NotFoundError); !ok {
+		t.Errorf("c.Block returned %v, want *NotFoundError", err)
+	} else if e.Message != "no block." {
+		t.Errorf("c.Block: got message %q, want %q", e.Message, "no block.")
+	}
+	if _, err := c.Transaction(ctx, types.TransactionID{}); err == nil {
+		t.Errorf("c.Transaction: want an error")
+	} else if e, ok := err.(*StatusError); !ok || e.StatusCode != http.StatusInternalServerError {
+		t.Errorf("c.Transaction returned %v, want *StatusError with status 500", err)
+	}
+
+	var events []*human.Event
+	err = c.Events(ctx, []types.UnlockHash{{}}, nil, func(e *human.Event) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("c.Events returned %v, want io.ErrUnexpectedEOF", err)
+	}
+	if len(events) != 2 || events[0].Type != human.EventBlock || events[1].Type != human.EventSiacoinSpent || events[1].Height != 7 {
+		t.Errorf("c.Events: got unexpected events %v", events)
+	}
+	if err := c.Events(ctx, nil, nil, func(e *human.Event) error { return nil }); err == nil {
+		t.Errorf("c.Events: want an error for bad request")
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.BlockHeaders(canceled); err == nil {
+		t.Errorf("c.BlockHeaders: want an error for canceled context")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// Tables written by export and their columns. IDs are hex encoded Sia
+// IDs, tables are joined by them and by heights.
+var exportTables = []struct {
+	name    string
+	columns []string
+}{
+	{"blocks", []string{"height", "id", "parent_id", "nonce", "timestamp", "transactions"}},
+	{"transactions", []string{"id", "height", "index", "size", "miner_fees"}},
+	{"siacoin_inputs", []string{"tx_id", "height", "index", "parent_id", "unlock_hash"}},
+	{"siacoin_outputs", []string{"id", "tx_id", "height", "index", "index0", "nature", "unlock_hash", "value", "created"}},
+	{"siafund_inputs", []string{"tx_id", "height", "index", "parent_id", "unlock_hash", "claim_unlock_hash"}},
+	{"siafund_outputs", []string{"id", "tx_id", "height", "index", "unlock_hash", "value"}},
+	{"contracts", []string{"id", "tx_id", "height", "index", "file_size", "file_merkle_root", "window_start", "window_end", "payout", "unlock_hash", "revision_number", "status"}},
+	{"revisions", []string{"contract_id", "tx_id", "height", "index", "new_revision_number", "new_file_size", "new_file_merkle_root", "new_window_start", "new_window_end", "new_unlock_hash"}},
+	{"storage_proofs", []string{"contract_id", "tx_id", "height", "index"}},
+}
+
+// exporter writes tables to temporary files, which are renamed to
+// the CSV files by commit, so a failed export leaves no partial files.
+type exporter struct {
+	dir       string
+	files     []*os.File
+	writers   map[string]*csv.Writer
+	committed bool
+}
+
+func newExporter(dir string) (*exporter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	e := &exporter{
+		dir:     dir,
+		writers: make(map[string]*csv.Writer),
+	}
+	for _, table := range exportTables {
+		f, err := os.Create(e.path(table.name) + ".tmp")
+		if err != nil {
+			e.abort()
+			return nil, err
+		}
+		e.files = append(e.files, f)
+		w := csv.NewWriter(f)
+		e.writers[table.name] = w
+		if err := w.Write(table.columns); err != nil {
+			e.abort()
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *exporter) path(table string) string {
+	return filepath.Join(e.dir, table+".csv")
+}
+
+func (e *exporter) write(table string, record ...string) error {
+	return e.writers[table].Write(record)
+}
+
+func (e *exporter) close() error {
+	var firstErr error
+	for _, w := range e.writers {
+		w.Flush()
+		if err := w.Error(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, f := range e.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	e.files, e.writers = nil, nil
+	return firstErr
+}
+
+// commit closes the temporary files and renames them to the CSV files.
+func (e *exporter) commit() error {
+	if err := e.close(); err != nil {
+		return err
+	}
+	for _, table := range exportTables {
+		if err := os.Rename(e.path(table.name)+".tmp", e.path(table.name)); err != nil {
+			return err
+		}
+	}
+	e.committed = true
+	return nil
+}
+
+// abort closes and removes the temporary files unless they were
+// committed.
+func (e *exporter) abort() {
+	if e.committed {
+		return
+	}
+	e.close()
+	for _, table := range exportTables {
+		os.Remove(e.path(table.name) + ".tmp")
+	}
+}
+
+func itoa(i int) string {
+	return strconv.Itoa(i)
+}
+
+func utoa(u uint64) string {
+	return strconv.FormatUint(u, 10)
+}
+
+// export writes the index to CSV files in the directory, one file per
+// table of exportTables. The files are replaced only if all of them were
+// written.
+func (db *Database) export(dir string) error {
+	e, err := newExporter(dir)
+	if err != nil {
+		return fmt.Errorf("newExporter: %v", err)
+	}
+	defer e.abort()
+	for height := 0; height < db.numBlocks(); height++ {
+		if err := db.exportBlock(e, height); err != nil {
+			return fmt.Errorf("block %d: %v", height, err)
+		}
+	}
+	return e.commit()
+}
+
+func (db *Database) exportBlock(e *exporter, height int) error {
+	block := db.blockAt(height)
+	h := itoa(height)
+	if err := e.write("blocks", h, block.ID().String(), block.ParentID.String(), fmt.Sprintf("%x", block.Nonce[:]), utoa(uint64(block.Timestamp)), itoa(len(block.Transactions))); err != nil {
+		return err
+	}
+	writeScos := func(records []scoRecord, txid string) error {
+		for _, r := range records {
+			o := r.sco.Value(db)
+			index0 := ""
+			if isProofOutput(r.sco.Nature) {
+				index0 = itoa(r.sco.Index0)
+			}
+			created := strconv.FormatBool(proofOutputCreated(db, &r.sco))
+			if err := e.write("siacoin_outputs", r.id.String(), txid, h, itoa(r.sco.Index), index0, natureStr(r.sco.Nature), o.UnlockHash.String(), o.Value.String(), created); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := range block.MinerPayouts {
+		if err := writeScos(createdScos(block, TxLocation{Block: height, Tx: -1}, i), ""); err != nil {
+			return err
+		}
+	}
+	for j := range block.Transactions {
+		tx := &block.Transactions[j]
+		txid := tx.ID().String()
+		fees := types.NewCurrency64(0)
+		for _, fee := range tx.MinerFees {
+			fees = fees.Add(fee)
+		}
+		if err := e.write("transactions", txid, h, itoa(j), itoa(tx.MarshalSiaSize()), fees.String()); err != nil {
+			return err
+		}
+		for i, sci := range tx.SiacoinInputs {
+			if err := e.write("siacoin_inputs", txid, h, itoa(i), sci.ParentID.String(), sci.UnlockConditions.UnlockHash().String()); err != nil {
+				return err
+			}
+		}
+		if err := writeScos(createdScos(block, TxLocation{Block: height, Tx: j}, 0), txid); err != nil {
+			return err
+		}
+		for i, sfi := range tx.SiafundInputs {
+			if err := e.write("siafund_inputs", txid, h, itoa(i), sfi.ParentID.String(), sfi.UnlockConditions.UnlockHash().String(), sfi.ClaimUnlockHash.String()); err != nil {
+				return err
+			}
+		}
+		for i, sfo := range tx.SiafundOutputs {
+			if err := e.write("siafund_outputs", tx.SiafundOutputID(uint64(i)).String(), txid, h, itoa(i), sfo.UnlockHash.String(), sfo.Value.String()); err != nil {
+				return err
+			}
+		}
+		for i, fc := range tx.FileContracts {
+			fcid := tx.FileContractID(uint64(i))
+			status := ""
+			if history, has := db.contract(fcid); has {
+				status = contractStatus(db, history)
+			}
+			if err := e.write("contracts", fcid.String(), txid, h, itoa(i), utoa(fc.FileSize), fc.FileMerkleRoot.String(), utoa(uint64(fc.WindowStart)), utoa(uint64(fc.WindowEnd)), fc.Payout.String(), fc.UnlockHash.String(), utoa(fc.RevisionNumber), status); err != nil {
+				return err
+			}
+		}
+		for i, rev := range tx.FileContractRevisions {
+			if err := e.write("revisions", rev.ParentID.String(), txid, h, itoa(i), utoa(rev.NewRevisionNumber), utoa(rev.NewFileSize), rev.NewFileMerkleRoot.String(), utoa(uint64(rev.NewWindowStart)), utoa(uint64(rev.NewWindowEnd)), rev.NewUnlockHash.String()); err != nil {
+				return err
+			}
+		}
+		for i, proof := range tx.StorageProofs {
+			if err := e.write("storage_proofs", proof.ParentID.String(), txid, h, itoa(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
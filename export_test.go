@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/bbolt"
+)
+
+// readTable reads the CSV file of the table as maps from columns to
+// values.
+func readTable(t *testing.T, dir, table string) []map[string]string {
+	t.Helper()
+	f, err := os.Open(filepath.Join(dir, table+".csv"))
+	if err != nil {
+		t.Fatalf("os.Open: %v", err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("reading %s: %v", table, err)
+	}
+	var rows []map[string]string
+	for _, record := range records[1:] {
+		row := make(map[string]string)
+		for i, column := range records[0] {
+			row[column] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// column returns the set of values of the column.
+func column(rows []map[string]string, name string) map[string]bool {
+	values := make(map[string]bool)
+	for _, row := range rows {
+		values[row[name]] = true
+	}
+	return values
+}
+
+func TestExport(t *testing.T) {
+	blocks := readTestBlocks(t)
+	db := openTestDatabase(t)
+	addTestBlocks(t, db, blocks)
+	dir := t.TempDir()
+	if err := db.view(func(db *Database) error {
+		return db.export(dir)
+	}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	nblocks := readTable(t, dir, "blocks")
+	if len(nblocks) != len(blocks) {
+		t.Errorf("blocks has %d rows, want %d", len(nblocks), len(blocks))
+	}
+	ntxs, nscis, nscos := 0, 0, 0
+	for _, block := range blocks {
+		nscos += len(block.MinerPayouts)
+		for _, tx := range block.Transactions {
+			ntxs++
+			nscis += len(tx.SiacoinInputs)
+			nscos += len(tx.SiacoinOutputs)
+		}
+	}
+	txs := readTable(t, dir, "transactions")
+	scis := readTable(t, dir, "siacoin_inputs")
+	scos := readTable(t, dir, "siacoin_outputs")
+	for _, c := range []struct {
+		table      string
+		rows, want int
+	}{
+		{"transactions", len(txs), ntxs},
+		{"siacoin_inputs", len(scis), nscis},
+		{"siacoin_outputs", len(scos), nscos},
+	} {
+		if c.rows != c.want {
+			t.Errorf("%s has %d rows, want %d", c.table, c.rows, c.want)
+		}
+	}
+
+	// Rows are joined by IDs and heights.
+	heights, txids, scoids := column(nblocks, "height"), column(txs, "id"), column(scos, "id")
+	for _, row := range txs {
+		if !heights[row["height"]] {
+			t.Fatalf("transaction %s has unknown height %s", row["id"], row["height"])
+		}
+	}
+	for _, row := range scis {
+		if !txids[row["tx_id"]] {
+			t.Fatalf("siacoin input of unknown transaction %s", row["tx_id"])
+		}
+		if !scoids[row["parent_id"]] {
+			t.Fatalf("siacoin input spends unknown output %s", row["parent_id"])
+		}
+	}
+	for _, row := range scos {
+		if row["tx_id"] != "" && !txids[row["tx_id"]] {
+			t.Fatalf("siacoin output %s of unknown transaction %s", row["id"], row["tx_id"])
+		}
+	}
+
+	// A failed export keeps the previous files.
+	before := readTable(t, dir, "blocks")
+	err := db.bdb.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketHeaders).Put(heightKey(500), []byte{1})
+	})
+	if err != nil {
+		t.Fatalf("corrupting the database: %v", err)
+	}
+	if err := db.view(func(db *Database) error {
+		return db.export(dir)
+	}); err == nil {
+		t.Fatalf("export of a corrupted database succeeded")
+	}
+	if after := readTable(t, dir, "blocks"); !reflect.DeepEqual(after, before) {
+		t.Errorf("failed export changed blocks.csv")
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmps) != 0 {
+		t.Errorf("failed export left temporary files %v", tmps)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"sort"
 )
@@ -13,15 +14,50 @@ var (
 )
 
 // File structure:
-// pages | prefixes | uint32(npages) | uint32(pageLen) | uint32(keyLen) | uint32(valueLen) | uint32(prefixLen)
+// pages | prefixes | crcs | valuesInfo | params | uint32(metaCRC) | uint32(version) | magic
+// params: uint32(npages) | uint32(pageLen) | uint32(keyLen) | uint32(valueLen) | uint32(prefixLen)
+// valuesInfo: uint64(length) | uint32(CRC32C) of the values file of
+// MultiMap, zeros for Map.
+// crcs has uint32 CRC32C of each page, metaCRC is CRC32C of prefixes,
+// crcs, valuesInfo and params. Files of version 2 have no valuesInfo.
+// Files of version 1 have no checksums and end with params:
+// pages | prefixes | params. They are still readable.
 
-const tailLen = 5 * 4
+const (
+	paramsLen     = 5 * 4
+	valuesInfoLen = 8 + 4
+	tailLen       = paramsLen + 2*4 + 4
+	formatVersion = 3
+)
+
+var (
+	tailMagic  = []byte("FMap")
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// CorruptedError is returned when the data of a map does not match its
+// checksum or is inconsistent.
+type CorruptedError struct {
+	Page   int // -1 if the error is not in a page.
+	Reason string
+}
+
+func (e *CorruptedError) Error() string {
+	if e.Page == -1 {
+		return fmt.Sprintf("corrupted map: %s", e.Reason)
+	}
+	return fmt.Sprintf("corrupted map: page %d: %s", e.Page, e.Reason)
+}
 
 type MapWriter struct {
 	pageLen, keyLen, valueLen, prefixLen int
 
 	data     io.Writer
 	prefixes []byte
+	crcs     []byte
+
+	valuesLen uint64
+	valuesCRC uint32
 
 	ffff []byte
 
@@ -100,10 +136,8 @@ func (w *MapWriter) Write(rec []byte) (int, error) {
 		for i := w.valueStart; i < w.pageLen; i++ {
 			w.prevPage[i] = 0xFF
 		}
-		if n, err := w.data.Write(w.prevPage); err != nil {
+		if err := w.writePage(w.prevPage); err != nil {
 			return 0, err
-		} else if n != w.pageLen {
-			return 0, io.ErrShortWrite
 		}
 		w.keyStart = n1
 		w.valueStart = w.valuesStart + n2
@@ -139,16 +173,19 @@ func (w *MapWriter) Close() error {
 		for i := w.valueStart; i < w.pageLen; i++ {
 			w.page[i] = 0xFF
 		}
-		if n, err := w.data.Write(w.page); err != nil {
+		if err := w.writePage(w.page); err != nil {
 			return err
-		} else if n != w.pageLen {
-			return io.ErrShortWrite
 		}
 		w.keyStart = 0
 	}
 	npages := len(w.prefixes) / w.prefixLen
-	suffix := w.prefixes
-	buf := make([]byte, 4)
+	suffix := append(w.prefixes, w.crcs...)
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, w.valuesLen)
+	suffix = append(suffix, buf...)
+	buf = buf[:4]
+	binary.LittleEndian.PutUint32(buf, w.valuesCRC)
+	suffix = append(suffix, buf...)
 	binary.LittleEndian.PutUint32(buf, uint32(npages))
 	suffix = append(suffix, buf...)
 	binary.LittleEndian.PutUint32(buf, uint32(w.pageLen))
@@ -159,6 +196,11 @@ func (w *MapWriter) Close() error {
 	suffix = append(suffix, buf...)
 	binary.LittleEndian.PutUint32(buf, uint32(w.prefixLen))
 	suffix = append(suffix, buf...)
+	binary.LittleEndian.PutUint32(buf, crc32.Checksum(suffix, castagnoli))
+	suffix = append(suffix, buf...)
+	binary.LittleEndian.PutUint32(buf, formatVersion)
+	suffix = append(suffix, buf...)
+	suffix = append(suffix, tailMagic...)
 	if n, err := w.data.Write(suffix); err != nil {
 		return err
 	} else if n != len(suffix) {
@@ -172,46 +214,104 @@ func (w *MapWriter) Close() error {
 	return nil
 }
 
+func (w *MapWriter) writePage(page []byte) error {
+	if n, err := w.data.Write(page); err != nil {
+		return err
+	} else if n != w.pageLen {
+		return io.ErrShortWrite
+	}
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, crc32.Checksum(page, castagnoli))
+	w.crcs = append(w.crcs, buf...)
+	return nil
+}
+
 type mapParams struct {
 	npages, pageLen, keyLen, valueLen, prefixLen         int
 	perPage, valuesStart, dataLen, prefixesLen, totalLen int
+
+	checksums     bool
+	metaCRC       uint32
+	crcsLen       int
+	valuesInfoLen int
+	// Metadata (prefixes, crcs, valuesInfo and params) is
+	// input[dataLen:metaEnd].
+	metaEnd int
 }
 
-func parseTail(tail []byte) (p mapParams) {
-	if len(tail) != tailLen {
-		panic("bad tail length")
+// readTail reads and checks the parameters of the map of the size.
+func readTail(r io.ReaderAt, size int64) (p mapParams, err error) {
+	if size < paramsLen {
+		return p, fmt.Errorf("input is too short")
+	}
+	tail := make([]byte, tailLen)
+	if size < tailLen {
+		tail = tail[:size]
+	}
+	if err := readFullAt(r, tail, size-int64(len(tail))); err != nil {
+		return p, fmt.Errorf("failed to read tail: %v", err)
+	}
+	thisTailLen := paramsLen
+	if len(tail) == tailLen && bytes.Equal(tail[tailLen-len(tailMagic):], tailMagic) {
+		version := binary.LittleEndian.Uint32(tail[paramsLen+4:])
+		if version != 2 && version != formatVersion {
+			return p, fmt.Errorf("unsupported version of fastmap format: %d", version)
+		}
+		if version == formatVersion {
+			p.valuesInfoLen = valuesInfoLen
+		}
+		p.checksums = true
+		p.metaCRC = binary.LittleEndian.Uint32(tail[paramsLen:])
+		thisTailLen = tailLen
+	}
+	params := tail[len(tail)-thisTailLen:]
+	p.npages = int(binary.LittleEndian.Uint32(params[0:4]))
+	p.pageLen = int(binary.LittleEndian.Uint32(params[4:8]))
+	p.keyLen = int(binary.LittleEndian.Uint32(params[8:12]))
+	p.valueLen = int(binary.LittleEndian.Uint32(params[12:16]))
+	p.prefixLen = int(binary.LittleEndian.Uint32(params[16:20]))
+	if p.keyLen == 0 || p.prefixLen > p.keyLen {
+		return p, fmt.Errorf("bad parameters of the map")
 	}
-	p.npages = int(binary.LittleEndian.Uint32(tail[0:4]))
-	p.pageLen = int(binary.LittleEndian.Uint32(tail[4:8]))
-	p.keyLen = int(binary.LittleEndian.Uint32(tail[8:12]))
-	p.valueLen = int(binary.LittleEndian.Uint32(tail[12:16]))
-	p.prefixLen = int(binary.LittleEndian.Uint32(tail[16:20]))
 	p.perPage = p.pageLen / (p.keyLen + p.valueLen)
 	p.valuesStart = p.perPage * p.keyLen
 	p.dataLen = p.npages * p.pageLen
 	p.prefixesLen = p.npages * p.prefixLen
-	p.totalLen = p.dataLen + p.prefixesLen + tailLen
-	return
+	if p.checksums {
+		p.crcsLen = p.npages * 4
+	}
+	p.metaEnd = p.dataLen + p.prefixesLen + p.crcsLen + p.valuesInfoLen + paramsLen
+	p.totalLen = p.metaEnd + thisTailLen - paramsLen
+	if size != int64(p.totalLen) {
+		return p, fmt.Errorf("input has incorrect length %d, want %d", size, p.totalLen)
+	}
+	return p, nil
 }
 
 type Map struct {
 	npages, pageLen, keyLen, valueLen, prefixLen, perPage, valuesStart int
 
 	data, prefixes []byte
+	ffff           []byte
+
+	// If checksums is set, crcs has CRC32C of pages and metaCRC is
+	// CRC32C of meta.
+	checksums  bool
+	crcs, meta []byte
+	metaCRC    uint32
+
+	// If hasValuesInfo is set, valuesLen and valuesCRC describe the
+	// values file of MultiMap.
+	hasValuesInfo bool
+	valuesLen     int64
+	valuesCRC     uint32
+
+	pages *pageCache // If set, pages are read from it instead of data.
 }
 
-func OpenMap(input []byte) (*Map, error) {
-	if len(input) < tailLen {
-		return nil, fmt.Errorf("input is too short")
-	}
-	tail := input[len(input)-tailLen:]
-	p := parseTail(tail)
-	if len(input) != p.totalLen {
-		return nil, fmt.Errorf("input has incorrect length %d, want %d", len(input), p.totalLen)
-	}
-	data := input[:p.dataLen]
-	prefixes := input[p.dataLen : p.dataLen+p.prefixesLen]
-	return &Map{
+// newMap creates the map from its data and metadata (input[p.dataLen:p.metaEnd]).
+func newMap(p mapParams, data, meta []byte) (*Map, error) {
+	m := &Map{
 		npages:      p.npages,
 		pageLen:     p.pageLen,
 		keyLen:      p.keyLen,
@@ -220,27 +320,167 @@ func OpenMap(input []byte) (*Map, error) {
 		perPage:     p.perPage,
 		valuesStart: p.valuesStart,
 		data:        data,
-		prefixes:    prefixes,
-	}, nil
+		prefixes:    meta[:p.prefixesLen],
+		ffff:        ffffKey(p.keyLen),
+		checksums:   p.checksums,
+		crcs:        meta[p.prefixesLen : p.prefixesLen+p.crcsLen],
+		meta:        meta,
+		metaCRC:     p.metaCRC,
+	}
+	if err := m.checkMeta(); err != nil {
+		return nil, err
+	}
+	if p.valuesInfoLen != 0 {
+		info := meta[p.prefixesLen+p.crcsLen:]
+		m.hasValuesInfo = true
+		m.valuesLen = int64(binary.LittleEndian.Uint64(info))
+		m.valuesCRC = binary.LittleEndian.Uint32(info[8:])
+	}
+	return m, nil
 }
 
-func (m *Map) Lookup(key []byte) ([]byte, error) {
-	if len(key) != m.keyLen {
-		return nil, fmt.Errorf("Bad keyLen")
+func OpenMap(input []byte) (*Map, error) {
+	p, err := readTail(bytes.NewReader(input), int64(len(input)))
+	if err != nil {
+		return nil, err
 	}
-	// Find the right page.
+	return newMap(p, input[:p.dataLen], input[p.dataLen:p.metaEnd])
+}
+
+// OpenMapReaderAt opens the map of the size stored in r without reading
+// it into memory. Only the prefixes of pages are kept in memory, pages
+// are read when needed and up to cachePages of them are cached.
+func OpenMapReaderAt(r io.ReaderAt, size int64, cachePages int) (*Map, error) {
+	p, err := readTail(r, size)
+	if err != nil {
+		return nil, err
+	}
+	meta := make([]byte, p.metaEnd-p.dataLen)
+	if err := readFullAt(r, meta, int64(p.dataLen)); err != nil {
+		return nil, fmt.Errorf("failed to read prefixes: %v", err)
+	}
+	m, err := newMap(p, nil, meta)
+	if err != nil {
+		return nil, err
+	}
+	m.pages = newPageCache(r, p.pageLen, cachePages, m.checkPage)
+	return m, nil
+}
+
+func (m *Map) checkMeta() error {
+	if m.checksums && crc32.Checksum(m.meta, castagnoli) != m.metaCRC {
+		return &CorruptedError{Page: -1, Reason: "checksum mismatch of prefixes"}
+	}
+	return nil
+}
+
+func (m *Map) checkPage(i int, page []byte) error {
+	if !m.checksums {
+		return nil
+	}
+	if crc32.Checksum(page, castagnoli) != binary.LittleEndian.Uint32(m.crcs[4*i:]) {
+		return &CorruptedError{Page: i, Reason: "checksum mismatch"}
+	}
+	return nil
+}
+
+// NumPages returns the number of pages in the map.
+func (m *Map) NumPages() int {
+	return m.npages
+}
+
+// Page returns i-th page of the map. If the map has checksums, they are
+// checked and *CorruptedError is returned in case of mismatch.
+func (m *Map) Page(i int) ([]byte, error) {
+	if i < 0 || i >= m.npages {
+		return nil, fmt.Errorf("page %d is out of range [0, %d)", i, m.npages)
+	}
+	if m.pages != nil {
+		return m.pages.get(i)
+	}
+	start := i * m.pageLen
+	page := m.data[start : start+m.pageLen]
+	if err := m.checkPage(i, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Verify reads the whole map and checks its checksums (if the map has
+// them) and the order and layout of keys in pages. It returns
+// *CorruptedError if the map is corrupted.
+func (m *Map) Verify() error {
+	if err := m.checkMeta(); err != nil {
+		return err
+	}
+	var prevKey []byte
+	for i := 0; i < m.npages; i++ {
+		page, err := m.Page(i)
+		if err != nil {
+			return err
+		}
+		corrupted := func(format string, args ...interface{}) error {
+			return &CorruptedError{Page: i, Reason: fmt.Sprintf(format, args...)}
+		}
+		n := m.perPage
+		for j := 0; j < m.perPage; j++ {
+			key := page[j*m.keyLen : (j+1)*m.keyLen]
+			if bytes.Equal(key, m.ffff) {
+				n = j
+				break
+			}
+			if prevKey != nil && bytes.Compare(prevKey, key) >= 0 {
+				return corrupted("key %x is not greater than previous key %x", key, prevKey)
+			}
+			if j == 0 {
+				if prevKey != nil && bytes.Equal(prevKey[:m.prefixLen], key[:m.prefixLen]) {
+					return corrupted("first key %x has the prefix of the previous page", key)
+				}
+				if !bytes.Equal(key[:m.prefixLen], m.prefixes[i*m.prefixLen:(i+1)*m.prefixLen]) {
+					return corrupted("first key %x does not match the prefix of the page", key)
+				}
+			}
+			prevKey = key
+		}
+		if n == 0 {
+			return corrupted("empty page")
+		}
+		for _, empty := range [][]byte{page[n*m.keyLen : m.valuesStart], page[m.valuesStart+n*m.valueLen:]} {
+			for _, b := range empty {
+				if b != 0xFF {
+					return corrupted("empty slots are not filled with FF")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// findPage returns the index of the page which may contain the key
+// or -1 if the key is less than the first key of the map.
+func (m *Map) findPage(key []byte) int {
 	prefix := key[:m.prefixLen]
-	ipage := sort.Search(m.npages, func(i int) bool {
+	return sort.Search(m.npages, func(i int) bool {
 		start := i * m.prefixLen
 		candidate := m.prefixes[start : start+m.prefixLen]
 		return bytes.Compare(candidate, prefix) > 0
 	}) - 1
+}
+
+func (m *Map) Lookup(key []byte) ([]byte, error) {
+	if len(key) != m.keyLen {
+		return nil, fmt.Errorf("Bad keyLen")
+	}
+	// Find the right page.
+	ipage := m.findPage(key)
 	if ipage == -1 {
 		// Not found.
 		return nil, nil
 	}
-	start := ipage * m.pageLen
-	page := m.data[start : start+m.pageLen]
+	page, err := m.Page(ipage)
+	if err != nil {
+		return nil, err
+	}
 	inside := sort.Search(m.perPage, func(i int) bool {
 		start := i * m.keyLen
 		candidate := page[start : start+m.keyLen]
@@ -250,7 +490,7 @@ func (m *Map) Lookup(key []byte) ([]byte, error) {
 		// Not found.
 		return nil, nil
 	}
-	start = inside * m.keyLen
+	start := inside * m.keyLen
 	candidate := page[start : start+m.keyLen]
 	if !bytes.Equal(key, candidate) {
 		// Not found.
@@ -272,21 +512,20 @@ type MapReader struct {
 	valueStart int
 	dataLen    int
 	pageLen    int
+	crcs       []byte // CRC32C of pages if the map has checksums.
 }
 
 func NewMapReader(size int, data io.ReaderAt) (*MapReader, error) {
-	if size < tailLen {
-		return nil, fmt.Errorf("input is too short")
-	}
-	tail := make([]byte, tailLen)
-	if n, err := data.ReadAt(tail, int64(size-tailLen)); err != nil {
-		return nil, fmt.Errorf("failed to read tail: %v", err)
-	} else if n != tailLen {
-		return nil, fmt.Errorf("short read")
+	p, err := readTail(data, int64(size))
+	if err != nil {
+		return nil, err
 	}
-	p := parseTail(tail)
-	if size != p.totalLen {
-		return nil, fmt.Errorf("input has incorrect length %d, want %d", size, p.totalLen)
+	var crcs []byte
+	if p.checksums {
+		crcs = make([]byte, p.crcsLen)
+		if err := readFullAt(data, crcs, int64(p.dataLen+p.prefixesLen)); err != nil {
+			return nil, fmt.Errorf("failed to read checksums: %v", err)
+		}
 	}
 	ffff := make([]byte, p.keyLen)
 	for i := range ffff {
@@ -301,6 +540,7 @@ func NewMapReader(size int, data io.ReaderAt) (*MapReader, error) {
 		data:        data,
 		ffff:        ffff,
 		page:        make([]byte, p.pageLen),
+		crcs:        crcs,
 		keyStart:    p.valuesStart, // This will cause page read from the first Read.
 	}, nil
 }
@@ -322,6 +562,12 @@ func (r *MapReader) Read(rec []byte) (int, error) {
 		} else if n != len(r.page) {
 			return 0, fmt.Errorf("short read from the underlying reader (%d != %d)", n, len(r.page))
 		}
+		if r.crcs != nil {
+			i := r.pageStart / r.pageLen
+			if crc32.Checksum(r.page, castagnoli) != binary.LittleEndian.Uint32(r.crcs[4*i:]) {
+				return 0, &CorruptedError{Page: i, Reason: "checksum mismatch"}
+			}
+		}
 		r.pageStart += r.pageLen
 		r.keyStart = 0
 		r.valueStart = r.valuesStart
@@ -2,7 +2,9 @@ package fastmap
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -230,3 +232,395 @@ func TestFastmapEmpty(t *testing.T) {
 		t.Errorf("expected to get 'not found', got %v", value)
 	}
 }
+
+func writeMap(t *testing.T, keys, values [][]byte, pageLen, prefixLen int) []byte {
+	var data bytes.Buffer
+	w, err := NewMapWriter(pageLen, len(keys[0]), len(values[0]), prefixLen, &data)
+	if err != nil {
+		t.Fatalf("NewMapWriter: %v", err)
+	}
+	for i, key := range keys {
+		if _, err := w.Write(append(append([]byte(nil), key...), values[i]...)); err != nil {
+			t.Fatalf("Write(): %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close(): %v", err)
+	}
+	return data.Bytes()
+}
+
+func buildMap(t *testing.T, keys, values [][]byte, pageLen, prefixLen int) *Map {
+	m, err := OpenMap(writeMap(t, keys, values, pageLen, prefixLen))
+	if err != nil {
+		t.Fatalf("OpenMap: %v", err)
+	}
+	return m
+}
+
+func TestIterator(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	var keys, values [][]byte
+	for i := 0; i < 20000; i++ {
+		key := make([]byte, 8)
+		r.Read(key)
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) == -1
+	})
+	for _, key := range keys {
+		values = append(values, append([]byte("v"), key...))
+	}
+	for _, pageLen := range []int{100, 4096} {
+		m := buildMap(t, keys, values, pageLen, 3)
+		// Forward and reverse iteration.
+		i := 0
+		it, err := m.Seek(nil)
+		if err != nil {
+			t.Fatalf("Seek: %v", err)
+		}
+		for ; it.Valid(); it.Next() {
+			if !bytes.Equal(it.Key(), keys[i]) || !bytes.Equal(it.Value(), values[i]) {
+				t.Fatalf("%d: record %d is %x, want %x", pageLen, i, it.Key(), keys[i])
+			}
+			i++
+		}
+		if i != len(keys) {
+			t.Fatalf("%d: iterated over %d records, want %d", pageLen, i, len(keys))
+		}
+		it.Prev()
+		for i--; it.Valid(); it.Prev() {
+			if !bytes.Equal(it.Key(), keys[i]) {
+				t.Fatalf("%d: reverse record %d is %x, want %x", pageLen, i, it.Key(), keys[i])
+			}
+			i--
+		}
+		if i != -1 {
+			t.Fatalf("%d: reverse iteration stopped at %d", pageLen, i)
+		}
+		it.Next()
+		if !it.Valid() || !bytes.Equal(it.Key(), keys[0]) {
+			t.Fatalf("%d: Next after the beginning did not return the first record", pageLen)
+		}
+		// Seek to existing and random keys.
+		for j := 0; j < 2000; j++ {
+			key := make([]byte, 8)
+			if j%2 == 0 {
+				copy(key, keys[r.Intn(len(keys))])
+			} else {
+				r.Read(key)
+			}
+			want := sort.Search(len(keys), func(i int) bool {
+				return bytes.Compare(keys[i], key) >= 0
+			})
+			it, err := m.Seek(key)
+			if err != nil {
+				t.Fatalf("Seek: %v", err)
+			}
+			if want == len(keys) {
+				if it.Valid() {
+					t.Errorf("%d: Seek(%x) returned %x, want the end", pageLen, key, it.Key())
+				}
+			} else if !it.Valid() || !bytes.Equal(it.Key(), keys[want]) {
+				t.Errorf("%d: Seek(%x) did not return %x", pageLen, key, keys[want])
+			}
+			if want == len(keys) || !bytes.Equal(keys[want], key) {
+				want--
+			}
+			it, err = m.SeekReverse(key)
+			if err != nil {
+				t.Fatalf("SeekReverse: %v", err)
+			}
+			if want == -1 {
+				if it.Valid() {
+					t.Errorf("%d: SeekReverse(%x) returned %x, want the beginning", pageLen, key, it.Key())
+				}
+			} else if !it.Valid() || !bytes.Equal(it.Key(), keys[want]) {
+				t.Errorf("%d: SeekReverse(%x) did not return %x", pageLen, key, keys[want])
+			}
+		}
+		// Prefix scans.
+		for _, prefix := range [][]byte{{0x00}, {0x42}, {0xFF}, {0x42, 0x43}, keys[100][:5], keys[100]} {
+			var want [][]byte
+			for _, key := range keys {
+				if bytes.HasPrefix(key, prefix) {
+					want = append(want, key)
+				}
+			}
+			var got [][]byte
+			if err := m.ScanPrefix(prefix, func(key, value []byte) error {
+				got = append(got, key)
+				return nil
+			}); err != nil {
+				t.Fatalf("ScanPrefix: %v", err)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("%d: ScanPrefix(%x) returned %d records, want %d", pageLen, prefix, len(got), len(want))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], want[i]) {
+					t.Errorf("%d: ScanPrefix(%x) returned %x, want %x", pageLen, prefix, got[i], want[i])
+				}
+			}
+		}
+		if _, err := m.Seek(make([]byte, 9)); err == nil {
+			t.Errorf("Seek with a long key succeeded")
+		}
+		stop := fmt.Errorf("stop")
+		n := 0
+		if err := m.ScanPrefix(nil, func(key, value []byte) error {
+			n++
+			return stop
+		}); err != stop || n != 1 {
+			t.Errorf("ScanPrefix did not stop on error: %v, %d calls", err, n)
+		}
+	}
+}
+
+func TestIteratorEmpty(t *testing.T) {
+	var data bytes.Buffer
+	w, err := NewMapWriter(4096, 10, 10, 10, &data)
+	if err != nil {
+		t.Fatalf("NewMapWriter: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close(): %v", err)
+	}
+	m, err := OpenMap(data.Bytes())
+	if err != nil {
+		t.Fatalf("OpenMap: %v", err)
+	}
+	it, err := m.Seek(nil)
+	if err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	if it.Valid() {
+		t.Errorf("Seek in empty map returned a record")
+	}
+	it.Prev()
+	it.Next()
+	if it.Valid() {
+		t.Errorf("iterator of empty map has a record")
+	}
+	it, err = m.SeekReverse(nil)
+	if err != nil {
+		t.Fatalf("SeekReverse: %v", err)
+	}
+	if it.Valid() {
+		t.Errorf("SeekReverse in empty map returned a record")
+	}
+}
+
+type failingReaderAt struct {
+	r      io.ReaderAt
+	failAt int64
+	nreads int
+}
+
+func (f *failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	f.nreads++
+	if off == f.failAt {
+		return 0, fmt.Errorf("disk failure")
+	}
+	return f.r.ReadAt(p, off)
+}
+
+func TestMapReaderAt(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	var keys, values [][]byte
+	for i := 0; i < 20000; i++ {
+		key := make([]byte, 8)
+		r.Read(key)
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) == -1
+	})
+	for _, key := range keys {
+		values = append(values, append([]byte("v"), key...))
+	}
+	const pageLen = 256
+	data := writeMap(t, keys, values, pageLen, 3)
+	reader := &failingReaderAt{r: bytes.NewReader(data), failAt: -1}
+	m, err := OpenMapReaderAt(reader, int64(len(data)), 4)
+	if err != nil {
+		t.Fatalf("OpenMapReaderAt: %v", err)
+	}
+	for i, key := range keys {
+		value, err := m.Lookup(key)
+		if err != nil {
+			t.Fatalf("Lookup(%x): %v", key, err)
+		}
+		if !bytes.Equal(value, values[i]) {
+			t.Fatalf("Lookup(%x) returned %x, want %x", key, value, values[i])
+		}
+	}
+	// Repeated lookups of the same keys are served by the cache.
+	if _, err := m.Lookup(keys[0]); err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	nreads := reader.nreads
+	for i := 0; i < 100; i++ {
+		if _, err := m.Lookup(keys[i%3]); err != nil {
+			t.Fatalf("Lookup: %v", err)
+		}
+	}
+	if reader.nreads != nreads {
+		t.Errorf("cached lookups caused %d reads", reader.nreads-nreads)
+	}
+	n := 0
+	if err := m.ScanPrefix(nil, func(key, value []byte) error {
+		if !bytes.Equal(key, keys[n]) {
+			return fmt.Errorf("record %d is %x, want %x", n, key, keys[n])
+		}
+		n++
+		return nil
+	}); err != nil {
+		t.Fatalf("ScanPrefix: %v", err)
+	}
+	if n != len(keys) {
+		t.Errorf("ScanPrefix returned %d records, want %d", n, len(keys))
+	}
+	// Errors of the reader are returned.
+	reader.failAt = 10 * pageLen
+	m, err = OpenMapReaderAt(reader, int64(len(data)), 4)
+	if err != nil {
+		t.Fatalf("OpenMapReaderAt: %v", err)
+	}
+	page, err := m.Page(10)
+	if err == nil {
+		t.Fatalf("Page(10) returned no error")
+	}
+	if page, err = m.Page(9); err != nil {
+		t.Fatalf("Page(9): %v", err)
+	}
+	if _, err := m.Lookup(page[:8]); err != nil {
+		t.Errorf("Lookup in page 9: %v", err)
+	}
+	if err := m.ScanPrefix(nil, func(key, value []byte) error {
+		return nil
+	}); err == nil {
+		t.Errorf("ScanPrefix over a failing page returned no error")
+	}
+	if _, err := OpenMapReaderAt(reader, int64(len(data)-1), 4); err == nil {
+		t.Errorf("OpenMapReaderAt with wrong size succeeded")
+	}
+}
+
+func TestMapChecksums(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	var keys, values [][]byte
+	for i := 0; i < 1000; i++ {
+		key := make([]byte, 8)
+		r.Read(key)
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) == -1
+	})
+	for _, key := range keys {
+		values = append(values, append([]byte("v"), key...))
+	}
+	const pageLen = 256
+	data := writeMap(t, keys, values, pageLen, 3)
+	m, err := OpenMap(data)
+	if err != nil {
+		t.Fatalf("OpenMap: %v", err)
+	}
+	if err := m.Verify(); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	npages := m.NumPages()
+	page3, err := m.Page(3)
+	if err != nil {
+		t.Fatalf("Page(3): %v", err)
+	}
+	key3 := append([]byte(nil), page3[:8]...)
+	checkCorrupted := func(what string, err error, page int) {
+		t.Helper()
+		var corrupted *CorruptedError
+		if !errors.As(err, &corrupted) {
+			t.Errorf("%s returned %v, want *CorruptedError", what, err)
+		} else if corrupted.Page != page {
+			t.Errorf("%s returned error in page %d, want %d", what, corrupted.Page, page)
+		}
+	}
+
+	// A bit flip in a page.
+	damaged := append([]byte(nil), data...)
+	damaged[3*pageLen+100] ^= 0x10
+	m, err = OpenMap(damaged)
+	if err != nil {
+		t.Fatalf("OpenMap: %v", err)
+	}
+	_, err = m.Lookup(key3)
+	checkCorrupted("Lookup", err, 3)
+	checkCorrupted("Verify", m.Verify(), 3)
+	if _, err := m.Lookup(keys[0]); err != nil {
+		t.Errorf("Lookup in an intact page: %v", err)
+	}
+	rm, err := OpenMapReaderAt(bytes.NewReader(damaged), int64(len(damaged)), 4)
+	if err != nil {
+		t.Fatalf("OpenMapReaderAt: %v", err)
+	}
+	_, err = rm.Lookup(key3)
+	checkCorrupted("ReaderAt Lookup", err, 3)
+	reader, err := NewMapReader(len(damaged), bytes.NewReader(damaged))
+	if err != nil {
+		t.Fatalf("NewMapReader: %v", err)
+	}
+	rec := make([]byte, 8+9)
+	for err == nil {
+		_, err = reader.Read(rec)
+	}
+	checkCorrupted("MapReader.Read", err, 3)
+
+	// A bit flip in prefixes, checksums of pages and parameters.
+	for _, pos := range []int{npages*pageLen + 1, npages*pageLen + npages*3 + 1, len(data) - tailLen + 1} {
+		damaged := append([]byte(nil), data...)
+		damaged[pos] ^= 0x01
+		_, err := OpenMap(damaged)
+		if pos >= len(data)-tailLen {
+			// Damaged parameters usually do not match the length.
+			if err == nil {
+				t.Errorf("OpenMap with damaged parameters succeeded")
+			}
+			continue
+		}
+		checkCorrupted("OpenMap", err, -1)
+		_, err = OpenMapReaderAt(bytes.NewReader(damaged), int64(len(damaged)), 4)
+		checkCorrupted("OpenMapReaderAt", err, -1)
+	}
+
+	// Files of version 1 have no checksums.
+	v1 := append([]byte(nil), data[:npages*(pageLen+3)]...)
+	v1 = append(v1, data[len(data)-tailLen:len(data)-tailLen+paramsLen]...)
+	m, err = OpenMap(v1)
+	if err != nil {
+		t.Fatalf("OpenMap(v1): %v", err)
+	}
+	if err := m.Verify(); err != nil {
+		t.Errorf("Verify(v1): %v", err)
+	}
+	for i, key := range keys {
+		value, err := m.Lookup(key)
+		if err != nil || !bytes.Equal(value, values[i]) {
+			t.Fatalf("Lookup(v1, %x) returned %x, %v; want %x", key, value, err, values[i])
+		}
+	}
+	// Order of keys is checked without checksums.
+	binary.BigEndian.PutUint64(v1[3*pageLen+8:], 0)
+	m, err = OpenMap(v1)
+	if err != nil {
+		t.Fatalf("OpenMap(v1): %v", err)
+	}
+	checkCorrupted("Verify(v1)", m.Verify(), 3)
+
+	// Unknown versions are rejected.
+	future := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(future[len(future)-8:], formatVersion+1)
+	if _, err := OpenMap(future); err == nil {
+		t.Errorf("OpenMap with unknown version succeeded")
+	}
+}
@@ -0,0 +1,172 @@
+package fastmap
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Iterator walks records of Map in the order of keys. Before the first
+// record and after the last record it is not Valid; Next and Prev move
+// it back to the records. If a page can not be read, the iterator stops
+// being Valid and Err returns the error.
+type Iterator struct {
+	m     *Map
+	page  int    // -1 before the first record, npages after the last one.
+	data  []byte // The page.
+	n     int    // Number of records in the page.
+	index int
+	err   error
+}
+
+// numRecords returns the number of records in the page. Empty slots in
+// the end of a page have keys of all FF, which are greater than keys.
+func (m *Map) numRecords(page []byte) int {
+	return sort.Search(m.perPage, func(i int) bool {
+		start := i * m.keyLen
+		return bytes.Equal(page[start:start+m.keyLen], m.ffff)
+	})
+}
+
+func (it *Iterator) setPage(ipage int) {
+	it.page = ipage
+	it.data = nil
+	it.n = 0
+	if ipage >= 0 && ipage < it.m.npages {
+		data, err := it.m.Page(ipage)
+		if err != nil {
+			it.err = err
+			return
+		}
+		it.data = data
+		it.n = it.m.numRecords(it.data)
+	}
+}
+
+// paddedKey returns the key padded to keyLen with the byte.
+func (m *Map) paddedKey(key []byte, pad byte) ([]byte, error) {
+	if len(key) > m.keyLen {
+		return nil, fmt.Errorf("key is longer than keys of the map")
+	}
+	padded := make([]byte, m.keyLen)
+	copy(padded, key)
+	for i := len(key); i < m.keyLen; i++ {
+		padded[i] = pad
+	}
+	return padded, nil
+}
+
+// Seek returns an iterator at the first record with the key not less
+// than key. A short key is padded with zeros, so Seek(nil) returns
+// an iterator at the first record.
+func (m *Map) Seek(key []byte) (*Iterator, error) {
+	key, err := m.paddedKey(key, 0x00)
+	if err != nil {
+		return nil, err
+	}
+	it := &Iterator{m: m}
+	ipage := m.findPage(key)
+	if ipage == -1 {
+		// All the keys are greater than the key.
+		it.setPage(0)
+	} else {
+		it.setPage(ipage)
+		if it.err != nil {
+			return nil, it.err
+		}
+		it.index = sort.Search(it.n, func(i int) bool {
+			start := i * m.keyLen
+			return bytes.Compare(it.data[start:start+m.keyLen], key) >= 0
+		})
+		if it.index == it.n {
+			it.setPage(ipage + 1)
+			it.index = 0
+		}
+	}
+	if it.err != nil {
+		return nil, it.err
+	}
+	return it, nil
+}
+
+// SeekReverse returns an iterator at the last record with the key not
+// greater than key. A short key is padded with bytes FF, so
+// SeekReverse(nil) returns an iterator at the last record.
+func (m *Map) SeekReverse(key []byte) (*Iterator, error) {
+	key, err := m.paddedKey(key, 0xFF)
+	if err != nil {
+		return nil, err
+	}
+	it, err := m.Seek(key)
+	if err != nil {
+		return nil, err
+	}
+	if !it.Valid() || !bytes.Equal(it.Key(), key) {
+		it.Prev()
+	}
+	if it.err != nil {
+		return nil, it.err
+	}
+	return it, nil
+}
+
+// Valid returns if the iterator is at a record.
+func (it *Iterator) Valid() bool {
+	return it.err == nil && it.index >= 0 && it.index < it.n
+}
+
+// Err returns the error of reading a page.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Key returns the key of the record. It must not be modified.
+func (it *Iterator) Key() []byte {
+	start := it.index * it.m.keyLen
+	return it.data[start : start+it.m.keyLen]
+}
+
+// Value returns the value of the record. It must not be modified.
+func (it *Iterator) Value() []byte {
+	start := it.m.valuesStart + it.index*it.m.valueLen
+	return it.data[start : start+it.m.valueLen]
+}
+
+// Next moves the iterator to the next record.
+func (it *Iterator) Next() {
+	if it.err != nil || it.page == it.m.npages {
+		return
+	}
+	it.index++
+	if it.index >= it.n {
+		it.setPage(it.page + 1)
+		it.index = 0
+	}
+}
+
+// Prev moves the iterator to the previous record.
+func (it *Iterator) Prev() {
+	if it.err != nil || it.page == -1 {
+		return
+	}
+	it.index--
+	if it.index < 0 {
+		it.setPage(it.page - 1)
+		it.index = it.n - 1
+	}
+}
+
+// ScanPrefix calls f for records with keys starting with prefix in the
+// order of keys. If f returns an error, the scan stops and returns it.
+func (m *Map) ScanPrefix(prefix []byte, f func(key, value []byte) error) error {
+	it, err := m.Seek(prefix)
+	if err != nil {
+		return err
+	}
+	for ; it.Valid() && bytes.HasPrefix(it.Key(), prefix); it.Next() {
+		if err := f(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
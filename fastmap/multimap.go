@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 )
 
@@ -95,6 +96,7 @@ type MultiMapWriter struct {
 	lenBuf           []byte
 	offset           uint64
 	offsetEnd        uint64
+	valuesCRC        uint32
 
 	inliner Inliner
 
@@ -160,6 +162,8 @@ func (u *MultiMapWriter) dump() error {
 	} else if n != len(u.batch) {
 		return io.ErrShortWrite
 	}
+	u.valuesCRC = crc32.Update(u.valuesCRC, castagnoli, u.lenBuf[:l])
+	u.valuesCRC = crc32.Update(u.valuesCRC, castagnoli, u.batch)
 	u.offset += uint64(l + len(u.batch))
 	if u.offset > u.offsetEnd {
 		return ErrLowOffsetLen
@@ -198,6 +202,8 @@ func (u *MultiMapWriter) Close() error {
 	if err := u.dump(); err != nil {
 		return err
 	}
+	u.fm.valuesLen = u.offset
+	u.fm.valuesCRC = u.valuesCRC
 	if err := u.fm.Close(); err != nil {
 		return err
 	}
@@ -215,6 +221,10 @@ type MultiMap struct {
 	values   []byte
 	valueLen int
 
+	// If valuesReader is set, values are read from it instead of values.
+	valuesReader io.ReaderAt
+	valuesSize   int64
+
 	uninliner Uninliner
 }
 
@@ -231,11 +241,43 @@ func OpenMultiMap(valueLen int, data, values []byte, uninliner Uninliner) (*Mult
 	}, nil
 }
 
+// OpenMultiMapReaderAt is like OpenMultiMap, but it reads the map and
+// the values from io.ReaderAt when needed, see OpenMapReaderAt.
+func OpenMultiMapReaderAt(valueLen int, data io.ReaderAt, dataSize int64, values io.ReaderAt, valuesSize int64, cachePages int, uninliner Uninliner) (*MultiMap, error) {
+	fm, err := OpenMapReaderAt(data, dataSize, cachePages)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiMap{
+		fm:           fm,
+		valueLen:     valueLen,
+		valuesReader: values,
+		valuesSize:   valuesSize,
+		uninliner:    uninliner,
+	}, nil
+}
+
 func (u *MultiMap) Lookup(key []byte) ([]byte, error) {
 	container, err := u.fm.Lookup(key)
 	if err != nil || container == nil {
 		return nil, err
 	}
+	return u.containerValues(container)
+}
+
+// ScanPrefix calls f for keys starting with prefix and their values in
+// the order of keys. If f returns an error, the scan stops and returns it.
+func (u *MultiMap) ScanPrefix(prefix []byte, f func(key, values []byte) error) error {
+	return u.fm.ScanPrefix(prefix, func(key, container []byte) error {
+		values, err := u.containerValues(container)
+		if err != nil {
+			return err
+		}
+		return f(key, values)
+	})
+}
+
+func (u *MultiMap) containerValues(container []byte) ([]byte, error) {
 	// Check if it is inlined.
 	isInlined, uninlined, err := u.uninliner.Uninline(container)
 	if err != nil {
@@ -244,18 +286,320 @@ func (u *MultiMap) Lookup(key []byte) ([]byte, error) {
 		return uninlined, nil
 	}
 	// No-inline case.
+	_, values, err := u.valuesAt(uninlined)
+	return values, err
+}
+
+// valuesAt returns the record of values file starting at the offset
+// (varint length and values) and the values themselves.
+func (u *MultiMap) valuesAt(offset []byte) (record, values []byte, err error) {
 	var fullOffset [8]byte
 	fullOffsetBytes := fullOffset[:]
-	copy(fullOffsetBytes, uninlined)
+	copy(fullOffsetBytes, offset)
 	lenPos := int(binary.LittleEndian.Uint64(fullOffsetBytes))
+	if u.valuesReader != nil {
+		return u.readValuesAt(int64(lenPos))
+	}
+	if lenPos < 0 || lenPos >= len(u.values) {
+		return nil, nil, valuesError(int64(lenPos), "offset is out of range")
+	}
 	size0, l := binary.Uvarint(u.values[lenPos:])
 	if l <= 0 {
-		return nil, fmt.Errorf("Error in database: bad varint at lenPos")
+		return nil, nil, valuesError(int64(lenPos), "bad varint")
+	}
+	if size0 > uint64(len(u.values)) {
+		return nil, nil, valuesError(int64(lenPos), "too many values")
 	}
 	dataStart := lenPos + l
 	dataEnd := dataStart + int(size0)*u.valueLen
 	if dataEnd > len(u.values) {
-		return nil, fmt.Errorf("Error in database: too large size")
+		return nil, nil, valuesError(int64(lenPos), "too many values")
+	}
+	return u.values[lenPos:dataEnd], u.values[dataStart:dataEnd], nil
+}
+
+// valuesError returns *CorruptedError about the record of values file
+// at the offset.
+func valuesError(offset int64, reason string) error {
+	return &CorruptedError{Page: -1, Reason: fmt.Sprintf("values at %d: %s", offset, reason)}
+}
+
+func (u *MultiMap) readValuesAt(lenPos int64) (record, values []byte, err error) {
+	if lenPos < 0 || lenPos >= u.valuesSize {
+		return nil, nil, valuesError(lenPos, "offset is out of range")
+	}
+	head := make([]byte, binary.MaxVarintLen64)
+	if rest := u.valuesSize - lenPos; rest < int64(len(head)) {
+		head = head[:rest]
+	}
+	if err := readFullAt(u.valuesReader, head, lenPos); err != nil {
+		return nil, nil, fmt.Errorf("failed to read values: %v", err)
+	}
+	size0, l := binary.Uvarint(head)
+	if l <= 0 {
+		return nil, nil, valuesError(lenPos, "bad varint")
+	}
+	if size0 > uint64(u.valuesSize) {
+		return nil, nil, valuesError(lenPos, "too many values")
+	}
+	dataEnd := lenPos + int64(l) + int64(size0)*int64(u.valueLen)
+	if dataEnd > u.valuesSize {
+		return nil, nil, valuesError(lenPos, "too many values")
+	}
+	record = make([]byte, dataEnd-lenPos)
+	if err := readFullAt(u.valuesReader, record, lenPos); err != nil {
+		return nil, nil, fmt.Errorf("failed to read values: %v", err)
+	}
+	return record, record[l:], nil
+}
+
+// Verify checks the underlying map (see Map.Verify), the checksum of
+// values file (if the map has it) and that records of values file are in
+// range and follow each other in the order of keys without gaps, as
+// MultiMapWriter writes them. It returns *CorruptedError if the map is
+// corrupted.
+func (u *MultiMap) Verify() error {
+	if err := u.fm.Verify(); err != nil {
+		return err
+	}
+	if err := u.verifyValuesCRC(); err != nil {
+		return err
+	}
+	p := u.LeafParams()
+	valuesStart := p.perPage() * p.KeyLen
+	var next int64
+	for i := 0; i < u.fm.npages; i++ {
+		page, err := u.fm.Page(i)
+		if err != nil {
+			return err
+		}
+		for j := 0; j < p.perPage(); j++ {
+			key := page[j*p.KeyLen : (j+1)*p.KeyLen]
+			if bytes.Equal(key, u.fm.ffff) {
+				break
+			}
+			start := valuesStart + j*p.ContainerLen
+			container := page[start : start+p.ContainerLen]
+			isInlined, uninlined, err := u.uninliner.Uninline(container)
+			if err != nil {
+				return &CorruptedError{Page: i, Reason: fmt.Sprintf("key %x: uninliner: %v", key, err)}
+			} else if isInlined {
+				continue
+			}
+			var fullOffset [8]byte
+			copy(fullOffset[:], uninlined)
+			if offset := int64(binary.LittleEndian.Uint64(fullOffset[:])); offset != next {
+				return &CorruptedError{Page: i, Reason: fmt.Sprintf("values of key %x start at %d, want %d", key, offset, next)}
+			}
+			record, _, err := u.valuesAt(uninlined)
+			if c, ok := err.(*CorruptedError); ok {
+				return &CorruptedError{Page: i, Reason: fmt.Sprintf("key %x: %s", key, c.Reason)}
+			} else if err != nil {
+				return err
+			}
+			next += int64(len(record))
+		}
+	}
+	if size := u.valuesLen(); next != size {
+		return &CorruptedError{Page: -1, Reason: fmt.Sprintf("values file has %d bytes, want %d", size, next)}
+	}
+	return nil
+}
+
+// verifyValuesCRC checks the length and the checksum of values file
+// stored in the map.
+func (u *MultiMap) verifyValuesCRC() error {
+	if !u.fm.hasValuesInfo {
+		return nil
+	}
+	size := u.valuesLen()
+	if size != u.fm.valuesLen {
+		return &CorruptedError{Page: -1, Reason: fmt.Sprintf("values file has %d bytes, want %d", size, u.fm.valuesLen)}
+	}
+	var crc uint32
+	if u.valuesReader != nil {
+		h := crc32.New(castagnoli)
+		if _, err := io.Copy(h, io.NewSectionReader(u.valuesReader, 0, size)); err != nil {
+			return fmt.Errorf("failed to read values: %v", err)
+		}
+		crc = h.Sum32()
+	} else {
+		crc = crc32.Checksum(u.values, castagnoli)
+	}
+	if crc != u.fm.valuesCRC {
+		return &CorruptedError{Page: -1, Reason: "checksum mismatch of values"}
+	}
+	return nil
+}
+
+func (u *MultiMap) valuesLen() int64 {
+	if u.valuesReader != nil {
+		return u.valuesSize
+	}
+	return int64(len(u.values))
+}
+
+// LeafParams describes the layout of page leaves of a MultiMap.
+type LeafParams struct {
+	PageLen, KeyLen, ContainerLen, ValueLen int
+}
+
+func (p LeafParams) perPage() int {
+	return p.PageLen / (p.KeyLen + p.ContainerLen)
+}
+
+// LeafParams returns parameters needed to parse page leaves of the map.
+func (u *MultiMap) LeafParams() LeafParams {
+	return LeafParams{
+		PageLen:      u.fm.pageLen,
+		KeyLen:       u.fm.keyLen,
+		ContainerLen: u.fm.valueLen,
+		ValueLen:     u.valueLen,
+	}
+}
+
+// NumPages returns the number of pages in the underlying map.
+func (u *MultiMap) NumPages() int {
+	return u.fm.NumPages()
+}
+
+// LeafPage returns the index of the page leaf which proves presence or
+// absence of the key: the last page with the first key not greater than
+// the key or the first page if there is no such page. It returns -1 if
+// the map is empty. If the page can not be read, it returns the page
+// and PageLeaf reports the error.
+func (u *MultiMap) LeafPage(key []byte) int {
+	if u.fm.npages == 0 {
+		return -1
+	}
+	ipage := u.fm.findPage(key)
+	if ipage == -1 {
+		return 0
+	}
+	// The key may have the prefix of the page and be less than its
+	// first key. Then it belongs to the previous page.
+	page, err := u.fm.Page(ipage)
+	if err != nil {
+		return ipage
+	}
+	firstKey := page[:u.fm.keyLen]
+	if ipage > 0 && bytes.Compare(key, firstKey) < 0 {
+		ipage--
+	}
+	return ipage
+}
+
+// PageLeaf returns i-th page with all the data needed to look up keys in
+// it without the rest of the map: the page itself, the first key of the
+// next page (all bytes FF for the last page) and the records of values
+// file (varint length and values) of non-inlined keys of the page in the
+// order of keys.
+func (u *MultiMap) PageLeaf(i int) ([]byte, error) {
+	page, err := u.fm.Page(i)
+	if err != nil {
+		return nil, err
+	}
+	leaf := make([]byte, 0, len(page)+u.fm.keyLen)
+	leaf = append(leaf, page...)
+	if i == u.fm.npages-1 {
+		leaf = append(leaf, ffffKey(u.fm.keyLen)...)
+	} else {
+		next, err := u.fm.Page(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		leaf = append(leaf, next[:u.fm.keyLen]...)
+	}
+	p := u.LeafParams()
+	valuesStart := p.perPage() * p.KeyLen
+	for j := 0; j < p.perPage(); j++ {
+		key := page[j*p.KeyLen : (j+1)*p.KeyLen]
+		if bytes.Equal(key, ffffKey(p.KeyLen)) {
+			break
+		}
+		start := valuesStart + j*p.ContainerLen
+		container := page[start : start+p.ContainerLen]
+		isInlined, uninlined, err := u.uninliner.Uninline(container)
+		if err != nil {
+			return nil, fmt.Errorf("uninliner: %v", err)
+		} else if isInlined {
+			continue
+		}
+		record, _, err := u.valuesAt(uninlined)
+		if err != nil {
+			return nil, err
+		}
+		leaf = append(leaf, record...)
+	}
+	return leaf, nil
+}
+
+func ffffKey(keyLen int) []byte {
+	key := make([]byte, keyLen)
+	for i := range key {
+		key[i] = 0xFF
+	}
+	return key
+}
+
+var (
+	ErrWrongLeaf = fmt.Errorf("the key does not belong to the page leaf")
+)
+
+// LookupLeaf finds the key in a page leaf produced by MultiMap.PageLeaf.
+// If first is true, the leaf is the first page of the map, so it is
+// responsible for keys less than its first key as well. It returns nil
+// if the key is absent and ErrWrongLeaf if the key can not be in the leaf.
+func LookupLeaf(leaf, key []byte, first bool, p LeafParams, uninliner Uninliner) ([]byte, error) {
+	if len(key) != p.KeyLen {
+		return nil, fmt.Errorf("Bad keyLen")
+	}
+	if len(leaf) < p.PageLen+p.KeyLen {
+		return nil, fmt.Errorf("leaf is too short")
+	}
+	page := leaf[:p.PageLen]
+	nextKey := leaf[p.PageLen : p.PageLen+p.KeyLen]
+	records := leaf[p.PageLen+p.KeyLen:]
+	if !first && bytes.Compare(key, page[:p.KeyLen]) < 0 {
+		return nil, ErrWrongLeaf
+	}
+	if bytes.Compare(key, nextKey) >= 0 {
+		return nil, ErrWrongLeaf
+	}
+	ffff := ffffKey(p.KeyLen)
+	valuesStart := p.perPage() * p.KeyLen
+	for j := 0; j < p.perPage(); j++ {
+		candidate := page[j*p.KeyLen : (j+1)*p.KeyLen]
+		c := bytes.Compare(candidate, key)
+		if c > 0 || bytes.Equal(candidate, ffff) {
+			// Not found.
+			return nil, nil
+		}
+		start := valuesStart + j*p.ContainerLen
+		container := page[start : start+p.ContainerLen]
+		isInlined, uninlined, err := uninliner.Uninline(container)
+		if err != nil {
+			return nil, fmt.Errorf("uninliner: %v", err)
+		}
+		var values []byte
+		if isInlined {
+			values = uninlined
+		} else {
+			size0, l := binary.Uvarint(records)
+			if l <= 0 {
+				return nil, fmt.Errorf("bad varint in leaf")
+			}
+			end := l + int(size0)*p.ValueLen
+			if end > len(records) {
+				return nil, fmt.Errorf("too large size in leaf")
+			}
+			values = records[l:end]
+			records = records[end:]
+		}
+		if c == 0 {
+			return values, nil
+		}
 	}
-	return u.values[dataStart:dataEnd], nil
+	// Not found.
+	return nil, nil
 }
@@ -2,9 +2,11 @@ package fastmap
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"math/rand"
 	"sort"
 	"testing"
@@ -148,6 +150,62 @@ next:
 				}
 			}
 		}
+		// Check prefix scan.
+		prefix := pairs[len(pairs)/2].key[:2]
+		nscanned := 0
+		if err := m.ScanPrefix(prefix, func(key, batch []byte) error {
+			if !bytes.HasPrefix(key, prefix) {
+				return fmt.Errorf("key %x does not have prefix %x", key, prefix)
+			}
+			want, err := m.Lookup(key)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(batch, want) {
+				return fmt.Errorf("values of key %x differ from Lookup", key)
+			}
+			nscanned++
+			return nil
+		}); err != nil {
+			t.Errorf("%s.ScanPrefix(%x): %v", name, prefix, err)
+		}
+		nwant := 0
+		for _, p := range pairs {
+			if bytes.HasPrefix(p.key, prefix) {
+				nwant++
+			}
+		}
+		if nscanned != nwant {
+			t.Errorf("%s.ScanPrefix(%x) returned %d keys, want %d", name, prefix, nscanned, nwant)
+		}
+		// Check the map read through io.ReaderAt.
+		var uninliner Uninliner = NoUninliner{}
+		if c.withInliner {
+			uninliner = NewFFOOInliner(c.valueLen)
+		}
+		rm, err := OpenMultiMapReaderAt(c.valueLen, bytes.NewReader(data.Bytes()), int64(data.Len()), bytes.NewReader(values.Bytes()), int64(values.Len()), 16, uninliner)
+		if err != nil {
+			t.Errorf("OpenMultiMapReaderAt%s: %v", name, err)
+			continue next
+		}
+		for _, p := range pairs {
+			key := p.key[:c.keyLen]
+			batch, err := rm.Lookup(key)
+			if err != nil {
+				t.Errorf("%s: ReaderAt Lookup(%x): %v", name, key, err)
+				continue
+			}
+			want, _ := m.Lookup(key)
+			if !bytes.Equal(batch, want) {
+				t.Errorf("%s: ReaderAt Lookup(%x) returned %x, want %x", name, key, batch, want)
+			}
+		}
+		if err := m.Verify(); err != nil {
+			t.Errorf("%s.Verify(): %v", name, err)
+		}
+		if err := rm.Verify(); err != nil {
+			t.Errorf("%s: ReaderAt Verify(): %v", name, err)
+		}
 	}
 }
 
@@ -205,3 +263,195 @@ func TestMultumapEmpty(t *testing.T) {
 		t.Errorf("expected to get 'not found', got %v", value)
 	}
 }
+
+func TestMultiMapLeaves(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	keyLen := 16
+	valueLen := 4
+	var keys [][]byte
+	for i := 0; i < 10000; i++ {
+		key := make([]byte, keyLen)
+		r.Read(key)
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) == -1
+	})
+	for _, withInliner := range []bool{false, true} {
+		var data, values bytes.Buffer
+		var w *MultiMapWriter
+		var err error
+		var uninliner Uninliner = NoUninliner{}
+		if withInliner {
+			w, err = NewMultiMapWriter(1024, keyLen, valueLen, 3, valueLen, 2*valueLen, &data, &values, NewFFOOInliner(valueLen))
+			uninliner = NewFFOOInliner(valueLen)
+		} else {
+			w, err = NewMultiMapWriter(1024, keyLen, valueLen, 3, valueLen, valueLen, &data, &values, NoInliner{})
+		}
+		if err != nil {
+			t.Fatalf("NewMultiMapWriter: %v", err)
+		}
+		record := make([]byte, keyLen+valueLen)
+		for i, key := range keys {
+			copy(record, key)
+			for j := 0; j < 1+i%4; j++ {
+				binary.BigEndian.PutUint32(record[keyLen:], uint32(i*4+j+1))
+				if _, err := w.Write(record); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		m, err := OpenMultiMap(valueLen, data.Bytes(), values.Bytes(), uninliner)
+		if err != nil {
+			t.Fatalf("OpenMultiMap: %v", err)
+		}
+		p := m.LeafParams()
+		check := func(key []byte) {
+			want, err := m.Lookup(key)
+			if err != nil {
+				t.Fatalf("Lookup(%x): %v", key, err)
+			}
+			ipage := m.LeafPage(key)
+			leaf, err := m.PageLeaf(ipage)
+			if err != nil {
+				t.Fatalf("PageLeaf(%d): %v", ipage, err)
+			}
+			got, err := LookupLeaf(leaf, key, ipage == 0, p, uninliner)
+			if err != nil {
+				t.Errorf("LookupLeaf(%x): %v", key, err)
+			} else if !bytes.Equal(got, want) {
+				t.Errorf("LookupLeaf(%x) = %x, want %x", key, got, want)
+			}
+			if ipage+1 < m.NumPages() {
+				next, err := m.PageLeaf(ipage + 1)
+				if err != nil {
+					t.Fatalf("PageLeaf(%d): %v", ipage+1, err)
+				}
+				if _, err := LookupLeaf(next, key, false, p, uninliner); err != ErrWrongLeaf {
+					t.Errorf("LookupLeaf(%x) in the next page returned %v, want ErrWrongLeaf", key, err)
+				}
+			}
+		}
+		for _, key := range keys {
+			check(key)
+			// Absent keys near the present one.
+			key2 := append([]byte{}, key...)
+			key2[keyLen-1] ^= 0x01
+			check(key2)
+			key2[3] ^= 0x01
+			check(key2)
+		}
+		check(make([]byte, keyLen))
+	}
+}
+
+func TestMultiMapVerify(t *testing.T) {
+	var data, values bytes.Buffer
+	w, err := NewMultiMapWriter(256, 8, 4, 3, 4, 4, &data, &values, NoInliner{})
+	if err != nil {
+		t.Fatalf("NewMultiMapWriter: %v", err)
+	}
+	r := rand.New(rand.NewSource(0))
+	var keys [][]byte
+	for i := 0; i < 1000; i++ {
+		key := make([]byte, 8)
+		r.Read(key)
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) == -1
+	})
+	for i, key := range keys {
+		for j := 0; j <= i%3; j++ {
+			record := append(append([]byte(nil), key...), 0, 0, 0, byte(j+1))
+			if _, err := w.Write(record); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	m, err := OpenMultiMap(4, data.Bytes(), values.Bytes(), NoUninliner{})
+	if err != nil {
+		t.Fatalf("OpenMultiMap: %v", err)
+	}
+	if err := m.Verify(); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	// Damaged length of values, truncated or extended values file.
+	damaged := append([]byte(nil), values.Bytes()...)
+	damaged[0]++
+	for _, v := range [][]byte{damaged, values.Bytes()[:values.Len()-1], append(values.Bytes(), 1)} {
+		m, err := OpenMultiMap(4, data.Bytes(), v, NoUninliner{})
+		if err != nil {
+			t.Fatalf("OpenMultiMap: %v", err)
+		}
+		var corrupted *CorruptedError
+		if err := m.Verify(); !errors.As(err, &corrupted) {
+			t.Errorf("Verify of damaged values returned %v, want *CorruptedError", err)
+		}
+	}
+
+	// A bit flip in a value is found by the checksum.
+	flipped := append([]byte(nil), values.Bytes()...)
+	flipped[len(flipped)-1] ^= 0x10
+	m, err = OpenMultiMap(4, data.Bytes(), flipped, NoUninliner{})
+	if err != nil {
+		t.Fatalf("OpenMultiMap: %v", err)
+	}
+	var corrupted *CorruptedError
+	if err := m.Verify(); !errors.As(err, &corrupted) || corrupted.Reason != "checksum mismatch of values" {
+		t.Errorf("Verify of a flipped value returned %v, want checksum mismatch", err)
+	}
+	rm, err := OpenMultiMapReaderAt(4, bytes.NewReader(data.Bytes()), int64(data.Len()), bytes.NewReader(flipped), int64(len(flipped)), 4, NoUninliner{})
+	if err != nil {
+		t.Fatalf("OpenMultiMapReaderAt: %v", err)
+	}
+	if err := rm.Verify(); !errors.As(err, &corrupted) {
+		t.Errorf("Verify of a flipped value with ReaderAt returned %v, want *CorruptedError", err)
+	}
+
+	// Lookups of values out of the file return *CorruptedError.
+	last := keys[len(keys)-1]
+	truncated := values.Bytes()[:values.Len()-4]
+	m, err = OpenMultiMap(4, data.Bytes(), truncated, NoUninliner{})
+	if err != nil {
+		t.Fatalf("OpenMultiMap: %v", err)
+	}
+	if _, err := m.Lookup(last); !errors.As(err, &corrupted) {
+		t.Errorf("Lookup in truncated values returned %v, want *CorruptedError", err)
+	}
+	rm, err = OpenMultiMapReaderAt(4, bytes.NewReader(data.Bytes()), int64(data.Len()), bytes.NewReader(truncated), int64(len(truncated)), 4, NoUninliner{})
+	if err != nil {
+		t.Fatalf("OpenMultiMapReaderAt: %v", err)
+	}
+	if _, err := rm.Lookup(last); !errors.As(err, &corrupted) {
+		t.Errorf("Lookup in truncated values with ReaderAt returned %v, want *CorruptedError", err)
+	}
+
+	// Maps of version 2 have no checksum of values.
+	d := data.Bytes()
+	infoStart := len(d) - tailLen - valuesInfoLen
+	v2 := append(append([]byte(nil), d[:infoStart]...), d[len(d)-tailLen:]...)
+	binary.LittleEndian.PutUint32(v2[len(v2)-8:], 2)
+	dataLen := m.NumPages() * 256
+	binary.LittleEndian.PutUint32(v2[len(v2)-12:], crc32.Checksum(v2[dataLen:len(v2)-12], castagnoli))
+	m, err = OpenMultiMap(4, v2, flipped, NoUninliner{})
+	if err != nil {
+		t.Fatalf("OpenMultiMap(v2): %v", err)
+	}
+	if err := m.Verify(); err != nil {
+		t.Errorf("Verify(v2): %v", err)
+	}
+	m, err = OpenMultiMap(4, v2, values.Bytes(), NoUninliner{})
+	if err != nil {
+		t.Fatalf("OpenMultiMap(v2): %v", err)
+	}
+	if got, err := m.Lookup(last); err != nil || len(got) == 0 {
+		t.Errorf("Lookup(v2) returned %x, %v", got, err)
+	}
+}
@@ -0,0 +1,94 @@
+package fastmap
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// readFullAt reads len(buf) bytes at the offset. Unlike ReadAt it does
+// not return io.EOF if the data ends exactly with buf.
+func readFullAt(r io.ReaderAt, buf []byte, offset int64) error {
+	n, err := r.ReadAt(buf, offset)
+	if n == len(buf) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// pageCache reads pages from io.ReaderAt and keeps up to size recently
+// used pages in memory. Pages are checked by check before caching. It is
+// safe for concurrent use. Returned pages are not reused, so they stay
+// valid after eviction.
+type pageCache struct {
+	r       io.ReaderAt
+	pageLen int
+	size    int
+	check   func(i int, page []byte) error
+
+	mu    sync.Mutex
+	lru   *list.List // Of *cachedPage, recently used first.
+	pages map[int]*list.Element
+}
+
+type cachedPage struct {
+	index int
+	data  []byte
+}
+
+func newPageCache(r io.ReaderAt, pageLen, size int, check func(i int, page []byte) error) *pageCache {
+	if size < 1 {
+		size = 1
+	}
+	return &pageCache{
+		r:       r,
+		pageLen: pageLen,
+		size:    size,
+		check:   check,
+		lru:     list.New(),
+		pages:   make(map[int]*list.Element),
+	}
+}
+
+func (c *pageCache) lookup(i int) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, has := c.pages[i]
+	if !has {
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cachedPage).data
+}
+
+func (c *pageCache) get(i int) ([]byte, error) {
+	if data := c.lookup(i); data != nil {
+		return data, nil
+	}
+	// Read without the lock, so slow reads do not block cached pages.
+	data := make([]byte, c.pageLen)
+	if err := readFullAt(c.r, data, int64(i)*int64(c.pageLen)); err != nil {
+		return nil, fmt.Errorf("failed to read page %d: %v", i, err)
+	}
+	if err := c.check(i, data); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, has := c.pages[i]; has {
+		// Read concurrently by another goroutine.
+		c.lru.MoveToFront(e)
+		return e.Value.(*cachedPage).data, nil
+	}
+	c.pages[i] = c.lru.PushFront(&cachedPage{index: i, data: data})
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.pages, oldest.Value.(*cachedPage).index)
+	}
+	return data, nil
+}
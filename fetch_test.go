@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/starius/sialite/netlib"
+	"github.com/starius/sialite/netlib/fakepeer"
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+var cachedBlocks []types.Block
+
+func readTestBlocks(t *testing.T) []types.Block {
+	if cachedBlocks == nil {
+		blocks, err := fakepeer.ReadBlocks(filepath.Join("cache", "testdata", "first_1000.blocks.gz"))
+		if err != nil {
+			t.Fatalf("fakepeer.ReadBlocks: %v", err)
+		}
+		cachedBlocks = blocks
+	}
+	return cachedBlocks
+}
+
+func openTestDatabase(t *testing.T) *Database {
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "sialite.db"), 100, 100)
+	if err != nil {
+		t.Fatalf("OpenDatabase: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+// fetchFrom runs fetchBlocks with a fake peer serving the blocks.
+func fetchFrom(t *testing.T, db *Database, blocks []types.Block) error {
+	p := fakepeer.New(blocks)
+	if err := p.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer p.Close()
+	pm := netlib.NewPeerManager([]modules.NetAddress{p.Addr()}, 1, netlib.NewIdentity("127.0.0.1:9981"))
+	pm.Discover = false
+	pm.MaintainInterval = 100 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	go pm.Run(ctx)
+	return db.fetchBlocks(ctx, pm)
+}
+
+func checkLastBlock(t *testing.T, db *Database, want types.BlockID) {
+	t.Helper()
+	if id, has := db.lastBlockID(); !has || id != want {
+		t.Fatalf("the last block is %s, want %s", id, want)
+	}
+}
+
+func TestFetchBlocks(t *testing.T) {
+	blocks := readTestBlocks(t)
+	db := openTestDatabase(t)
+	if err := db.addBlocks([]*types.Block{&types.GenesisBlock}); err != nil {
+		t.Fatalf("addBlocks(genesis): %v", err)
+	}
+	// Catch up with a peer and then with a peer having more blocks.
+	for _, n := range []int{500, len(blocks)} {
+		if err := fetchFrom(t, db, blocks[:n]); err != nil {
+			t.Fatalf("fetchBlocks from %d blocks: %v", n, err)
+		}
+		checkLastBlock(t, db, blocks[n-1].ID())
+	}
+	// A longer fork of blocks without proof of work is rejected as
+	// a whole and the main chain stays intact.
+	if err := fetchFrom(t, db, fakepeer.Fork(blocks, 900, 200)); err == nil {
+		t.Errorf("fetchBlocks accepted blocks without proof of work")
+	}
+	checkLastBlock(t, db, blocks[len(blocks)-1].ID())
+	if !db.knownBlock(blocks[950].ID()) {
+		t.Errorf("block 950 was disconnected")
+	}
+}
+
+func TestWantRelayed(t *testing.T) {
+	blocks := readTestBlocks(t)
+	db := openTestDatabase(t)
+	addTestBlocks(t, db, blocks[:900])
+	if db.wantRelayed(blocks[899].Header()) {
+		t.Errorf("a known block is wanted")
+	}
+	if !db.wantRelayed(blocks[900].Header()) {
+		t.Errorf("the next block is not wanted")
+	}
+	unsolved := blocks[900].Header()
+	for unsolved.Nonce[0] = 0; ; unsolved.Nonce[0]++ {
+		id := unsolved.ID()
+		if id[0] != 0 {
+			break
+		}
+	}
+	if db.wantRelayed(unsolved) {
+		t.Errorf("a header not meeting the target is wanted")
+	}
+}
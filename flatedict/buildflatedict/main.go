@@ -21,7 +21,7 @@ var (
 
 func main() {
 	flag.Parse()
-	s, err := cache.NewServer(*input)
+	s, err := cache.NewServer(*input, cache.ServerOptions{})
 	if err != nil {
 		log.Fatalf("cache.NewBuilder: %v", err)
 	}
@@ -32,14 +32,14 @@ func (db *Database) handleBlock(w http.ResponseWriter, r *http.Request, ps httpr
 		return
 	}
 	id := types.BlockID(idhash)
-	block, has := db.id2block[id]
+	height, has := db.blockHeight(id)
 	if !has {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "no block with id %q.\n", idhex)
 		return
 	}
 	enc := json.NewEncoder(w)
-	enc.Encode(db.wrapBlock(block))
+	enc.Encode(db.wrapBlock(height))
 }
 
 func (db *Database) handleBlocki(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -49,14 +49,13 @@ func (db *Database) handleBlocki(w http.ResponseWriter, r *http.Request, ps http
 		fmt.Fprintf(w, "strconv.Atoi: %v.\n", err)
 		return
 	}
-	if index < 0 || index > len(db.height2block) {
+	if index < 0 || index >= db.numBlocks() {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "No block with height %d.\n", index)
 		return
 	}
-	block := db.height2block[index]
 	enc := json.NewEncoder(w)
-	enc.Encode(db.wrapBlock(block))
+	enc.Encode(db.wrapBlock(index))
 }
 
 func (db *Database) handleTx(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -68,14 +67,20 @@ func (db *Database) handleTx(w http.ResponseWriter, r *http.Request, ps httprout
 		return
 	}
 	id := types.TransactionID(idhash)
-	tx, has := db.id2tx[id]
-	if !has {
+	enc := json.NewEncoder(w)
+	if loc, has := db.txLocation(id); has {
+		enc.Encode(db.wrapTx(loc))
+	} else if tx, has := db.mempool.tx(id); has {
+		enc.Encode(db.wrapUnconfirmedTx(tx))
+	} else {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "no transaction with id %q.\n", idhex)
-		return
 	}
+}
+
+func (db *Database) handleMempool(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	enc := json.NewEncoder(w)
-	enc.Encode(db.wrapTx(tx))
+	enc.Encode(db.mempoolTxs())
 }
 
 func (db *Database) handleContract(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -87,7 +92,7 @@ func (db *Database) handleContract(w http.ResponseWriter, r *http.Request, ps ht
 		return
 	}
 	id := types.FileContractID(idhash)
-	history, has := db.id2history[id]
+	history, has := db.contract(id)
 	if !has {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "no contract with id %q.\n", idhex)
@@ -117,6 +122,28 @@ func (db *Database) handleAddress(w http.ResponseWriter, r *http.Request, ps htt
 	enc.Encode(history)
 }
 
+func (db *Database) handleBalance(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	idhex := ps.ByName("idhex")
+	var id types.UnlockHash
+	if err := id.LoadString(idhex); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "id.LoadString: %v.\n", err)
+		return
+	}
+	balance, err := db.addressBalance(id)
+	if unavailable(err) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "db.addressBalance: %v.\n", err)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "db.addressBalance: %v.\n", err)
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.Encode(balance)
+}
+
 func (db *Database) handleSiacoinOutput(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	idhex := ps.ByName("idhex")
 	var idhash crypto.Hash
@@ -126,7 +153,7 @@ func (db *Database) handleSiacoinOutput(w http.ResponseWriter, r *http.Request,
 		return
 	}
 	id := types.SiacoinOutputID(idhash)
-	sco, has := db.id2sco[id]
+	sco, has := db.sco(id)
 	if !has {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "no Siacoin output with id %q.\n", idhex)
@@ -146,17 +173,41 @@ func (db *Database) handleSiafundOutput(w http.ResponseWriter, r *http.Request,
 		return
 	}
 	id := types.SiafundOutputID(idhash)
-	sco, has := db.id2sfo[id]
+	sfo, has := db.sfo(id)
 	if !has {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "no Siafund output with id %q.\n", idhex)
 		return
 	}
-	data := db.siafundOutput(sco)
+	data := db.siafundOutput(sfo)
 	enc := json.NewEncoder(w)
 	enc.Encode(data)
 }
 
+func (db *Database) handleOrphans(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	enc := json.NewEncoder(w)
+	enc.Encode(db.orphanHeaders())
+}
+
+func (db *Database) handleOrphan(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	idhex := ps.ByName("idhex")
+	var idhash crypto.Hash
+	if err := idhash.LoadString(idhex); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "id.LoadString: %v.\n", err)
+		return
+	}
+	id := types.BlockID(idhash)
+	orphan, has := db.orphan(id)
+	if !has {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "no orphan block with id %q.\n", idhex)
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.Encode(wrapOrphan(orphan))
+}
+
 func (db *Database) handleHash(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	idhex := ps.ByName("idhex")
 	if len(idhex) == 76 {
@@ -169,21 +220,27 @@ func (db *Database) handleHash(w http.ResponseWriter, r *http.Request, ps httpro
 		fmt.Fprintf(w, "id.LoadString: %v.\n", err)
 		return
 	}
-	if _, has := db.id2block[types.BlockID(id)]; has {
+	if _, has := db.blockHeight(types.BlockID(id)); has {
 		db.handleBlock(w, r, ps)
 		return
-	} else if _, has := db.id2tx[types.TransactionID(id)]; has {
+	} else if _, has := db.txLocation(types.TransactionID(id)); has {
 		db.handleTx(w, r, ps)
 		return
-	} else if _, has := db.id2history[types.FileContractID(id)]; has {
+	} else if _, has := db.contract(types.FileContractID(id)); has {
 		db.handleContract(w, r, ps)
 		return
-	} else if _, has := db.id2sco[types.SiacoinOutputID(id)]; has {
+	} else if _, has := db.sco(types.SiacoinOutputID(id)); has {
 		db.handleSiacoinOutput(w, r, ps)
 		return
-	} else if _, has := db.id2sfo[types.SiafundOutputID(id)]; has {
+	} else if _, has := db.sfo(types.SiafundOutputID(id)); has {
 		db.handleSiafundOutput(w, r, ps)
 		return
+	} else if _, has := db.orphan(types.BlockID(id)); has {
+		db.handleOrphan(w, r, ps)
+		return
+	} else if _, has := db.mempool.tx(types.TransactionID(id)); has {
+		db.handleTx(w, r, ps)
+		return
 	} else {
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("Can't recognize the hash.\n"))
@@ -191,14 +248,194 @@ func (db *Database) handleHash(w http.ResponseWriter, r *http.Request, ps httpro
 	}
 }
 
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 100
+)
+
+func (db *Database) handleSearch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	limit := defaultSearchLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "strconv.Atoi: %v.\n", err)
+			return
+		}
+		if limit <= 0 || limit > maxSearchLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "limit must be in range [1, %d].\n", maxSearchLimit)
+			return
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.Encode(db.search(r.URL.Query().Get("q"), limit))
+}
+
+func (db *Database) handleStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	stats, err := db.lastStats()
+	if unavailable(err) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "db.lastStats: %v.\n", err)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "db.lastStats: %v.\n", err)
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.Encode(stats)
+}
+
+func (db *Database) handleCharts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	bucket := r.URL.Query().Get("bucket")
+	if bucket == "" {
+		bucket = "day"
+	}
+	charts, err := db.charts(bucket, r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if unavailable(err) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "db.charts: %v.\n", err)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "db.charts: %v.\n", err)
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.Encode(charts)
+}
+
+const (
+	defaultRichListLimit = 20
+	maxRichListLimit     = 100
+)
+
+func (db *Database) handleRichList(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	coin := r.URL.Query().Get("coin")
+	if coin == "" {
+		coin = coinSiacoin
+	}
+	limit := defaultRichListLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "strconv.Atoi: %v.\n", err)
+			return
+		}
+		if limit <= 0 || limit > maxRichListLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "limit must be in range [1, %d].\n", maxRichListLimit)
+			return
+		}
+	}
+	list, err := db.richListPage(coin, r.URL.Query().Get("startwith"), limit)
+	if unavailable(err) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "db.richListPage: %v.\n", err)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "db.richListPage: %v.\n", err)
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.Encode(list)
+}
+
+func (db *Database) handleHosts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	hosts, err := db.hostsPage(r.URL.Query().Get("startwith"))
+	if unavailable(err) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "db.hostsPage: %v.\n", err)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "db.hostsPage: %v.\n", err)
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.Encode(hosts)
+}
+
+func (db *Database) handleHost(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	pubkey := ps.ByName("pubkey")
+	var spk types.SiaPublicKey
+	spk.LoadString(pubkey)
+	if len(spk.Key) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "bad public key %q.\n", pubkey)
+		return
+	}
+	host, err := db.hostInfo(spk)
+	if unavailable(err) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "db.hostInfo: %v.\n", err)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "db.hostInfo: %v.\n", err)
+		return
+	} else if host == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "no host with public key %q.\n", pubkey)
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.Encode(host)
+}
+
+// unavailable returns if the error means that the data is not kept by
+// the index, e.g. statistics of an index opened with OpenCache.
+func unavailable(err error) bool {
+	return err == ErrNoStats || err == ErrNoBalances || err == ErrNoHosts
+}
+
+type dbHandle func(db *Database, w http.ResponseWriter, r *http.Request, ps httprouter.Params)
+
+// handle runs the handler in a read-only transaction of the database
+// holding db.mu, so blocks are not added meanwhile. If reading the index
+// fails, the status is 404 for unavailable data and 500 otherwise.
+func (db *Database) handle(h dbHandle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		err := db.view(func(db *Database) error {
+			h(db, w, r, ps)
+			return nil
+		})
+		if unavailable(err) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "db.view: %v.\n", err)
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "db.view: %v.\n", err)
+		}
+	}
+}
+
 func (db *Database) addHandlers(router *httprouter.Router) {
-	router.GET("/blocks", db.handleBlocks)
-	router.GET("/block/:idhex", db.handleBlock)
-	router.GET("/blocki/:i", db.handleBlocki)
-	router.GET("/tx/:idhex", db.handleTx)
-	router.GET("/contract/:idhex", db.handleContract)
-	router.GET("/address/:idhex", db.handleAddress)
-	router.GET("/siacoin-output/:idhex", db.handleSiacoinOutput)
-	router.GET("/siafund-output/:idhex", db.handleSiafundOutput)
-	router.GET("/hash/:idhex", db.handleHash)
+	router.GET("/blocks", db.handle((*Database).handleBlocks))
+	router.GET("/block/:idhex", db.handle((*Database).handleBlock))
+	router.GET("/blocki/:i", db.handle((*Database).handleBlocki))
+	router.GET("/tx/:idhex", db.handle((*Database).handleTx))
+	router.GET("/contract/:idhex", db.handle((*Database).handleContract))
+	router.GET("/address/:idhex", db.handle((*Database).handleAddress))
+	router.GET("/address/:idhex/balance", db.handle((*Database).handleBalance))
+	router.GET("/siacoin-output/:idhex", db.handle((*Database).handleSiacoinOutput))
+	router.GET("/siafund-output/:idhex", db.handle((*Database).handleSiafundOutput))
+	router.GET("/orphans", db.handle((*Database).handleOrphans))
+	router.GET("/orphan/:idhex", db.handle((*Database).handleOrphan))
+	router.GET("/mempool", db.handle((*Database).handleMempool))
+	router.GET("/events", db.handleEvents)
+	router.GET("/search", db.handle((*Database).handleSearch))
+	router.GET("/stats", db.handle((*Database).handleStats))
+	router.GET("/charts", db.handle((*Database).handleCharts))
+	router.GET("/richlist", db.handle((*Database).handleRichList))
+	router.GET("/hosts", db.handle((*Database).handleHosts))
+	router.GET("/host/:pubkey", db.handle((*Database).handleHost))
+	router.GET("/hash/:idhex", db.handle((*Database).handleHash))
 }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/starius/sialite/human"
+	"gitlab.com/NebulousLabs/Sia/crypto"
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+const hostsPageSize = 100
+
+var ErrNoHosts = fmt.Errorf("host directory is not available")
+
+type hostRecord struct {
+	PublicKey      types.SiaPublicKey
+	NetAddress     modules.NetAddress   // From the last announcement.
+	NetAddresses   []modules.NetAddress // Distinct, in order of announcements.
+	FirstAnnounced int
+	LastAnnounced  int
+	Announcements  int
+}
+
+// hostKey returns the key of the host in bucketHosts. Only Ed25519 keys
+// can be announced, so all the keys have the same length.
+func hostKey(spk types.SiaPublicKey) ([]byte, bool) {
+	if spk.Algorithm != types.SignatureEd25519 || len(spk.Key) != crypto.PublicKeySize {
+		return nil, false
+	}
+	return append(append([]byte(nil), spk.Algorithm[:]...), spk.Key...), true
+}
+
+func hostContractKey(key []byte, fcid types.FileContractID) []byte {
+	return append(append([]byte(nil), key...), fcid[:]...)
+}
+
+// addHosts adds host announcements with valid signatures found in the
+// block added at the height and links revised contracts to the keys in
+// their UnlockConditions.
+func (db *Database) addHosts(height int, block *types.Block) error {
+	for j := range block.Transactions {
+		tx := &block.Transactions[j]
+		for _, data := range tx.ArbitraryData {
+			if !bytes.HasPrefix(data, modules.PrefixHostAnnouncement[:]) {
+				continue
+			}
+			netAddress, spk, err := modules.DecodeAnnouncement(data)
+			if err != nil {
+				continue
+			}
+			if err := db.announceHost(height, netAddress, spk); err != nil {
+				return err
+			}
+		}
+		for _, rev := range tx.FileContractRevisions {
+			for _, spk := range rev.UnlockConditions.PublicKeys {
+				key, ok := hostKey(spk)
+				if !ok {
+					continue
+				}
+				if err := db.put(bucketHostContracts, hostContractKey(key, rev.ParentID), true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (db *Database) announceHost(height int, netAddress modules.NetAddress, spk types.SiaPublicKey) error {
+	key, ok := hostKey(spk)
+	if !ok {
+		return nil
+	}
+	h, has := db.host(key)
+	if !has {
+		h = &hostRecord{
+			PublicKey:      spk,
+			FirstAnnounced: height,
+		}
+	}
+	h.NetAddress = netAddress
+	known := false
+	for _, a := range h.NetAddresses {
+		if a == netAddress {
+			known = true
+		}
+	}
+	if !known {
+		h.NetAddresses = append(h.NetAddresses, netAddress)
+	}
+	h.LastAnnounced = height
+	h.Announcements++
+	return db.put(bucketHosts, key, *h)
+}
+
+func (db *Database) wrapHost(key []byte, h *hostRecord) human.Host {
+	hh := human.Host{
+		PublicKey:      h.PublicKey.String(),
+		NetAddress:     string(h.NetAddress),
+		FirstAnnounced: h.FirstAnnounced,
+		LastAnnounced:  h.LastAnnounced,
+		Announcements:  h.Announcements,
+		Contracts:      db.hostContracts(key),
+	}
+	for _, a := range h.NetAddresses {
+		hh.NetAddresses = append(hh.NetAddresses, string(a))
+	}
+	return hh
+}
+
+func (db *Database) hostInfo(spk types.SiaPublicKey) (*human.Host, error) {
+	if db.server != nil {
+		return nil, ErrNoHosts
+	}
+	key, ok := hostKey(spk)
+	if !ok {
+		return nil, fmt.Errorf("not an Ed25519 key: %s", spk)
+	}
+	h, has := db.host(key)
+	if !has {
+		return nil, nil
+	}
+	hh := db.wrapHost(key, h)
+	return &hh, nil
+}
+
+func (db *Database) hostsPage(startWith string) (*human.Hosts, error) {
+	if db.server != nil {
+		return nil, ErrNoHosts
+	}
+	start, err := hex.DecodeString(startWith)
+	if err != nil {
+		return nil, fmt.Errorf("hex.DecodeString: %v", err)
+	}
+	hosts := &human.Hosts{
+		Hosts: []human.Host{},
+	}
+	keys := db.hostKeys(start, hostsPageSize+1)
+	for i, key := range keys {
+		if i == hostsPageSize {
+			hosts.Next = hex.EncodeToString(key)
+			break
+		}
+		h, _ := db.host(key)
+		hosts.Hosts = append(hosts.Hosts, db.wrapHost(key, h))
+	}
+	return hosts, nil
+}
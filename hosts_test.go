@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/starius/sialite/human"
+	"github.com/starius/sialite/netlib/fakepeer"
+	"gitlab.com/NebulousLabs/Sia/crypto"
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+func announce(t *testing.T, addr modules.NetAddress, spk types.SiaPublicKey, sk crypto.SecretKey) []byte {
+	announcement, err := modules.CreateAnnouncement(addr, spk, sk)
+	if err != nil {
+		t.Fatalf("modules.CreateAnnouncement: %v", err)
+	}
+	return announcement
+}
+
+func newHostKey() (types.SiaPublicKey, crypto.SecretKey) {
+	sk, pk := crypto.GenerateKeyPair()
+	return types.Ed25519PublicKey(pk), sk
+}
+
+func hostInfo(t *testing.T, db *Database, spk types.SiaPublicKey) (h *human.Host) {
+	t.Helper()
+	err := db.view(func(db *Database) error {
+		var err error
+		h, err = db.hostInfo(spk)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("hostInfo: %v", err)
+	}
+	return
+}
+
+func checkHost(t *testing.T, db *Database, spk types.SiaPublicKey, want *human.Host) {
+	t.Helper()
+	h := hostInfo(t, db, spk)
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("host %s is %+v, want %+v", spk, h, want)
+	}
+}
+
+func TestHosts(t *testing.T) {
+	blocks := readTestBlocks(t)
+	db := openTestDatabase(t)
+	skipWork(db)
+	addTestBlocks(t, db, blocks[:901])
+
+	host, hostSK := newHostKey()
+	forger, _ := newHostKey()
+	renter, renterSK := newHostKey()
+	addr1, addr2 := modules.NetAddress("1.2.3.4:9982"), modules.NetAddress("5.6.7.8:9982")
+	// The announcement of forger is signed by another key.
+	forged := announce(t, addr1, forger, renterSK)
+	chain := fakepeer.Fork(blocks, 900, 10)
+	chain[901].Transactions = []types.Transaction{{
+		FileContracts: []types.FileContract{{WindowStart: 950, WindowEnd: 960}},
+	}}
+	fcid := chain[901].Transactions[0].FileContractID(0)
+	chain[902].Transactions = []types.Transaction{{
+		ArbitraryData: [][]byte{announce(t, addr1, host, hostSK), forged},
+	}}
+	chain[903].Transactions = []types.Transaction{{
+		FileContractRevisions: []types.FileContractRevision{{
+			ParentID: fcid,
+			UnlockConditions: types.UnlockConditions{
+				PublicKeys:         []types.SiaPublicKey{renter, host},
+				SignaturesRequired: 2,
+			},
+			NewRevisionNumber: 1,
+			NewWindowStart:    950,
+			NewWindowEnd:      960,
+		}},
+	}}
+	chain[905].Transactions = []types.Transaction{{
+		ArbitraryData: [][]byte{announce(t, addr2, host, hostSK)},
+	}}
+	chain[907].Transactions = []types.Transaction{{
+		ArbitraryData: [][]byte{announce(t, addr1, host, hostSK)},
+	}}
+	relink(chain, 900)
+	if err := process(db, chain[901:], 100); err != nil {
+		t.Fatalf("processBlocks: %v", err)
+	}
+	checkHost(t, db, host, &human.Host{
+		PublicKey:      host.String(),
+		NetAddress:     string(addr1),
+		NetAddresses:   []string{string(addr1), string(addr2)},
+		FirstAnnounced: 902,
+		LastAnnounced:  907,
+		Announcements:  3,
+		Contracts:      []types.FileContractID{fcid},
+	})
+	checkHost(t, db, forger, nil)
+	// The renter is not announced, its contracts are not served.
+	checkHost(t, db, renter, nil)
+
+	// Reorganizations undo announcements and links to contracts.
+	fork := fakepeer.Fork(chain, 904, 10)
+	if err := process(db, fork[905:], 100); err != nil {
+		t.Fatalf("processBlocks(fork): %v", err)
+	}
+	checkLastBlock(t, db, fork[len(fork)-1].ID())
+	checkHost(t, db, host, &human.Host{
+		PublicKey:      host.String(),
+		NetAddress:     string(addr1),
+		NetAddresses:   []string{string(addr1)},
+		FirstAnnounced: 902,
+		LastAnnounced:  902,
+		Announcements:  1,
+		Contracts:      []types.FileContractID{fcid},
+	})
+	fork = fakepeer.Fork(chain, 901, 20)
+	if err := process(db, fork[902:], 100); err != nil {
+		t.Fatalf("processBlocks(fork): %v", err)
+	}
+	checkHost(t, db, host, nil)
+	err := db.view(func(db *Database) error {
+		key, _ := hostKey(host)
+		if ids := db.hostContracts(key); len(ids) != 0 {
+			t.Errorf("host has contracts %v after the reorganization", ids)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("db.view: %v", err)
+	}
+}
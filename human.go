@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"sort"
 
 	"github.com/starius/sialite/human"
 	"gitlab.com/NebulousLabs/Sia/types"
@@ -15,6 +16,7 @@ type blocksPosition struct {
 
 func (db *Database) headers(startWith string) (*human.BlockHeaders, error) {
 	const maxRecords = 50000
+	numBlocks := uint32(db.numBlocks())
 	var bp blocksPosition
 	if startWith != "" {
 		startWithBytes, err := hex.DecodeString(startWith)
@@ -24,22 +26,17 @@ func (db *Database) headers(startWith string) (*human.BlockHeaders, error) {
 		if err := json.Unmarshal(startWithBytes, &bp); err != nil {
 			return nil, fmt.Errorf("json.Unmarshal: %v", err)
 		}
-		if bp.Start > uint32(len(db.height2block)) {
+		if bp.Start > numBlocks {
 			return nil, fmt.Errorf("Start is too high")
 		}
 	}
 	h := &human.BlockHeaders{
 		Headers: make([]human.BlockHeader, 0, maxRecords),
 	}
-	for i := bp.Start; i < uint32(len(db.height2block)) && i-bp.Start < maxRecords; i++ {
-		block := db.height2block[i]
-		h.Headers = append(h.Headers, human.BlockHeader{
-			ID:        db.block2id[block],
-			Nonce:     block.Nonce,
-			Timestamp: block.Timestamp,
-		})
+	for i := bp.Start; i < numBlocks && i-bp.Start < maxRecords; i++ {
+		h.Headers = append(h.Headers, db.header(int(i)))
 	}
-	if bp.Start+maxRecords < uint32(len(db.height2block)) {
+	if bp.Start+maxRecords < numBlocks {
 		bp.Start = bp.Start + maxRecords
 		nextBytes, err := json.Marshal(bp)
 		if err != nil {
@@ -50,30 +47,57 @@ func (db *Database) headers(startWith string) (*human.BlockHeaders, error) {
 	return h, nil
 }
 
-func (db *Database) source0(block *types.Block, index int) *human.Source {
+func (db *Database) source0(height int, index int) *human.Source {
 	return &human.Source{
-		Block:  db.block2id[block],
-		Blocki: db.block2height[block],
+		Block:  db.blockID(height),
+		Blocki: height,
 		Index:  index,
 	}
 }
 
-func (db *Database) source(tx *types.Transaction, index int) *human.Source {
-	block := db.tx2block[tx]
-	source := db.source0(block, index)
-	txid := tx.ID()
+func (db *Database) source(loc TxLocation, index int) *human.Source {
+	source := db.source0(loc.Block, index)
+	txid := db.tx(loc).ID()
 	source.Tx = &txid
 	return source
 }
 
+// spent returns the source of the input spending the output or nil.
+func (db *Database) spent(outid types.SiacoinOutputID) *human.Source {
+	sci, has := db.sci(outid)
+	if !has {
+		return nil
+	}
+	return db.source(sci.TxLocation, sci.Index)
+}
+
+// proofOutputs wraps a set of proof outputs of the contract. All the
+// revisions share IDs of outputs, so only the created set is spent.
+func (db *Database) proofOutputs(fcid types.FileContractID, proofStatus types.ProofStatus, outputs []types.SiacoinOutput, created bool) []*human.SiacoinOutput {
+	var houtputs []*human.SiacoinOutput
+	for i := range outputs {
+		outid := fcid.StorageProofOutputID(proofStatus, uint64(i))
+		o := &human.SiacoinOutput{
+			SiacoinOutput: &outputs[i],
+			ID:            outid,
+			Created:       &created,
+		}
+		if created {
+			o.Spent = db.spent(outid)
+		}
+		houtputs = append(houtputs, o)
+	}
+	return houtputs
+}
+
 func (db *Database) contractHistory(history *ContractHistory) *human.ContractHistory {
-	contractTx := history.contract.tx
-	contract := &contractTx.FileContracts[history.contract.index]
-	fcid := contractTx.FileContractID(uint64(history.contract.index))
+	contractTx := db.tx(history.Contract.TxLocation)
+	contract := &contractTx.FileContracts[history.Contract.Index]
+	fcid := contractTx.FileContractID(uint64(history.Contract.Index))
 	h := &human.ContractHistory{
 		Contract: human.Contract{
 			ID:             fcid,
-			Source:         db.source(contractTx, history.contract.index),
+			Source:         db.source(history.Contract.TxLocation, history.Contract.Index),
 			FileSize:       contract.FileSize,
 			FileMerkleRoot: contract.FileMerkleRoot,
 			WindowStart:    contract.WindowStart,
@@ -83,36 +107,15 @@ func (db *Database) contractHistory(history *ContractHistory) *human.ContractHis
 			RevisionNumber: contract.RevisionNumber,
 		},
 	}
-	for i := range contract.ValidProofOutputs {
-		sco := &contract.ValidProofOutputs[i]
-		outid := fcid.StorageProofOutputID(types.ProofValid, uint64(i))
-		hsco := &human.SiacoinOutput{
-			SiacoinOutput: sco,
-			ID:            outid,
-		}
-		sci, has := db.id2sci[outid]
-		if has {
-			hsco.Spent = db.source(sci.tx, sci.index)
-		}
-		h.Contract.ValidProofOutputs = append(h.Contract.ValidProofOutputs, hsco)
-	}
-	for i := range contract.MissedProofOutputs {
-		sco := &contract.MissedProofOutputs[i]
-		outid := fcid.StorageProofOutputID(types.ProofMissed, uint64(i))
-		hsco := &human.SiacoinOutput{
-			SiacoinOutput: sco,
-			ID:            outid,
-		}
-		sci, has := db.id2sci[outid]
-		if has {
-			hsco.Spent = db.source(sci.tx, sci.index)
-		}
-		h.Contract.MissedProofOutputs = append(h.Contract.MissedProofOutputs, hsco)
-	}
-	for _, rev := range history.revs {
-		r := &rev.tx.FileContractRevisions[rev.index]
+	status := contractStatus(db, history)
+	h.Status = status
+	last := len(history.Revs) == 0
+	h.Contract.ValidProofOutputs = db.proofOutputs(fcid, types.ProofValid, contract.ValidProofOutputs, last && status == human.ContractSucceeded)
+	h.Contract.MissedProofOutputs = db.proofOutputs(fcid, types.ProofMissed, contract.MissedProofOutputs, last && status == human.ContractFailed)
+	for j, rev := range history.Revs {
+		r := rev.Value(db)
 		hrev := human.Revision{
-			Source:            db.source(rev.tx, rev.index),
+			Source:            db.source(rev.TxLocation, rev.Index),
 			ParentID:          r.ParentID,
 			UnlockConditions:  r.UnlockConditions,
 			NewRevisionNumber: r.NewRevisionNumber,
@@ -122,112 +125,102 @@ func (db *Database) contractHistory(history *ContractHistory) *human.ContractHis
 			NewWindowEnd:      r.NewWindowEnd,
 			NewUnlockHash:     r.NewUnlockHash,
 		}
-		for i := range r.NewValidProofOutputs {
-			sco := &r.NewValidProofOutputs[i]
-			outid := fcid.StorageProofOutputID(types.ProofValid, uint64(i))
-			hsco := &human.SiacoinOutput{
-				SiacoinOutput: sco,
-				ID:            outid,
-			}
-			sci, has := db.id2sci[outid]
-			if has {
-				hsco.Spent = db.source(sci.tx, sci.index)
-			}
-			hrev.NewValidProofOutputs = append(hrev.NewValidProofOutputs, hsco)
-		}
-		for i := range r.NewMissedProofOutputs {
-			sco := &r.NewMissedProofOutputs[i]
-			outid := fcid.StorageProofOutputID(types.ProofMissed, uint64(i))
-			hsco := &human.SiacoinOutput{
-				SiacoinOutput: sco,
-				ID:            outid,
-			}
-			sci, has := db.id2sci[outid]
-			if has {
-				hsco.Spent = db.source(sci.tx, sci.index)
-			}
-			hrev.NewMissedProofOutputs = append(hrev.NewMissedProofOutputs, hsco)
-		}
+		last := j == len(history.Revs)-1
+		hrev.NewValidProofOutputs = db.proofOutputs(fcid, types.ProofValid, r.NewValidProofOutputs, last && status == human.ContractSucceeded)
+		hrev.NewMissedProofOutputs = db.proofOutputs(fcid, types.ProofMissed, r.NewMissedProofOutputs, last && status == human.ContractFailed)
 		h.Revisions = append(h.Revisions, hrev)
 	}
-	if history.proof != nil {
+	if history.Proof != nil {
 		h.Proof = &human.Proof{
-			StorageProof: &history.proof.tx.StorageProofs[history.proof.index],
-			Source:       db.source(history.proof.tx, history.proof.index),
+			StorageProof: history.Proof.Value(db),
+			Source:       db.source(history.Proof.TxLocation, history.Proof.Index),
 		}
 	}
 	return h
 }
 
 func (db *Database) scoSource(sco *SiacoinOutput) *human.Source {
-	source := db.source0(sco.block, sco.index)
-	if sco.tx != nil {
-		txid := sco.tx.ID()
+	source := db.source0(sco.Block, sco.Index)
+	if sco.Tx != -1 {
+		txid := db.tx(sco.TxLocation).ID()
 		source.Tx = &txid
 	}
-	source.Nature = natureStr(sco.nature)
-	if sco.nature == validProofOutput || sco.nature == missedProofOutput || sco.nature == validProofOutputInRevision || sco.nature == missedProofOutputInRevision {
-		source.Index0 = &sco.index0
+	source.Nature = natureStr(sco.Nature)
+	if isProofOutput(sco.Nature) {
+		source.Index0 = &sco.Index0
 	}
 	return source
 }
 
-func (db *Database) wrapTx(tx *types.Transaction) *human.Transaction {
-	block := db.tx2block[tx]
+func (db *Database) wrapTx(loc TxLocation) *human.Transaction {
+	ht := db.wrapTransaction(db.tx(loc), true)
+	ht.Block = db.blockID(loc.Block)
+	ht.Blocki = loc.Block
+	return ht
+}
+
+func (db *Database) wrapUnconfirmedTx(tx *types.Transaction) *human.Transaction {
+	ht := db.wrapTransaction(tx, false)
+	ht.Blocki = -1
+	return ht
+}
+
+// wrapTransaction wraps confirmed or unconfirmed transaction. Outputs
+// spent by an unconfirmed transaction may be unconfirmed too, then the
+// input has no parent and source.
+func (db *Database) wrapTransaction(tx *types.Transaction, confirmed bool) *human.Transaction {
 	ht := &human.Transaction{
 		ID:                    tx.ID(),
-		Block:                 db.block2id[block],
-		Blocki:                db.block2height[block],
+		Confirmed:             confirmed,
 		Size:                  tx.MarshalSiaSize(),
 		MinerFees:             tx.MinerFees,
 		ArbitraryData:         tx.ArbitraryData,
 		TransactionSignatures: tx.TransactionSignatures,
 	}
+	for _, data := range tx.ArbitraryData {
+		ht.DecodedArbitraryData = append(ht.DecodedArbitraryData, human.DecodeData(data))
+	}
 	for i := range tx.SiacoinInputs {
 		sci := &tx.SiacoinInputs[i]
-		sco := db.id2sco[sci.ParentID]
 		hsci := &human.SiacoinInput{
 			SiacoinInput: sci,
-			Parent:       sco.Value(db),
-			Source:       db.scoSource(sco),
+		}
+		if sco, has := db.sco(sci.ParentID); has {
+			hsci.Parent = sco.Value(db)
+			hsci.Source = db.scoSource(sco)
 		}
 		ht.SiacoinInputs = append(ht.SiacoinInputs, hsci)
 	}
 	for i := range tx.SiacoinOutputs {
-		sco := &tx.SiacoinOutputs[i]
 		outid := tx.SiacoinOutputID(uint64(i))
-		hsco := &human.SiacoinOutput{
-			SiacoinOutput: sco,
+		ht.SiacoinOutputs = append(ht.SiacoinOutputs, &human.SiacoinOutput{
+			SiacoinOutput: &tx.SiacoinOutputs[i],
 			ID:            outid,
-		}
-		sci, has := db.id2sci[outid]
-		if has {
-			hsco.Spent = db.source(sci.tx, sci.index)
-		}
-		ht.SiacoinOutputs = append(ht.SiacoinOutputs, hsco)
+			Spent:         db.spent(outid),
+		})
 	}
 	for i := range tx.SiafundInputs {
 		sfi := &tx.SiafundInputs[i]
-		sfo := db.id2sfo[sfi.ParentID]
-		source := db.source(sfo.tx, sfo.index)
 		hsfi := &human.SiafundInput{
 			SiafundInput: sfi,
-			Parent:       sfo.Value(),
-			Source:       source,
+		}
+		if sfo, has := db.sfo(sfi.ParentID); has {
+			hsfi.Parent = sfo.Value(db)
+			hsfi.Source = db.source(sfo.TxLocation, sfo.Index)
 		}
 		ht.SiafundInputs = append(ht.SiafundInputs, hsfi)
+		if !confirmed {
+			// Claim output is created when the transaction is confirmed.
+			continue
+		}
 		// Claim.
 		claimid := sfi.ParentID.SiaClaimOutputID()
-		sco := db.id2sco[claimid]
-		hsco := &human.SiacoinOutput{
+		sco, _ := db.sco(claimid)
+		ht.SiacoinOutputs = append(ht.SiacoinOutputs, &human.SiacoinOutput{
 			SiacoinOutput: sco.Value(db),
 			ID:            claimid,
-		}
-		sci, has := db.id2sci[claimid]
-		if has {
-			hsco.Spent = db.source(sci.tx, sci.index)
-		}
-		ht.SiacoinOutputs = append(ht.SiacoinOutputs, hsco)
+			Spent:         db.spent(claimid),
+		})
 	}
 	for i := range tx.SiafundOutputs {
 		sfo := &tx.SiafundOutputs[i]
@@ -236,104 +229,102 @@ func (db *Database) wrapTx(tx *types.Transaction) *human.Transaction {
 			SiafundOutput: sfo,
 			ID:            outid,
 		}
-		sfi, has := db.id2sfi[outid]
+		sfi, has := db.sfi(outid)
 		if has {
-			hsfo.Spent = db.source(sfi.tx, sfi.index)
+			hsfo.Spent = db.source(sfi.TxLocation, sfi.Index)
 		}
 		ht.SiafundOutputs = append(ht.SiafundOutputs, hsfo)
 	}
 	for i := range tx.FileContracts {
 		fcid := tx.FileContractID(uint64(i))
-		history := db.id2history[fcid]
-		ht.FileContracts = append(ht.FileContracts, &human.FileContract{
-			ID:      fcid,
-			History: db.contractHistory(history),
-		})
+		hfc := &human.FileContract{
+			ID: fcid,
+		}
+		if history, has := db.contract(fcid); has {
+			hfc.History = db.contractHistory(history)
+		}
+		ht.FileContracts = append(ht.FileContracts, hfc)
 	}
 	for i := range tx.FileContractRevisions {
 		rev := &tx.FileContractRevisions[i]
-		history := db.id2history[rev.ParentID]
-		ht.FileContractRevisions = append(ht.FileContractRevisions, &human.FileContractRevision{
-			Index:   i,
-			History: db.contractHistory(history),
-		})
+		hrev := &human.FileContractRevision{
+			Index: i,
+		}
+		if history, has := db.contract(rev.ParentID); has {
+			hrev.History = db.contractHistory(history)
+		}
+		ht.FileContractRevisions = append(ht.FileContractRevisions, hrev)
 	}
 	for i := range tx.StorageProofs {
 		proof := &tx.StorageProofs[i]
-		history := db.id2history[proof.ParentID]
-		ht.StorageProofs = append(ht.StorageProofs, &human.StorageProof{
-			History: db.contractHistory(history),
-		})
+		hproof := &human.StorageProof{}
+		if history, has := db.contract(proof.ParentID); has {
+			hproof.History = db.contractHistory(history)
+		}
+		ht.StorageProofs = append(ht.StorageProofs, hproof)
 	}
 	return ht
 }
 
-func (db *Database) wrapBlock(block *types.Block) *human.Block {
+func (db *Database) wrapBlock(height int) *human.Block {
+	block := db.blockAt(height)
 	hb := &human.Block{
-		Height:   db.block2height[block],
-		ParentID: block.ParentID,
-		BlockHeader: human.BlockHeader{
-			ID:        db.block2id[block],
-			Nonce:     block.Nonce,
-			Timestamp: block.Timestamp,
-		},
+		Height:      height,
+		ParentID:    block.ParentID,
+		BlockHeader: db.header(height),
 	}
 	for i := range block.MinerPayouts {
-		sco := &block.MinerPayouts[i]
 		outid := block.MinerPayoutID(uint64(i))
-		hsco := &human.SiacoinOutput{
-			SiacoinOutput: sco,
+		hb.MinerPayouts = append(hb.MinerPayouts, &human.SiacoinOutput{
+			SiacoinOutput: &block.MinerPayouts[i],
 			ID:            outid,
-		}
-		sci, has := db.id2sci[outid]
-		if has {
-			hsco.Spent = db.source(sci.tx, sci.index)
-		}
-		hb.MinerPayouts = append(hb.MinerPayouts, hsco)
+			Spent:         db.spent(outid),
+		})
 	}
 	for i := range block.Transactions {
-		tx := &block.Transactions[i]
-		hb.Transactions = append(hb.Transactions, db.wrapTx(tx))
+		hb.Transactions = append(hb.Transactions, db.wrapTx(TxLocation{Block: height, Tx: i}))
 	}
 	return hb
 }
 
 func (db *Database) siacoinOutput(sco *SiacoinOutput) *human.SiacoinRecord {
+	outid := sco.ID(db)
 	r := &human.SiacoinRecord{
 		Income: &human.SiacoinOutput{
 			SiacoinOutput: sco.Value(db),
-			ID:            sco.ID(),
+			ID:            outid,
 		},
 		IncomeSource: db.scoSource(sco),
 	}
-	if sco.tx != nil {
-		r.IncomeTx = db.wrapTx(sco.tx)
+	if isProofOutput(sco.Nature) {
+		created := proofOutputCreated(db, sco)
+		r.Income.Created = &created
+	}
+	if sco.Tx != -1 {
+		r.IncomeTx = db.wrapTx(sco.TxLocation)
 	}
-	outid := sco.ID()
-	sci, has := db.id2sci[outid]
+	sci, has := db.sci(outid)
 	if has {
-		r.Income.Spent = db.source(sci.tx, sci.index)
-		r.SpentTx = db.wrapTx(sci.tx)
+		r.Income.Spent = db.source(sci.TxLocation, sci.Index)
+		r.SpentTx = db.wrapTx(sci.TxLocation)
 	}
 	return r
 }
 
 func (db *Database) siafundOutput(sfo *SiafundOutput) *human.SiafundRecord {
+	outid := sfo.ID(db)
 	r := &human.SiafundRecord{
 		Income: &human.SiafundOutput{
-			SiafundOutput: sfo.Value(),
-			ID:            sfo.ID(),
+			SiafundOutput: sfo.Value(db),
+			ID:            outid,
 		},
+		IncomeSource: db.source(sfo.TxLocation, sfo.Index),
+		IncomeTx:     db.wrapTx(sfo.TxLocation),
 	}
-	if sfo.tx != nil {
-		r.IncomeSource = db.source(sfo.tx, sfo.index)
-		r.IncomeTx = db.wrapTx(sfo.tx)
-	}
-	outid := sfo.ID()
-	sfi, has := db.id2sfi[outid]
+	sfi, has := db.sfi(outid)
 	if has {
-		r.Income.Spent = db.source(sfi.tx, sfi.index)
-		r.SpentTx = db.wrapTx(sfi.tx)
+		r.Income.Spent = db.source(sfi.TxLocation, sfi.Index)
+		r.SpentTx = db.wrapTx(sfi.TxLocation)
 	}
 	return r
 }
@@ -343,8 +334,8 @@ type historyPosition struct {
 }
 
 func (db *Database) addressHistory(address types.UnlockHash, startWith string) (*human.AddressHistory, error) {
-	scos := db.address2sco[address]
-	sfos := db.address2sfo[address]
+	scosLen := db.addressScosLen(address)
+	sfosLen := db.addressSfosLen(address)
 	var hi historyPosition
 	if startWith != "" {
 		startWithBytes, err := hex.DecodeString(startWith)
@@ -354,28 +345,28 @@ func (db *Database) addressHistory(address types.UnlockHash, startWith string) (
 		if err := json.Unmarshal(startWithBytes, &hi); err != nil {
 			return nil, fmt.Errorf("json.Unmarshal: %v", err)
 		}
-		if hi.ScoStart > uint32(len(scos)) {
+		if hi.ScoStart > uint32(scosLen) {
 			return nil, fmt.Errorf("ScoStart is too high")
 		}
-		if hi.SfoStart > uint32(len(sfos)) {
+		if hi.SfoStart > uint32(sfosLen) {
 			return nil, fmt.Errorf("SfoStart is too high")
 		}
 	} else {
-		hi.ScoStart = uint32(len(scos))
-		hi.SfoStart = uint32(len(sfos))
+		hi.ScoStart = uint32(scosLen)
+		hi.SfoStart = uint32(sfosLen)
 	}
 	const maxRecords = 20
 	quota := maxRecords
 	h := &human.AddressHistory{
 		UnlockHash:        address,
-		SiacoinHistoryLen: len(scos),
-		SiafundHistoryLen: len(sfos),
+		SiacoinHistoryLen: scosLen,
+		SiafundHistoryLen: sfosLen,
 	}
 	for i := int(hi.SfoStart) - 1; i >= 0; i-- {
 		if quota == 0 {
 			break
 		}
-		h.SiafundHistory = append(h.SiafundHistory, db.siafundOutput(sfos[i]))
+		h.SiafundHistory = append(h.SiafundHistory, db.siafundOutput(db.addressSfo(address, i)))
 		quota--
 		hi.SfoStart--
 	}
@@ -383,7 +374,7 @@ func (db *Database) addressHistory(address types.UnlockHash, startWith string) (
 		if quota == 0 {
 			break
 		}
-		h.SiacoinHistory = append(h.SiacoinHistory, db.siacoinOutput(scos[i]))
+		h.SiacoinHistory = append(h.SiacoinHistory, db.siacoinOutput(db.addressSco(address, i)))
 		quota--
 		hi.ScoStart--
 	}
@@ -394,5 +385,83 @@ func (db *Database) addressHistory(address types.UnlockHash, startWith string) (
 		}
 		h.Next = hex.EncodeToString(nextBytes)
 	}
+	if startWith == "" {
+		for _, tx := range db.mempool.all() {
+			if touchesAddress(tx, address) {
+				h.Unconfirmed = append(h.Unconfirmed, db.wrapUnconfirmedTx(tx))
+			}
+		}
+	}
 	return h, nil
 }
+
+// touchesAddress returns if the transaction pays to the address or spends
+// its outputs.
+func touchesAddress(tx *types.Transaction, address types.UnlockHash) bool {
+	for _, sci := range tx.SiacoinInputs {
+		if sci.UnlockConditions.UnlockHash() == address {
+			return true
+		}
+	}
+	for _, sco := range tx.SiacoinOutputs {
+		if sco.UnlockHash == address {
+			return true
+		}
+	}
+	for _, sfi := range tx.SiafundInputs {
+		if sfi.UnlockConditions.UnlockHash() == address || sfi.ClaimUnlockHash == address {
+			return true
+		}
+	}
+	for _, sfo := range tx.SiafundOutputs {
+		if sfo.UnlockHash == address {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *Database) mempoolTxs() *human.Mempool {
+	m := &human.Mempool{
+		Transactions: []*human.Transaction{},
+	}
+	for _, tx := range db.mempool.all() {
+		m.Transactions = append(m.Transactions, db.wrapUnconfirmedTx(tx))
+	}
+	return m
+}
+
+func orphanHeader(o *Orphan) human.OrphanHeader {
+	return human.OrphanHeader{
+		BlockHeader: human.BlockHeader{
+			ID:        o.Block.ID(),
+			Nonce:     o.Block.Nonce,
+			Timestamp: o.Block.Timestamp,
+		},
+		Height:   o.Height,
+		ParentID: o.Block.ParentID,
+	}
+}
+
+// orphanHeaders returns headers of orphans, the highest first.
+func (db *Database) orphanHeaders() *human.OrphanHeaders {
+	orphans := db.orphans()
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].Height > orphans[j].Height
+	})
+	h := &human.OrphanHeaders{
+		Orphans: make([]human.OrphanHeader, 0, len(orphans)),
+	}
+	for _, o := range orphans {
+		h.Orphans = append(h.Orphans, orphanHeader(o))
+	}
+	return h
+}
+
+func wrapOrphan(o *Orphan) *human.OrphanBlock {
+	return &human.OrphanBlock{
+		OrphanHeader: orphanHeader(o),
+		MinerPayouts: o.Block.MinerPayouts,
+		Transactions: o.Block.Transactions,
+	}
+}
@@ -0,0 +1,120 @@
+package human
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"unicode/utf8"
+
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+const (
+	// Types of decoded ArbitraryData.
+	DataHostAnnouncement = "host_announcement"
+	DataNonSia           = "non_sia"
+)
+
+var (
+	// Prefixes of ArbitraryData known to Sia.
+	PrefixHostAnnouncement = modules.PrefixHostAnnouncement
+	PrefixNonSia           = types.Specifier{'N', 'o', 'n', 'S', 'i', 'a'}
+)
+
+// DataDecoder decodes ArbitraryData starting with the prefix it was
+// registered for. The result is marshaled to JSON.
+type DataDecoder func(data []byte) (interface{}, error)
+
+type dataType struct {
+	name   string
+	decode DataDecoder
+}
+
+var (
+	dataTypes   = make(map[types.Specifier]dataType)
+	dataTypesMu sync.RWMutex
+)
+
+// RegisterDataDecoder makes DecodeData decode ArbitraryData starting with
+// the prefix with the decoder. The name becomes Type of DecodedData.
+// It replaces the decoder previously registered for the prefix.
+func RegisterDataDecoder(prefix types.Specifier, name string, decode DataDecoder) {
+	dataTypesMu.Lock()
+	defer dataTypesMu.Unlock()
+	dataTypes[prefix] = dataType{name: name, decode: decode}
+}
+
+// DecodedData is ArbitraryData with a known prefix. If it is malformed,
+// Error is set instead of Value.
+type DecodedData struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// DecodeData decodes ArbitraryData with a registered prefix. It returns
+// nil if the prefix is unknown.
+func DecodeData(data []byte) *DecodedData {
+	var prefix types.Specifier
+	if len(data) < len(prefix) {
+		return nil
+	}
+	copy(prefix[:], data)
+	dataTypesMu.RLock()
+	t, has := dataTypes[prefix]
+	dataTypesMu.RUnlock()
+	if !has {
+		return nil
+	}
+	d := &DecodedData{Type: t.name}
+	value, err := t.decode(data)
+	if err != nil {
+		d.Error = err.Error()
+	} else {
+		d.Value = value
+	}
+	return d
+}
+
+// HostAnnouncement is ArbitraryData announcing a host. Announcements
+// with invalid signatures are ignored by Sia and reported as errors.
+type HostAnnouncement struct {
+	NetAddress string `json:"netaddress"`
+	PublicKey  string `json:"publickey"`
+}
+
+// DecodeHostAnnouncement decodes a host announcement and checks its
+// signature with modules.DecodeAnnouncement.
+func DecodeHostAnnouncement(data []byte) (interface{}, error) {
+	netAddress, spk, err := modules.DecodeAnnouncement(data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding host announcement: %v", err)
+	}
+	return &HostAnnouncement{
+		NetAddress: string(netAddress),
+		PublicKey:  spk.String(),
+	}, nil
+}
+
+// DataPayload is ArbitraryData following a prefix: as text if it is
+// valid UTF-8 and as hex otherwise.
+type DataPayload struct {
+	Text string `json:"text,omitempty"`
+	Hex  string `json:"hex,omitempty"`
+}
+
+// DecodePayload is DataDecoder returning DataPayload. It can be
+// registered for custom prefixes.
+func DecodePayload(data []byte) (interface{}, error) {
+	payload := data[types.SpecifierLen:]
+	if utf8.Valid(payload) {
+		return &DataPayload{Text: string(payload)}, nil
+	}
+	return &DataPayload{Hex: hex.EncodeToString(payload)}, nil
+}
+
+func init() {
+	RegisterDataDecoder(PrefixHostAnnouncement, DataHostAnnouncement, DecodeHostAnnouncement)
+	RegisterDataDecoder(PrefixNonSia, DataNonSia, DecodePayload)
+}
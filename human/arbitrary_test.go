@@ -0,0 +1,92 @@
+package human
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/NebulousLabs/Sia/crypto"
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+func TestDecodeHostAnnouncement(t *testing.T) {
+	sk, pk := crypto.GenerateKeyPair()
+	spk := types.Ed25519PublicKey(pk)
+	announcement, err := modules.CreateAnnouncement("1.2.3.4:9982", spk, sk)
+	if err != nil {
+		t.Fatalf("modules.CreateAnnouncement: %v", err)
+	}
+	d := DecodeData(announcement)
+	want := &DecodedData{
+		Type: DataHostAnnouncement,
+		Value: &HostAnnouncement{
+			NetAddress: "1.2.3.4:9982",
+			PublicKey:  spk.String(),
+		},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("DecodeData(announcement) = %+v, want %+v", d, want)
+	}
+
+	// A broken signature is reported as an error.
+	forged := append([]byte(nil), announcement...)
+	forged[len(forged)-1] ^= 1
+	d = DecodeData(forged)
+	if d == nil || d.Type != DataHostAnnouncement || d.Value != nil || d.Error == "" {
+		t.Errorf("DecodeData(forged announcement) = %+v, want an error", d)
+	}
+}
+
+func TestDecodeNonSia(t *testing.T) {
+	for _, tc := range []struct {
+		payload string
+		want    *DataPayload
+	}{
+		{"hello", &DataPayload{Text: "hello"}},
+		{"", &DataPayload{}},
+		{"\xff\x00", &DataPayload{Hex: "ff00"}},
+	} {
+		d := DecodeData(append(PrefixNonSia[:], tc.payload...))
+		want := &DecodedData{Type: DataNonSia, Value: tc.want}
+		if !reflect.DeepEqual(d, want) {
+			t.Errorf("DecodeData(NonSia %q) = %+v, want %+v", tc.payload, d, want)
+		}
+	}
+}
+
+func TestDecodeUnknown(t *testing.T) {
+	unknown := types.Specifier{'U', 'n', 'k', 'n', 'o', 'w', 'n'}
+	for _, data := range [][]byte{
+		nil,
+		[]byte("NonSia"), // Shorter than a specifier.
+		append(unknown[:], "payload"...),
+	} {
+		if d := DecodeData(data); d != nil {
+			t.Errorf("DecodeData(%q) = %+v, want nil", data, d)
+		}
+	}
+}
+
+func TestRegisterDataDecoder(t *testing.T) {
+	prefix := types.Specifier{'T', 'e', 's', 't'}
+	defer func() {
+		dataTypesMu.Lock()
+		delete(dataTypes, prefix)
+		dataTypesMu.Unlock()
+	}()
+	data := append(prefix[:], "payload"...)
+	RegisterDataDecoder(prefix, "first", DecodePayload)
+	if d := DecodeData(data); d == nil || d.Type != "first" || !reflect.DeepEqual(d.Value, &DataPayload{Text: "payload"}) {
+		t.Errorf("DecodeData = %+v, want payload decoded by the first decoder", d)
+	}
+	// Registering the prefix again replaces the decoder.
+	RegisterDataDecoder(prefix, "second", func(data []byte) (interface{}, error) {
+		return nil, fmt.Errorf("bad data")
+	})
+	d := DecodeData(data)
+	if d == nil || d.Type != "second" || d.Value != nil || !strings.Contains(d.Error, "bad data") {
+		t.Errorf("DecodeData = %+v, want the error of the second decoder", d)
+	}
+}
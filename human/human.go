@@ -35,6 +35,10 @@ type SiacoinOutput struct {
 	*types.SiacoinOutput
 	ID    types.SiacoinOutputID `json:"id"`
 	Spent *Source               `json:"spent"`
+
+	// Set for storage proof outputs: if the output was created, i.e. it
+	// belongs to the last revision and to the set chosen by the outcome.
+	Created *bool `json:"created,omitempty"`
 }
 
 type SiafundInput struct {
@@ -84,10 +88,19 @@ type Proof struct {
 	Source *Source `json:"source"`
 }
 
+const (
+	// Status of a contract.
+	ContractActive      = "active"       // Before WindowStart.
+	ContractProofWindow = "proof_window" // No storage proof yet, from WindowStart until WindowEnd.
+	ContractSucceeded   = "succeeded"    // Valid proof outputs were created.
+	ContractFailed      = "failed"       // Missed proof outputs were created.
+)
+
 type ContractHistory struct {
 	Contract  Contract   `json:"contract"`
 	Revisions []Revision `json:"revisions"`
 	Proof     *Proof     `json:"proof"`
+	Status    string     `json:"status"`
 }
 
 type FileContract struct {
@@ -104,8 +117,11 @@ type StorageProof struct {
 	History *ContractHistory `json:"history"`
 }
 
+// Transaction is either confirmed or in the mempool. Block and Blocki
+// of an unconfirmed transaction are zero ID and -1.
 type Transaction struct {
 	ID                    types.TransactionID          `json:"id"`
+	Confirmed             bool                         `json:"confirmed"`
 	Block                 types.BlockID                `json:"block"`
 	Blocki                int                          `json:"blocki"`
 	Size                  int                          `json:"size"`
@@ -118,6 +134,7 @@ type Transaction struct {
 	SiafundOutputs        []*SiafundOutput             `json:"siafundoutputs"`
 	MinerFees             []types.Currency             `json:"minerfees"`
 	ArbitraryData         [][]byte                     `json:"arbitrarydata"`
+	DecodedArbitraryData  []*DecodedData               `json:"decodedarbitrarydata"` // Nil for unknown prefixes.
 	TransactionSignatures []types.TransactionSignature `json:"transactionsignatures"`
 }
 
@@ -150,4 +167,176 @@ type AddressHistory struct {
 	Next              string           `json:"next"`
 	SiacoinHistoryLen int              `json:"siacoin_history_len"`
 	SiafundHistoryLen int              `json:"siafund_history_len"`
+
+	// Unconfirmed transactions touching the address. Only on first page.
+	Unconfirmed []*Transaction `json:"unconfirmed"`
+}
+
+// Mempool lists unconfirmed transactions in the order of arrival.
+type Mempool struct {
+	Transactions []*Transaction `json:"transactions"`
+}
+
+// OrphanHeader describes a block disconnected from the main chain by
+// a reorganization. Height is the height the block had in the chain.
+type OrphanHeader struct {
+	BlockHeader
+	Height   int           `json:"height"`
+	ParentID types.BlockID `json:"parentid"`
+}
+
+type OrphanHeaders struct {
+	Orphans []OrphanHeader `json:"orphans"`
+}
+
+// OrphanBlock is not indexed, so its outputs are not annotated.
+type OrphanBlock struct {
+	OrphanHeader
+	MinerPayouts []types.SiacoinOutput `json:"minerpayouts"`
+	Transactions []types.Transaction   `json:"transactions"`
+}
+
+// Types of Event.
+const (
+	EventBlock           = "block"        // Block connected to the main chain.
+	EventDisconnected    = "disconnected" // Block disconnected by a reorganization.
+	EventSiacoinReceived = "siacoin_received"
+	EventSiacoinSpent    = "siacoin_spent"
+	EventSiafundReceived = "siafund_received"
+	EventSiafundSpent    = "siafund_spent"
+	EventContract        = "contract"
+	EventRevision        = "revision"
+	EventStorageProof    = "storage_proof"
+)
+
+// Event is pushed to subscribers when a block is connected to or
+// disconnected from the main chain. Block events are sent to everyone,
+// other events only to subscribers of Address or Contract. Output is
+// the ID of received or spent output, Value is set for received outputs.
+// Storage proof outputs are received when the contract is resolved; such
+// events have Contract set and no Tx for missed proof outputs.
+type Event struct {
+	Type     string                `json:"type"`
+	Height   int                   `json:"height"`
+	Block    BlockHeader           `json:"block"`
+	Tx       *types.TransactionID  `json:"tx"`
+	Address  *types.UnlockHash     `json:"address"`
+	Contract *types.FileContractID `json:"contract"`
+	Output   *crypto.Hash          `json:"output"`
+	Value    *types.Currency       `json:"value"`
+}
+
+// Kinds of objects found by search, in the order of ranking.
+const (
+	SearchBlock         = "block"
+	SearchTransaction   = "transaction"
+	SearchContract      = "contract"
+	SearchSiacoinOutput = "siacoin_output"
+	SearchSiafundOutput = "siafund_output"
+	SearchAddress       = "address"
+	SearchOrphan        = "orphan"
+)
+
+// SearchMatch is an object found by search. ID is hex ID or address
+// with checksum. Height is set for blocks and orphans.
+type SearchMatch struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Height *int   `json:"height"`
+	Exact  bool   `json:"exact"`
+}
+
+// SearchResults lists exact matches first, then matches ranked by type.
+type SearchResults struct {
+	Query   string        `json:"query"`
+	Matches []SearchMatch `json:"matches"`
+}
+
+// BlockStats are statistics of a block. Fields starting with Total
+// describe the chain up to and including the block. Hashrate is
+// estimated in hashes per second.
+type BlockStats struct {
+	Height               int             `json:"height"`
+	ID                   types.BlockID   `json:"id"`
+	Timestamp            types.Timestamp `json:"timestamp"`
+	Transactions         int             `json:"transactions"`
+	Fees                 types.Currency  `json:"fees"`
+	ActiveAddresses      int             `json:"active_addresses"`
+	NewContracts         int             `json:"new_contracts"`
+	ContractsVolume      uint64          `json:"contracts_volume"` // Sum of FileSize of new contracts.
+	Sfpool               types.Currency  `json:"sfpool"`
+	CoinSupply           types.Currency  `json:"coin_supply"`
+	Difficulty           types.Currency  `json:"difficulty"`
+	Hashrate             types.Currency  `json:"hashrate"`
+	TotalTransactions    int             `json:"total_transactions"`
+	TotalContracts       int             `json:"total_contracts"`
+	TotalContractsVolume uint64          `json:"total_contracts_volume"`
+}
+
+// DayStats are statistics of blocks with timestamps within a UTC day.
+// Sfpool, CoinSupply, Difficulty and Hashrate are from the highest block
+// of the day.
+type DayStats struct {
+	Day             types.Timestamp `json:"day"` // Start of the day.
+	Blocks          int             `json:"blocks"`
+	Transactions    int             `json:"transactions"`
+	Fees            types.Currency  `json:"fees"`
+	ActiveAddresses int             `json:"active_addresses"`
+	NewContracts    int             `json:"new_contracts"`
+	ContractsVolume uint64          `json:"contracts_volume"`
+	Sfpool          types.Currency  `json:"sfpool"`
+	CoinSupply      types.Currency  `json:"coin_supply"`
+	Difficulty      types.Currency  `json:"difficulty"`
+	Hashrate        types.Currency  `json:"hashrate"`
+}
+
+// Charts is a time series of statistics. Bucket is "block" or "day",
+// the corresponding list is set.
+type Charts struct {
+	Bucket string       `json:"bucket"`
+	Blocks []BlockStats `json:"blocks"`
+	Days   []DayStats   `json:"days"`
+}
+
+// AddressBalance is the current balance of an address. Like in Sia, miner
+// payouts, Siafund claims and storage proof outputs are counted when they
+// mature, MaturityDelay blocks after the block creating them. Storage
+// proof outputs are created when the contract is resolved by a storage
+// proof or expires.
+type AddressBalance struct {
+	UnlockHash types.UnlockHash `json:"unlockhash"`
+	Siacoins   types.Currency   `json:"siacoins"`
+	Siafunds   types.Currency   `json:"siafunds"`
+}
+
+type RichListEntry struct {
+	Rank       int              `json:"rank"` // Starting with 1.
+	UnlockHash types.UnlockHash `json:"unlockhash"`
+	Balance    types.Currency   `json:"balance"`
+}
+
+// RichList lists addresses by balance of the coin ("sc" or "sf"),
+// the richest first.
+type RichList struct {
+	Coin    string          `json:"coin"`
+	Entries []RichListEntry `json:"entries"`
+	Next    string          `json:"next"`
+}
+
+// Host is a host which announced itself in the blockchain.
+type Host struct {
+	PublicKey      string   `json:"publickey"`
+	NetAddress     string   `json:"netaddress"`   // From the last announcement.
+	NetAddresses   []string `json:"netaddresses"` // All announced ones.
+	FirstAnnounced int      `json:"firstannounced"`
+	LastAnnounced  int      `json:"lastannounced"`
+	Announcements  int      `json:"announcements"`
+
+	// Contracts revised with the key of the host in UnlockConditions.
+	Contracts []types.FileContractID `json:"contracts"`
+}
+
+type Hosts struct {
+	Hosts []Host `json:"hosts"`
+	Next  string `json:"next"`
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/starius/sialite/human"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+func TestWrapArbitraryData(t *testing.T) {
+	db := openTestDatabase(t)
+	nonSia := append(human.PrefixNonSia[:], "hello"...)
+	unknown := []byte("unknown prefix and payload")
+	tx := &types.Transaction{ArbitraryData: [][]byte{nonSia, unknown}}
+	var ht *human.Transaction
+	err := db.view(func(db *Database) error {
+		ht = db.wrapUnconfirmedTx(tx)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("db.view: %v", err)
+	}
+	// Data with unknown prefixes is only served as raw bytes.
+	if len(ht.ArbitraryData) != 2 || !bytes.Equal(ht.ArbitraryData[0], nonSia) || !bytes.Equal(ht.ArbitraryData[1], unknown) {
+		t.Errorf("ArbitraryData is %q, want %q", ht.ArbitraryData, tx.ArbitraryData)
+	}
+	if len(ht.DecodedArbitraryData) != 2 {
+		t.Fatalf("got %d decoded items, want 2", len(ht.DecodedArbitraryData))
+	}
+	if d := ht.DecodedArbitraryData[0]; d == nil || d.Type != human.DataNonSia {
+		t.Errorf("NonSia data is decoded as %+v", d)
+	}
+	if d := ht.DecodedArbitraryData[1]; d != nil {
+		t.Errorf("data with unknown prefix is decoded as %+v", d)
+	}
+}
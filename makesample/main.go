@@ -1,15 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"math/rand"
-	"net/http"
 	"os"
 	"path/filepath"
 
-	"github.com/starius/sialite/human"
+	"github.com/starius/sialite/explorerclient"
 	"gitlab.com/NebulousLabs/Sia/types"
 )
 
@@ -25,36 +25,34 @@ const (
 var (
 	addr = flag.String("addr", "http://127.0.0.1:8080", "HTTP API address of sialite")
 	out  = flag.String("out", "sample", "Directory to write sample")
+
+	client *explorerclient.Client
+	ctx    = context.Background()
 )
 
+func writeJSON(fname string, v interface{}) {
+	o, err := os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	enc := json.NewEncoder(o)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(v); err != nil {
+		panic(err)
+	}
+	o.Close()
+}
+
 func doList() (ids []types.BlockID) {
 	startWith := ""
 	for {
 		n := len(ids)
-		resp, err := http.Get(*addr + "/blocks?startwith=" + startWith)
+		headers, err := client.BlockHeadersPage(ctx, startWith)
 		if err != nil {
 			panic(err)
 		}
-		if resp.StatusCode != http.StatusOK {
-			panic(resp.StatusCode)
-		}
-		dec := json.NewDecoder(resp.Body)
-		var headers human.BlockHeaders
-		if err := dec.Decode(&headers); err != nil {
-			panic(err)
-		}
-		resp.Body.Close()
 		fname := fmt.Sprintf("blocks%07d.json", n)
-		o, err := os.Create(filepath.Join(*out, fname))
-		if err != nil {
-			panic(err)
-		}
-		enc := json.NewEncoder(o)
-		enc.SetIndent("", "    ")
-		if err := enc.Encode(headers); err != nil {
-			panic(err)
-		}
-		o.Close()
+		writeJSON(filepath.Join(*out, fname), headers)
 		for _, h := range headers.Headers {
 			ids = append(ids, h.ID)
 		}
@@ -68,29 +66,11 @@ func doList() (ids []types.BlockID) {
 
 func doBlocks(ids []types.BlockID) (txs []types.TransactionID, addresses []types.UnlockHash, scos []types.SiacoinOutputID, sfos []types.SiafundOutputID, contracts []types.FileContractID) {
 	for _, blockID := range ids {
-		resp, err := http.Get(*addr + "/block/" + blockID.String())
-		if err != nil {
-			panic(err)
-		}
-		if resp.StatusCode != http.StatusOK {
-			panic(resp.StatusCode)
-		}
-		dec := json.NewDecoder(resp.Body)
-		var block human.Block
-		if err := dec.Decode(&block); err != nil {
-			panic(err)
-		}
-		resp.Body.Close()
-		o, err := os.Create(filepath.Join(*out, "blocks", blockID.String()+".json"))
+		block, err := client.Block(ctx, blockID)
 		if err != nil {
 			panic(err)
 		}
-		enc := json.NewEncoder(o)
-		enc.SetIndent("", "    ")
-		if err := enc.Encode(block); err != nil {
-			panic(err)
-		}
-		o.Close()
+		writeJSON(filepath.Join(*out, "blocks", blockID.String()+".json"), block)
 		// Find transaction ids and addresses.
 		for _, so := range block.MinerPayouts {
 			addresses = append(addresses, so.UnlockHash)
@@ -148,19 +128,10 @@ func findRare(ids []types.BlockID) (scos []types.SiacoinOutputID, sfos []types.S
 		if len(scos) != 0 && len(sfos) != 0 {
 			break
 		}
-		resp, err := http.Get(*addr + "/block/" + blockID.String())
+		block, err := client.Block(ctx, blockID)
 		if err != nil {
 			panic(err)
 		}
-		if resp.StatusCode != http.StatusOK {
-			panic(resp.StatusCode)
-		}
-		dec := json.NewDecoder(resp.Body)
-		var block human.Block
-		if err := dec.Decode(&block); err != nil {
-			panic(err)
-		}
-		resp.Body.Close()
 		for _, tx := range block.Transactions {
 			for _, si := range tx.SiacoinInputs {
 				if si.Source.Nature == "sia_claim_output" {
@@ -180,146 +151,57 @@ func findRare(ids []types.BlockID) (scos []types.SiacoinOutputID, sfos []types.S
 
 func doTxs(ids []types.TransactionID) {
 	for _, txID := range ids {
-		resp, err := http.Get(*addr + "/tx/" + txID.String())
+		tx, err := client.Transaction(ctx, txID)
 		if err != nil {
 			panic(err)
 		}
-		if resp.StatusCode != http.StatusOK {
-			panic(resp.StatusCode)
-		}
-		dec := json.NewDecoder(resp.Body)
-		var tx human.Transaction
-		if err := dec.Decode(&tx); err != nil {
-			panic(err)
-		}
-		resp.Body.Close()
-		o, err := os.Create(filepath.Join(*out, "txs", txID.String()+".json"))
-		if err != nil {
-			panic(err)
-		}
-		enc := json.NewEncoder(o)
-		enc.SetIndent("", "    ")
-		if err := enc.Encode(tx); err != nil {
-			panic(err)
-		}
-		o.Close()
+		writeJSON(filepath.Join(*out, "txs", txID.String()+".json"), tx)
 	}
 }
 
 func doAddresses(addresses []types.UnlockHash) {
 	for _, address := range addresses {
-		resp, err := http.Get(*addr + "/address/" + address.String())
+		history, err := client.AddressHistory(ctx, address)
 		if err != nil {
 			panic(err)
 		}
-		if resp.StatusCode != http.StatusOK {
-			panic(resp.StatusCode)
-		}
-		dec := json.NewDecoder(resp.Body)
-		var history human.AddressHistory
-		if err := dec.Decode(&history); err != nil {
-			panic(err)
-		}
-		resp.Body.Close()
-		o, err := os.Create(filepath.Join(*out, "addresses", address.String()+".json"))
-		if err != nil {
-			panic(err)
-		}
-		enc := json.NewEncoder(o)
-		enc.SetIndent("", "    ")
-		if err := enc.Encode(history); err != nil {
-			panic(err)
-		}
-		o.Close()
+		writeJSON(filepath.Join(*out, "addresses", address.String()+".json"), history)
 	}
 }
 
 func doScos(scos []types.SiacoinOutputID) {
 	for _, id := range scos {
-		resp, err := http.Get(*addr + "/siacoin-output/" + id.String())
+		record, err := client.SiacoinOutput(ctx, id)
 		if err != nil {
 			panic(err)
 		}
-		if resp.StatusCode != http.StatusOK {
-			panic(resp.Status + " " + id.String())
-		}
-		dec := json.NewDecoder(resp.Body)
-		var record human.SiacoinRecord
-		if err := dec.Decode(&record); err != nil {
-			panic(err)
-		}
-		resp.Body.Close()
-		o, err := os.Create(filepath.Join(*out, "siacoin-output", id.String()+".json"))
-		if err != nil {
-			panic(err)
-		}
-		enc := json.NewEncoder(o)
-		enc.SetIndent("", "    ")
-		if err := enc.Encode(record); err != nil {
-			panic(err)
-		}
-		o.Close()
+		writeJSON(filepath.Join(*out, "siacoin-output", id.String()+".json"), record)
 	}
 }
 
 func doSfos(sfos []types.SiafundOutputID) {
 	for _, id := range sfos {
-		resp, err := http.Get(*addr + "/siafund-output/" + id.String())
+		record, err := client.SiafundOutput(ctx, id)
 		if err != nil {
 			panic(err)
 		}
-		if resp.StatusCode != http.StatusOK {
-			panic(resp.StatusCode)
-		}
-		dec := json.NewDecoder(resp.Body)
-		var record human.SiafundRecord
-		if err := dec.Decode(&record); err != nil {
-			panic(err)
-		}
-		resp.Body.Close()
-		o, err := os.Create(filepath.Join(*out, "siafund-output", id.String()+".json"))
-		if err != nil {
-			panic(err)
-		}
-		enc := json.NewEncoder(o)
-		enc.SetIndent("", "    ")
-		if err := enc.Encode(record); err != nil {
-			panic(err)
-		}
-		o.Close()
+		writeJSON(filepath.Join(*out, "siafund-output", id.String()+".json"), record)
 	}
 }
 
 func doContracts(contracts []types.FileContractID) {
 	for _, id := range contracts {
-		resp, err := http.Get(*addr + "/contract/" + id.String())
+		history, err := client.Contract(ctx, id)
 		if err != nil {
 			panic(err)
 		}
-		if resp.StatusCode != http.StatusOK {
-			panic(resp.Status + " " + id.String())
-		}
-		dec := json.NewDecoder(resp.Body)
-		var history human.ContractHistory
-		if err := dec.Decode(&history); err != nil {
-			panic(err)
-		}
-		resp.Body.Close()
-		o, err := os.Create(filepath.Join(*out, "contracts", id.String()+".json"))
-		if err != nil {
-			panic(err)
-		}
-		enc := json.NewEncoder(o)
-		enc.SetIndent("", "    ")
-		if err := enc.Encode(history); err != nil {
-			panic(err)
-		}
-		o.Close()
+		writeJSON(filepath.Join(*out, "contracts", id.String()+".json"), history)
 	}
 }
 
 func main() {
 	flag.Parse()
+	client = explorerclient.NewClient(*addr, nil)
 	os.Mkdir(*out, 0755)
 	os.Mkdir(filepath.Join(*out, "blocks"), 0755)
 	os.Mkdir(filepath.Join(*out, "txs"), 0755)
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/starius/sialite/netlib"
+	"github.com/xtaci/smux"
+	"gitlab.com/NebulousLabs/Sia/crypto"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+var (
+	ErrMempoolFull = fmt.Errorf("mempool is full")
+)
+
+// Mempool holds unconfirmed transactions relayed by the peer. Every
+// transaction spends outputs which exist in the database or are created
+// by earlier transactions of the mempool and are not spent elsewhere.
+type Mempool struct {
+	size    int
+	txs     []*types.Transaction // In the order of arrival.
+	ids     map[types.TransactionID]*types.Transaction
+	spent   map[crypto.Hash]types.TransactionID // Output ID -> spending tx.
+	created map[crypto.Hash]bool                // IDs of outputs of txs.
+
+	mu sync.RWMutex
+}
+
+func NewMempool(size int) *Mempool {
+	m := &Mempool{size: size}
+	m.reset()
+	return m
+}
+
+func (m *Mempool) reset() {
+	m.txs = nil
+	m.ids = make(map[types.TransactionID]*types.Transaction)
+	m.spent = make(map[crypto.Hash]types.TransactionID)
+	m.created = make(map[crypto.Hash]bool)
+}
+
+// check returns an error if the transaction spends an unknown or spent
+// output.
+func (m *Mempool) check(db *Database, tx *types.Transaction) error {
+	for _, sci := range tx.SiacoinInputs {
+		id := crypto.Hash(sci.ParentID)
+		if _, has := m.spent[id]; has {
+			return fmt.Errorf("Siacoin output %s is spent by unconfirmed transaction %s", sci.ParentID, m.spent[id])
+		}
+		if _, has := db.sci(sci.ParentID); has {
+			return fmt.Errorf("Siacoin output %s is spent", sci.ParentID)
+		}
+		if _, has := db.sco(sci.ParentID); !has && !m.created[id] {
+			return fmt.Errorf("unknown Siacoin output %s", sci.ParentID)
+		}
+	}
+	for _, sfi := range tx.SiafundInputs {
+		id := crypto.Hash(sfi.ParentID)
+		if _, has := m.spent[id]; has {
+			return fmt.Errorf("Siafund output %s is spent by unconfirmed transaction %s", sfi.ParentID, m.spent[id])
+		}
+		if _, has := db.sfi(sfi.ParentID); has {
+			return fmt.Errorf("Siafund output %s is spent", sfi.ParentID)
+		}
+		if _, has := db.sfo(sfi.ParentID); !has && !m.created[id] {
+			return fmt.Errorf("unknown Siafund output %s", sfi.ParentID)
+		}
+	}
+	return nil
+}
+
+func (m *Mempool) add(tx *types.Transaction) {
+	txid := tx.ID()
+	m.txs = append(m.txs, tx)
+	m.ids[txid] = tx
+	for _, sci := range tx.SiacoinInputs {
+		m.spent[crypto.Hash(sci.ParentID)] = txid
+	}
+	for _, sfi := range tx.SiafundInputs {
+		m.spent[crypto.Hash(sfi.ParentID)] = txid
+	}
+	for i := range tx.SiacoinOutputs {
+		m.created[crypto.Hash(tx.SiacoinOutputID(uint64(i)))] = true
+	}
+	for i := range tx.SiafundOutputs {
+		m.created[crypto.Hash(tx.SiafundOutputID(uint64(i)))] = true
+	}
+}
+
+// removeLast removes the last n transactions added.
+func (m *Mempool) removeLast(n int) {
+	for _, tx := range m.txs[len(m.txs)-n:] {
+		delete(m.ids, tx.ID())
+		for _, sci := range tx.SiacoinInputs {
+			delete(m.spent, crypto.Hash(sci.ParentID))
+		}
+		for _, sfi := range tx.SiafundInputs {
+			delete(m.spent, crypto.Hash(sfi.ParentID))
+		}
+		for i := range tx.SiacoinOutputs {
+			delete(m.created, crypto.Hash(tx.SiacoinOutputID(uint64(i))))
+		}
+		for i := range tx.SiafundOutputs {
+			delete(m.created, crypto.Hash(tx.SiafundOutputID(uint64(i))))
+		}
+	}
+	m.txs = m.txs[:len(m.txs)-n]
+}
+
+// addSet adds the transaction set if all its transactions are valid.
+// Known and confirmed transactions are skipped.
+func (m *Mempool) addSet(db *Database, set []types.Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	added := 0
+	for i := range set {
+		tx := &set[i]
+		txid := tx.ID()
+		if _, has := m.ids[txid]; has {
+			continue
+		}
+		if _, has := db.txLocation(txid); has {
+			continue
+		}
+		err := m.check(db, tx)
+		if err == nil && len(m.txs) >= m.size {
+			err = ErrMempoolFull
+		}
+		if err != nil {
+			m.removeLast(added)
+			return fmt.Errorf("transaction %s: %v", txid, err)
+		}
+		m.add(tx)
+		added++
+	}
+	return nil
+}
+
+// prune removes transactions which were confirmed or became invalid
+// after the database changed.
+func (m *Mempool) prune(db *Database) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	txs := m.txs
+	m.reset()
+	for _, tx := range txs {
+		if _, has := db.txLocation(tx.ID()); has {
+			continue
+		}
+		if err := m.check(db, tx); err != nil {
+			continue
+		}
+		m.add(tx)
+	}
+}
+
+func (m *Mempool) tx(id types.TransactionID) (*types.Transaction, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tx, has := m.ids[id]
+	return tx, has
+}
+
+func (m *Mempool) all() []*types.Transaction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]*types.Transaction(nil), m.txs...)
+}
+
+// receiveRelayed adds transaction sets relayed by the peer to the
+// mempool and sends headers of new blocks it announces to hchan until
+// the session fails.
+func (db *Database) receiveRelayed(ctx context.Context, sess *smux.Session, hchan chan types.BlockHeader) {
+	tchan := make(chan []types.Transaction, 100)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- netlib.AcceptRPCs(ctx, sess, map[string]netlib.RPCHandler{
+			"RelayTransactionSet": netlib.TransactionSetsHandler(ctx, tchan),
+			"RelayHeader":         netlib.HeadersHandler(ctx, hchan),
+		})
+	}()
+	for {
+		select {
+		case set := <-tchan:
+			db.mu.RLock()
+			err := db.view(func(db *Database) error {
+				return db.mempool.addSet(db, set)
+			})
+			db.mu.RUnlock()
+			if err != nil {
+				log.Printf("rejected transaction set: %v.", err)
+			}
+		case err := <-errChan:
+			log.Printf("netlib.AcceptRPCs: %v.", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/starius/sialite/human"
+	"github.com/starius/sialite/netlib"
+	"github.com/starius/sialite/netlib/fakepeer"
+	"github.com/xtaci/smux"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// spendPayout returns a transaction paying the first miner payout of
+// the block to the address.
+func spendPayout(block *types.Block, address types.UnlockHash) types.Transaction {
+	return spendOutput(types.SiacoinOutputID(block.MinerPayoutID(0)), block.MinerPayouts[0].Value, address)
+}
+
+func spendOutput(id types.SiacoinOutputID, value types.Currency, address types.UnlockHash) types.Transaction {
+	return types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{{ParentID: id}},
+		SiacoinOutputs: []types.SiacoinOutput{{Value: value, UnlockHash: address}},
+	}
+}
+
+func addSet(db *Database, set ...types.Transaction) error {
+	return db.view(func(db *Database) error {
+		return db.mempool.addSet(db, set)
+	})
+}
+
+func checkMempool(t *testing.T, db *Database, want ...types.Transaction) {
+	t.Helper()
+	all := db.mempool.all()
+	if len(all) != len(want) {
+		t.Fatalf("mempool has %d transactions, want %d", len(all), len(want))
+	}
+	for i, tx := range all {
+		if tx.ID() != want[i].ID() {
+			t.Errorf("mempool transaction %d is %s, want %s", i, tx.ID(), want[i].ID())
+		}
+	}
+}
+
+func TestMempool(t *testing.T) {
+	blocks := readTestBlocks(t)[:200]
+	db := openTestDatabase(t)
+	skipWork(db)
+	addTestBlocks(t, db, blocks)
+	db.mempool = NewMempool(3)
+
+	tx1 := spendPayout(&blocks[10], types.UnlockHash{1})
+	// tx2 spends the output of unconfirmed tx1.
+	tx2 := spendOutput(tx1.SiacoinOutputID(0), tx1.SiacoinOutputs[0].Value, types.UnlockHash{2})
+	if err := addSet(db, tx1, tx2); err != nil {
+		t.Fatalf("addSet(tx1, tx2): %v", err)
+	}
+	// Known transactions are skipped.
+	if err := addSet(db, tx1); err != nil {
+		t.Fatalf("addSet(tx1) again: %v", err)
+	}
+	checkMempool(t, db, tx1, tx2)
+
+	tx3 := spendPayout(&blocks[11], types.UnlockHash{3})
+	doubleSpend := spendPayout(&blocks[10], types.UnlockHash{4})
+	unknown := spendOutput(types.SiacoinOutputID{5}, types.NewCurrency64(1), types.UnlockHash{5})
+	for _, set := range [][]types.Transaction{
+		{doubleSpend},
+		{unknown},
+		// The set is added as a whole or not at all.
+		{tx3, doubleSpend},
+	} {
+		if err := addSet(db, set...); err == nil {
+			t.Errorf("addSet accepted invalid set %v", set)
+		}
+	}
+	checkMempool(t, db, tx1, tx2)
+	tx4 := spendPayout(&blocks[12], types.UnlockHash{6})
+	if err := addSet(db, tx3, tx4); err == nil || !strings.Contains(err.Error(), ErrMempoolFull.Error()) {
+		t.Errorf("addSet to full mempool returned %v, want %v", err, ErrMempoolFull)
+	}
+	if err := addSet(db, tx3); err != nil {
+		t.Fatalf("addSet(tx3): %v", err)
+	}
+	checkMempool(t, db, tx1, tx2, tx3)
+
+	// The block confirms tx1 and spends the output spent by tx3.
+	block := types.Block{
+		ParentID:  blocks[len(blocks)-1].ID(),
+		Timestamp: blocks[len(blocks)-1].Timestamp,
+		Transactions: []types.Transaction{
+			tx1,
+			spendPayout(&blocks[11], types.UnlockHash{7}),
+		},
+	}
+	if err := db.addBlocks([]*types.Block{&block}); err != nil {
+		t.Fatalf("addBlocks: %v", err)
+	}
+	checkMempool(t, db, tx2)
+
+	router := httprouter.New()
+	db.addHandlers(router)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	w := get("/mempool")
+	var mempool human.Mempool
+	if err := json.Unmarshal(w.Body.Bytes(), &mempool); err != nil {
+		t.Fatalf("GET /mempool: json.Unmarshal: %v", err)
+	}
+	if len(mempool.Transactions) != 1 || mempool.Transactions[0].ID != tx2.ID() || mempool.Transactions[0].Confirmed {
+		t.Errorf("GET /mempool returned %s, want unconfirmed %s", w.Body, tx2.ID())
+	}
+	for _, tc := range []struct {
+		tx        types.Transaction
+		status    int
+		confirmed bool
+	}{
+		{tx1, http.StatusOK, true},
+		{tx2, http.StatusOK, false},
+		{tx3, http.StatusNotFound, false},
+	} {
+		path := fmt.Sprintf("/tx/%s", tc.tx.ID())
+		w := get(path)
+		if w.Code != tc.status {
+			t.Errorf("GET %s: status %d, want %d: %s", path, w.Code, tc.status, w.Body)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		var tx human.Transaction
+		if err := json.Unmarshal(w.Body.Bytes(), &tx); err != nil {
+			t.Fatalf("GET %s: json.Unmarshal: %v", path, err)
+		}
+		if tx.Confirmed != tc.confirmed {
+			t.Errorf("GET %s: confirmed is %v, want %v", path, tx.Confirmed, tc.confirmed)
+		}
+	}
+}
+
+func TestMempoolRelay(t *testing.T) {
+	blocks := readTestBlocks(t)[:200]
+	db := openTestDatabase(t)
+	addTestBlocks(t, db, blocks)
+	p := fakepeer.New(blocks)
+	if err := p.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer p.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn, err := netlib.ConnectAs(ctx, string(p.Addr()), netlib.NewIdentity("127.0.0.1:9981"))
+	if err != nil {
+		t.Fatalf("ConnectAs: %v", err)
+	}
+	sess, err := smux.Client(conn, nil)
+	if err != nil {
+		t.Fatalf("smux.Client: %v", err)
+	}
+	defer sess.Close()
+	go db.receiveRelayed(ctx, sess, make(chan types.BlockHeader, 1))
+	tx := spendPayout(&blocks[10], types.UnlockHash{1})
+	// The session is registered by the peer after the handshake.
+	for i := 0; i < 50; i++ {
+		p.RelayTransactionSet([]types.Transaction{tx})
+		time.Sleep(100 * time.Millisecond)
+		if _, has := db.mempool.tx(tx.ID()); has {
+			break
+		}
+	}
+	checkMempool(t, db, tx)
+}
@@ -0,0 +1,454 @@
+package netlib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"gitlab.com/NebulousLabs/Sia/encoding"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// Recorded chain is stored in two files. The data file starts with
+// chainMagic followed by a record per block from the genesis block:
+// 8 bytes of the length of the block, 4 bytes of CRC32C of the block
+// and the block encoded by Sia. The index file (data file + ".idx")
+// has an entry per block: 8 bytes of the offset of the record in the
+// data file and 32 bytes of the ID of the block. Integers are little
+// endian. Records are written before index entries, so records without
+// entries and a partial record at the end are what an interrupted
+// writer leaves; they are recovered or removed by OpenChainFile and
+// ignored by ReadChainFile.
+
+var chainMagic = []byte("SiaChn01")
+
+const (
+	recordHeaderLen = 12
+	indexEntryLen   = 40
+	// Number of blocks in a batch of SendBlocks, see MaxCatchUpBlocks.
+	catchUpBlocks = 10
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type chainEntry struct {
+	offset int64
+	id     types.BlockID
+}
+
+// ChainFile is a recorded chain with random access by height.
+type ChainFile struct {
+	data    *os.File
+	index   *os.File
+	entries []chainEntry
+	heights map[types.BlockID]int
+	end     int64 // End of the last record.
+}
+
+// IsChainFile returns if the file starts with the magic of a recorded
+// chain.
+func IsChainFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	magic := make([]byte, len(chainMagic))
+	if _, err := io.ReadFull(f, magic); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return bytes.Equal(magic, chainMagic), nil
+}
+
+// OpenChainFile opens the recorded chain for writing or creates an empty
+// one. Blocks written by an interrupted writer are recovered if they are
+// complete, the rest is removed. Only one process may open the chain
+// with OpenChainFile at a time.
+func OpenChainFile(path string) (*ChainFile, error) {
+	return openChainFile(path, os.O_RDWR|os.O_CREATE)
+}
+
+// ReadChainFile opens the recorded chain for reading. The files are not
+// changed, so the chain can be read while a writer appends to it; blocks
+// without index entries are ignored.
+func ReadChainFile(path string) (*ChainFile, error) {
+	return openChainFile(path, os.O_RDONLY)
+}
+
+func openChainFile(path string, flag int) (*ChainFile, error) {
+	data, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(path+".idx", flag, 0644)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	c := &ChainFile{
+		data:    data,
+		index:   index,
+		heights: make(map[types.BlockID]int),
+	}
+	if flag == os.O_RDONLY {
+		_, _, err = c.load()
+	} else {
+		err = c.recover()
+	}
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// load reads the index, dropping entries of records which are not in the
+// data file. It returns the size of the data file and if the index has
+// to be rewritten.
+func (c *ChainFile) load() (size int64, changed bool, err error) {
+	info, err := c.data.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	size = info.Size()
+	magic := make([]byte, len(chainMagic))
+	if _, err := c.data.ReadAt(magic, 0); err == io.EOF {
+		return 0, false, fmt.Errorf("not a recorded chain")
+	} else if err != nil {
+		return 0, false, err
+	}
+	if !bytes.Equal(magic, chainMagic) {
+		return 0, false, fmt.Errorf("not a recorded chain")
+	}
+	indexBytes, err := ioutil.ReadAll(c.index)
+	if err != nil {
+		return 0, false, err
+	}
+	for i := 0; i+indexEntryLen <= len(indexBytes); i += indexEntryLen {
+		e := chainEntry{offset: int64(binary.LittleEndian.Uint64(indexBytes[i:]))}
+		copy(e.id[:], indexBytes[i+8:i+indexEntryLen])
+		c.addEntry(e)
+	}
+	changed = len(indexBytes)%indexEntryLen != 0
+	c.end = int64(len(chainMagic))
+	for len(c.entries) != 0 {
+		last := c.entries[len(c.entries)-1]
+		if length, err := c.recordLen(last.offset, size); err == nil {
+			c.end = last.offset + recordHeaderLen + length
+			break
+		}
+		c.dropEntries(len(c.entries) - 1)
+		changed = true
+	}
+	return size, changed, nil
+}
+
+func (c *ChainFile) recover() error {
+	info, err := c.data.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < int64(len(chainMagic)) {
+		if _, err := c.data.WriteAt(chainMagic, 0); err != nil {
+			return err
+		}
+	}
+	size, changed, err := c.load()
+	if err != nil {
+		return err
+	}
+	// Index records following the last entry.
+	for {
+		block, next, err := c.readRecord(c.end, size)
+		if err != nil {
+			break
+		}
+		c.addEntry(chainEntry{offset: c.end, id: block.ID()})
+		c.end = next
+		changed = true
+	}
+	if c.end != size {
+		if err := c.data.Truncate(c.end); err != nil {
+			return err
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if err := c.index.Truncate(0); err != nil {
+		return err
+	}
+	for i, e := range c.entries {
+		if err := c.writeEntry(i, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordLen returns the length of the block in the record at the offset
+// if the record fits in size bytes.
+func (c *ChainFile) recordLen(offset, size int64) (int64, error) {
+	if offset+recordHeaderLen > size {
+		return 0, io.ErrUnexpectedEOF
+	}
+	header := make([]byte, recordHeaderLen)
+	if _, err := c.data.ReadAt(header, offset); err != nil {
+		return 0, err
+	}
+	length := int64(binary.LittleEndian.Uint64(header))
+	if length > int64(types.BlockSizeLimit) {
+		return 0, fmt.Errorf("record at %d is too long: %d", offset, length)
+	}
+	if offset+recordHeaderLen+length > size {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return length, nil
+}
+
+// readRecord reads and checks the record at the offset. It returns the
+// block and the offset of the next record.
+func (c *ChainFile) readRecord(offset, size int64) (*types.Block, int64, error) {
+	length, err := c.recordLen(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, recordHeaderLen+length)
+	if _, err := c.data.ReadAt(buf, offset); err != nil {
+		return nil, 0, err
+	}
+	if crc32.Checksum(buf[recordHeaderLen:], castagnoli) != binary.LittleEndian.Uint32(buf[8:]) {
+		return nil, 0, fmt.Errorf("bad checksum of record at %d", offset)
+	}
+	block := new(types.Block)
+	if err := encoding.Unmarshal(buf[recordHeaderLen:], block); err != nil {
+		return nil, 0, fmt.Errorf("decoding record at %d: %v", offset, err)
+	}
+	return block, offset + int64(len(buf)), nil
+}
+
+func (c *ChainFile) addEntry(e chainEntry) {
+	c.heights[e.id] = len(c.entries)
+	c.entries = append(c.entries, e)
+}
+
+// dropEntries forgets the entries starting with the height.
+func (c *ChainFile) dropEntries(height int) {
+	for _, e := range c.entries[height:] {
+		delete(c.heights, e.id)
+	}
+	c.entries = c.entries[:height]
+}
+
+func (c *ChainFile) writeEntry(height int, e chainEntry) error {
+	buf := make([]byte, indexEntryLen)
+	binary.LittleEndian.PutUint64(buf, uint64(e.offset))
+	copy(buf[8:], e.id[:])
+	_, err := c.index.WriteAt(buf, int64(height)*indexEntryLen)
+	return err
+}
+
+// Len returns the number of blocks.
+func (c *ChainFile) Len() int {
+	return len(c.entries)
+}
+
+// ID returns the ID of the block at the height.
+func (c *ChainFile) ID(height int) types.BlockID {
+	return c.entries[height].id
+}
+
+// Height returns the height of the block with the ID.
+func (c *ChainFile) Height(id types.BlockID) (int, bool) {
+	height, has := c.heights[id]
+	return height, has
+}
+
+// BlocksAfter implements BlockSource.
+func (c *ChainFile) BlocksAfter(history [32]types.BlockID, n int) ([]types.Block, bool, error) {
+	start := -1
+	for _, id := range history {
+		if height, has := c.Height(id); has {
+			start = height + 1
+			break
+		}
+	}
+	if start == -1 {
+		return nil, false, nil
+	}
+	end := start + n
+	if end > c.Len() {
+		end = c.Len()
+	}
+	var blocks []types.Block
+	for height := start; height < end; height++ {
+		block, err := c.Block(height)
+		if err != nil {
+			return nil, false, err
+		}
+		blocks = append(blocks, *block)
+	}
+	return blocks, end < c.Len(), nil
+}
+
+// Block reads the block at the height and checks its checksum.
+func (c *ChainFile) Block(height int) (*types.Block, error) {
+	if height < 0 || height >= len(c.entries) {
+		return nil, fmt.Errorf("no block at height %d", height)
+	}
+	e := c.entries[height]
+	block, _, err := c.readRecord(e.offset, c.end)
+	if err != nil {
+		return nil, err
+	}
+	if block.ID() != e.id {
+		return nil, fmt.Errorf("block at height %d has ID %s, the index has %s", height, block.ID(), e.id)
+	}
+	return block, nil
+}
+
+// Append adds the block following the last block. The first block must
+// be the genesis block.
+func (c *ChainFile) Append(block *types.Block) error {
+	id := block.ID()
+	if n := len(c.entries); n == 0 && id != types.GenesisID {
+		return fmt.Errorf("the first block %s is not the genesis block", id)
+	} else if n != 0 && block.ParentID != c.entries[n-1].id {
+		return fmt.Errorf("block %s does not follow the last block %s", id, c.entries[n-1].id)
+	}
+	data := encoding.Marshal(*block)
+	buf := make([]byte, recordHeaderLen, recordHeaderLen+len(data))
+	binary.LittleEndian.PutUint64(buf, uint64(len(data)))
+	binary.LittleEndian.PutUint32(buf[8:], crc32.Checksum(data, castagnoli))
+	buf = append(buf, data...)
+	if _, err := c.data.WriteAt(buf, c.end); err != nil {
+		return err
+	}
+	e := chainEntry{offset: c.end, id: id}
+	if err := c.writeEntry(len(c.entries), e); err != nil {
+		return err
+	}
+	c.addEntry(e)
+	c.end += int64(len(buf))
+	return nil
+}
+
+// Truncate removes the blocks starting with the height, e.g. when they
+// are replaced by a fork.
+func (c *ChainFile) Truncate(height int) error {
+	if height >= len(c.entries) {
+		return nil
+	}
+	end := c.entries[height].offset
+	if err := c.index.Truncate(int64(height) * indexEntryLen); err != nil {
+		return err
+	}
+	if err := c.data.Truncate(end); err != nil {
+		return err
+	}
+	c.dropEntries(height)
+	c.end = end
+	return nil
+}
+
+// History returns IDs of blocks for SendBlocks RPC, like blockHistory
+// of Sia.
+func (c *ChainFile) History() (history [32]types.BlockID) {
+	height := len(c.entries) - 1
+	step := 1
+	for i := 0; i < 31 && height >= 0; i++ {
+		history[i] = c.entries[height].id
+		if i >= 9 {
+			step *= 2
+		}
+		if height <= step {
+			break
+		}
+		height -= step
+	}
+	history[31] = types.GenesisID
+	return
+}
+
+func (c *ChainFile) Close() error {
+	err1 := c.data.Close()
+	err2 := c.index.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// Stream returns a stream answering SendBlocks RPC with the blocks
+// following the latest block of the history found in the file, like
+// a peer. It serves one RPC.
+func (c *ChainFile) Stream() io.ReadWriter {
+	return &chainStream{c: c, next: -1}
+}
+
+type chainStream struct {
+	c       *ChainFile
+	request []byte
+	next    int // Height of the next block to send, -1 before the request.
+	buf     bytes.Buffer
+	done    bool
+}
+
+func (s *chainStream) Write(b []byte) (int, error) {
+	s.request = append(s.request, b...)
+	// RPC name and history, both prefixed with the length.
+	const historyStart = 8 + 8 + 8
+	const requestLen = historyStart + 32*32
+	if s.next == -1 && len(s.request) >= requestLen {
+		var history [32]types.BlockID
+		if err := encoding.Unmarshal(s.request[historyStart:requestLen], &history); err != nil {
+			return 0, err
+		}
+		s.next = 1
+		for _, id := range history {
+			if height, has := s.c.Height(id); has {
+				s.next = height + 1
+				break
+			}
+		}
+	}
+	return len(b), nil
+}
+
+func (s *chainStream) Read(b []byte) (int, error) {
+	if s.next == -1 {
+		return 0, fmt.Errorf("SendBlocks request was not sent")
+	}
+	if s.buf.Len() == 0 && !s.done {
+		end := s.next + catchUpBlocks
+		if end > s.c.Len() {
+			end = s.c.Len()
+		}
+		blocks := []types.Block{}
+		for height := s.next; height < end; height++ {
+			block, err := s.c.Block(height)
+			if err != nil {
+				return 0, err
+			}
+			blocks = append(blocks, *block)
+		}
+		if err := encoding.WriteObject(&s.buf, blocks); err != nil {
+			return 0, err
+		}
+		if err := encoding.WriteObject(&s.buf, end < s.c.Len()); err != nil {
+			return 0, err
+		}
+		s.next = end
+		s.done = end >= s.c.Len()
+	}
+	if s.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return s.buf.Read(b)
+}
@@ -0,0 +1,349 @@
+// Package fakepeer implements an in-process Sia node for tests. It does
+// the handshake of Sia gateway, serves SendBlocks, SendBlk and ShareNodes
+// RPCs over smux from a list of blocks and relays headers and transaction
+// sets to the connected nodes.
+package fakepeer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xtaci/smux"
+	"gitlab.com/NebulousLabs/Sia/encoding"
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/types"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+const handshakeTimeout = 10 * time.Second
+
+type sessionHeader struct {
+	GenesisID  types.BlockID
+	UniqueID   [8]byte
+	NetAddress modules.NetAddress
+}
+
+func rpcID(name string) (id [8]byte) {
+	copy(id[:], name)
+	return
+}
+
+// ReadBlocks reads gzipped blocks encoded by encoding.WriteObject one by
+// one, like cache/testdata/first_1000.blocks.gz.
+func ReadBlocks(path string) ([]types.Block, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("gzip.NewReader: %v", err)
+	}
+	var blocks []types.Block
+	for {
+		var block types.Block
+		err := encoding.ReadObject(gz, &block, types.BlockSizeLimit)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("encoding.ReadObject: %v", err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// Fork returns the chain of blocks up to the height followed by n blocks
+// forking from it. The blocks of the fork are not mined, so they fail
+// the check of proof of work.
+func Fork(blocks []types.Block, height, n int) []types.Block {
+	chain := append([]types.Block(nil), blocks[:height+1]...)
+	for i := 0; i < n; i++ {
+		parent := &chain[len(chain)-1]
+		block := types.Block{
+			ParentID:  parent.ID(),
+			Timestamp: parent.Timestamp + types.Timestamp(types.BlockFrequency),
+			MinerPayouts: []types.SiacoinOutput{{
+				Value: types.CalculateCoinbase(types.BlockHeight(height + 1 + i)),
+			}},
+		}
+		fastrand.Read(block.Nonce[:])
+		chain = append(chain, block)
+	}
+	return chain
+}
+
+// Peer is a fake Sia node. Its fields must not be changed after Listen.
+type Peer struct {
+	// Blocks is the chain starting with the genesis block.
+	Blocks []types.Block
+	// Nodes are returned by ShareNodes RPC.
+	Nodes []modules.NetAddress
+	// BatchSize is the number of blocks in a batch of SendBlocks.
+	BatchSize int
+
+	// If DisconnectAt is not 0, the connection is closed in the middle
+	// of the batch of SendBlocks containing the block at this height.
+	DisconnectAt int
+	// If StallAt is not 0, SendBlocks stops sending data before the batch
+	// containing the block at this height until the connection is closed.
+	StallAt int
+	// If MalformedAt is not 0, garbage is sent instead of the batch of
+	// SendBlocks containing the block at this height.
+	MalformedAt int
+
+	uniqueID [8]byte
+	ids      []types.BlockID
+	ln       net.Listener
+
+	mu        sync.Mutex
+	sessions  []*smux.Session
+	histories [][32]types.BlockID
+}
+
+// New returns a fake peer serving the blocks.
+func New(blocks []types.Block) *Peer {
+	p := &Peer{
+		Blocks:    blocks,
+		BatchSize: 10,
+	}
+	fastrand.Read(p.uniqueID[:])
+	return p
+}
+
+// Listen starts accepting connections on a random port of localhost.
+func (p *Peer) Listen() error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	p.ln = ln
+	for i := range p.Blocks {
+		p.ids = append(p.ids, p.Blocks[i].ID())
+	}
+	go p.accept()
+	return nil
+}
+
+// Addr returns the address the peer listens on.
+func (p *Peer) Addr() modules.NetAddress {
+	return modules.NetAddress(p.ln.Addr().String())
+}
+
+// Close stops accepting connections and closes the sessions.
+func (p *Peer) Close() error {
+	err := p.ln.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, sess := range p.sessions {
+		sess.Close()
+	}
+	return err
+}
+
+// Histories returns the histories of blocks received by SendBlocks RPC.
+func (p *Peer) Histories() [][32]types.BlockID {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][32]types.BlockID(nil), p.histories...)
+}
+
+// RelayHeader calls RelayHeader RPC of all the connected nodes.
+func (p *Peer) RelayHeader(header types.BlockHeader) {
+	p.relay("RelayHeader", header)
+}
+
+// RelayTransactionSet calls RelayTransactionSet RPC of all the connected
+// nodes.
+func (p *Peer) RelayTransactionSet(set []types.Transaction) {
+	p.relay("RelayTransactionSet", set)
+}
+
+func (p *Peer) relay(name string, obj interface{}) {
+	p.mu.Lock()
+	sessions := append([]*smux.Session(nil), p.sessions...)
+	p.mu.Unlock()
+	for _, sess := range sessions {
+		stream, err := sess.OpenStream()
+		if err != nil {
+			continue
+		}
+		encoding.WriteObject(stream, rpcID(name))
+		encoding.WriteObject(stream, obj)
+		stream.Close()
+	}
+}
+
+func (p *Peer) accept() {
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		go p.serve(conn)
+	}
+}
+
+func (p *Peer) handshake(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	var version string
+	if err := encoding.ReadObject(conn, &version, 100); err != nil {
+		return err
+	}
+	if err := encoding.WriteObject(conn, "1.3.7"); err != nil {
+		return err
+	}
+	var remote sessionHeader
+	if err := encoding.ReadObject(conn, &remote, 100); err != nil {
+		return err
+	}
+	if remote.GenesisID != types.GenesisID {
+		encoding.WriteObject(conn, "peer has different genesis ID")
+		return fmt.Errorf("peer has different genesis ID")
+	} else if remote.UniqueID == p.uniqueID {
+		encoding.WriteObject(conn, "can't connect to our own address")
+		return fmt.Errorf("peer has our UniqueID")
+	} else if err := remote.NetAddress.IsStdValid(); err != nil {
+		encoding.WriteObject(conn, "invalid remote address")
+		return fmt.Errorf("invalid remote address: %v", err)
+	}
+	if err := encoding.WriteObject(conn, modules.AcceptResponse); err != nil {
+		return err
+	}
+	ours := sessionHeader{
+		GenesisID:  types.GenesisID,
+		UniqueID:   p.uniqueID,
+		NetAddress: p.Addr(),
+	}
+	if err := encoding.WriteObject(conn, ours); err != nil {
+		return err
+	}
+	var response string
+	if err := encoding.ReadObject(conn, &response, 100); err != nil {
+		return err
+	}
+	if response != modules.AcceptResponse {
+		return fmt.Errorf("peer rejected our header: %v", response)
+	}
+	return nil
+}
+
+func (p *Peer) serve(conn net.Conn) {
+	if err := p.handshake(conn); err != nil {
+		conn.Close()
+		return
+	}
+	sess, err := smux.Server(conn, nil)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	p.mu.Lock()
+	p.sessions = append(p.sessions, sess)
+	p.mu.Unlock()
+	for {
+		stream, err := sess.AcceptStream()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer stream.Close()
+			var id [8]byte
+			if err := encoding.ReadObject(stream, &id, 8); err != nil {
+				return
+			}
+			switch id {
+			case rpcID("SendBlocks"):
+				p.sendBlocks(conn, stream)
+			case rpcID("SendBlk"):
+				p.sendBlk(stream)
+			case rpcID("ShareNodes"):
+				encoding.WriteObject(stream, p.Nodes)
+			}
+		}()
+	}
+}
+
+// start returns the height of the block following the latest block of
+// the history known to the peer.
+func (p *Peer) start(history [32]types.BlockID) int {
+	for _, id := range history {
+		for height := range p.ids {
+			if p.ids[height] == id {
+				return height + 1
+			}
+		}
+	}
+	return 1
+}
+
+func (p *Peer) sendBlocks(conn net.Conn, stream *smux.Stream) {
+	var history [32]types.BlockID
+	if err := encoding.ReadObject(stream, &history, 32*32); err != nil {
+		return
+	}
+	p.mu.Lock()
+	p.histories = append(p.histories, history)
+	p.mu.Unlock()
+	for start := p.start(history); ; {
+		end := start + p.BatchSize
+		if end > len(p.Blocks) {
+			end = len(p.Blocks)
+		}
+		contains := func(height int) bool {
+			return height != 0 && start <= height && height < end
+		}
+		if contains(p.StallAt) {
+			<-stream.GetDieCh()
+			return
+		}
+		if contains(p.MalformedAt) {
+			garbage := fastrand.Bytes(100)
+			encoding.WritePrefixedBytes(stream, garbage)
+			encoding.WriteObject(stream, true)
+			return
+		}
+		data := encoding.Marshal(p.Blocks[start:end])
+		var buf bytes.Buffer
+		encoding.WritePrefixedBytes(&buf, data)
+		if contains(p.DisconnectAt) {
+			stream.Write(buf.Bytes()[:buf.Len()/2])
+			// Let smux send the data before the connection is closed.
+			time.Sleep(100 * time.Millisecond)
+			conn.Close()
+			return
+		}
+		if _, err := stream.Write(buf.Bytes()); err != nil {
+			return
+		}
+		more := end < len(p.Blocks)
+		if err := encoding.WriteObject(stream, more); err != nil {
+			return
+		}
+		if !more {
+			return
+		}
+		start = end
+	}
+}
+
+func (p *Peer) sendBlk(stream *smux.Stream) {
+	var id types.BlockID
+	if err := encoding.ReadObject(stream, &id, 32); err != nil {
+		return
+	}
+	for i := range p.ids {
+		if p.ids[i] == id {
+			encoding.WriteObject(stream, p.Blocks[i])
+			return
+		}
+	}
+}
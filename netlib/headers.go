@@ -0,0 +1,106 @@
+package netlib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/xtaci/smux"
+	"gitlab.com/NebulousLabs/Sia/encoding"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// RequestBlock calls SendBlk RPC of the peer and returns the block
+// with the ID.
+func RequestBlock(conn io.ReadWriter, id types.BlockID) (*types.Block, error) {
+	if err := encoding.WriteObject(conn, rpcID("SendBlk")); err != nil {
+		return nil, err
+	}
+	if err := encoding.WriteObject(conn, id); err != nil {
+		return nil, err
+	}
+	var block types.Block
+	if err := encoding.ReadObject(conn, &block, types.BlockSizeLimit); err != nil {
+		return nil, err
+	}
+	if block.ID() != id {
+		return nil, fmt.Errorf("peer sent block %s instead of %s", block.ID(), id)
+	}
+	return &block, nil
+}
+
+// RelayHeader calls RelayHeader RPC of the peer announcing a new block.
+// The peer requests the block with SendBlk RPC if it needs it.
+func RelayHeader(conn io.Writer, header types.BlockHeader) error {
+	if err := encoding.WriteObject(conn, rpcID("RelayHeader")); err != nil {
+		return err
+	}
+	return encoding.WriteObject(conn, header)
+}
+
+// HeadersHandler returns a handler of RelayHeader RPC sending headers
+// of new blocks announced by the peer to hchan.
+func HeadersHandler(ctx context.Context, hchan chan types.BlockHeader) RPCHandler {
+	return func(stream *smux.Stream) {
+		var header types.BlockHeader
+		if err := encoding.ReadObject(stream, &header, types.BlockHeaderSize); err != nil {
+			log.Printf("reading block header: %v.", err)
+			return
+		}
+		select {
+		case hchan <- header:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// ReceiveHeaders accepts streams opened by the peer and sends headers
+// relayed with RelayHeader RPC to hchan. Other RPCs are ignored. It
+// returns when the session fails or ctx is done.
+func ReceiveHeaders(ctx context.Context, sess *smux.Session, hchan chan types.BlockHeader) error {
+	return AcceptRPCs(ctx, sess, map[string]RPCHandler{
+		"RelayHeader": HeadersHandler(ctx, hchan),
+	})
+}
+
+// DownloadHeadersFromHistory is like DownloadBlocksFromHistory, but
+// sends headers of the blocks to hchan. Sia has no RPC sending only
+// headers, so the blocks are downloaded, passed to Check and dropped.
+func (m *PeerManager) DownloadHeadersFromHistory(ctx context.Context, hchan chan types.BlockHeader, history [32]types.BlockID) (types.BlockID, error) {
+	bchan := make(chan *types.Block, 100)
+	var lastID types.BlockID
+	errChan := make(chan error, 1)
+	go func() {
+		var err error
+		lastID, err = m.DownloadBlocksFromHistory(ctx, bchan, history)
+		close(bchan)
+		errChan <- err
+	}()
+	for block := range bchan {
+		select {
+		case hchan <- block.Header():
+		case <-ctx.Done():
+		}
+	}
+	err := <-errChan
+	return lastID, err
+}
+
+// RelayHeader announces a new block to all the peers.
+func (m *PeerManager) RelayHeader(header types.BlockHeader) {
+	for _, p := range m.Peers() {
+		go func(p *Peer) {
+			stream, err := p.Sess.OpenStream()
+			if err != nil {
+				return
+			}
+			defer stream.Close()
+			stream.SetDeadline(time.Now().Add(handshakeTimeout))
+			if err := RelayHeader(stream, header); err != nil {
+				log.Printf("RelayHeader to %s: %v.", p.Addr, err)
+			}
+		}(p)
+	}
+}
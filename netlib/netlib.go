@@ -2,11 +2,13 @@ package netlib
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"time"
 
 	"github.com/xtaci/smux"
 	"gitlab.com/NebulousLabs/Sia/build"
@@ -23,91 +25,158 @@ type sessionHeader struct {
 	NetAddress modules.NetAddress
 }
 
+// Identity is what the node tells peers in the session header.
+type Identity struct {
+	UniqueID [8]byte
+	// NetAddress is where the node accepts connections. Peers only take
+	// the port from it and try to connect back to it.
+	NetAddress modules.NetAddress
+}
+
+// NewIdentity returns an identity with a random UniqueID. Peers reject
+// connections with their own UniqueID, so it must not be shared.
+func NewIdentity(netAddress modules.NetAddress) Identity {
+	id := Identity{NetAddress: netAddress}
+	fastrand.Read(id.UniqueID[:])
+	return id
+}
+
+// DefaultIdentity is used by Connect.
+var DefaultIdentity = NewIdentity("127.0.0.1:9981")
+
+const (
+	handshakeTimeout = 30 * time.Second
+	// batchTimeout limits the time of reading a batch of SendBlocks.
+	batchTimeout = 5 * time.Minute
+)
+
 func Connect(ctx context.Context, node string) (net.Conn, error) {
+	return ConnectAs(ctx, node, DefaultIdentity)
+}
+
+// ConnectAs connects to the node and performs the handshake of Sia
+// gateway presenting the identity.
+func ConnectAs(ctx context.Context, node string, identity Identity) (net.Conn, error) {
 	log.Println("Using node: ", node)
-	conn, err := net.Dial("tcp", node)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", node)
 	if err != nil {
 		return nil, err
 	}
+	stop := watchContext(ctx, conn)
+	err = handshake(ctx, conn, identity)
+	stop()
+	if e := ctxErr(ctx); e != nil {
+		err = e
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func handshake(ctx context.Context, conn net.Conn, identity Identity) error {
+	setDeadline(ctx, conn, handshakeTimeout)
+	defer conn.SetDeadline(time.Time{})
 	version := build.Version
 	if err := encoding.WriteObject(conn, version); err != nil {
-		return nil, err
+		return err
 	}
 	if err := encoding.ReadObject(conn, &version, uint64(100)); err != nil {
-		return nil, err
+		return err
 	}
 	log.Println(version)
 	sh := sessionHeader{
 		GenesisID:  types.GenesisID,
-		UniqueID:   [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
-		NetAddress: modules.NetAddress("example.com:1111"),
+		UniqueID:   identity.UniqueID,
+		NetAddress: identity.NetAddress,
 	}
 	if err := encoding.WriteObject(conn, sh); err != nil {
-		return nil, err
+		return err
 	}
 	var response string
 	if err := encoding.ReadObject(conn, &response, 100); err != nil {
-		return nil, fmt.Errorf("failed to read header acceptance: %v", err)
+		return fmt.Errorf("failed to read header acceptance: %v", err)
 	} else if response == modules.StopResponse {
-		return nil, fmt.Errorf("peer did not want a connection")
+		return fmt.Errorf("peer did not want a connection")
 	} else if response != modules.AcceptResponse {
-		return nil, fmt.Errorf("peer rejected our header: %v", response)
+		return fmt.Errorf("peer rejected our header: %v", response)
 	}
 	if err := encoding.ReadObject(conn, &sh, uint64(100)); err != nil {
-		return nil, err
+		return err
 	}
-	if err := encoding.WriteObject(conn, modules.AcceptResponse); err != nil {
-		return nil, err
+	if sh.GenesisID != types.GenesisID {
+		encoding.WriteObject(conn, "peer has different genesis ID")
+		return fmt.Errorf("peer has different genesis ID")
+	} else if sh.UniqueID == identity.UniqueID {
+		encoding.WriteObject(conn, "can't connect to our own address")
+		return fmt.Errorf("connected to ourselves")
 	}
-	return conn, nil
+	return encoding.WriteObject(conn, modules.AcceptResponse)
 }
 
 func DownloadBlocks(ctx context.Context, bchan chan *types.Block, conn io.ReadWriter, prevBlockID types.BlockID) (types.BlockID, error) {
-	var prevBlock *types.Block
-	var err error
-	var rpcName [8]byte
-	copy(rpcName[:], "SendBlocks")
-	if err = encoding.WriteObject(conn, rpcName); err != nil {
-		return prevBlockID, err
-	}
 	var history [32]types.BlockID
+	history[0] = prevBlockID
 	history[31] = types.GenesisID
-	moreAvailable := true
+	return DownloadBlocksFromHistory(ctx, bchan, conn, history)
+}
+
+// DownloadBlocksFromHistory downloads blocks following the latest block
+// from history known to the peer. history lists IDs of blocks from the
+// newest to the genesis block, see SendBlocks RPC of Sia. It returns
+// the ID of the last downloaded block or history[0] if none. If ctx is
+// done, conn is closed to interrupt pending reads.
+func DownloadBlocksFromHistory(ctx context.Context, bchan chan *types.Block, conn io.ReadWriter, history [32]types.BlockID) (types.BlockID, error) {
+	prevBlockID := history[0]
+	stop := watchContext(ctx, conn)
+	defer stop()
+	err := downloadBlocks(ctx, bchan, conn, history, &prevBlockID)
+	if e := ctxErr(ctx); e != nil {
+		err = e
+	}
+	return prevBlockID, err
+}
+
+func downloadBlocks(ctx context.Context, bchan chan *types.Block, conn io.ReadWriter, history [32]types.BlockID, prevBlockID *types.BlockID) error {
+	setDeadline(ctx, conn, batchTimeout)
+	if err := encoding.WriteObject(conn, rpcID("SendBlocks")); err != nil {
+		return err
+	}
 	// Send the block ids.
-	history[0] = prevBlockID
-	if err = encoding.WriteObject(conn, history); err != nil {
-		goto exit
+	if err := encoding.WriteObject(conn, history); err != nil {
+		return err
 	}
-	for moreAvailable {
-		select {
-		case <-ctx.Done():
-			err = ctx.Err()
-			goto exit
-		default:
-		}
+	for moreAvailable := true; moreAvailable; {
 		// Read a slice of blocks from the wire.
+		setDeadline(ctx, conn, batchTimeout)
 		var newBlocks []types.Block
-		if err = encoding.ReadObject(conn, &newBlocks, uint64(consensus.MaxCatchUpBlocks)*types.BlockSizeLimit); err != nil {
-			goto exit
+		if err := readObject(conn, &newBlocks, uint64(consensus.MaxCatchUpBlocks)*types.BlockSizeLimit); err != nil {
+			return err
 		}
-		if err = encoding.ReadObject(conn, &moreAvailable, 1); err != nil {
-			goto exit
+		if err := readObject(conn, &moreAvailable, 1); err != nil {
+			return err
 		}
 		for i := range newBlocks {
 			b := &newBlocks[i]
-			bchan <- b
-			prevBlock = b
+			select {
+			case bchan <- b:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			*prevBlockID = b.ID()
 		}
 	}
-exit:
-	if prevBlock != nil {
-		prevBlockID = prevBlock.ID()
-	}
-	return prevBlockID, nil
+	return nil
 }
 
 func DownloadAllBlocks(ctx context.Context, bchan chan *types.Block, sess func() (io.ReadWriter, error)) error {
-	prevBlockID := types.GenesisID
+	return DownloadAllBlocksSince(ctx, bchan, sess, types.GenesisID)
+}
+
+// DownloadAllBlocksSince downloads all blocks following prevBlockID.
+func DownloadAllBlocksSince(ctx context.Context, bchan chan *types.Block, sess func() (io.ReadWriter, error), prevBlockID types.BlockID) error {
 	for {
 		stream, err := sess()
 		if err != nil {
@@ -120,7 +189,7 @@ func DownloadAllBlocks(ctx context.Context, bchan chan *types.Block, sess func()
 			log.Printf("No error, all blocks were downloaded. Stopping.")
 			break
 		}
-		if err != io.EOF && err != io.ErrUnexpectedEOF {
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
 			return err
 		}
 		prevBlockID = newPrevBlockID
@@ -128,6 +197,138 @@ func DownloadAllBlocks(ctx context.Context, bchan chan *types.Block, sess func()
 	return nil
 }
 
+// readObject is like encoding.ReadObject, but it returns invalidDataError
+// if the data was read but can not be decoded.
+func readObject(r io.Reader, obj interface{}, maxLen uint64) error {
+	prefix := make([]byte, 8)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return err
+	}
+	dataLen := encoding.DecUint64(prefix)
+	if dataLen > maxLen {
+		return &invalidDataError{fmt.Errorf("length %d exceeds maxLen of %d", dataLen, maxLen)}
+	}
+	data := make([]byte, dataLen)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	if err := encoding.Unmarshal(data, obj); err != nil {
+		return &invalidDataError{err}
+	}
+	return nil
+}
+
+// watchContext closes conn, if it is an io.Closer, when ctx is done.
+// This interrupts pending reads and writes, which ignore ctx. The
+// returned function stops watching.
+func watchContext(ctx context.Context, conn interface{}) func() {
+	closer, ok := conn.(io.Closer)
+	if !ok || ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			closer.Close()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// ctxErr returns the error of ctx. A deadline of conn set by setDeadline
+// can expire before ctx is done, so ctx is also considered expired after
+// its deadline.
+func ctxErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, has := ctx.Deadline(); has && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// setDeadline sets the deadline of conn, if it supports deadlines, to
+// timeout from now or to the deadline of ctx if it is earlier.
+func setDeadline(ctx context.Context, conn interface{}, timeout time.Duration) {
+	d, ok := conn.(interface {
+		SetDeadline(t time.Time) error
+	})
+	if !ok {
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, has := ctx.Deadline(); has && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	d.SetDeadline(deadline)
+}
+
+// rpcID returns the name of RPC as sent on the wire.
+func rpcID(name string) (id [8]byte) {
+	copy(id[:], name)
+	return
+}
+
+// RPCHandler handles a stream opened by the peer. The name of RPC was
+// already read from the stream.
+type RPCHandler func(stream *smux.Stream)
+
+// AcceptRPCs accepts streams opened by the peer and calls the handler
+// of the RPC in a new goroutine. RPCs without a handler are ignored.
+// It returns when the session fails or ctx is done. In the latter case
+// the session is closed.
+func AcceptRPCs(ctx context.Context, sess *smux.Session, handlers map[string]RPCHandler) error {
+	byID := make(map[[8]byte]RPCHandler)
+	for name, handler := range handlers {
+		byID[rpcID(name)] = handler
+	}
+	stop := watchContext(ctx, sess)
+	defer stop()
+	for {
+		stream, err := sess.AcceptStream()
+		if ctx.Err() != nil {
+			if stream != nil {
+				stream.Close()
+			}
+			return ctx.Err()
+		} else if err != nil {
+			return err
+		}
+		go func() {
+			defer stream.Close()
+			stream.SetDeadline(time.Now().Add(2 * time.Minute))
+			var id [8]byte
+			if err := encoding.ReadObject(stream, &id, 8); err != nil {
+				return
+			}
+			if handler, has := byID[id]; has {
+				handler(stream)
+			}
+		}()
+	}
+}
+
+// TransactionSetsHandler returns a handler of RelayTransactionSet RPC
+// sending transaction sets to tchan.
+func TransactionSetsHandler(ctx context.Context, tchan chan []types.Transaction) RPCHandler {
+	return func(stream *smux.Stream) {
+		var set []types.Transaction
+		if err := encoding.ReadObject(stream, &set, modules.TransactionSetSizeLimit); err != nil {
+			log.Printf("reading transaction set: %v.", err)
+			return
+		}
+		select {
+		case tchan <- set:
+		case <-ctx.Done():
+		}
+	}
+}
+
 type blockchainReader struct {
 	impl io.Reader
 }
@@ -141,8 +342,24 @@ func (t *blockchainReader) Write(b []byte) (int, error) {
 	return len(b), nil
 }
 
+// OpenOrConnect returns a function opening streams serving SendBlocks
+// RPC. If file is set, they are read from the file: a recorded chain
+// (see ChainFile) or raw SendBlocks responses. Otherwise the node or
+// a bootstrap peer is connected to.
 func OpenOrConnect(ctx context.Context, file, node string) (*smux.Session, func() (io.ReadWriter, error), error) {
 	if file != "" {
+		if isChain, err := IsChainFile(file); err != nil {
+			return nil, nil, err
+		} else if isChain {
+			c, err := ReadChainFile(file)
+			if err != nil {
+				return nil, nil, err
+			}
+			f := func() (io.ReadWriter, error) {
+				return c.Stream(), nil
+			}
+			return nil, f, nil
+		}
 		bc, err := os.Open(file)
 		if err != nil {
 			return nil, nil, err
@@ -0,0 +1,594 @@
+package netlib
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/starius/sialite/cache"
+	"github.com/starius/sialite/netlib/fakepeer"
+	"github.com/xtaci/smux"
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+var cachedBlocks []types.Block
+
+func readBlocks(t *testing.T) []types.Block {
+	if cachedBlocks == nil {
+		blocks, err := fakepeer.ReadBlocks(filepath.Join("..", "cache", "testdata", "first_1000.blocks.gz"))
+		if err != nil {
+			t.Fatalf("fakepeer.ReadBlocks: %v", err)
+		}
+		cachedBlocks = blocks
+	}
+	return cachedBlocks
+}
+
+func startPeer(t *testing.T, blocks []types.Block, setup func(p *fakepeer.Peer)) *fakepeer.Peer {
+	p := fakepeer.New(blocks)
+	if setup != nil {
+		setup(p)
+	}
+	if err := p.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() {
+		p.Close()
+	})
+	return p
+}
+
+func connect(t *testing.T, p *fakepeer.Peer) *smux.Session {
+	conn, err := ConnectAs(context.Background(), string(p.Addr()), NewIdentity("127.0.0.1:9981"))
+	if err != nil {
+		t.Fatalf("ConnectAs: %v", err)
+	}
+	sess, err := smux.Client(conn, nil)
+	if err != nil {
+		t.Fatalf("smux.Client: %v", err)
+	}
+	t.Cleanup(func() {
+		sess.Close()
+	})
+	return sess
+}
+
+func newManager(seeds ...modules.NetAddress) *PeerManager {
+	pm := NewPeerManager(seeds, 1, NewIdentity("127.0.0.1:9981"))
+	pm.StallTimeout = time.Second
+	pm.MaintainInterval = 100 * time.Millisecond
+	return pm
+}
+
+// collect returns the blocks sent to bchan by download and checks that
+// they follow the genesis block.
+func collect(t *testing.T, download func(bchan chan *types.Block) error) ([]*types.Block, error) {
+	bchan := make(chan *types.Block, 100)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- download(bchan)
+		close(bchan)
+	}()
+	var blocks []*types.Block
+	parent := types.GenesisID
+	for block := range bchan {
+		if block.ParentID != parent {
+			t.Errorf("block %d does not follow the previous block", len(blocks)+1)
+		}
+		parent = block.ID()
+		blocks = append(blocks, block)
+	}
+	return blocks, <-errChan
+}
+
+func checkChain(t *testing.T, got []*types.Block, want []types.Block) {
+	if len(got) != len(want)-1 {
+		t.Fatalf("got %d blocks, want %d", len(got), len(want)-1)
+	}
+	for i, block := range got {
+		if block.ID() != want[i+1].ID() {
+			t.Fatalf("block %d is %s, want %s", i+1, block.ID(), want[i+1].ID())
+		}
+	}
+}
+
+func TestDownloadBlocks(t *testing.T) {
+	blocks := readBlocks(t)
+	p := startPeer(t, blocks, nil)
+	sess := connect(t, p)
+	got, err := collect(t, func(bchan chan *types.Block) error {
+		stream, err := sess.OpenStream()
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		_, err = DownloadBlocks(context.Background(), bchan, stream, types.GenesisID)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("DownloadBlocks: %v", err)
+	}
+	checkChain(t, got, blocks)
+}
+
+func TestDownloadBlocksErrors(t *testing.T) {
+	blocks := readBlocks(t)
+	cases := []struct {
+		name  string
+		setup func(p *fakepeer.Peer)
+		check func(err error) bool
+	}{
+		{"disconnect", func(p *fakepeer.Peer) { p.DisconnectAt = 300 }, func(err error) bool {
+			return err != nil
+		}},
+		{"malformed", func(p *fakepeer.Peer) { p.MalformedAt = 300 }, func(err error) bool {
+			_, ok := err.(*invalidDataError)
+			return ok
+		}},
+	}
+	for _, tc := range cases {
+		p := startPeer(t, blocks, tc.setup)
+		sess := connect(t, p)
+		stream, err := sess.OpenStream()
+		if err != nil {
+			t.Fatalf("OpenStream: %v", err)
+		}
+		got, err := collect(t, func(bchan chan *types.Block) error {
+			_, err := DownloadBlocks(context.Background(), bchan, stream, types.GenesisID)
+			return err
+		})
+		stream.Close()
+		if !tc.check(err) {
+			t.Errorf("%s: DownloadBlocks returned unexpected error: %v", tc.name, err)
+		}
+		if len(got) != 290 {
+			t.Errorf("%s: got %d blocks, want 290", tc.name, len(got))
+		}
+	}
+}
+
+func TestDownloadBlocksCancel(t *testing.T) {
+	blocks := readBlocks(t)
+	p := startPeer(t, blocks, func(p *fakepeer.Peer) {
+		p.StallAt = 300
+	})
+	sess := connect(t, p)
+	stream, err := sess.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	defer stream.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = collect(t, func(bchan chan *types.Block) error {
+		_, err := DownloadBlocks(ctx, bchan, stream, types.GenesisID)
+		return err
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("DownloadBlocks returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("DownloadBlocks returned after %s", elapsed)
+	}
+	// Sending to a full channel is interrupted as well.
+	p2 := startPeer(t, blocks, nil)
+	stream2, err := connect(t, p2).OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	defer stream2.Close()
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel2()
+	if _, err := DownloadBlocks(ctx2, make(chan *types.Block), stream2, types.GenesisID); err != context.DeadlineExceeded {
+		t.Errorf("DownloadBlocks to a full channel returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestConnectHandshake(t *testing.T) {
+	blocks := readBlocks(t)
+	p := startPeer(t, blocks, nil)
+	identity := NewIdentity("127.0.0.1:9981")
+	if _, err := ConnectAs(context.Background(), string(p.Addr()), identity); err != nil {
+		t.Fatalf("ConnectAs: %v", err)
+	}
+	if _, err := ConnectAs(context.Background(), string(p.Addr()), Identity{NetAddress: "bad address"}); err == nil {
+		t.Errorf("ConnectAs with invalid NetAddress succeeded.")
+	}
+}
+
+func TestPeerManagerFailover(t *testing.T) {
+	blocks := readBlocks(t)
+	good := startPeer(t, blocks, nil)
+	stalling := startPeer(t, blocks, func(p *fakepeer.Peer) {
+		p.StallAt = 600
+		p.Nodes = []modules.NetAddress{good.Addr()}
+	})
+	disconnecting := startPeer(t, blocks, func(p *fakepeer.Peer) {
+		p.DisconnectAt = 300
+		p.Nodes = []modules.NetAddress{stalling.Addr()}
+	})
+	pm := newManager(disconnecting.Addr())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pm.Run(ctx)
+	var history [32]types.BlockID
+	history[0] = types.GenesisID
+	got, err := collect(t, func(bchan chan *types.Block) error {
+		_, err := pm.DownloadBlocksFromHistory(ctx, bchan, history)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("DownloadBlocksFromHistory: %v", err)
+	}
+	checkChain(t, got, blocks)
+}
+
+func TestPeerManagerBansMalformed(t *testing.T) {
+	blocks := readBlocks(t)
+	good := startPeer(t, blocks, nil)
+	malformed := startPeer(t, blocks, func(p *fakepeer.Peer) {
+		p.MalformedAt = 300
+		p.Nodes = []modules.NetAddress{good.Addr()}
+	})
+	pm := newManager(malformed.Addr())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pm.Run(ctx)
+	var history [32]types.BlockID
+	history[0] = types.GenesisID
+	got, err := collect(t, func(bchan chan *types.Block) error {
+		_, err := pm.DownloadBlocksFromHistory(ctx, bchan, history)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("DownloadBlocksFromHistory: %v", err)
+	}
+	checkChain(t, got, blocks)
+	pm.mu.Lock()
+	banned := pm.isBanned(malformed.Addr())
+	pm.mu.Unlock()
+	if !banned {
+		t.Errorf("the peer sending malformed data was not banned.")
+	}
+}
+
+func TestPeerManagerBansFork(t *testing.T) {
+	blocks := readBlocks(t)
+	good := startPeer(t, blocks, nil)
+	forking := startPeer(t, fakepeer.Fork(blocks, 500, 20), func(p *fakepeer.Peer) {
+		p.Nodes = []modules.NetAddress{good.Addr()}
+	})
+	pm := newManager(forking.Addr())
+	v := cache.NewValidator(nil, nil, 0)
+	if err := v.Add(&types.GenesisBlock); err != nil {
+		t.Fatalf("Add(genesis): %v", err)
+	}
+	pm.Check = v.Add
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pm.Run(ctx)
+	var history [32]types.BlockID
+	history[0] = types.GenesisID
+	got, err := collect(t, func(bchan chan *types.Block) error {
+		_, err := pm.DownloadBlocksFromHistory(ctx, bchan, history)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("DownloadBlocksFromHistory: %v", err)
+	}
+	checkChain(t, got, blocks)
+	pm.mu.Lock()
+	banned := pm.isBanned(forking.Addr())
+	pm.mu.Unlock()
+	if !banned {
+		t.Errorf("the peer sending the fork was not banned.")
+	}
+}
+
+func TestPeerManagerDropsStale(t *testing.T) {
+	blocks := readBlocks(t)
+	p := startPeer(t, blocks, nil)
+	pm := newManager(p.Addr())
+	stale := true
+	pm.Check = func(block *types.Block) error {
+		if block.ID() == blocks[400].ID() && stale {
+			stale = false
+			return &cache.UnknownParentError{ID: block.ID(), ParentID: block.ParentID}
+		}
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pm.Run(ctx)
+	var history [32]types.BlockID
+	history[0] = types.GenesisID
+	got, err := collect(t, func(bchan chan *types.Block) error {
+		_, err := pm.DownloadBlocksFromHistory(ctx, bchan, history)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("DownloadBlocksFromHistory: %v", err)
+	}
+	checkChain(t, got, blocks)
+	pm.mu.Lock()
+	banned := pm.isBanned(p.Addr())
+	pm.mu.Unlock()
+	if banned {
+		t.Errorf("the peer was banned because of a stale check.")
+	}
+}
+
+func TestRelayHeader(t *testing.T) {
+	blocks := readBlocks(t)
+	p := startPeer(t, blocks, nil)
+	sess := connect(t, p)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hchan := make(chan types.BlockHeader, 1)
+	go ReceiveHeaders(ctx, sess, hchan)
+	want := blocks[700].Header()
+	// The session is registered by the peer after the handshake.
+relay:
+	for i := 0; i < 50; i++ {
+		p.RelayHeader(want)
+		select {
+		case header := <-hchan:
+			if header != want {
+				t.Fatalf("got header %v, want %v", header, want)
+			}
+			break relay
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	stream, err := sess.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	defer stream.Close()
+	block, err := RequestBlock(stream, want.ID())
+	if err != nil {
+		t.Fatalf("RequestBlock: %v", err)
+	}
+	if block.ID() != want.ID() {
+		t.Errorf("RequestBlock returned block %s, want %s", block.ID(), want.ID())
+	}
+}
+
+func TestRequestNodes(t *testing.T) {
+	blocks := readBlocks(t)
+	nodes := []modules.NetAddress{"1.2.3.4:9981", "example.com:9981"}
+	p := startPeer(t, blocks, func(p *fakepeer.Peer) {
+		p.Nodes = nodes
+	})
+	sess := connect(t, p)
+	stream, err := sess.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	defer stream.Close()
+	got, err := RequestNodes(stream)
+	if err != nil {
+		t.Fatalf("RequestNodes: %v", err)
+	}
+	if len(got) != len(nodes) || got[0] != nodes[0] || got[1] != nodes[1] {
+		t.Errorf("RequestNodes returned %v, want %v", got, nodes)
+	}
+}
+
+func writeChainFile(t *testing.T, path string, blocks []types.Block) *ChainFile {
+	c, err := OpenChainFile(path)
+	if err != nil {
+		t.Fatalf("OpenChainFile: %v", err)
+	}
+	for i := range blocks {
+		if err := c.Append(&blocks[i]); err != nil {
+			t.Fatalf("Append(block %d): %v", i, err)
+		}
+	}
+	return c
+}
+
+func TestChainFile(t *testing.T) {
+	blocks := readBlocks(t)
+	path := filepath.Join(t.TempDir(), "chain.dat")
+	c := writeChainFile(t, path, blocks)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	_, f, err := OpenOrConnect(context.Background(), path, "")
+	if err != nil {
+		t.Fatalf("OpenOrConnect: %v", err)
+	}
+	got, err := collect(t, func(bchan chan *types.Block) error {
+		return DownloadAllBlocks(context.Background(), bchan, f)
+	})
+	if err != nil {
+		t.Fatalf("DownloadAllBlocks: %v", err)
+	}
+	checkChain(t, got, blocks)
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	return info.Size()
+}
+
+func TestChainFileRecovery(t *testing.T) {
+	blocks := readBlocks(t)
+	path := filepath.Join(t.TempDir(), "chain.dat")
+	c := writeChainFile(t, path, blocks[:101])
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	// An interrupted writer left blocks 99 and 100 without index entries,
+	// a partial index entry and a partial record.
+	if err := os.Truncate(path+".idx", 99*indexEntryLen+17); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := f.Write([]byte{1, 2, 3, 4, 5}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	f.Close()
+	dataSize, indexSize := fileSize(t, path), fileSize(t, path+".idx")
+
+	// A reader sees indexed blocks and does not change the files.
+	r, err := ReadChainFile(path)
+	if err != nil {
+		t.Fatalf("ReadChainFile: %v", err)
+	}
+	if r.Len() != 99 {
+		t.Errorf("reader has %d blocks, want 99", r.Len())
+	}
+	if _, has := r.Height(blocks[99].ID()); has {
+		t.Errorf("reader knows block 99 without an index entry")
+	}
+	r.Close()
+	if fileSize(t, path) != dataSize || fileSize(t, path+".idx") != indexSize {
+		t.Errorf("ReadChainFile changed the files")
+	}
+
+	// The writer recovers complete records and removes the rest.
+	c, err = OpenChainFile(path)
+	if err != nil {
+		t.Fatalf("OpenChainFile: %v", err)
+	}
+	if c.Len() != 101 {
+		t.Fatalf("recovered %d blocks, want 101", c.Len())
+	}
+	if height, has := c.Height(blocks[100].ID()); !has || height != 100 {
+		t.Errorf("Height(block 100) = %d, %v", height, has)
+	}
+	if fileSize(t, path) != dataSize-5 {
+		t.Errorf("the partial record was not removed")
+	}
+	if err := c.Append(&blocks[101]); err != nil {
+		t.Fatalf("Append(block 101): %v", err)
+	}
+	if err := c.Truncate(50); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if _, has := c.Height(blocks[60].ID()); has {
+		t.Errorf("a truncated block is known")
+	}
+	c.Close()
+	c, err = OpenChainFile(path)
+	if err != nil {
+		t.Fatalf("OpenChainFile: %v", err)
+	}
+	defer c.Close()
+	if c.Len() != 50 {
+		t.Errorf("reopened chain has %d blocks, want 50", c.Len())
+	}
+}
+
+func TestChainFileChecksum(t *testing.T) {
+	blocks := readBlocks(t)
+	path := filepath.Join(t.TempDir(), "chain.dat")
+	c := writeChainFile(t, path, blocks[:100])
+	defer c.Close()
+	// Corrupt a byte of the block at height 50.
+	offset := c.entries[50].offset + recordHeaderLen + 10
+	b := make([]byte, 1)
+	if _, err := c.data.ReadAt(b, offset); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	b[0] ^= 1
+	if _, err := c.data.WriteAt(b, offset); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	if _, err := c.Block(50); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Block(50) of corrupted record returned %v, want a checksum error", err)
+	}
+	if _, err := c.Block(49); err != nil {
+		t.Errorf("Block(49): %v", err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	blocks := readBlocks(t)
+	c := writeChainFile(t, filepath.Join(t.TempDir(), "chain.dat"), blocks)
+	defer c.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	nodes := []modules.NetAddress{"1.2.3.4:9981"}
+	server := &Server{
+		Source:   c,
+		Identity: ListenerIdentity(ln),
+		Nodes: func() []modules.NetAddress {
+			return nodes
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(ctx, ln)
+	}()
+	addr := modules.NetAddress(ln.Addr().String())
+	if _, err := ConnectAs(ctx, string(addr), server.Identity); err == nil {
+		t.Errorf("the server accepted its own identity.")
+	}
+	pm := newManager(addr)
+	go pm.Run(ctx)
+	var history [32]types.BlockID
+	history[0] = blocks[100].ID()
+	history[1] = types.GenesisID
+	got, err := collect(t, func(bchan chan *types.Block) error {
+		bchan <- &blocks[1]
+		for i := 2; i <= 100; i++ {
+			bchan <- &blocks[i]
+		}
+		_, err := pm.DownloadBlocksFromHistory(ctx, bchan, history)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("DownloadBlocksFromHistory: %v", err)
+	}
+	checkChain(t, got, blocks)
+	p, err := pm.WaitPeer(ctx)
+	if err != nil {
+		t.Fatalf("WaitPeer: %v", err)
+	}
+	stream, err := p.Sess.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	block, err := RequestBlock(stream, blocks[500].ID())
+	stream.Close()
+	if err != nil {
+		t.Fatalf("RequestBlock: %v", err)
+	}
+	if block.ID() != blocks[500].ID() {
+		t.Errorf("RequestBlock returned block %s", block.ID())
+	}
+	stream, err = p.Sess.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	gotNodes, err := RequestNodes(stream)
+	stream.Close()
+	if err != nil {
+		t.Fatalf("RequestNodes: %v", err)
+	}
+	if len(gotNodes) != 1 || gotNodes[0] != nodes[0] {
+		t.Errorf("RequestNodes returned %v, want %v", gotNodes, nodes)
+	}
+	cancel()
+	if err := <-serveErr; err != context.Canceled {
+		t.Errorf("Serve returned %v, want %v", err, context.Canceled)
+	}
+}
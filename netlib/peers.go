@@ -0,0 +1,474 @@
+package netlib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/xtaci/smux"
+	"gitlab.com/NebulousLabs/Sia/encoding"
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/types"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+const (
+	banDuration    = 24 * time.Hour
+	maxSharedNodes = 10
+	maxNodes       = 1000
+	// Number of peers a download switches to in a row without getting
+	// a block before it fails.
+	maxFailovers = 10
+)
+
+// RequestNodes calls ShareNodes RPC of the peer and returns the nodes
+// it knows.
+func RequestNodes(conn io.ReadWriter) ([]modules.NetAddress, error) {
+	var rpcName [8]byte
+	copy(rpcName[:], "ShareNodes")
+	if err := encoding.WriteObject(conn, rpcName); err != nil {
+		return nil, err
+	}
+	var nodes []modules.NetAddress
+	if err := encoding.ReadObject(conn, &nodes, maxSharedNodes*modules.MaxEncodedNetAddressLength); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// Peer is a session with a node.
+type Peer struct {
+	Addr modules.NetAddress
+	Sess *smux.Session
+}
+
+// invalidDataError is returned by downloads if the peer sent data which
+// is not valid. Such peers are banned.
+type invalidDataError struct {
+	err error
+}
+
+func (e *invalidDataError) Error() string {
+	return e.err.Error()
+}
+
+// staleError is implemented by errors of PeerManager.Check which do not
+// mean that the block is invalid.
+type staleError interface {
+	Stale() bool
+}
+
+// PeerManager maintains sessions with several nodes. It starts with seed
+// nodes and discovers more of them with ShareNodes RPC. Nodes sending
+// invalid data are banned.
+type PeerManager struct {
+	// StallTimeout is how long a download waits for data from a peer
+	// before switching to another peer.
+	StallTimeout time.Duration
+	// MaintainInterval is how often failed peers are replaced.
+	MaintainInterval time.Duration
+	// Discover enables requesting nodes from peers. If it is false,
+	// the manager only connects to the seeds.
+	Discover bool
+	// Check, if set, is called for every downloaded block before it is
+	// passed on. Peers sending blocks failing it are banned, unless
+	// the error has method Stale() bool returning true: such errors
+	// are caused by the state of the checker, not by the block, and the
+	// peer is only dropped.
+	Check func(block *types.Block) error
+
+	identity Identity
+	target   int
+	seeds    map[modules.NetAddress]bool
+
+	mu        sync.Mutex
+	peers     map[modules.NetAddress]*Peer
+	nodes     map[modules.NetAddress]bool
+	banned    map[modules.NetAddress]time.Time
+	onConnect func(p *Peer)
+	changed   chan struct{} // Closed and replaced when a peer is added.
+}
+
+// NewPeerManager returns a manager which keeps target sessions. Run must
+// be called to connect to peers.
+func NewPeerManager(seeds []modules.NetAddress, target int, identity Identity) *PeerManager {
+	m := &PeerManager{
+		StallTimeout:     2 * time.Minute,
+		MaintainInterval: 10 * time.Second,
+		Discover:         true,
+		identity:         identity,
+		target:           target,
+		seeds:            make(map[modules.NetAddress]bool),
+		peers:            make(map[modules.NetAddress]*Peer),
+		nodes:            make(map[modules.NetAddress]bool),
+		banned:           make(map[modules.NetAddress]time.Time),
+		changed:          make(chan struct{}),
+	}
+	for _, addr := range seeds {
+		m.seeds[addr] = true
+		m.nodes[addr] = true
+	}
+	return m
+}
+
+// Run connects to peers and replaces failed ones until ctx is done.
+// Then it closes all the sessions.
+func (m *PeerManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.MaintainInterval)
+	defer ticker.Stop()
+	for {
+		m.maintain(ctx)
+		select {
+		case <-ctx.Done():
+			m.mu.Lock()
+			for addr, p := range m.peers {
+				p.Sess.Close()
+				delete(m.peers, addr)
+			}
+			m.mu.Unlock()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetOnConnect makes the manager call f in a new goroutine for every
+// current and future peer.
+func (m *PeerManager) SetOnConnect(f func(p *Peer)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onConnect = f
+	for _, p := range m.peers {
+		go f(p)
+	}
+}
+
+// Peers returns the peers with open sessions.
+func (m *PeerManager) Peers() []*Peer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var peers []*Peer
+	for _, p := range m.peers {
+		if !p.Sess.IsClosed() {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// WaitPeer returns a random peer with an open session. If there are no
+// such peers, it waits for one.
+func (m *PeerManager) WaitPeer(ctx context.Context) (*Peer, error) {
+	for {
+		peers := m.Peers()
+		if len(peers) != 0 {
+			return peers[fastrand.Intn(len(peers))], nil
+		}
+		m.mu.Lock()
+		changed := m.changed
+		m.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		case <-time.After(m.MaintainInterval):
+		}
+	}
+}
+
+// AddNodes adds nodes to the list of nodes to connect to. Invalid and
+// banned nodes are skipped.
+func (m *PeerManager) AddNodes(nodes []modules.NetAddress) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, addr := range nodes {
+		if len(m.nodes) >= maxNodes {
+			return
+		}
+		if addr.IsStdValid() != nil || m.isBanned(addr) {
+			continue
+		}
+		m.nodes[addr] = true
+	}
+}
+
+// Drop closes the session with the peer. The node can be connected
+// to again.
+func (m *PeerManager) Drop(addr modules.NetAddress, reason error) {
+	log.Printf("dropping peer %s: %v.", addr, reason)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removePeer(addr)
+}
+
+// Ban closes the session with the peer and does not connect to the node
+// for banDuration.
+func (m *PeerManager) Ban(addr modules.NetAddress, reason error) {
+	log.Printf("banning peer %s: %v.", addr, reason)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removePeer(addr)
+	m.banned[addr] = time.Now().Add(banDuration)
+}
+
+func (m *PeerManager) removePeer(addr modules.NetAddress) {
+	if p, has := m.peers[addr]; has {
+		p.Sess.Close()
+		delete(m.peers, addr)
+	}
+}
+
+func (m *PeerManager) isBanned(addr modules.NetAddress) bool {
+	until, has := m.banned[addr]
+	if has && time.Now().After(until) {
+		delete(m.banned, addr)
+		return false
+	}
+	return has
+}
+
+// candidates returns up to n random nodes to connect to.
+func (m *PeerManager) candidates(n int) []modules.NetAddress {
+	var all []modules.NetAddress
+	for addr := range m.nodes {
+		if _, has := m.peers[addr]; !has && !m.isBanned(addr) {
+			all = append(all, addr)
+		}
+	}
+	var result []modules.NetAddress
+	for _, i := range fastrand.Perm(len(all)) {
+		if len(result) == n {
+			break
+		}
+		result = append(result, all[i])
+	}
+	return result
+}
+
+func (m *PeerManager) maintain(ctx context.Context) {
+	m.mu.Lock()
+	for addr, p := range m.peers {
+		if p.Sess.IsClosed() {
+			delete(m.peers, addr)
+		}
+	}
+	candidates := m.candidates(m.target - len(m.peers))
+	discover := m.Discover && len(m.nodes) < 2*m.target
+	m.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, addr := range candidates {
+		wg.Add(1)
+		go func(addr modules.NetAddress) {
+			defer wg.Done()
+			m.connect(ctx, addr)
+		}(addr)
+	}
+	wg.Wait()
+	if discover {
+		m.discover(ctx)
+	}
+}
+
+func (m *PeerManager) connect(ctx context.Context, addr modules.NetAddress) {
+	conn, err := ConnectAs(ctx, string(addr), m.identity)
+	if err != nil {
+		log.Printf("connecting to %s: %v.", addr, err)
+		m.mu.Lock()
+		if !m.seeds[addr] {
+			delete(m.nodes, addr)
+		}
+		m.mu.Unlock()
+		return
+	}
+	sess, err := smux.Client(conn, nil)
+	if err != nil {
+		log.Printf("smux.Client for %s: %v.", addr, err)
+		conn.Close()
+		return
+	}
+	p := &Peer{Addr: addr, Sess: sess}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ctx.Err() != nil || m.isBanned(addr) {
+		sess.Close()
+		return
+	}
+	m.peers[addr] = p
+	close(m.changed)
+	m.changed = make(chan struct{})
+	if m.onConnect != nil {
+		go m.onConnect(p)
+	}
+}
+
+func (m *PeerManager) discover(ctx context.Context) {
+	for _, p := range m.Peers() {
+		if ctx.Err() != nil {
+			return
+		}
+		stream, err := p.Sess.OpenStream()
+		if err != nil {
+			continue
+		}
+		setDeadline(ctx, stream, handshakeTimeout)
+		stop := watchContext(ctx, stream)
+		nodes, err := RequestNodes(stream)
+		stop()
+		stream.Close()
+		if err != nil {
+			log.Printf("RequestNodes from %s: %v.", p.Addr, err)
+			continue
+		}
+		m.AddNodes(nodes)
+	}
+}
+
+// DownloadBlocksFromHistory is like the package-level
+// DownloadBlocksFromHistory, but it uses the peers: if a peer fails or
+// stalls, the download goes on from another peer. Peers sending blocks
+// which do not follow the history or each other or fail Check are
+// banned.
+func (m *PeerManager) DownloadBlocksFromHistory(ctx context.Context, bchan chan *types.Block, history [32]types.BlockID) (types.BlockID, error) {
+	lastID := history[0]
+	failures := 0
+	for {
+		p, err := m.WaitPeer(ctx)
+		if err != nil {
+			return lastID, err
+		}
+		newLastID, err := m.downloadFrom(ctx, p, bchan, history)
+		if newLastID != lastID {
+			lastID = newLastID
+			history = extendHistory(history, lastID)
+			failures = 0
+		}
+		if err == nil {
+			return lastID, nil
+		}
+		if ctx.Err() != nil {
+			return lastID, ctx.Err()
+		}
+		if _, ok := err.(*invalidDataError); ok {
+			m.Ban(p.Addr, err)
+		} else {
+			m.Drop(p.Addr, err)
+		}
+		failures++
+		if failures == maxFailovers {
+			return lastID, fmt.Errorf("%d peers failed in a row, the last one: %v", failures, err)
+		}
+	}
+}
+
+func (m *PeerManager) downloadFrom(ctx context.Context, p *Peer, bchan chan *types.Block, history [32]types.BlockID) (types.BlockID, error) {
+	stream, err := p.Sess.OpenStream()
+	if err != nil {
+		return history[0], err
+	}
+	ws := &watchedStream{Stream: stream, timeout: m.StallTimeout}
+	inner := make(chan *types.Block)
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := DownloadBlocksFromHistory(ctx, inner, ws, history)
+		close(inner)
+		errChan <- err
+	}()
+	known := make(map[types.BlockID]bool)
+	for _, id := range history {
+		if id != (types.BlockID{}) {
+			known[id] = true
+		}
+	}
+	lastID := history[0]
+	var stop error
+	for block := range inner {
+		if stop != nil {
+			continue
+		}
+		if !known[block.ParentID] {
+			stop = &invalidDataError{fmt.Errorf("block %s does not follow known blocks", block.ID())}
+			ws.Close()
+			continue
+		}
+		if m.Check != nil {
+			if err := m.Check(block); err != nil {
+				if s, ok := err.(staleError); ok && s.Stale() {
+					stop = fmt.Errorf("can not check block %s: %v", block.ID(), err)
+				} else {
+					stop = &invalidDataError{fmt.Errorf("block %s failed the check: %v", block.ID(), err)}
+				}
+				ws.Close()
+				continue
+			}
+		}
+		select {
+		case bchan <- block:
+		case <-ctx.Done():
+			stop = ctx.Err()
+			ws.Close()
+			continue
+		}
+		lastID = block.ID()
+		known = map[types.BlockID]bool{lastID: true}
+	}
+	err = <-errChan
+	ws.Close()
+	if stop != nil {
+		return lastID, stop
+	}
+	if err == nil {
+		err = ws.Err()
+	}
+	return lastID, err
+}
+
+// extendHistory returns history starting with the ID of a block which
+// follows history[0].
+func extendHistory(history [32]types.BlockID, id types.BlockID) [32]types.BlockID {
+	var extended [32]types.BlockID
+	extended[0] = id
+	copy(extended[1:31], history[:30])
+	extended[31] = types.GenesisID
+	return extended
+}
+
+// watchedStream fails reads and writes waiting longer than timeout and
+// remembers the first error of the stream.
+type watchedStream struct {
+	*smux.Stream
+	timeout time.Duration
+
+	mu  sync.Mutex
+	err error
+}
+
+func (s *watchedStream) Read(b []byte) (int, error) {
+	s.Stream.SetReadDeadline(time.Now().Add(s.timeout))
+	n, err := s.Stream.Read(b)
+	s.setErr(err)
+	return n, err
+}
+
+func (s *watchedStream) Write(b []byte) (int, error) {
+	s.Stream.SetWriteDeadline(time.Now().Add(s.timeout))
+	n, err := s.Stream.Write(b)
+	s.setErr(err)
+	return n, err
+}
+
+func (s *watchedStream) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *watchedStream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
@@ -0,0 +1,210 @@
+package netlib
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/xtaci/smux"
+	"gitlab.com/NebulousLabs/Sia/build"
+	"gitlab.com/NebulousLabs/Sia/encoding"
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// Oldest version of Sia using the handshake implemented here.
+const minPeerVersion = "1.3.1"
+
+// BlockSource is a chain served to peers by Server, e.g. ChainFile.
+type BlockSource interface {
+	// BlocksAfter returns up to n blocks following the latest block of
+	// the history found in the chain and if more blocks follow them.
+	// The lookup and the blocks use the same state of the chain, so the
+	// blocks follow the block of the history even if the chain changes
+	// meanwhile. If no block of the history is found, no blocks are
+	// returned.
+	BlocksAfter(history [32]types.BlockID, n int) ([]types.Block, bool, error)
+	// Height returns the height of the block if it is in the chain.
+	Height(id types.BlockID) (int, bool)
+	// Block returns the block at the height.
+	Block(height int) (*types.Block, error)
+}
+
+// Server accepts connections of Sia nodes and serves blocks of Source
+// with SendBlocks and SendBlk RPCs, so the nodes can download the chain
+// from it like from siad.
+type Server struct {
+	Source   BlockSource
+	Identity Identity
+	// Nodes, if set, returns nodes for ShareNodes RPC.
+	Nodes func() []modules.NetAddress
+	// Handlers handle other RPCs, e.g. RelayHeader.
+	Handlers map[string]RPCHandler
+}
+
+// ListenerIdentity returns a new identity with the port of ln. Peers
+// combine it with the address of the connection to connect back.
+func ListenerIdentity(ln net.Listener) Identity {
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return NewIdentity(modules.NetAddress(net.JoinHostPort("127.0.0.1", port)))
+}
+
+// Serve accepts connections from ln until ctx is done. Then ln and
+// the sessions are closed.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	stop := watchContext(ctx, ln)
+	defer stop()
+	handlers := map[string]RPCHandler{
+		"SendBlocks": s.sendBlocks,
+		"SendBlk":    s.sendBlk,
+		"ShareNodes": s.shareNodes,
+	}
+	for name, handler := range s.Handlers {
+		handlers[name] = handler
+	}
+	for {
+		conn, err := ln.Accept()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
+			return err
+		}
+		go s.serveConn(ctx, conn, handlers)
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn, handlers map[string]RPCHandler) {
+	stop := watchContext(ctx, conn)
+	err := acceptHandshake(ctx, conn, s.Identity)
+	stop()
+	if err != nil {
+		log.Printf("handshake with %s: %v.", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	sess, err := smux.Server(conn, nil)
+	if err != nil {
+		log.Printf("smux.Server for %s: %v.", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	defer sess.Close()
+	log.Printf("Serving %s.", conn.RemoteAddr())
+	if err := AcceptRPCs(ctx, sess, handlers); err != nil && ctx.Err() == nil {
+		log.Printf("session with %s: %v.", conn.RemoteAddr(), err)
+	}
+}
+
+// acceptHandshake is the side of handshake accepting the connection.
+func acceptHandshake(ctx context.Context, conn net.Conn, identity Identity) error {
+	setDeadline(ctx, conn, handshakeTimeout)
+	defer conn.SetDeadline(time.Time{})
+	var version string
+	if err := encoding.ReadObject(conn, &version, build.MaxEncodedVersionLength); err != nil {
+		return err
+	}
+	if !build.IsVersion(version) || build.VersionCmp(version, minPeerVersion) < 0 {
+		encoding.WriteObject(conn, "reject")
+		return fmt.Errorf("unsupported version %q", version)
+	}
+	if err := encoding.WriteObject(conn, build.Version); err != nil {
+		return err
+	}
+	var sh sessionHeader
+	if err := encoding.ReadObject(conn, &sh, 100); err != nil {
+		return err
+	}
+	if sh.GenesisID != types.GenesisID {
+		encoding.WriteObject(conn, "peer has different genesis ID")
+		return fmt.Errorf("peer has different genesis ID")
+	} else if sh.UniqueID == identity.UniqueID {
+		encoding.WriteObject(conn, "can't connect to our own address")
+		return fmt.Errorf("connected to ourselves")
+	} else if err := sh.NetAddress.IsStdValid(); err != nil {
+		encoding.WriteObject(conn, "invalid remote address")
+		return fmt.Errorf("invalid remote address: %v", err)
+	}
+	if err := encoding.WriteObject(conn, modules.AcceptResponse); err != nil {
+		return err
+	}
+	sh = sessionHeader{
+		GenesisID:  types.GenesisID,
+		UniqueID:   identity.UniqueID,
+		NetAddress: identity.NetAddress,
+	}
+	if err := encoding.WriteObject(conn, sh); err != nil {
+		return err
+	}
+	var response string
+	if err := encoding.ReadObject(conn, &response, 100); err != nil {
+		return fmt.Errorf("failed to read header acceptance: %v", err)
+	} else if response != modules.AcceptResponse {
+		return fmt.Errorf("peer rejected our header: %v", response)
+	}
+	return nil
+}
+
+// sendBlocks answers SendBlocks RPC with the blocks following the latest
+// block of the history found in Source. If none is found, no blocks are
+// sent, like in Sia. Each batch follows the blocks sent before, so if
+// the chain is reorganized meanwhile, the peer gets a fork.
+func (s *Server) sendBlocks(stream *smux.Stream) {
+	var history [32]types.BlockID
+	if err := encoding.ReadObject(stream, &history, 32*32); err != nil {
+		log.Printf("SendBlocks: reading history: %v.", err)
+		return
+	}
+	for more := true; more; {
+		stream.SetDeadline(time.Now().Add(batchTimeout))
+		blocks, m, err := s.Source.BlocksAfter(history, catchUpBlocks)
+		if err != nil {
+			log.Printf("SendBlocks: %v.", err)
+			return
+		}
+		if len(blocks) != 0 {
+			history = extendHistory(history, blocks[len(blocks)-1].ID())
+		}
+		more = m
+		if err := encoding.WriteObject(stream, blocks); err != nil {
+			return
+		}
+		if err := encoding.WriteObject(stream, more); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) sendBlk(stream *smux.Stream) {
+	var id types.BlockID
+	if err := encoding.ReadObject(stream, &id, 32); err != nil {
+		log.Printf("SendBlk: reading ID: %v.", err)
+		return
+	}
+	height, has := s.Source.Height(id)
+	if !has {
+		return
+	}
+	block, err := s.Source.Block(height)
+	if err != nil {
+		log.Printf("SendBlk: block %d: %v.", height, err)
+		return
+	}
+	if block.ID() != id {
+		// The chain was reorganized after the lookup.
+		return
+	}
+	encoding.WriteObject(stream, *block)
+}
+
+func (s *Server) shareNodes(stream *smux.Stream) {
+	var nodes []modules.NetAddress
+	if s.Nodes != nil {
+		nodes = s.Nodes()
+	}
+	if len(nodes) > maxSharedNodes {
+		nodes = nodes[:maxSharedNodes]
+	}
+	encoding.WriteObject(stream, nodes)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/starius/sialite/human"
+	"gitlab.com/NebulousLabs/Sia/crypto"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// Kinds of objects found by search, in the order of ranking.
+var searchKinds = []string{
+	human.SearchBlock,
+	human.SearchTransaction,
+	human.SearchContract,
+	human.SearchSiacoinOutput,
+	human.SearchSiafundOutput,
+	human.SearchAddress,
+	human.SearchOrphan,
+}
+
+// search finds objects by a block height, a prefix of hex ID or
+// an address with checksum. Returns up to limit matches: exact matches
+// first, then other matches ranked by kind.
+func (db *Database) search(q string, limit int) *human.SearchResults {
+	q = strings.ToLower(strings.TrimSpace(q))
+	results := &human.SearchResults{
+		Query:   q,
+		Matches: []human.SearchMatch{},
+	}
+	// Leading zeros are common in block IDs, so "000" is not a height.
+	if height, err := strconv.Atoi(q); err == nil && strconv.Itoa(height) == q && height >= 0 && height < db.numBlocks() {
+		results.Matches = append(results.Matches, human.SearchMatch{
+			Type:   human.SearchBlock,
+			ID:     db.blockID(height).String(),
+			Height: &height,
+			Exact:  true,
+		})
+	}
+	prefix := q
+	if len(q) == len(types.UnlockHash{}.String()) {
+		var address types.UnlockHash
+		if err := address.LoadString(q); err == nil {
+			prefix = q[:2*crypto.HashSize]
+		}
+	}
+	if _, err := hex.DecodeString(prefix + strings.Repeat("0", len(prefix)%2)); err == nil && prefix != "" && len(prefix) <= 2*crypto.HashSize {
+		exact := len(prefix) == 2*crypto.HashSize
+		for _, kind := range searchKinds {
+			for _, id := range db.idsWithPrefix(kind, prefix, limit) {
+				m := human.SearchMatch{
+					Type:  kind,
+					ID:    id.String(),
+					Exact: exact,
+				}
+				switch kind {
+				case human.SearchBlock:
+					height, _ := db.blockHeight(types.BlockID(id))
+					m.Height = &height
+				case human.SearchOrphan:
+					orphan, _ := db.orphan(types.BlockID(id))
+					m.Height = &orphan.Height
+				case human.SearchAddress:
+					m.ID = types.UnlockHash(id).String()
+				}
+				results.Matches = append(results.Matches, m)
+			}
+		}
+	}
+	sort.SliceStable(results.Matches, func(i, j int) bool {
+		return results.Matches[i].Exact && !results.Matches[j].Exact
+	})
+	if len(results.Matches) > limit {
+		results.Matches = results.Matches[:limit]
+	}
+	return results
+}
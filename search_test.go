@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/starius/sialite/human"
+	"github.com/starius/sialite/netlib/fakepeer"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+func searchDB(t *testing.T, db *Database, q string, limit int) (results *human.SearchResults) {
+	t.Helper()
+	err := db.view(func(db *Database) error {
+		results = db.search(q, limit)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("db.view: %v", err)
+	}
+	return
+}
+
+// hasMatch returns if the matches include the object.
+func hasMatch(matches []human.SearchMatch, kind, id string, exact bool) bool {
+	for _, m := range matches {
+		if m.Type == kind && m.ID == id && m.Exact == exact {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSearch(t *testing.T) {
+	blocks := readTestBlocks(t)[:300]
+	db := openTestDatabase(t)
+	skipWork(db)
+	addTestBlocks(t, db, blocks)
+	// Block 299 becomes an orphan.
+	fork := fakepeer.Fork(blocks, 298, 2)
+	if err := db.addBlocks([]*types.Block{&fork[299], &fork[300]}); err != nil {
+		t.Fatalf("addBlocks(fork): %v", err)
+	}
+
+	block := &blocks[10]
+	tx := blocks[5].Transactions[0]
+	address := block.MinerPayouts[0].UnlockHash
+	scoID := block.MinerPayoutID(0).String()
+	for _, tc := range []struct {
+		q    string
+		kind string
+		id   string
+		// Height is checked for blocks and orphans.
+		height int
+	}{
+		{q: block.ID().String(), kind: human.SearchBlock, id: block.ID().String(), height: 10},
+		{q: tx.ID().String(), kind: human.SearchTransaction, id: tx.ID().String()},
+		{q: scoID, kind: human.SearchSiacoinOutput, id: scoID},
+		{q: address.String(), kind: human.SearchAddress, id: address.String()},
+		{q: " " + strings.ToUpper(address.String()) + " ", kind: human.SearchAddress, id: address.String()},
+		{q: blocks[299].ID().String(), kind: human.SearchOrphan, id: blocks[299].ID().String(), height: 299},
+	} {
+		results := searchDB(t, db, tc.q, 10)
+		if len(results.Matches) == 0 {
+			t.Errorf("search(%q) found nothing", tc.q)
+			continue
+		}
+		m := results.Matches[0]
+		if m.Type != tc.kind || m.ID != tc.id || !m.Exact {
+			t.Errorf("search(%q) = %+v, want exact %s %s", tc.q, m, tc.kind, tc.id)
+		}
+		if (tc.kind == human.SearchBlock || tc.kind == human.SearchOrphan) && (m.Height == nil || *m.Height != tc.height) {
+			t.Errorf("search(%q) returned height %v, want %d", tc.q, m.Height, tc.height)
+		}
+	}
+
+	// Prefixes, including odd ones, find objects in all the buckets.
+	for _, tc := range []struct {
+		kind, id string
+	}{
+		{human.SearchTransaction, tx.ID().String()},
+		{human.SearchSiacoinOutput, scoID},
+		{human.SearchAddress, address.String()},
+		{human.SearchOrphan, blocks[299].ID().String()},
+	} {
+		for _, n := range []int{15, 16} {
+			q := tc.id[:n]
+			if !hasMatch(searchDB(t, db, q, 100).Matches, tc.kind, tc.id, false) {
+				t.Errorf("search(%q) does not find %s %s", q, tc.kind, tc.id)
+			}
+		}
+	}
+
+	// A height is an exact match ranked before the IDs starting with it.
+	results := searchDB(t, db, "10", 100)
+	if len(results.Matches) < 2 {
+		t.Fatalf("search(10) found %d matches, want the block and IDs", len(results.Matches))
+	}
+	if m := results.Matches[0]; m.Type != human.SearchBlock || m.ID != block.ID().String() || !m.Exact || *m.Height != 10 {
+		t.Errorf("search(10) = %+v, want block 10", m)
+	}
+	for _, m := range results.Matches[1:] {
+		if m.Exact || !strings.HasPrefix(m.ID, "10") {
+			t.Errorf("search(10) returned %+v", m)
+		}
+	}
+	// Leading zeros and heights above the chain are not heights.
+	for _, q := range []string{"010", "1000"} {
+		for _, m := range searchDB(t, db, q, 100).Matches {
+			if m.Exact {
+				t.Errorf("search(%q) returned exact match %+v", q, m)
+			}
+		}
+	}
+
+	// A short prefix is ambiguous: matches are limited and ranked by kind.
+	rank := make(map[string]int)
+	for i, kind := range searchKinds {
+		rank[kind] = i
+	}
+	for _, q := range []string{"a", "b", "c"} {
+		matches := searchDB(t, db, q, 20).Matches
+		if len(matches) != 20 {
+			t.Errorf("search(%q) found %d matches, want the limit", q, len(matches))
+		}
+		for i, m := range matches {
+			if m.Exact {
+				t.Errorf("search(%q) returned exact match %+v", q, m)
+			}
+			if i != 0 && rank[m.Type] < rank[matches[i-1].Type] {
+				t.Errorf("search(%q) ranks %s after %s", q, m.Type, matches[i-1].Type)
+			}
+		}
+	}
+
+	// Queries which are neither heights nor hex prefixes.
+	for _, q := range []string{"", "  ", "zz", "-1", block.ID().String() + "0", address.String()[:75] + "x"} {
+		if matches := searchDB(t, db, q, 10).Matches; len(matches) != 0 {
+			t.Errorf("search(%q) = %+v, want nothing", q, matches)
+		}
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	blocks := readTestBlocks(t)[:20]
+	db := openTestDatabase(t)
+	addTestBlocks(t, db, blocks)
+	router := httprouter.New()
+	db.addHandlers(router)
+	for _, tc := range []struct {
+		path   string
+		status int
+	}{
+		{"/search?q=10", http.StatusOK},
+		{"/search?q=10&limit=100", http.StatusOK},
+		{"/search?q=10&limit=0", http.StatusBadRequest},
+		{"/search?q=10&limit=101", http.StatusBadRequest},
+		{"/search?q=10&limit=x", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.status {
+			t.Errorf("GET %s: status %d, want %d: %s", tc.path, w.Code, tc.status, w.Body)
+		}
+	}
+}
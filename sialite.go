@@ -1,28 +1,51 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/coreos/bbolt"
 	"github.com/julienschmidt/httprouter"
+	"github.com/starius/sialite/cache"
+	"github.com/starius/sialite/human"
 	"github.com/starius/sialite/netlib"
-	"github.com/xtaci/smux"
+	"gitlab.com/NebulousLabs/Sia/encoding"
+	"gitlab.com/NebulousLabs/Sia/modules"
 	"gitlab.com/NebulousLabs/Sia/types"
 )
 
 var (
-	addr       = flag.String("addr", ":8080", "HTTP API address")
-	blockchain = flag.String("blockchain", "", "Input file with blockchain")
-	source     = flag.String("source", "", "Source of data (siad node)")
-	files      = flag.String("files", "", "Dir to write files")
-	nblocks    = flag.Int("nblocks", 0, "Approximate max number of blocks (0 = all)")
+	addr        = flag.String("addr", ":8080", "HTTP API address")
+	blockchain  = flag.String("blockchain", "", "Input file with blockchain")
+	source      = flag.String("source", "", "Source of data (siad node)")
+	files       = flag.String("files", "", "Dir to write files")
+	nblocks     = flag.Int("nblocks", 0, "Approximate max number of blocks (0 = all)")
+	dbPath      = flag.String("db", "sialite.db", "Database file")
+	cacheSize   = flag.Int("block-cache", 1000, "Number of decoded blocks to keep in memory")
+	batchSize   = flag.Int("batch", 1000, "Number of blocks added in one transaction of database")
+	reorgDepth  = flag.Int("reorg-depth", 1000, "Max number of blocks which can be disconnected by a reorganization")
+	cacheDir    = flag.String("cache", "", "Serve read-only from cache directory built by sialitebuilder -explorer instead of the database")
+	readIndices = flag.Bool("cache-read-indices", false, "Read the indices of -cache from files when needed instead of mapping them to memory")
+	cachePages  = flag.Int("cache-index-pages", 1024, "Number of pages of each index of -cache to keep in memory with -cache-read-indices")
+	mempoolSize = flag.Int("mempool", 10000, "Max number of unconfirmed transactions to keep")
+	dataPrefix  = flag.String("data-prefixes", "", "Comma-separated prefixes of ArbitraryData to show as text or hex, e.g. MyApp,Other")
+	exportDir   = flag.String("export", "", "Write the index of the database or -cache to CSV files in the directory and exit")
+	numPeers    = flag.Int("peers", 4, "Number of nodes to keep connections with")
+	gateway     = flag.String("gateway", "", "Address to serve Sia gateway protocol on (e.g. :9981), so nodes can download blocks from sialite")
 )
 
+// Relayed headers trigger fetching blocks at most this often.
+const relayFetchInterval = time.Second
+
 const (
 	// For SiacoinOutput.nature.
 	siacoinOutput               = iota
@@ -50,380 +73,792 @@ func natureStr(nature int) string {
 	} else if nature == siaClaimOutput {
 		return "sia_claim_output"
 	} else {
-		panic("unknown nature")
+		failIndex(fmt.Errorf("unknown nature %d", nature))
+		return ""
 	}
 }
 
+// TxLocation is the location of a transaction in the blockchain.
+type TxLocation struct {
+	Block int // Height of the block.
+	Tx    int // Index of the transaction in the block.
+}
+
 type SiacoinOutput struct {
-	tx     *types.Transaction // Empty for MinerPayouts.
-	block  *types.Block
-	nature int
-	index  int // Index of SiacoinOutput in slice.
-	index0 int // Index of FileContract or FileContractRevision.
-}
-
-func (o *SiacoinOutput) ID() types.SiacoinOutputID {
-	if o.nature == siacoinOutput {
-		return o.tx.SiacoinOutputID(uint64(o.index))
-	} else if o.nature == minerPayout {
-		return o.block.MinerPayoutID(uint64(o.index))
-	} else if o.nature == validProofOutput {
-		return o.tx.FileContractID(uint64(o.index0)).StorageProofOutputID(types.ProofValid, uint64(o.index))
-	} else if o.nature == missedProofOutput {
-		return o.tx.FileContractID(uint64(o.index0)).StorageProofOutputID(types.ProofMissed, uint64(o.index))
-	} else if o.nature == validProofOutputInRevision {
-		return o.tx.FileContractRevisions[o.index0].ParentID.StorageProofOutputID(types.ProofValid, uint64(o.index))
-	} else if o.nature == missedProofOutputInRevision {
-		return o.tx.FileContractRevisions[o.index0].ParentID.StorageProofOutputID(types.ProofMissed, uint64(o.index))
-	} else if o.nature == siaClaimOutput {
-		return o.tx.SiafundInputs[o.index].ParentID.SiaClaimOutputID()
+	TxLocation // Tx is -1 for MinerPayouts.
+
+	Nature int
+	Index  int // Index of SiacoinOutput in slice.
+	Index0 int // Index of FileContract or FileContractRevision.
+}
+
+func (o *SiacoinOutput) ID(db *Database) types.SiacoinOutputID {
+	if o.Nature == minerPayout {
+		return db.blockAt(o.Block).MinerPayoutID(uint64(o.Index))
+	}
+	tx := db.tx(o.TxLocation)
+	if o.Nature == siacoinOutput {
+		return tx.SiacoinOutputID(uint64(o.Index))
+	} else if o.Nature == validProofOutput {
+		return tx.FileContractID(uint64(o.Index0)).StorageProofOutputID(types.ProofValid, uint64(o.Index))
+	} else if o.Nature == missedProofOutput {
+		return tx.FileContractID(uint64(o.Index0)).StorageProofOutputID(types.ProofMissed, uint64(o.Index))
+	} else if o.Nature == validProofOutputInRevision {
+		return tx.FileContractRevisions[o.Index0].ParentID.StorageProofOutputID(types.ProofValid, uint64(o.Index))
+	} else if o.Nature == missedProofOutputInRevision {
+		return tx.FileContractRevisions[o.Index0].ParentID.StorageProofOutputID(types.ProofMissed, uint64(o.Index))
+	} else if o.Nature == siaClaimOutput {
+		return tx.SiafundInputs[o.Index].ParentID.SiaClaimOutputID()
 	} else {
-		panic("Unknown nature")
+		failIndex(fmt.Errorf("unknown nature %d", o.Nature))
+		return types.SiacoinOutputID{}
 	}
 }
 
 func (o *SiacoinOutput) Value(db *Database) *types.SiacoinOutput {
-	if o.nature == siacoinOutput {
-		return &o.tx.SiacoinOutputs[o.index]
-	} else if o.nature == minerPayout {
-		return &o.block.MinerPayouts[o.index]
-	} else if o.nature == validProofOutput {
-		return &o.tx.FileContracts[o.index0].ValidProofOutputs[o.index]
-	} else if o.nature == missedProofOutput {
-		return &o.tx.FileContracts[o.index0].MissedProofOutputs[o.index]
-	} else if o.nature == validProofOutputInRevision {
-		return &o.tx.FileContractRevisions[o.index0].NewValidProofOutputs[o.index]
-	} else if o.nature == missedProofOutputInRevision {
-		return &o.tx.FileContractRevisions[o.index0].NewMissedProofOutputs[o.index]
-	} else if o.nature == siaClaimOutput {
-		sfi := &o.tx.SiafundInputs[o.index]
+	if o.Nature == minerPayout {
+		return &db.blockAt(o.Block).MinerPayouts[o.Index]
+	}
+	tx := db.tx(o.TxLocation)
+	if o.Nature == siacoinOutput {
+		return &tx.SiacoinOutputs[o.Index]
+	} else if o.Nature == validProofOutput {
+		return &tx.FileContracts[o.Index0].ValidProofOutputs[o.Index]
+	} else if o.Nature == missedProofOutput {
+		return &tx.FileContracts[o.Index0].MissedProofOutputs[o.Index]
+	} else if o.Nature == validProofOutputInRevision {
+		return &tx.FileContractRevisions[o.Index0].NewValidProofOutputs[o.Index]
+	} else if o.Nature == missedProofOutputInRevision {
+		return &tx.FileContractRevisions[o.Index0].NewMissedProofOutputs[o.Index]
+	} else if o.Nature == siaClaimOutput {
+		sfi := &tx.SiafundInputs[o.Index]
 		sfoid := sfi.ParentID
-		sfo := db.id2sfo[sfoid]
-		block1 := db.block2height[db.tx2block[sfo.tx]]
-		block2 := db.block2height[db.tx2block[o.tx]] - 1
+		sfo, _ := db.sfo(sfoid)
+		block1 := sfo.Block
+		block2 := o.Block - 1
 		value := types.NewCurrency64(0)
 		if block2 > block1 {
-			diff := db.height2sfpool[block2].Sub(db.height2sfpool[block1])
-			value = diff.Mul(sfo.Value().Value).Div64(10000)
+			diff := db.sfpool(block2).Sub(db.sfpool(block1))
+			value = diff.Mul(sfo.Value(db).Value).Div64(10000)
 		}
 		return &types.SiacoinOutput{
 			UnlockHash: sfi.ClaimUnlockHash,
 			Value:      value,
 		}
 	} else {
-		panic("Unknown nature")
+		failIndex(fmt.Errorf("unknown nature %d", o.Nature))
+		return nil
 	}
 }
 
 type SiafundOutput struct {
-	tx    *types.Transaction
-	index int // Index of SiafundOutput in slice.
+	TxLocation
+	Index int // Index of SiafundOutput in slice.
 }
 
-func (o *SiafundOutput) ID() types.SiafundOutputID {
-	return o.tx.SiafundOutputID(uint64(o.index))
+func (o *SiafundOutput) ID(db *Database) types.SiafundOutputID {
+	return db.tx(o.TxLocation).SiafundOutputID(uint64(o.Index))
 }
 
-func (o *SiafundOutput) Value() *types.SiafundOutput {
-	return &o.tx.SiafundOutputs[o.index]
+func (o *SiafundOutput) Value(db *Database) *types.SiafundOutput {
+	return &db.tx(o.TxLocation).SiafundOutputs[o.Index]
 }
 
 type SiacoinInput struct {
-	tx    *types.Transaction
-	index int // Index of SiacoinInput in slice.
+	TxLocation
+	Index int // Index of SiacoinInput in slice.
 }
 
-func (i *SiacoinInput) ID() types.SiacoinOutputID {
-	return i.Value().ParentID
+func (i *SiacoinInput) ID(db *Database) types.SiacoinOutputID {
+	return i.Value(db).ParentID
 }
 
-func (i *SiacoinInput) Value() types.SiacoinInput {
-	return i.tx.SiacoinInputs[i.index]
+func (i *SiacoinInput) Value(db *Database) *types.SiacoinInput {
+	return &db.tx(i.TxLocation).SiacoinInputs[i.Index]
 }
 
 type SiafundInput struct {
-	tx    *types.Transaction
-	index int // Index of SiafundInput in slice.
+	TxLocation
+	Index int // Index of SiafundInput in slice.
 }
 
-func (i *SiafundInput) ID() types.SiafundOutputID {
-	return i.Value().ParentID
+func (i *SiafundInput) ID(db *Database) types.SiafundOutputID {
+	return i.Value(db).ParentID
 }
 
-func (i *SiafundInput) Value() types.SiafundInput {
-	return i.tx.SiafundInputs[i.index]
+func (i *SiafundInput) Value(db *Database) *types.SiafundInput {
+	return &db.tx(i.TxLocation).SiafundInputs[i.Index]
 }
 
 type Contract struct {
-	tx    *types.Transaction
-	index int // Index of FileContract in slice.
+	TxLocation
+	Index int // Index of FileContract in slice.
 }
 
-func (c *Contract) Value() *types.FileContract {
-	return &c.tx.FileContracts[c.index]
+func (c *Contract) Value(db *Database) *types.FileContract {
+	return &db.tx(c.TxLocation).FileContracts[c.Index]
 }
 
 type ContractRev struct {
-	tx    *types.Transaction
-	index int // Index of FileContractRevision in slice.
+	TxLocation
+	Index int // Index of FileContractRevision in slice.
 }
 
-func (c *ContractRev) Value() *types.FileContractRevision {
-	return &c.tx.FileContractRevisions[c.index]
+func (c *ContractRev) Value(db *Database) *types.FileContractRevision {
+	return &db.tx(c.TxLocation).FileContractRevisions[c.Index]
 }
 
 type StorageProof struct {
-	tx    *types.Transaction
-	index int // Index of StorageProof in slice.
+	TxLocation
+	Index int // Index of StorageProof in slice.
 }
 
-func (s *StorageProof) Value() *types.StorageProof {
-	return &s.tx.StorageProofs[s.index]
+func (s *StorageProof) Value(db *Database) *types.StorageProof {
+	return &db.tx(s.TxLocation).StorageProofs[s.Index]
 }
 
 type ContractHistory struct {
-	contract Contract
-	revs     []ContractRev
-	proof    *StorageProof
+	Contract Contract
+	Revs     []ContractRev
+	Proof    *StorageProof
+}
+
+// MarshalSia encodes the history as encoding.Marshal would if it did not
+// fail on nil pointers: Proof is prepended with a flag.
+func (h ContractHistory) MarshalSia(w io.Writer) error {
+	e := encoding.NewEncoder(w)
+	if err := e.EncodeAll(h.Contract, h.Revs, h.Proof != nil); err != nil {
+		return err
+	}
+	if h.Proof != nil {
+		return e.Encode(*h.Proof)
+	}
+	return nil
+}
+
+// Orphan is a block disconnected from the main chain by a reorganization.
+type Orphan struct {
+	Height int
+	Block  types.Block
 }
 
+// Database is the index of the blockchain stored in bolt database or,
+// read-only, in cache directory. Methods reading or writing the index
+// must be called on the copy of Database passed to the callback of view
+// or update.
 type Database struct {
-	block2height  map[*types.Block]int
-	block2id      map[*types.Block]types.BlockID
-	height2block  []*types.Block
-	height2sfpool []types.Currency
-	id2block      map[types.BlockID]*types.Block
-	id2tx         map[types.TransactionID]*types.Transaction
-	tx2block      map[*types.Transaction]*types.Block
-	id2sco        map[types.SiacoinOutputID]*SiacoinOutput
-	id2sfo        map[types.SiafundOutputID]*SiafundOutput
-	id2sci        map[types.SiacoinOutputID]*SiacoinInput
-	id2sfi        map[types.SiafundOutputID]*SiafundInput
-	address2sco   map[types.UnlockHash][]*SiacoinOutput
-	address2sfo   map[types.UnlockHash][]*SiafundOutput
-	id2history    map[types.FileContractID]*ContractHistory
-
-	mu sync.RWMutex
-}
-
-func NewDatabase() *Database {
-	return &Database{
-		block2height: make(map[*types.Block]int),
-		block2id:     make(map[*types.Block]types.BlockID),
-		id2block:     make(map[types.BlockID]*types.Block),
-		id2tx:        make(map[types.TransactionID]*types.Transaction),
-		tx2block:     make(map[*types.Transaction]*types.Block),
-		id2sco:       make(map[types.SiacoinOutputID]*SiacoinOutput),
-		id2sfo:       make(map[types.SiafundOutputID]*SiafundOutput),
-		id2sci:       make(map[types.SiacoinOutputID]*SiacoinInput),
-		id2sfi:       make(map[types.SiafundOutputID]*SiafundInput),
-		address2sco:  make(map[types.UnlockHash][]*SiacoinOutput),
-		address2sfo:  make(map[types.UnlockHash][]*SiafundOutput),
-		id2history:   make(map[types.FileContractID]*ContractHistory),
-	}
-}
-
-func (db *Database) addSco(o *SiacoinOutput) {
-	db.id2sco[o.ID()] = o
-	a := o.Value(db).UnlockHash
-	db.address2sco[a] = append(db.address2sco[a], o)
+	index // Bound by view and update.
+
+	bdb     *bolt.DB
+	btx     *bolt.Tx
+	server  *cache.Server
+	blocks  *blockCache
+	undo    *[]undoRecord   // Set while addBlock runs, see put.
+	events  *[]*human.Event // Set while addBlocks runs if hub is active.
+	mempool *Mempool
+	hub     *Hub
+
+	// verify checks a block before addBlock adds it at the height.
+	// It is verifyBlock; tests replace it to skip proof of work.
+	verify func(db *Database, height int, block *types.Block) error
+
+	mu *sync.RWMutex
+}
+
+func (db *Database) addSco(o *SiacoinOutput) error {
+	if err := db.putSco(o); err != nil {
+		return err
+	}
+	return db.addAddressSco(o.Value(db).UnlockHash, o.ID(db))
 }
 
-func (db *Database) addSfo(o *SiafundOutput) {
-	db.id2sfo[o.ID()] = o
-	a := o.Value().UnlockHash
-	db.address2sfo[a] = append(db.address2sfo[a], o)
+// putSco stores the output without adding it to the history of the
+// address. It is used for storage proof outputs, see indexProofOutputs.
+func (db *Database) putSco(o *SiacoinOutput) error {
+	id := o.ID(db)
+	return db.put(bucketScos, id[:], *o)
 }
 
-func (db *Database) addSci(i *SiacoinInput) {
-	db.id2sci[i.ID()] = i
+func (db *Database) addAddressSco(a types.UnlockHash, id types.SiacoinOutputID) error {
+	n := db.addressScosLen(a)
+	if err := db.put(bucketAddressScos, addressKey(a, n), id); err != nil {
+		return err
+	}
+	return db.put(bucketAddressScos, a[:], n+1)
+}
+
+func (db *Database) addSfo(o *SiafundOutput) error {
+	id := o.ID(db)
+	if err := db.put(bucketSfos, id[:], *o); err != nil {
+		return err
+	}
+	a := o.Value(db).UnlockHash
+	n := db.addressSfosLen(a)
+	if err := db.put(bucketAddressSfos, addressKey(a, n), id); err != nil {
+		return err
+	}
+	return db.put(bucketAddressSfos, a[:], n+1)
 }
 
-func (db *Database) addSfi(i *SiafundInput) {
-	db.id2sfi[i.ID()] = i
-	db.addSco(&SiacoinOutput{
-		tx:     i.tx,
-		block:  db.tx2block[i.tx],
-		nature: siaClaimOutput,
-		index:  i.index,
+func (db *Database) addSci(i *SiacoinInput) error {
+	id := i.ID(db)
+	return db.put(bucketScis, id[:], *i)
+}
+
+func (db *Database) addSfi(i *SiafundInput) error {
+	id := i.ID(db)
+	if err := db.put(bucketSfis, id[:], *i); err != nil {
+		return err
+	}
+	return db.addSco(&SiacoinOutput{
+		TxLocation: i.TxLocation,
+		Nature:     siaClaimOutput,
+		Index:      i.Index,
 	})
 }
 
+func (db *Database) putContract(fcid types.FileContractID, h *ContractHistory) error {
+	return db.put(bucketContracts, fcid[:], *h)
+}
+
 func (db *Database) addBlock(block *types.Block) error {
-	height := len(db.height2block)
-	db.height2block = append(db.height2block, block)
 	id := block.ID()
+	if _, has := db.blockHeight(id); has {
+		// Already known, e.g. when resuming from the beginning of the file.
+		return nil
+	}
+	height := db.numBlocks()
+	if height != 0 && block.ParentID != db.blockID(height-1) {
+		return fmt.Errorf("block %s does not extend the last block %s", id, db.blockID(height-1))
+	}
+	if err := db.verify(db, height, block); err != nil {
+		return err
+	}
 	log.Printf("processing block %d %s.", height, id)
-	db.block2height[block] = height
-	db.block2id[block] = id
-	db.id2block[id] = block
+	var undo []undoRecord
+	db.undo = &undo
+	defer func() {
+		db.undo = nil
+	}()
+	if err := db.put(bucketBlocks, heightKey(height), *block); err != nil {
+		return err
+	}
+	header := human.BlockHeader{
+		ID:        id,
+		Nonce:     block.Nonce,
+		Timestamp: block.Timestamp,
+	}
+	if err := db.put(bucketHeaders, heightKey(height), header); err != nil {
+		return err
+	}
+	if err := db.put(bucketHeights, id[:], height); err != nil {
+		return err
+	}
+	if err := db.put(bucketMeta, keyNumBlocks, height+1); err != nil {
+		return err
+	}
+	db.blocks.add(id, block)
 	sfpool := types.NewCurrency64(0)
 	if height != 0 {
-		sfpool = db.height2sfpool[len(db.height2sfpool)-1]
+		sfpool = db.sfpool(height - 1)
 	}
 	for i := range block.MinerPayouts {
-		db.addSco(&SiacoinOutput{
-			block:  block,
-			nature: minerPayout,
-			index:  i,
-		})
+		if err := db.addSco(&SiacoinOutput{
+			TxLocation: TxLocation{Block: height, Tx: -1},
+			Nature:     minerPayout,
+			Index:      i,
+		}); err != nil {
+			return err
+		}
 	}
 	for j := range block.Transactions {
 		tx := &block.Transactions[j]
-		db.id2tx[tx.ID()] = tx
-		db.tx2block[tx] = block
+		loc := TxLocation{Block: height, Tx: j}
+		txid := tx.ID()
+		if err := db.put(bucketTxs, txid[:], loc); err != nil {
+			return err
+		}
 		for i := range tx.SiacoinInputs {
-			db.addSci(&SiacoinInput{
-				tx:    tx,
-				index: i,
-			})
+			if err := db.addSci(&SiacoinInput{
+				TxLocation: loc,
+				Index:      i,
+			}); err != nil {
+				return err
+			}
 		}
 		for i := range tx.SiafundInputs {
-			db.addSfi(&SiafundInput{
-				tx:    tx,
-				index: i,
-			})
+			if err := db.addSfi(&SiafundInput{
+				TxLocation: loc,
+				Index:      i,
+			}); err != nil {
+				return err
+			}
 		}
 		for i := range tx.SiacoinOutputs {
-			db.addSco(&SiacoinOutput{
-				block:  block,
-				tx:     tx,
-				nature: siacoinOutput,
-				index:  i,
-			})
+			if err := db.addSco(&SiacoinOutput{
+				TxLocation: loc,
+				Nature:     siacoinOutput,
+				Index:      i,
+			}); err != nil {
+				return err
+			}
 		}
 		for i := range tx.SiafundOutputs {
-			db.addSfo(&SiafundOutput{
-				tx:    tx,
-				index: i,
-			})
+			if err := db.addSfo(&SiafundOutput{
+				TxLocation: loc,
+				Index:      i,
+			}); err != nil {
+				return err
+			}
 		}
 		for i0, contract := range tx.FileContracts {
 			fcid := tx.FileContractID(uint64(i0))
-			h, has := db.id2history[fcid]
-			if !has {
-				h = &ContractHistory{}
-				db.id2history[fcid] = h
+			h, _ := db.contract(fcid)
+			h.Contract = Contract{
+				TxLocation: loc,
+				Index:      i0,
 			}
-			h.contract = Contract{
-				tx:    tx,
-				index: i0,
+			if err := db.putContract(fcid, h); err != nil {
+				return err
 			}
 			sum := types.NewCurrency64(0)
 			for i, o := range contract.ValidProofOutputs {
-				db.addSco(&SiacoinOutput{
-					tx:     tx,
-					block:  block,
-					nature: validProofOutput,
-					index:  i,
-					index0: i0,
-				})
+				if err := db.putSco(&SiacoinOutput{
+					TxLocation: loc,
+					Nature:     validProofOutput,
+					Index:      i,
+					Index0:     i0,
+				}); err != nil {
+					return err
+				}
 				sum = sum.Add(o.Value)
 			}
 			tax := contract.Payout.Sub(sum)
 			for i := range contract.MissedProofOutputs {
-				db.addSco(&SiacoinOutput{
-					tx:     tx,
-					block:  block,
-					nature: missedProofOutput,
-					index:  i,
-					index0: i0,
-				})
+				if err := db.putSco(&SiacoinOutput{
+					TxLocation: loc,
+					Nature:     missedProofOutput,
+					Index:      i,
+					Index0:     i0,
+				}); err != nil {
+					return err
+				}
 			}
 			sfpool = sfpool.Add(tax)
 		}
 		for i0, rev := range tx.FileContractRevisions {
-			h := db.id2history[rev.ParentID]
-			h.revs = append(h.revs, ContractRev{
-				tx:    tx,
-				index: i0,
+			h, has := db.contract(rev.ParentID)
+			if !has {
+				return fmt.Errorf("revision of unknown contract %s", rev.ParentID)
+			}
+			h.Revs = append(h.Revs, ContractRev{
+				TxLocation: loc,
+				Index:      i0,
 			})
+			if err := db.putContract(rev.ParentID, h); err != nil {
+				return err
+			}
 			for i := range rev.NewValidProofOutputs {
-				db.addSco(&SiacoinOutput{
-					tx:     tx,
-					block:  block,
-					nature: validProofOutputInRevision,
-					index:  i,
-					index0: i0,
-				})
+				if err := db.putSco(&SiacoinOutput{
+					TxLocation: loc,
+					Nature:     validProofOutputInRevision,
+					Index:      i,
+					Index0:     i0,
+				}); err != nil {
+					return err
+				}
 			}
 			for i := range rev.NewMissedProofOutputs {
-				db.addSco(&SiacoinOutput{
-					tx:     tx,
-					block:  block,
-					nature: missedProofOutputInRevision,
-					index:  i,
-					index0: i0,
-				})
+				if err := db.putSco(&SiacoinOutput{
+					TxLocation: loc,
+					Nature:     missedProofOutputInRevision,
+					Index:      i,
+					Index0:     i0,
+				}); err != nil {
+					return err
+				}
 			}
 		}
 		for i0, proof := range tx.StorageProofs {
-			db.id2history[proof.ParentID].proof = &StorageProof{
-				tx:    tx,
-				index: i0,
+			h, has := db.contract(proof.ParentID)
+			if !has {
+				return fmt.Errorf("storage proof of unknown contract %s", proof.ParentID)
+			}
+			h.Proof = &StorageProof{
+				TxLocation: loc,
+				Index:      i0,
+			}
+			if err := db.putContract(proof.ParentID, h); err != nil {
+				return err
+			}
+		}
+	}
+	if err := db.put(bucketSfpools, heightKey(height), sfpool); err != nil {
+		return err
+	}
+	if err := db.trackExpiring(block); err != nil {
+		return err
+	}
+	resolved := db.resolvedContracts(height, block)
+	if err := db.indexProofOutputs(resolved); err != nil {
+		return err
+	}
+	if err := db.addStats(height, block); err != nil {
+		return err
+	}
+	if err := db.addBalances(height, block); err != nil {
+		return err
+	}
+	if err := db.addHosts(height, block); err != nil {
+		return err
+	}
+	db.undo = nil
+	if err := db.put(bucketUndo, heightKey(height), undo); err != nil {
+		return err
+	}
+	if height >= *reorgDepth {
+		if err := db.btx.Bucket(bucketUndo).Delete(heightKey(height - *reorgDepth)); err != nil {
+			return err
+		}
+	}
+	if db.events != nil {
+		*db.events = append(*db.events, db.blockEvents(height, block, resolved)...)
+	}
+	return db.btx.Bucket(bucketOrphans).Delete(id[:])
+}
+
+// removeBlock disconnects the last block: it restores all the records
+// changed by addBlock and saves the block as an orphan.
+func (db *Database) removeBlock() error {
+	height := db.numBlocks() - 1
+	data := db.btx.Bucket(bucketUndo).Get(heightKey(height))
+	if data == nil {
+		return fmt.Errorf("no undo records of block %d, it is deeper than -reorg-depth", height)
+	}
+	var undo []undoRecord
+	if err := encoding.Unmarshal(data, &undo); err != nil {
+		return fmt.Errorf("decoding undo records of block %d: %v", height, err)
+	}
+	block := db.blockAt(height)
+	id := block.ID()
+	log.Printf("disconnecting block %d %s.", height, id)
+	if err := db.put(bucketOrphans, id[:], Orphan{Height: height, Block: *block}); err != nil {
+		return err
+	}
+	for i := len(undo) - 1; i >= 0; i-- {
+		r := undo[i]
+		b := db.btx.Bucket(r.Bucket)
+		if r.Existed {
+			if err := b.Put(r.Key, r.Value); err != nil {
+				return err
 			}
+		} else if err := b.Delete(r.Key); err != nil {
+			return err
 		}
 	}
-	db.height2sfpool = append(db.height2sfpool, sfpool)
+	if err := db.btx.Bucket(bucketUndo).Delete(heightKey(height)); err != nil {
+		return err
+	}
+	if db.events != nil {
+		*db.events = append(*db.events, disconnectedEvent(height, block))
+	}
 	return nil
 }
 
-func processBlocks(ctx context.Context, bchan chan *types.Block) (*Database, error) {
+// shortForkError is returned by disconnectFork if the fork is not longer
+// than the main chain. More blocks of the fork may make it longer.
+type shortForkError struct {
+	parent, length, height int
+}
+
+func (e *shortForkError) Error() string {
+	return fmt.Sprintf("fork after block %d is not longer than the main chain: %d <= %d blocks", e.parent, e.length, e.height)
+}
+
+// disconnectFork checks if the block forks from the main chain. If n
+// blocks starting with it make the chain longer than the main chain,
+// blocks after the fork point are disconnected. Otherwise it fails
+// with *shortForkError.
+func (db *Database) disconnectFork(block *types.Block, n int) error {
+	height := db.numBlocks()
+	if height == 0 || block.ParentID == db.blockID(height-1) {
+		return nil
+	}
+	if _, has := db.blockHeight(block.ID()); has {
+		return nil
+	}
+	parent, has := db.blockHeight(block.ParentID)
+	if !has {
+		return fmt.Errorf("block %s has unknown parent %s", block.ID(), block.ParentID)
+	}
+	if parent+1+n <= height {
+		return &shortForkError{parent: parent, length: parent + 1 + n, height: height}
+	}
+	log.Printf("reorganization: disconnecting %d blocks after block %d.", height-1-parent, parent)
+	for h := height - 1; h > parent; h-- {
+		if err := db.removeBlock(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addBlocks adds the blocks in one transaction. If the blocks fork from
+// the main chain and make a longer chain, the main chain is reorganized.
+func (db *Database) addBlocks(blocks []*types.Block) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var events []*human.Event
+	err := db.update(func(db *Database) error {
+		if db.hub.active() {
+			db.events = &events
+		}
+		for i, block := range blocks {
+			if err := db.disconnectFork(block, len(blocks)-i); err != nil {
+				return err
+			}
+			if err := db.addBlock(block); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	db.hub.publish(events)
+	return db.view(func(db *Database) error {
+		db.mempool.prune(db)
+		return nil
+	})
+}
+
+// blockHistory returns IDs of blocks used by SendBlocks RPC to find
+// the latest block known to the peer: the last 10 blocks, then blocks
+// with exponentially growing step, and the genesis block, like in Sia.
+func (db *Database) blockHistory() (history [32]types.BlockID) {
+	height := db.numBlocks() - 1
+	step := 1
+	for i := 0; i < 31 && height >= 0; i++ {
+		history[i] = db.blockID(height)
+		if i >= 9 {
+			step *= 2
+		}
+		if height <= step {
+			break
+		}
+		height -= step
+	}
+	history[31] = types.GenesisID
+	return
+}
+
+// lastBlockID returns the ID of the last block or false if the
+// database is empty.
+func (db *Database) lastBlockID() (id types.BlockID, has bool) {
+	db.view(func(db *Database) error {
+		if n := db.numBlocks(); n != 0 {
+			id, has = db.blockID(n-1), true
+		}
+		return nil
+	})
+	return
+}
+
+// knownBlock returns if the block is in the main chain.
+func (db *Database) knownBlock(id types.BlockID) (has bool) {
+	db.view(func(db *Database) error {
+		_, has = db.blockHeight(id)
+		return nil
+	})
+	return
+}
+
+// wantRelayed returns true if the relayed header announces an unknown
+// block meeting the target of the next block. Headers are cheap to send,
+// so others do not trigger fetching blocks.
+func (db *Database) wantRelayed(header types.BlockHeader) (want bool) {
+	id := header.ID()
+	db.view(func(db *Database) error {
+		if _, has := db.blockHeight(id); has {
+			return nil
+		}
+		r, has := db.stats(db.numBlocks() - 1)
+		if !has {
+			return nil
+		}
+		target := r.Target.ChildTarget
+		want = bytes.Compare(target[:], id[:]) >= 0
+		return nil
+	})
+	return
+}
+
+// chainSource serves the main chain of the database to peers.
+type chainSource struct {
+	db *Database
+}
+
+func (s chainSource) BlocksAfter(history [32]types.BlockID, n int) (blocks []types.Block, more bool, err error) {
+	err = s.db.view(func(db *Database) error {
+		start := -1
+		for _, id := range history {
+			if height, has := db.blockHeight(id); has {
+				start = height + 1
+				break
+			}
+		}
+		if start == -1 {
+			return nil
+		}
+		end := start + n
+		if end > db.numBlocks() {
+			end = db.numBlocks()
+		}
+		for height := start; height < end; height++ {
+			blocks = append(blocks, *db.blockAt(height))
+		}
+		more = end < db.numBlocks()
+		return nil
+	})
+	return
+}
+
+func (s chainSource) Height(id types.BlockID) (height int, has bool) {
+	s.db.view(func(db *Database) error {
+		height, has = db.blockHeight(id)
+		return nil
+	})
+	return
+}
+
+func (s chainSource) Block(height int) (block *types.Block, err error) {
+	s.db.view(func(db *Database) error {
+		if height >= 0 && height < db.numBlocks() {
+			block = db.blockAt(height)
+		}
+		return nil
+	})
+	if block == nil {
+		return nil, fmt.Errorf("no block at height %d", height)
+	}
+	return block, nil
+}
+
+func processBlocks(ctx context.Context, db *Database, bchan chan *types.Block) error {
 	log.Printf("processBlocks")
-	db := NewDatabase()
 	i := 0
+	var batch []*types.Block
 	for block := range bchan {
 		i++
 		if *nblocks != 0 && i > *nblocks {
 			log.Printf("processBlocks got %d blocks", *nblocks)
 			break
 		}
-		if err := db.addBlock(block); err != nil {
-			return nil, err
+		batch = append(batch, block)
+		if len(batch)%*batchSize == 0 {
+			err := db.addBlocks(batch)
+			if _, short := err.(*shortForkError); short {
+				// The fork may span several batches. Keep its blocks
+				// until it becomes longer than the main chain.
+				continue
+			} else if err != nil {
+				return err
+			}
+			batch = nil
 		}
 	}
-	return db, nil
+	err := db.addBlocks(batch)
+	if _, short := err.(*shortForkError); short {
+		log.Printf("dropping %d blocks: %v.", len(batch), err)
+		return nil
+	}
+	return err
 }
 
-func (db *Database) fetchBlocks(ctx context.Context, sess *smux.Session) error {
-	stream, err := sess.OpenStream()
-	if err != nil {
-		return err
-	}
-	defer stream.Close()
+func (db *Database) fetchBlocks(ctx context.Context, pm *netlib.PeerManager) error {
+	var history [32]types.BlockID
+	db.view(func(db *Database) error {
+		history = db.blockHistory()
+		return nil
+	})
 	bchan := make(chan *types.Block, 20)
-	prevBlock := db.height2block[len(db.height2block)-1]
-	prevBlockID := db.block2id[prevBlock]
-	if _, err := netlib.DownloadBlocks(ctx, bchan, stream, prevBlockID); err != nil {
-		return err
-	}
-	close(bchan)
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := pm.DownloadBlocksFromHistory(ctx, bchan, history)
+		close(bchan)
+		errChan <- err
+	}()
+	var blocks []*types.Block
 	for block := range bchan {
-		if err := db.addBlock(block); err != nil {
-			return err
-		}
+		blocks = append(blocks, block)
 	}
-	return nil
+	if err := db.addBlocks(blocks); err != nil {
+		return err
+	}
+	return <-errChan
 }
 
-func main() {
-	flag.Parse()
-	ctx := context.Background()
-	sess, f, err := netlib.OpenOrConnect(ctx, *blockchain, *source)
-	if err != nil {
-		panic(err)
+// seedNodes returns nodes from -source or bootstrap peers of Sia.
+func seedNodes() []modules.NetAddress {
+	if *source == "" {
+		return modules.BootstrapPeers
+	}
+	var nodes []modules.NetAddress
+	for _, node := range strings.Split(*source, ",") {
+		nodes = append(nodes, modules.NetAddress(node))
 	}
+	return nodes
+}
+
+// initialDownload adds all the blocks from the source to the database.
+// It returns the peer manager, if the source is the network.
+func initialDownload(ctx context.Context, db *Database, identity netlib.Identity) *netlib.PeerManager {
 	bchan := make(chan *types.Block, 1000)
-	bchan <- &types.GenesisBlock
-	var db *Database
+	prevBlockID, has := db.lastBlockID()
+	if !has {
+		bchan <- &types.GenesisBlock
+		prevBlockID = types.GenesisID
+	}
+	var download func(ctx context.Context) error
+	var pm *netlib.PeerManager
+	if *blockchain != "" {
+		_, f, err := netlib.OpenOrConnect(ctx, *blockchain, "")
+		if err != nil {
+			panic(err)
+		}
+		download = func(ctx context.Context) error {
+			return netlib.DownloadAllBlocksSince(ctx, bchan, f, prevBlockID)
+		}
+	} else {
+		pm = netlib.NewPeerManager(seedNodes(), *numPeers, identity)
+		pm.Discover = *source == ""
+		var v *cache.Validator
+		db.view(func(db *Database) error {
+			v = db.newValidator(*reorgDepth)
+			return nil
+		})
+		pm.Check = v.Add
+		go pm.Run(ctx)
+		history := [32]types.BlockID{prevBlockID}
+		if has {
+			db.view(func(db *Database) error {
+				history = db.blockHistory()
+				return nil
+			})
+		}
+		history[31] = types.GenesisID
+		download = func(ctx context.Context) error {
+			_, err := pm.DownloadBlocksFromHistory(ctx, bchan, history)
+			return err
+		}
+	}
 	var wg sync.WaitGroup
 	wg.Add(2)
 	ctx, cancel := context.WithCancel(ctx)
 	go func() {
 		defer wg.Done()
-		if err := netlib.DownloadAllBlocks(ctx, bchan, f); err != nil {
+		if err := download(ctx); err != nil {
 			if err != context.Canceled {
 				panic(err)
 			}
@@ -432,7 +867,7 @@ func main() {
 	}()
 	go func() {
 		defer wg.Done()
-		if db, err = processBlocks(ctx, bchan); err != nil {
+		if err := processBlocks(ctx, db, bchan); err != nil {
 			panic(err)
 		}
 		cancel()
@@ -441,22 +876,117 @@ func main() {
 	}()
 	wg.Wait()
 
-	fmt.Println("Initial block download completed.")
+	db.view(func(db *Database) error {
+		fmt.Printf("Initial block download completed. Number of blocks: %d.\n", db.numBlocks())
+		return nil
+	})
+	return pm
+}
+
+func main() {
+	flag.Parse()
+	for _, prefix := range strings.Split(*dataPrefix, ",") {
+		if prefix == "" {
+			continue
+		}
+		if len(prefix) > types.SpecifierLen {
+			panic(fmt.Sprintf("prefix %q is longer than %d bytes", prefix, types.SpecifierLen))
+		}
+		var specifier types.Specifier
+		copy(specifier[:], prefix)
+		human.RegisterDataDecoder(specifier, prefix, human.DecodePayload)
+	}
+	ctx := context.Background()
+	identity := netlib.DefaultIdentity
+	var ln net.Listener
+	if *gateway != "" && *exportDir == "" {
+		var err error
+		ln, err = net.Listen("tcp", *gateway)
+		if err != nil {
+			log.Fatalf("net.Listen: %v", err)
+		}
+		identity = netlib.ListenerIdentity(ln)
+	}
+	var db *Database
+	var pm *netlib.PeerManager
+	if *cacheDir != "" {
+		var err error
+		db, err = OpenCache(*cacheDir, *cacheSize, cache.ServerOptions{
+			ReadIndices: *readIndices,
+			CachePages:  *cachePages,
+		})
+		if err != nil {
+			panic(err)
+		}
+		db.view(func(db *Database) error {
+			fmt.Printf("Opened cache. Number of blocks: %d.\n", db.numBlocks())
+			return nil
+		})
+	} else {
+		var err error
+		db, err = OpenDatabase(*dbPath, *cacheSize, *mempoolSize)
+		if err != nil {
+			panic(err)
+		}
+		if *exportDir == "" {
+			pm = initialDownload(ctx, db, identity)
+		}
+	}
+	defer db.Close()
 
-	fmt.Printf("len(tx2block) = %d\n", len(db.tx2block))
-	fmt.Printf("len(id2sco) = %d\n", len(db.id2sco))
-	fmt.Printf("len(id2sfo) = %d\n", len(db.id2sfo))
-	fmt.Printf("len(id2sci) = %d\n", len(db.id2sci))
-	fmt.Printf("len(id2sfi) = %d\n", len(db.id2sfi))
-	fmt.Printf("len(address2sco) = %d\n", len(db.address2sco))
-	fmt.Printf("len(address2sfo) = %d\n", len(db.address2sfo))
-	fmt.Printf("len(id2history) = %d\n", len(db.id2history))
+	if *exportDir != "" {
+		if err := db.view(func(db *Database) error {
+			return db.export(*exportDir)
+		}); err != nil {
+			log.Fatalf("export: %v", err)
+		}
+		return
+	}
+
+	hchan := make(chan types.BlockHeader, 10)
+	if ln != nil {
+		server := &netlib.Server{
+			Source:   chainSource{db},
+			Identity: identity,
+		}
+		if pm != nil {
+			server.Nodes = func() []modules.NetAddress {
+				var nodes []modules.NetAddress
+				for _, p := range pm.Peers() {
+					nodes = append(nodes, p.Addr)
+				}
+				return nodes
+			}
+			server.Handlers = map[string]netlib.RPCHandler{
+				"RelayHeader": netlib.HeadersHandler(ctx, hchan),
+			}
+		}
+		go func() {
+			log.Fatalf("Server.Serve: %v", server.Serve(ctx, ln))
+		}()
+	}
 
-	if sess != nil {
+	if pm != nil {
+		pm.SetOnConnect(func(p *netlib.Peer) {
+			db.receiveRelayed(ctx, p.Sess, hchan)
+		})
 		go func() {
-			for range time.NewTicker(5 * time.Second).C {
+			ticker := time.NewTicker(5 * time.Second)
+			var lastFetch time.Time
+			for {
+				select {
+				case <-ticker.C:
+				case header := <-hchan:
+					// Skipped headers are caught up by the ticker.
+					if time.Since(lastFetch) < relayFetchInterval || !db.wantRelayed(header) {
+						continue
+					}
+				}
+				lastFetch = time.Now()
 				ctx := context.Background()
-				db.fetchBlocks(ctx, sess)
+				if err := db.fetchBlocks(ctx, pm); err != nil {
+					log.Printf("fetchBlocks: %v.", err)
+				}
 			}
 		}()
 	}
@@ -465,8 +995,6 @@ func main() {
 	db.addHandlers(router)
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		db.mu.RLock()
-		defer db.mu.RUnlock()
 		router.ServeHTTP(w, r)
 	}
 	log.Fatal(http.ListenAndServe(*addr, http.HandlerFunc(handler)))
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/starius/sialite/netlib/fakepeer"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// skipWork makes the database accept blocks without proof of work,
+// such as blocks of fakepeer.Fork.
+func skipWork(db *Database) {
+	db.verify = func(db *Database, height int, block *types.Block) error {
+		return nil
+	}
+}
+
+// relink fixes ParentID of blocks after the height, after blocks of
+// the chain were changed.
+func relink(chain []types.Block, height int) {
+	for i := height + 1; i < len(chain); i++ {
+		chain[i].ParentID = chain[i-1].ID()
+	}
+}
+
+// addTestBlocks adds the chain starting with the genesis block.
+func addTestBlocks(t *testing.T, db *Database, blocks []types.Block) {
+	batch := []*types.Block{&types.GenesisBlock}
+	for i := 1; i < len(blocks); i++ {
+		batch = append(batch, &blocks[i])
+	}
+	if err := db.addBlocks(batch); err != nil {
+		t.Fatalf("addBlocks: %v", err)
+	}
+}
+
+// process runs processBlocks over the blocks with the batch size.
+func process(db *Database, blocks []types.Block, size int) error {
+	defer func(old int) {
+		*batchSize = old
+	}(*batchSize)
+	*batchSize = size
+	bchan := make(chan *types.Block, len(blocks))
+	for i := range blocks {
+		bchan <- &blocks[i]
+	}
+	close(bchan)
+	return processBlocks(context.Background(), db, bchan)
+}
+
+func TestForkAcrossBatches(t *testing.T) {
+	blocks := readTestBlocks(t)
+	db := openTestDatabase(t)
+	skipWork(db)
+	addTestBlocks(t, db, blocks)
+
+	// The fork is too short to replace the main chain: it is dropped.
+	short := fakepeer.Fork(blocks, 900, 50)
+	if err := process(db, short[901:], 20); err != nil {
+		t.Fatalf("processBlocks(short fork): %v", err)
+	}
+	checkLastBlock(t, db, blocks[len(blocks)-1].ID())
+
+	// The fork becomes longer than the main chain in its fifth batch.
+	fork := fakepeer.Fork(blocks, 900, 200)
+	if err := process(db, fork[901:], 20); err != nil {
+		t.Fatalf("processBlocks(fork): %v", err)
+	}
+	checkLastBlock(t, db, fork[len(fork)-1].ID())
+	if db.knownBlock(blocks[950].ID()) {
+		t.Errorf("block 950 of the old chain is still in the main chain")
+	}
+	if !db.knownBlock(fork[950].ID()) {
+		t.Errorf("block 950 of the fork is not in the main chain")
+	}
+	// Blocks at the same heights are served from the new chain.
+	err := db.view(func(db *Database) error {
+		for _, height := range []int{900, 950, 1000} {
+			if got := db.blockAt(height).ID(); got != fork[height].ID() {
+				t.Errorf("blockAt(%d) = %s, want %s", height, got, fork[height].ID())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("db.view: %v", err)
+	}
+}
+
+func TestChainSourceBlocksAfter(t *testing.T) {
+	blocks := readTestBlocks(t)
+	db := openTestDatabase(t)
+	skipWork(db)
+	addTestBlocks(t, db, blocks[:100])
+	s := chainSource{db}
+	got, more, err := s.BlocksAfter([32]types.BlockID{blocks[50].ID()}, 10)
+	if err != nil {
+		t.Fatalf("BlocksAfter: %v", err)
+	}
+	if len(got) != 10 || got[0].ID() != blocks[51].ID() || got[9].ID() != blocks[60].ID() || !more {
+		t.Fatalf("BlocksAfter(block 50) returned %d blocks, more = %v", len(got), more)
+	}
+	if got, _, _ := s.BlocksAfter([32]types.BlockID{{1}}, 10); len(got) != 0 {
+		t.Errorf("BlocksAfter(unknown block) returned %d blocks", len(got))
+	}
+
+	// After a reorganization the blocks follow the latest block of the
+	// history still in the chain.
+	fork := fakepeer.Fork(blocks[:100], 55, 50)
+	if err := process(db, fork[56:], 100); err != nil {
+		t.Fatalf("processBlocks(fork): %v", err)
+	}
+	got, more, err = s.BlocksAfter([32]types.BlockID{blocks[60].ID(), blocks[50].ID()}, 100)
+	if err != nil {
+		t.Fatalf("BlocksAfter: %v", err)
+	}
+	if len(got) != len(fork)-51 || got[0].ID() != blocks[51].ID() || got[5].ID() != fork[56].ID() || more {
+		t.Errorf("BlocksAfter after the reorganization returned %d blocks, more = %v", len(got), more)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/starius/sialite/cache"
+	"github.com/starius/sialite/human"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+const (
+	secondsPerDay = 24 * 60 * 60
+
+	maxChartPoints = 5000
+	defaultBlocks  = 100 // Default range of block charts.
+	defaultDays    = 30  // Default range of day charts.
+)
+
+var (
+	ErrNoStats = fmt.Errorf("statistics are not available")
+)
+
+// statsRecord is stored for every block. The state of difficulty
+// adjustment is kept to compute the statistics of the next block.
+type statsRecord struct {
+	Stats  human.BlockStats
+	Target cache.TargetState
+}
+
+// dbHeaders provides block headers of the database to cache package.
+type dbHeaders struct {
+	db *Database
+}
+
+func (h dbHeaders) Length() int {
+	return h.db.numBlocks()
+}
+
+func (h dbHeaders) Index(i int) cache.BlockInfo {
+	header := h.db.header(i)
+	info := cache.BlockInfo{
+		BlockHeader: types.BlockHeader{
+			Nonce:     header.Nonce,
+			Timestamp: header.Timestamp,
+		},
+		CurrentID: header.ID,
+	}
+	if i != 0 {
+		info.ParentID = h.db.blockID(i - 1)
+	}
+	return info
+}
+
+// verifyBlock checks the block to be added at the height against the
+// headers and the difficulty of the chain.
+func (db *Database) verifyBlock(height int, block *types.Block) error {
+	var parent *cache.TargetState
+	if height != 0 {
+		r, has := db.stats(height - 1)
+		if !has {
+			return fmt.Errorf("no statistics of block %d", height-1)
+		}
+		parent = &r.Target
+	}
+	if err := cache.VerifyBlock(dbHeaders{db}, parent, block); err != nil {
+		return fmt.Errorf("invalid block: %v", err)
+	}
+	return nil
+}
+
+// newValidator returns a validator of blocks following the last block.
+func (db *Database) newValidator(depth int) *cache.Validator {
+	v := cache.NewValidator(dbHeaders{db}, func(height int) *cache.TargetState {
+		r, _ := db.stats(height)
+		return &r.Target
+	}, depth)
+	if db.numBlocks() == 0 {
+		v.Add(&types.GenesisBlock)
+	}
+	return v
+}
+
+// addStats stores statistics of the block added at the height and adds
+// them to statistics of its day.
+func (db *Database) addStats(height int, block *types.Block) error {
+	var parent *cache.TargetState
+	var prev human.BlockStats
+	if height != 0 {
+		r, has := db.stats(height - 1)
+		if !has {
+			return fmt.Errorf("no statistics of block %d", height-1)
+		}
+		parent = &r.Target
+		prev = r.Stats
+	}
+	target := cache.NextTargetState(dbHeaders{db}, parent)
+	s := human.BlockStats{
+		Height:     height,
+		ID:         block.ID(),
+		Timestamp:  block.Timestamp,
+		Fees:       types.NewCurrency64(0),
+		Sfpool:     db.sfpool(height),
+		CoinSupply: types.CalculateNumSiacoins(types.BlockHeight(height)),
+		Difficulty: target.Target.Difficulty(),
+		Hashrate:   target.Hashrate(),
+	}
+	addresses := make(map[types.UnlockHash]bool)
+	for _, mp := range block.MinerPayouts {
+		addresses[mp.UnlockHash] = true
+	}
+	for _, tx := range block.Transactions {
+		s.Transactions++
+		for _, fee := range tx.MinerFees {
+			s.Fees = s.Fees.Add(fee)
+		}
+		for _, sci := range tx.SiacoinInputs {
+			addresses[sci.UnlockConditions.UnlockHash()] = true
+		}
+		for _, sco := range tx.SiacoinOutputs {
+			addresses[sco.UnlockHash] = true
+		}
+		for _, sfi := range tx.SiafundInputs {
+			addresses[sfi.UnlockConditions.UnlockHash()] = true
+		}
+		for _, sfo := range tx.SiafundOutputs {
+			addresses[sfo.UnlockHash] = true
+		}
+		for _, contract := range tx.FileContracts {
+			s.NewContracts++
+			s.ContractsVolume += contract.FileSize
+		}
+	}
+	s.ActiveAddresses = len(addresses)
+	s.TotalTransactions = prev.TotalTransactions + s.Transactions
+	s.TotalContracts = prev.TotalContracts + s.NewContracts
+	s.TotalContractsVolume = prev.TotalContractsVolume + s.ContractsVolume
+	if err := db.put(bucketStats, heightKey(height), statsRecord{Stats: s, Target: *target}); err != nil {
+		return err
+	}
+
+	day := int(block.Timestamp / secondsPerDay)
+	d, has := db.dayStats(day)
+	if !has {
+		d = &human.DayStats{
+			Day:  types.Timestamp(day * secondsPerDay),
+			Fees: types.NewCurrency64(0),
+		}
+	}
+	for address := range addresses {
+		key := append(dayKey(day), address[:]...)
+		if db.btx.Bucket(bucketDayAddrs).Get(key) != nil {
+			continue
+		}
+		if err := db.put(bucketDayAddrs, key, true); err != nil {
+			return err
+		}
+		d.ActiveAddresses++
+	}
+	d.Blocks++
+	d.Transactions += s.Transactions
+	d.Fees = d.Fees.Add(s.Fees)
+	d.NewContracts += s.NewContracts
+	d.ContractsVolume += s.ContractsVolume
+	// Blocks are added in the order of heights.
+	d.Sfpool = s.Sfpool
+	d.CoinSupply = s.CoinSupply
+	d.Difficulty = s.Difficulty
+	d.Hashrate = s.Hashrate
+	return db.put(bucketDayStats, dayKey(day), *d)
+}
+
+func (db *Database) lastStats() (*human.BlockStats, error) {
+	n := db.numBlocks()
+	if n == 0 {
+		return nil, ErrNoStats
+	}
+	r, has := db.stats(n - 1)
+	if !has {
+		return nil, ErrNoStats
+	}
+	return &r.Stats, nil
+}
+
+// parseRange parses optional bounds of a range. Missing from defaults
+// to width values before to.
+func parseRange(fromStr, toStr string, defaultTo, width int) (from, to int, err error) {
+	to = defaultTo
+	if toStr != "" {
+		if to, err = strconv.Atoi(toStr); err != nil {
+			return 0, 0, fmt.Errorf("bad to: %v", err)
+		}
+	}
+	from = to - width + 1
+	if fromStr != "" {
+		if from, err = strconv.Atoi(fromStr); err != nil {
+			return 0, 0, fmt.Errorf("bad from: %v", err)
+		}
+	}
+	return from, to, nil
+}
+
+// charts returns statistics of blocks with heights in [from, to] if
+// bucket is "block" or of days with timestamps in [from, to] if bucket
+// is "day". Empty from and to select the last blocks or days.
+func (db *Database) charts(bucket, fromStr, toStr string) (*human.Charts, error) {
+	last, err := db.lastStats()
+	if err != nil {
+		return nil, err
+	}
+	charts := &human.Charts{
+		Bucket: bucket,
+	}
+	switch bucket {
+	case "block":
+		from, to, err := parseRange(fromStr, toStr, last.Height, defaultBlocks)
+		if err != nil {
+			return nil, err
+		}
+		if from < 0 {
+			from = 0
+		}
+		if to > last.Height {
+			to = last.Height
+		}
+		if to-from+1 > maxChartPoints {
+			return nil, fmt.Errorf("too many blocks: %d > %d", to-from+1, maxChartPoints)
+		}
+		charts.Blocks = []human.BlockStats{}
+		for height := from; height <= to; height++ {
+			r, _ := db.stats(height)
+			charts.Blocks = append(charts.Blocks, r.Stats)
+		}
+	case "day":
+		from, to, err := parseRange(fromStr, toStr, int(last.Timestamp), defaultDays*secondsPerDay)
+		if err != nil {
+			return nil, err
+		}
+		fromDay, toDay := from/secondsPerDay, to/secondsPerDay
+		if toDay-fromDay+1 > maxChartPoints {
+			return nil, fmt.Errorf("too many days: %d > %d", toDay-fromDay+1, maxChartPoints)
+		}
+		charts.Days = []human.DayStats{}
+		for day := fromDay; day <= toDay; day++ {
+			if d, has := db.dayStats(day); has {
+				charts.Days = append(charts.Days, *d)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("bucket must be block or day, got %q", bucket)
+	}
+	return charts, nil
+}
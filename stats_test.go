@@ -0,0 +1,153 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/starius/sialite/human"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// blockAddresses returns the addresses of outputs and inputs of the block.
+func blockAddresses(block *types.Block) map[types.UnlockHash]bool {
+	addresses := make(map[types.UnlockHash]bool)
+	for _, mp := range block.MinerPayouts {
+		addresses[mp.UnlockHash] = true
+	}
+	for _, tx := range block.Transactions {
+		for _, sci := range tx.SiacoinInputs {
+			addresses[sci.UnlockConditions.UnlockHash()] = true
+		}
+		for _, sco := range tx.SiacoinOutputs {
+			addresses[sco.UnlockHash] = true
+		}
+		for _, sfi := range tx.SiafundInputs {
+			addresses[sfi.UnlockConditions.UnlockHash()] = true
+		}
+		for _, sfo := range tx.SiafundOutputs {
+			addresses[sfo.UnlockHash] = true
+		}
+	}
+	return addresses
+}
+
+func blockFees(block *types.Block) types.Currency {
+	fees := types.NewCurrency64(0)
+	for _, tx := range block.Transactions {
+		for _, fee := range tx.MinerFees {
+			fees = fees.Add(fee)
+		}
+	}
+	return fees
+}
+
+// checkStats compares statistics of the database with statistics of
+// the chain, which has no file contracts.
+func checkStats(t *testing.T, db *Database, blocks []types.Block) {
+	t.Helper()
+	days := make(map[int]*human.DayStats)
+	dayAddresses := make(map[int]map[types.UnlockHash]bool)
+	total := 0
+	err := db.view(func(db *Database) error {
+		for height := range blocks {
+			block := &blocks[height]
+			addresses := blockAddresses(block)
+			total += len(block.Transactions)
+			r, has := db.stats(height)
+			if !has {
+				t.Fatalf("no statistics of block %d", height)
+			}
+			s := r.Stats
+			if s.Height != height || s.ID != block.ID() || s.Timestamp != block.Timestamp {
+				t.Errorf("block %d: statistics of block %d %s", height, s.Height, s.ID)
+			}
+			if s.Transactions != len(block.Transactions) || s.TotalTransactions != total {
+				t.Errorf("block %d: %d transactions, %d in total, want %d and %d", height, s.Transactions, s.TotalTransactions, len(block.Transactions), total)
+			}
+			if !s.Fees.Equals(blockFees(block)) {
+				t.Errorf("block %d: fees %s, want %s", height, s.Fees, blockFees(block))
+			}
+			if s.ActiveAddresses != len(addresses) {
+				t.Errorf("block %d: %d active addresses, want %d", height, s.ActiveAddresses, len(addresses))
+			}
+			if want := types.CalculateNumSiacoins(types.BlockHeight(height)); !s.CoinSupply.Equals(want) {
+				t.Errorf("block %d: coin supply %s, want %s", height, s.CoinSupply, want)
+			}
+			if s.Difficulty.IsZero() || s.NewContracts != 0 || s.TotalContracts != 0 {
+				t.Errorf("block %d: difficulty %s, %d contracts, %d in total", height, s.Difficulty, s.NewContracts, s.TotalContracts)
+			}
+
+			day := int(block.Timestamp / secondsPerDay)
+			d, has := days[day]
+			if !has {
+				d = &human.DayStats{Day: types.Timestamp(day * secondsPerDay), Fees: types.NewCurrency64(0)}
+				days[day] = d
+				dayAddresses[day] = make(map[types.UnlockHash]bool)
+			}
+			for address := range addresses {
+				dayAddresses[day][address] = true
+			}
+			d.Blocks++
+			d.Transactions += s.Transactions
+			d.Fees = d.Fees.Add(s.Fees)
+			d.ActiveAddresses = len(dayAddresses[day])
+			d.CoinSupply, d.Difficulty = s.CoinSupply, s.Difficulty
+		}
+		last := int(blocks[len(blocks)-1].Timestamp / secondsPerDay)
+		for day := int(blocks[0].Timestamp / secondsPerDay); day <= last+1; day++ {
+			want, wantHas := days[day]
+			got, has := db.dayStats(day)
+			if has != wantHas {
+				t.Errorf("day %d: has statistics is %v, want %v", day, has, wantHas)
+				continue
+			}
+			if !has {
+				continue
+			}
+			if got.Day != want.Day || got.Blocks != want.Blocks || got.Transactions != want.Transactions || got.ActiveAddresses != want.ActiveAddresses {
+				t.Errorf("day %d: %+v, want %+v", day, got, want)
+			}
+			if !got.Fees.Equals(want.Fees) || !got.CoinSupply.Equals(want.CoinSupply) || !got.Difficulty.Equals(want.Difficulty) {
+				t.Errorf("day %d: fees %s, coin supply %s and difficulty %s, want %s, %s and %s", day, got.Fees, got.CoinSupply, got.Difficulty, want.Fees, want.CoinSupply, want.Difficulty)
+			}
+		}
+		if _, has := db.stats(len(blocks)); has {
+			t.Errorf("statistics of block %d above the chain", len(blocks))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("db.view: %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	blocks := readTestBlocks(t)
+	db := openTestDatabase(t)
+	addTestBlocks(t, db, blocks)
+	checkStats(t, db, blocks)
+
+	// Disconnecting blocks removes their statistics and their share of
+	// statistics of days, including active addresses.
+	const keep = 700
+	err := db.update(func(db *Database) error {
+		for db.numBlocks() > keep {
+			if err := db.removeBlock(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("removeBlock: %v", err)
+	}
+	checkStats(t, db, blocks[:keep])
+
+	var rest []*types.Block
+	for i := keep; i < len(blocks); i++ {
+		rest = append(rest, &blocks[i])
+	}
+	if err := db.addBlocks(rest); err != nil {
+		t.Fatalf("addBlocks: %v", err)
+	}
+	checkStats(t, db, blocks)
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/bbolt"
+	"github.com/starius/sialite/human"
+	"gitlab.com/NebulousLabs/Sia/crypto"
+	"gitlab.com/NebulousLabs/Sia/encoding"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// Buckets of the database. Heights are stored as 8 byte big endian
+// numbers, so blocks are ordered by height. Other records are encoded
+// with Sia encoding. Counters are stored explicitly rather than found
+// with cursors: bolt cursors skip the last records if their page was
+// emptied by deletions in the same transaction, e.g. during removeBlock.
+var (
+	bucketMeta          = []byte("meta")          // "numBlocks", "maturedBalanceBlocks" -> int
+	bucketBlocks        = []byte("blocks")        // height -> types.Block
+	bucketHeaders       = []byte("headers")       // height -> human.BlockHeader
+	bucketHeights       = []byte("heights")       // types.BlockID -> height
+	bucketSfpools       = []byte("sfpools")       // height -> types.Currency
+	bucketTxs           = []byte("txs")           // types.TransactionID -> TxLocation
+	bucketScos          = []byte("scos")          // types.SiacoinOutputID -> SiacoinOutput
+	bucketSfos          = []byte("sfos")          // types.SiafundOutputID -> SiafundOutput
+	bucketScis          = []byte("scis")          // types.SiacoinOutputID -> SiacoinInput
+	bucketSfis          = []byte("sfis")          // types.SiafundOutputID -> SiafundInput
+	bucketAddressScos   = []byte("addressScos")   // address + 4 byte index -> types.SiacoinOutputID, address -> count
+	bucketAddressSfos   = []byte("addressSfos")   // address + 4 byte index -> types.SiafundOutputID, address -> count
+	bucketContracts     = []byte("contracts")     // types.FileContractID -> ContractHistory
+	bucketUndo          = []byte("undo")          // height -> []undoRecord
+	bucketOrphans       = []byte("orphans")       // types.BlockID -> Orphan
+	bucketStats         = []byte("stats")         // height -> statsRecord
+	bucketDayStats      = []byte("dayStats")      // day -> human.DayStats
+	bucketDayAddrs      = []byte("dayAddrs")      // day + address -> true if active in the day
+	bucketBalances      = []byte("balances")      // address -> balanceRecord
+	bucketRichSc        = []byte("richSc")        // richKey(siacoins, address) -> true
+	bucketRichSf        = []byte("richSf")        // richKey(siafunds, address) -> true
+	bucketExpiring      = []byte("expiring")      // height -> []types.FileContractID with WindowEnd at height
+	bucketHosts         = []byte("hosts")         // hostKey -> hostRecord
+	bucketHostContracts = []byte("hostContracts") // hostKey + types.FileContractID -> true if revision has the key
+
+	allBuckets = [][]byte{
+		bucketMeta,
+		bucketBlocks,
+		bucketHeaders,
+		bucketHeights,
+		bucketSfpools,
+		bucketTxs,
+		bucketScos,
+		bucketSfos,
+		bucketScis,
+		bucketSfis,
+		bucketAddressScos,
+		bucketAddressSfos,
+		bucketContracts,
+		bucketUndo,
+		bucketOrphans,
+		bucketStats,
+		bucketDayStats,
+		bucketDayAddrs,
+		bucketBalances,
+		bucketRichSc,
+		bucketRichSf,
+		bucketExpiring,
+		bucketHosts,
+		bucketHostContracts,
+	}
+
+	keyNumBlocks     = []byte("numBlocks")
+	keyBalanceBlocks = []byte("maturedBalanceBlocks") // Number of blocks applied to balances.
+
+	// Buckets keyed by IDs of the kinds of objects found by search.
+	searchBuckets = map[string][][]byte{
+		human.SearchBlock:         {bucketHeights},
+		human.SearchTransaction:   {bucketTxs},
+		human.SearchContract:      {bucketContracts},
+		human.SearchSiacoinOutput: {bucketScos},
+		human.SearchSiafundOutput: {bucketSfos},
+		human.SearchAddress:       {bucketAddressScos, bucketAddressSfos},
+		human.SearchOrphan:        {bucketOrphans},
+	}
+)
+
+func heightKey(height int) []byte {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], uint64(height))
+	return key[:]
+}
+
+// dayKey is the key of a UTC day: the number of days since Unix epoch.
+func dayKey(day int) []byte {
+	return heightKey(day)
+}
+
+func addressKey(address types.UnlockHash, index int) []byte {
+	key := make([]byte, len(address)+4)
+	copy(key, address[:])
+	binary.BigEndian.PutUint32(key[len(address):], uint32(index))
+	return key
+}
+
+// blockCache is LRU cache of decoded blocks indexed by block ID. It is
+// shared by all transactions: a block is found by the ID which the
+// transaction has at the height, so a transaction never gets a block of
+// another snapshot, and blocks of rolled back or disconnected blocks
+// are just never found.
+type blockCache struct {
+	size  int
+	order *list.List
+	items map[types.BlockID]*list.Element
+
+	mu sync.Mutex
+}
+
+type cachedBlock struct {
+	id    types.BlockID
+	block *types.Block
+}
+
+func newBlockCache(size int) *blockCache {
+	return &blockCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[types.BlockID]*list.Element),
+	}
+}
+
+func (c *blockCache) get(id types.BlockID) *types.Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, has := c.items[id]
+	if !has {
+		return nil
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedBlock).block
+}
+
+func (c *blockCache) add(id types.BlockID, block *types.Block) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, has := c.items[id]; has {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[id] = c.order.PushFront(&cachedBlock{id: id, block: block})
+	for c.order.Len() > c.size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*cachedBlock).id)
+	}
+}
+
+// index is the read-only index of the blockchain. It is implemented by
+// boltIndex and cacheIndex.
+type index interface {
+	numBlocks() int
+	blockAt(height int) *types.Block
+	header(height int) human.BlockHeader
+	blockHeight(id types.BlockID) (height int, has bool)
+	sfpool(height int) types.Currency
+	txLocation(id types.TransactionID) (loc TxLocation, has bool)
+	sco(id types.SiacoinOutputID) (*SiacoinOutput, bool)
+	sfo(id types.SiafundOutputID) (*SiafundOutput, bool)
+	sci(id types.SiacoinOutputID) (*SiacoinInput, bool)
+	sfi(id types.SiafundOutputID) (*SiafundInput, bool)
+	contract(id types.FileContractID) (*ContractHistory, bool)
+	addressScosLen(address types.UnlockHash) int
+	addressSfosLen(address types.UnlockHash) int
+	addressSco(address types.UnlockHash, index int) *SiacoinOutput
+	addressSfo(address types.UnlockHash, index int) *SiafundOutput
+	orphan(id types.BlockID) (*Orphan, bool)
+	orphans() []*Orphan
+
+	stats(height int) (*statsRecord, bool)
+	dayStats(day int) (*human.DayStats, bool)
+	balance(address types.UnlockHash) (*balanceRecord, bool)
+
+	// richList returns up to limit keys of the rich list of the coin
+	// (coinSiacoin or coinSiafund) starting with key start.
+	richList(coin string, start []byte, limit int) [][]byte
+
+	host(key []byte) (*hostRecord, bool)
+	// hostKeys returns up to limit keys of hosts starting with key start.
+	hostKeys(start []byte, limit int) [][]byte
+	hostContracts(key []byte) []types.FileContractID
+
+	// idsWithPrefix returns up to limit IDs of the kind of objects
+	// (human.Search*) whose lowercase hex starts with prefix.
+	idsWithPrefix(kind, prefix string, limit int) []crypto.Hash
+}
+
+// undoRecord is the state of a key before addBlock changed it. Records
+// of a block are applied in reverse order to disconnect the block.
+type undoRecord struct {
+	Bucket  []byte
+	Key     []byte
+	Existed bool
+	Value   []byte
+}
+
+var (
+	ErrReadOnly = fmt.Errorf("the index is read-only")
+)
+
+// indexError is raised by readers of the index, which have no error
+// result, when the index is corrupted. view and update recover it and
+// return its error, so a broken record fails the request or rolls back
+// the update instead of crashing the process.
+type indexError struct {
+	err error
+}
+
+func failIndex(err error) {
+	panic(indexError{err})
+}
+
+// run calls f with db and returns the error of indexError raised by f.
+func (db *Database) run(f func(db *Database) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(indexError)
+			if !ok {
+				panic(r)
+			}
+			err = e.err
+		}
+	}()
+	return f(db)
+}
+
+func OpenDatabase(path string, blockCacheSize, mempoolSize int) (*Database, error) {
+	bdb, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("bolt.Open(%q): %v", path, err)
+	}
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		for _, name := range allBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		bdb.Close()
+		return nil, fmt.Errorf("creating buckets: %v", err)
+	}
+	db := &Database{
+		bdb:     bdb,
+		blocks:  newBlockCache(blockCacheSize),
+		mempool: NewMempool(mempoolSize),
+		hub:     NewHub(),
+		verify:  (*Database).verifyBlock,
+		mu:      new(sync.RWMutex),
+	}
+	if err := db.update((*Database).backfillBalances); err != nil {
+		bdb.Close()
+		return nil, fmt.Errorf("backfillBalances: %v", err)
+	}
+	return db, nil
+}
+
+func (db *Database) Close() error {
+	if db.server != nil {
+		return db.server.Close()
+	}
+	return db.bdb.Close()
+}
+
+// view calls f with a copy of db bound to a read-only transaction.
+func (db *Database) view(f func(db *Database) error) error {
+	if db.server != nil {
+		v := *db
+		v.index = newCacheIndex(db.server, db.blocks)
+		return v.run(f)
+	}
+	return db.bdb.View(func(tx *bolt.Tx) error {
+		v := *db
+		v.btx = tx
+		v.index = &boltIndex{btx: tx, blocks: db.blocks}
+		return v.run(f)
+	})
+}
+
+// update calls f with a copy of db bound to a writable transaction.
+// If f fails, all its changes are discarded.
+func (db *Database) update(f func(db *Database) error) error {
+	if db.server != nil {
+		return ErrReadOnly
+	}
+	return db.bdb.Update(func(tx *bolt.Tx) error {
+		v := *db
+		v.btx = tx
+		v.index = &boltIndex{btx: tx, blocks: db.blocks}
+		return v.run(f)
+	})
+}
+
+// put stores v encoded with Sia encoding. Pass values, not pointers:
+// Sia encoding prepends a pointer with a flag, which get does not expect.
+// If db.undo is set, the previous state of the key is appended to it.
+func (db *Database) put(bucket, key []byte, v interface{}) error {
+	db.journal(bucket, key)
+	return db.btx.Bucket(bucket).Put(key, encoding.Marshal(v))
+}
+
+// del deletes the key. Like put, it is journaled while addBlock runs.
+func (db *Database) del(bucket, key []byte) error {
+	db.journal(bucket, key)
+	return db.btx.Bucket(bucket).Delete(key)
+}
+
+func (db *Database) journal(bucket, key []byte) {
+	if db.undo == nil {
+		return
+	}
+	old := db.btx.Bucket(bucket).Get(key)
+	*db.undo = append(*db.undo, undoRecord{
+		Bucket:  bucket,
+		Key:     append([]byte(nil), key...),
+		Existed: old != nil,
+		Value:   append([]byte(nil), old...),
+	})
+}
+
+func (db *Database) blockID(height int) types.BlockID {
+	return db.header(height).ID
+}
+
+func (db *Database) tx(loc TxLocation) *types.Transaction {
+	block := db.blockAt(loc.Block)
+	if block == nil {
+		failIndex(fmt.Errorf("transaction %d refers to missing block %d", loc.Tx, loc.Block))
+	}
+	if loc.Tx < 0 || loc.Tx >= len(block.Transactions) {
+		failIndex(fmt.Errorf("block %d has no transaction %d", loc.Block, loc.Tx))
+	}
+	return &block.Transactions[loc.Tx]
+}
+
+// boltIndex reads the index from a transaction of bolt database.
+type boltIndex struct {
+	btx    *bolt.Tx
+	blocks *blockCache
+}
+
+func (ix *boltIndex) get(bucket, key []byte, v interface{}) bool {
+	data := ix.btx.Bucket(bucket).Get(key)
+	if data == nil {
+		return false
+	}
+	if err := encoding.Unmarshal(data, v); err != nil {
+		failIndex(fmt.Errorf("corrupted record in bucket %s: %v", bucket, err))
+	}
+	return true
+}
+
+func (ix *boltIndex) numBlocks() (n int) {
+	ix.get(bucketMeta, keyNumBlocks, &n)
+	return
+}
+
+func (ix *boltIndex) blockAt(height int) *types.Block {
+	if height == 0 {
+		// Decoding turns nil slices of the genesis block into empty
+		// slices, which are rendered differently in JSON.
+		return &types.GenesisBlock
+	}
+	var header human.BlockHeader
+	if !ix.get(bucketHeaders, heightKey(height), &header) {
+		return nil
+	}
+	if block := ix.blocks.get(header.ID); block != nil {
+		return block
+	}
+	block := new(types.Block)
+	if !ix.get(bucketBlocks, heightKey(height), block) {
+		return nil
+	}
+	ix.blocks.add(header.ID, block)
+	return block
+}
+
+func (ix *boltIndex) header(height int) (header human.BlockHeader) {
+	ix.get(bucketHeaders, heightKey(height), &header)
+	return
+}
+
+func (ix *boltIndex) blockHeight(id types.BlockID) (height int, has bool) {
+	has = ix.get(bucketHeights, id[:], &height)
+	return
+}
+
+func (ix *boltIndex) sfpool(height int) (sfpool types.Currency) {
+	ix.get(bucketSfpools, heightKey(height), &sfpool)
+	return
+}
+
+func (ix *boltIndex) txLocation(id types.TransactionID) (loc TxLocation, has bool) {
+	has = ix.get(bucketTxs, id[:], &loc)
+	return
+}
+
+func (ix *boltIndex) sco(id types.SiacoinOutputID) (*SiacoinOutput, bool) {
+	o := new(SiacoinOutput)
+	return o, ix.get(bucketScos, id[:], o)
+}
+
+func (ix *boltIndex) sfo(id types.SiafundOutputID) (*SiafundOutput, bool) {
+	o := new(SiafundOutput)
+	return o, ix.get(bucketSfos, id[:], o)
+}
+
+func (ix *boltIndex) sci(id types.SiacoinOutputID) (*SiacoinInput, bool) {
+	i := new(SiacoinInput)
+	return i, ix.get(bucketScis, id[:], i)
+}
+
+func (ix *boltIndex) sfi(id types.SiafundOutputID) (*SiafundInput, bool) {
+	i := new(SiafundInput)
+	return i, ix.get(bucketSfis, id[:], i)
+}
+
+func (ix *boltIndex) contract(id types.FileContractID) (*ContractHistory, bool) {
+	h := new(ContractHistory)
+	return h, ix.get(bucketContracts, id[:], h)
+}
+
+// addressLen returns the number of records of the address in the bucket.
+func (ix *boltIndex) addressLen(bucket []byte, address types.UnlockHash) (n int) {
+	ix.get(bucket, address[:], &n)
+	return
+}
+
+func (ix *boltIndex) addressScosLen(address types.UnlockHash) int {
+	return ix.addressLen(bucketAddressScos, address)
+}
+
+func (ix *boltIndex) addressSfosLen(address types.UnlockHash) int {
+	return ix.addressLen(bucketAddressSfos, address)
+}
+
+func (ix *boltIndex) addressSco(address types.UnlockHash, index int) *SiacoinOutput {
+	var id types.SiacoinOutputID
+	ix.get(bucketAddressScos, addressKey(address, index), &id)
+	o, _ := ix.sco(id)
+	return o
+}
+
+func (ix *boltIndex) addressSfo(address types.UnlockHash, index int) *SiafundOutput {
+	var id types.SiafundOutputID
+	ix.get(bucketAddressSfos, addressKey(address, index), &id)
+	o, _ := ix.sfo(id)
+	return o
+}
+
+func (ix *boltIndex) stats(height int) (*statsRecord, bool) {
+	r := new(statsRecord)
+	return r, ix.get(bucketStats, heightKey(height), r)
+}
+
+func (ix *boltIndex) dayStats(day int) (*human.DayStats, bool) {
+	d := new(human.DayStats)
+	return d, ix.get(bucketDayStats, dayKey(day), d)
+}
+
+func (ix *boltIndex) balance(address types.UnlockHash) (*balanceRecord, bool) {
+	b := &balanceRecord{
+		Siacoins: types.NewCurrency64(0),
+		Siafunds: types.NewCurrency64(0),
+	}
+	return b, ix.get(bucketBalances, address[:], b)
+}
+
+func (ix *boltIndex) richList(coin string, start []byte, limit int) [][]byte {
+	var keys [][]byte
+	c := ix.btx.Bucket(richBuckets[coin]).Cursor()
+	for k, _ := c.Seek(start); k != nil && len(keys) < limit; k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	return keys
+}
+
+func (ix *boltIndex) host(key []byte) (*hostRecord, bool) {
+	h := new(hostRecord)
+	return h, ix.get(bucketHosts, key, h)
+}
+
+func (ix *boltIndex) hostKeys(start []byte, limit int) [][]byte {
+	var keys [][]byte
+	c := ix.btx.Bucket(bucketHosts).Cursor()
+	for k, _ := c.Seek(start); k != nil && len(keys) < limit; k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	return keys
+}
+
+func (ix *boltIndex) hostContracts(key []byte) []types.FileContractID {
+	var ids []types.FileContractID
+	c := ix.btx.Bucket(bucketHostContracts).Cursor()
+	for k, _ := c.Seek(key); k != nil && bytes.HasPrefix(k, key); k, _ = c.Next() {
+		var fcid types.FileContractID
+		copy(fcid[:], k[len(key):])
+		ids = append(ids, fcid)
+	}
+	return ids
+}
+
+func (ix *boltIndex) orphan(id types.BlockID) (*Orphan, bool) {
+	o := new(Orphan)
+	return o, ix.get(bucketOrphans, id[:], o)
+}
+
+func (ix *boltIndex) orphans() []*Orphan {
+	var orphans []*Orphan
+	ix.btx.Bucket(bucketOrphans).ForEach(func(k, v []byte) error {
+		o := new(Orphan)
+		if err := encoding.Unmarshal(v, o); err != nil {
+			failIndex(fmt.Errorf("corrupted record in bucket %s: %v", bucketOrphans, err))
+		}
+		orphans = append(orphans, o)
+		return nil
+	})
+	return orphans
+}
+
+// idsWithPrefix scans the buckets of the kind from the prefix, since
+// bolt keeps keys sorted. Keys of address buckets which include an index
+// are skipped by seeking to the next address.
+func (ix *boltIndex) idsWithPrefix(kind, prefix string, limit int) []crypto.Hash {
+	seek, err := hex.DecodeString(prefix + strings.Repeat("0", len(prefix)%2))
+	if err != nil {
+		return nil
+	}
+	seen := make(map[crypto.Hash]bool)
+	var ids []crypto.Hash
+	for _, bucket := range searchBuckets[kind] {
+		c := ix.btx.Bucket(bucket).Cursor()
+		n := 0
+		k, _ := c.Seek(seek)
+		for k != nil && n < limit && len(k) >= crypto.HashSize {
+			if !strings.HasPrefix(hex.EncodeToString(k[:crypto.HashSize]), prefix) {
+				break
+			}
+			if len(k) != crypto.HashSize {
+				next := append(append([]byte(nil), k[:crypto.HashSize]...), 0xff, 0xff, 0xff, 0xff, 0xff)
+				k, _ = c.Seek(next)
+				continue
+			}
+			var id crypto.Hash
+			copy(id[:], k)
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+			n++
+			k, _ = c.Next()
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coreos/bbolt"
+	"gitlab.com/NebulousLabs/Sia/encoding"
+)
+
+func TestCorruptedIndex(t *testing.T) {
+	blocks := readTestBlocks(t)
+	db := openTestDatabase(t)
+	addTestBlocks(t, db, blocks[:10])
+	id := blocks[5].ID()
+	tx := blocks[5].Transactions[0]
+	txid := tx.ID()
+	err := db.bdb.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketHeights).Put(id[:], []byte{1}); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketTxs).Put(txid[:], encoding.Marshal(TxLocation{Block: 1000, Tx: 0}))
+	})
+	if err != nil {
+		t.Fatalf("corrupting the database: %v", err)
+	}
+	err = db.view(func(db *Database) error {
+		db.blockHeight(id)
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "corrupted record") {
+		t.Errorf("reading an undecodable record returned %v, want an error", err)
+	}
+	err = db.view(func(db *Database) error {
+		loc, _ := db.txLocation(txid)
+		db.tx(loc)
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "missing block") {
+		t.Errorf("reading a transaction of a missing block returned %v, want an error", err)
+	}
+	// The rest of the database is readable.
+	checkLastBlock(t, db, blocks[9].ID())
+}
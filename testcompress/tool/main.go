@@ -30,7 +30,7 @@ func (s stat) ratio() float64 {
 
 func main() {
 	flag.Parse()
-	s, err := cache.NewServer(*input)
+	s, err := cache.NewServer(*input, cache.ServerOptions{})
 	if err != nil {
 		log.Fatalf("cache.NewBuilder: %v", err)
 	}